@@ -1,17 +1,24 @@
 package api
 
 import (
+	"bytes"
 	"crypto/rand"
+	"errors"
 	"fmt"
 	"ht/helper"
 	"ht/model"
 	"ht/server"
+	"ht/server/jobs"
 	"ht/web/handler"
+	"io"
 	"log"
 	"net/http"
+	"net/url"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/gorilla/csrf"
 	"github.com/labstack/echo/v4"
 )
 
@@ -73,6 +80,16 @@ func (r Middleware) getSession(c echo.Context) (*model.Session, error) {
 	} else {
 		return nil, fmt.Errorf("invalid type email_verified: %T", userId)
 	}
+	// sessions of older versions are not restricted
+	if restrictedBool, ok := session.Values["restricted"].(bool); ok {
+		currentSession.Restricted = restrictedBool
+	}
+	if voiceVerifiedAt, ok := session.Values["voice_verified_at"].(int64); ok {
+		currentSession.VoiceVerifiedAt = time.Unix(voiceVerifiedAt, 0)
+	}
+	if secondFactorPendingBool, ok := session.Values["second_factor_pending"].(bool); ok {
+		currentSession.SecondFactorPending = secondFactorPendingBool
+	}
 	if createdAtTime, ok := createdAt.(int64); ok {
 		currentSession.CreatedAt = time.Unix(createdAtTime, 0)
 	} else {
@@ -102,6 +119,8 @@ func (r Middleware) AuthMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
 			return echo.NewHTTPError(http.StatusUnauthorized, fmt.Errorf("not logged in"))
 		} else if !session.EmailVerified {
 			return echo.NewHTTPError(http.StatusUnauthorized, fmt.Errorf("email not verified"))
+		} else if session.SecondFactorPending && !isIdentificationRoute(c) {
+			return echo.NewHTTPError(http.StatusUnauthorized, fmt.Errorf("voice verification required"))
 		} else {
 			helper.SetContext(c, helper.UserRIDKey, session.UserID)
 			return next(c)
@@ -133,6 +152,8 @@ func (r Middleware) ViewAuthMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
 			return handler.HandleLoginView(c)
 		} else if !session.EmailVerified {
 			return handler.HandleVerifyEmailView(c)
+		} else if session.SecondFactorPending && !isIdentificationRoute(c) {
+			return c.Redirect(http.StatusSeeOther, "/identification")
 		} else {
 			helper.SetContext(c, helper.UserRIDKey, session.UserID)
 			return next(c)
@@ -140,6 +161,182 @@ func (r Middleware) ViewAuthMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
 	}
 }
 
+// isIdentificationRoute returns true for the routes a partial session may use to complete the login.
+func isIdentificationRoute(c echo.Context) bool {
+	return c.Path() == "/identification" || strings.HasPrefix(c.Path(), "/identification/")
+}
+
+// UnrestrictedMiddleware allows sessions not started under duress. Restricted sessions get an error
+// which looks like an outage, so whoever coerces the user is not warned.
+func (r Middleware) UnrestrictedMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
+	return r.AuthMiddleware(func(c echo.Context) error {
+		session, err := r.getSession(c)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusNotFound, fmt.Errorf("error getting session: %v", err))
+		}
+
+		if session.Restricted {
+			log.Printf("blocked %v of restricted session of user %v", c.Request().URL.Path, session.UserID)
+			return echo.NewHTTPError(http.StatusServiceUnavailable, "This is temporarily unavailable, please try again later.")
+		}
+		return next(c)
+	})
+}
+
+// RequireRecentVoiceVerification allows sessions whose voice was accepted within maxAge, e.g. for sensitive
+// actions, and users without an enrolled voice. Other sessions are sent through the identification and returned
+// afterwards: page requests to themselves, HTMX requests to the page they were sent from, as the request itself
+// can not be repeated.
+func (r Middleware) RequireRecentVoiceVerification(maxAge time.Duration) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return r.AuthMiddleware(func(c echo.Context) error {
+			session, err := r.getSession(c)
+			if err != nil {
+				return echo.NewHTTPError(http.StatusNotFound, fmt.Errorf("error getting session: %v", err))
+			}
+
+			if time.Since(session.VoiceVerifiedAt) <= maxAge {
+				return next(c)
+			}
+			// like the login, users without an enrolled voice rely on their password alone
+			enrolled, err := r.server.UserService.HasActiveVoiceProfile(helper.GetCurrentUserRID(c.Request().Context()))
+			if err != nil {
+				return echo.NewHTTPError(http.StatusInternalServerError, err)
+			}
+			if !enrolled {
+				return next(c)
+			}
+
+			htmx := c.Request().Header.Get("HX-Request") == "true"
+			returnPath := c.Request().URL.RequestURI()
+			if htmx {
+				returnPath = localPath(c.Request().Header.Get("HX-Current-URL"))
+			} else if c.Request().Method != http.MethodGet {
+				return echo.NewHTTPError(http.StatusUnauthorized, "Please verify your voice again.")
+			}
+
+			err = r.server.AuthService.SetVoiceVerificationReturn(c, returnPath)
+			if err != nil {
+				return echo.NewHTTPError(http.StatusInternalServerError, err)
+			}
+
+			if htmx {
+				c.Response().Header().Add("HX-Redirect", "/identification")
+				return c.NoContent(http.StatusOK)
+			}
+			return c.Redirect(http.StatusSeeOther, "/identification")
+		})
+	}
+}
+
+// localPath returns the path and query of the url, the user is only ever returned to this site.
+func localPath(rawUrl string) string {
+	parsed, err := url.Parse(rawUrl)
+	if err != nil || !strings.HasPrefix(parsed.Path, "/") || strings.HasPrefix(parsed.Path, "//") {
+		return "/user"
+	}
+	return parsed.RequestURI()
+}
+
+func (r Middleware) AdminMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
+	return r.UnrestrictedMiddleware(func(c echo.Context) error {
+		userRid := helper.GetCurrentUserRID(c.Request().Context())
+		if !r.server.AuthService.HasRole(userRid, model.RoleAdmin) {
+			return echo.NewHTTPError(http.StatusForbidden, fmt.Errorf("missing permission"))
+		}
+		return next(c)
+	})
+}
+
+func (r Middleware) ViewAdminMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
+	return r.ViewAuthMiddleware(func(c echo.Context) error {
+		userRid := helper.GetCurrentUserRID(c.Request().Context())
+		if !r.server.AuthService.HasRole(userRid, model.RoleAdmin) {
+			return handler.HandleNotFound(c)
+		}
+		return next(c)
+	})
+}
+
+// AgentMiddleware allows agents and admins.
+func (r Middleware) AgentMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
+	return r.UnrestrictedMiddleware(func(c echo.Context) error {
+		userRid := helper.GetCurrentUserRID(c.Request().Context())
+		if !r.server.AuthService.HasAnyRole(userRid, model.RoleAgent, model.RoleAdmin) {
+			return echo.NewHTTPError(http.StatusForbidden, fmt.Errorf("missing permission"))
+		}
+		return next(c)
+	})
+}
+
+func (r Middleware) ViewAgentMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
+	return r.ViewAuthMiddleware(func(c echo.Context) error {
+		userRid := helper.GetCurrentUserRID(c.Request().Context())
+		if !r.server.AuthService.HasAnyRole(userRid, model.RoleAgent, model.RoleAdmin) {
+			return handler.HandleNotFound(c)
+		}
+		return next(c)
+	})
+}
+
+// FraudMiddleware allows the fraud team and admins.
+func (r Middleware) FraudMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
+	return r.UnrestrictedMiddleware(func(c echo.Context) error {
+		userRid := helper.GetCurrentUserRID(c.Request().Context())
+		if !r.server.AuthService.HasAnyRole(userRid, model.RoleFraud, model.RoleAdmin) {
+			return echo.NewHTTPError(http.StatusForbidden, fmt.Errorf("missing permission"))
+		}
+		return next(c)
+	})
+}
+
+func (r Middleware) ViewFraudMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
+	return r.ViewAuthMiddleware(func(c echo.Context) error {
+		userRid := helper.GetCurrentUserRID(c.Request().Context())
+		if !r.server.AuthService.HasAnyRole(userRid, model.RoleFraud, model.RoleAdmin) {
+			return handler.HandleNotFound(c)
+		}
+		return next(c)
+	})
+}
+
+// SkipCSRFForCallbacks disables the csrf check for callbacks of the jobs service,
+// they are authenticated by JobsCallbackMiddleware. It has to run before the csrf middleware.
+func (r Middleware) SkipCSRFForCallbacks(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if strings.HasPrefix(c.Request().URL.Path, "/callback/") {
+			c.SetRequest(csrf.UnsafeSkipCheck(c.Request()))
+		}
+		return next(c)
+	}
+}
+
+// JobsCallbackMiddleware verifies the signature of callbacks of the jobs service.
+func (r Middleware) JobsCallbackMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		body, err := io.ReadAll(http.MaxBytesReader(c.Response(), c.Request().Body, 1<<20))
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid body"})
+		}
+
+		err = r.server.JobsCallbackVerifier.Verify(
+			c.Request().Header.Get(jobs.HEADER_TIMESTAMP),
+			c.Request().Header.Get(jobs.HEADER_SIGNATURE),
+			body,
+		)
+		if errors.Is(err, jobs.ErrInvalidSignature) {
+			log.Printf("rejected jobs callback: %v", err)
+			return c.JSON(http.StatusUnauthorized, map[string]string{"error": "invalid signature"})
+		} else if err != nil {
+			log.Printf("error verifying jobs callback: %v", err)
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "callback not verified"})
+		}
+
+		c.Request().Body = io.NopCloser(bytes.NewReader(body))
+		return next(c)
+	}
+}
+
 func (r Middleware) ThrottleMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
 	return func(c echo.Context) error {
 		helper.Throttle()
@@ -17,6 +17,9 @@ import (
 	"golang.org/x/time/rate"
 )
 
+// VOICE_VERIFICATION_MAX_AGE is how long an accepted voice check allows sensitive actions.
+const VOICE_VERIFICATION_MAX_AGE = 5 * time.Minute
+
 type Router struct {
 	echo   *echo.Echo
 	server *server.Server
@@ -44,6 +47,15 @@ func StartServer() {
 	router.RegisterRoutes()
 
 	echo.HTTPErrorHandler = handler.HandleErrorView
+
+	// expires identification attempts stuck in pending or processing
+	quit, done := server.IdentificationService.Cleanup(time.Minute)
+	defer server.IdentificationService.StopCleanup(quit, done)
+
+	// dispatches queued jobs to the jobs service
+	jobQuit, jobDone := server.JobService.Work(time.Second)
+	defer server.JobService.StopWork(jobQuit, jobDone)
+
 	echo.Logger.SetLevel(log.DEBUG)
 	echo.Logger.Fatal(
 		echo.Start(fmt.Sprintf(":%v", helper.GetEnvVariable("SERVER_PORT"))),
@@ -61,10 +73,18 @@ func (r *Router) RegisterRoutes() {
 	authView := handler.NewAuthView(r.server)
 	userView := handler.NewUserView(r.server)
 	identificationView := handler.NewIdentificationView(r.server)
+	adminView := handler.NewAdminView(r.server)
+	agentView := handler.NewAgentView(r.server)
+	batchView := handler.NewBatchView(r.server)
+	duplicateView := handler.NewDuplicateView(r.server)
+	watchlistView := handler.NewWatchlistView(r.server)
+	callbackView := handler.NewCallbackView(r.server)
+	recoveryView := handler.NewRecoveryView(r.server)
 
 	r.echo.Use(middleware.RateLimiter(middleware.NewRateLimiterMemoryStore(
 		rate.Limit(20),
 	)))
+	r.echo.Use(m.SkipCSRFForCallbacks)
 	// TODO remove csrf.Secure(false) in production
 	csrfMiddleware := csrf.Protect(m.csrfKey, csrf.Path("/"), csrf.Secure(false), csrf.ErrorHandler(http.HandlerFunc(handler.HandleCSRFErrorView)))
 	r.echo.Use(echo.WrapMiddleware(csrfMiddleware))
@@ -79,37 +99,119 @@ func (r *Router) RegisterRoutes() {
 	r.echo.GET("/login", handler.HandleLoginView)
 	r.echo.GET("/forgotPassword", handler.HandleForgotPasswordView)
 	r.echo.GET("/resetPassword", handler.HandleResetPasswordView)
+	r.echo.GET("/voiceLogin", authView.HandleVoiceLoginView)
 
 	// api
 	r.echo.POST("/auth/registerWithEmail", authView.HandleRegisterWithEmail)
 	r.echo.POST("/auth/requestNewEmailVerificationCode", m.AuthMiddlewareUnverified(authView.HandleRequestNewEmailVerificationCode))
 	r.echo.POST("/auth/verifyEmail", m.AuthMiddlewareUnverified(authView.HandleVerifyEmail))
 	r.echo.POST("/auth/loginWithEmail", authView.HandleLoginWithEmail)
+	r.echo.POST("/auth/loginWithVoice", authView.HandleLoginWithVoice)
 	r.echo.POST("/auth/requestPasswordReset", authView.HandleRequestPasswordReset)
 	r.echo.POST("/auth/resetPassword", m.AuthMiddlewareUnverified(authView.HandleResetPassword))
 	r.echo.POST("/auth/logout", authView.HandleLogout)
 
+	// view
+	r.echo.GET("/recovery", recoveryView.HandleRecoveryStart)
+	r.echo.GET("/recovery/:rid", recoveryView.HandleRecovery)
+	r.echo.GET("/recovery/:rid/cancel", recoveryView.HandleCancelRecoveryView)
+
+	// api
+	r.echo.POST("/auth/recovery/start", recoveryView.HandleStartRecovery)
+	r.echo.POST("/auth/recovery/:rid/verify", recoveryView.HandleVerifyRecovery)
+	r.echo.POST("/auth/recovery/:rid/complete", recoveryView.HandleCompleteRecovery)
+	r.echo.POST("/auth/recovery/:rid/cancel", recoveryView.HandleCancelRecovery)
+
 	// view
 	r.echo.GET("/user", m.ViewAuthMiddleware(userView.HandleUser))
 	r.echo.GET("/user/onboardingStart", m.ViewAuthMiddleware(userView.HandleOnboardingStart))
-	r.echo.GET("/user/onboardingRecording/:step", m.ViewAuthMiddleware(userView.HandleOnboardingRecording))
-	r.echo.GET("/user/onboardingSuccess", m.ViewAuthMiddleware(userView.HandleOnboardingSuccess))
+	r.echo.GET("/user/onboardingRecording/:step", m.ViewAuthMiddleware(userView.HandleDefaultOnboardingRecording))
+	r.echo.GET("/user/profile/:profile/onboardingRecording/:step", m.ViewAuthMiddleware(userView.HandleOnboardingRecording))
+	r.echo.GET("/user/profile/:profile/onboardingSuccess", m.ViewAuthMiddleware(userView.HandleOnboardingSuccess))
+	r.echo.GET("/user/profile/:profile/recordings", m.ViewAuthMiddleware(m.RequireRecentVoiceVerification(VOICE_VERIFICATION_MAX_AGE)(userView.HandleVoiceProfileRecordings)))
+	r.echo.GET("/user/recording/:rid", m.UnrestrictedMiddleware(m.RequireRecentVoiceVerification(VOICE_VERIFICATION_MAX_AGE)(userView.HandleReferenceRecording)))
 
 	// api
-	r.echo.POST("/user/createReferenceRecording/:step", m.AuthMiddleware(userView.HandleCreateReferenceRecording))
+	r.echo.POST("/user/profile/:profile/createReferenceRecording/:step", m.UnrestrictedMiddleware(userView.HandleCreateReferenceRecording))
+	r.echo.POST("/user/updateAdaptation", m.UnrestrictedMiddleware(userView.HandleUpdateAdaptation))
+	r.echo.POST("/user/createProfile", m.UnrestrictedMiddleware(userView.HandleCreateVoiceProfile))
+	r.echo.POST("/user/createDuressProfile", m.UnrestrictedMiddleware(m.RequireRecentVoiceVerification(VOICE_VERIFICATION_MAX_AGE)(userView.HandleCreateDuressVoiceProfile)))
+	r.echo.POST("/user/profile/:profile/rename", m.UnrestrictedMiddleware(userView.HandleRenameVoiceProfile))
+	r.echo.POST("/user/profile/:profile/retrain", m.UnrestrictedMiddleware(m.RequireRecentVoiceVerification(VOICE_VERIFICATION_MAX_AGE)(userView.HandleRetrainVoiceProfile)))
+	r.echo.POST("/user/profile/:profile/delete", m.UnrestrictedMiddleware(m.RequireRecentVoiceVerification(VOICE_VERIFICATION_MAX_AGE)(userView.HandleDeleteVoiceProfile)))
+	r.echo.POST("/user/changeEmail", m.UnrestrictedMiddleware(m.RequireRecentVoiceVerification(VOICE_VERIFICATION_MAX_AGE)(userView.HandleChangeEmail)))
+	r.echo.POST("/user/confirmEmailChange", m.UnrestrictedMiddleware(userView.HandleConfirmEmailChange))
+	r.echo.POST("/user/delete", m.UnrestrictedMiddleware(m.RequireRecentVoiceVerification(VOICE_VERIFICATION_MAX_AGE)(userView.HandleDeleteAccount)))
 
 	// view
 	r.echo.GET("/identification", m.ViewAuthMiddleware(identificationView.HandleIdentification))
-	r.echo.GET("/identification/identicationPending", m.ViewAuthMiddleware(identificationView.HandleAuthenticationWaiting))
+	r.echo.GET("/identification/identificationPending", m.ViewAuthMiddleware(identificationView.HandleAuthenticationWaiting))
 	r.echo.GET("/identification/result", m.ViewAuthMiddleware(identificationView.HandleResult))
+	r.echo.GET("/identification/events", m.AuthMiddleware(identificationView.HandleIdentificationEvents))
+	r.echo.GET("/identification/stream", m.AuthMiddleware(identificationView.HandleIdentificationStream))
 
 	// api
 	r.echo.POST("/identification/createIdentificationAttempt", m.AuthMiddleware(identificationView.HandleCreateIdentificationAttempt))
+	r.echo.POST("/identification/verifyStepUp", m.AuthMiddleware(identificationView.HandleVerifyStepUp))
+	r.echo.POST("/identification/requestStepUpCode", m.AuthMiddleware(identificationView.HandleRequestStepUpCode))
+	r.echo.POST("/identification/requestUnlockCode", m.AuthMiddleware(identificationView.HandleRequestUnlockCode))
+	r.echo.POST("/identification/unlock", m.AuthMiddleware(identificationView.HandleUnlock))
+	r.echo.POST("/identification/requestLoginCode", m.AuthMiddleware(identificationView.HandleRequestLoginCode))
+	r.echo.POST("/identification/verifyLoginCode", m.AuthMiddleware(identificationView.HandleVerifyLoginCode))
+
+	// view
+	r.echo.GET("/admin", m.ViewAdminMiddleware(adminView.HandleAdmin))
+	r.echo.GET("/admin/user", m.ViewAdminMiddleware(adminView.HandleAdminUser))
+
+	// api
+	r.echo.POST("/admin/user/:rid/revertAdaptation", m.AdminMiddleware(adminView.HandleRevertAdaptation))
+	r.echo.POST("/admin/user/:rid/disableAdaptation", m.AdminMiddleware(adminView.HandleDisableAdaptation))
+	r.echo.POST("/admin/user/:rid/dismissTemplateRefresh", m.AdminMiddleware(adminView.HandleDismissTemplateRefresh))
+	r.echo.POST("/admin/user/:rid/unlockVoice", m.AdminMiddleware(adminView.HandleUnlockVoice))
+	r.echo.POST("/admin/user/:rid/updateStatus", m.AdminMiddleware(adminView.HandleUpdateUserStatus))
+
+	// view
+	r.echo.GET("/admin/watchlist", m.ViewAdminMiddleware(watchlistView.HandleWatchlist))
+
+	// api
+	r.echo.POST("/admin/watchlist/add", m.AdminMiddleware(watchlistView.HandleAddWatchlistEntry))
+	r.echo.POST("/admin/watchlist/:rid/deactivate", m.AdminMiddleware(watchlistView.HandleDeactivateWatchlistEntry))
+
+	// view
+	r.echo.GET("/agent", m.ViewAgentMiddleware(agentView.HandleAgent))
+	r.echo.GET("/agent/customer", m.ViewAgentMiddleware(agentView.HandleAgentCustomer))
+	r.echo.GET("/agent/verification/:rid", m.ViewAgentMiddleware(agentView.HandleAssistedVerification))
+
+	// api
+	r.echo.POST("/agent/customer/:rid/verify", m.AgentMiddleware(agentView.HandleCreateAssistedVerification))
+
+	// view
+	r.echo.GET("/batch", m.ViewFraudMiddleware(batchView.HandleBatches))
+	r.echo.GET("/batch/:rid", m.ViewFraudMiddleware(batchView.HandleBatch))
+
+	// api
+	r.echo.POST("/batch/create", m.FraudMiddleware(batchView.HandleCreateBatch))
+	r.echo.GET("/batch/:rid/result", m.FraudMiddleware(batchView.HandleBatchResult))
+
+	// view
+	r.echo.GET("/duplicates", m.ViewFraudMiddleware(duplicateView.HandleDuplicateReviews))
+	r.echo.GET("/duplicates/:rid", m.ViewFraudMiddleware(duplicateView.HandleDuplicateReview))
+
+	// api
+	r.echo.POST("/duplicates/:rid/resolve", m.FraudMiddleware(duplicateView.HandleResolveDuplicateReview))
+
+	// api
+	r.echo.POST("/callback/referenceSamples", m.JobsCallbackMiddleware(callbackView.HandleReferenceSamplesCallback))
+	r.echo.POST("/callback/identificationAttempt", m.JobsCallbackMiddleware(callbackView.HandleIdentificationAttemptCallback))
 
 	r.echo.RouteNotFound("/*", handler.HandleNotFound)
 
 	r.echo.Use(middleware.GzipWithConfig(middleware.GzipConfig{
 		Level: 5,
+		// server-sent events have to be flushed unbuffered
+		Skipper: func(c echo.Context) bool {
+			return c.Request().Header.Get(echo.HeaderAccept) == "text/event-stream"
+		},
 	}))
 	r.echo.Static("/static/", "./web/static")
 }
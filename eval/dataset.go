@@ -0,0 +1,125 @@
+package eval
+
+import (
+	"encoding/csv"
+	"fmt"
+	"ht/model"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+type role string
+
+const (
+	roleEnroll role = "enroll"
+	roleProbe  role = "probe"
+)
+
+// labelledRecording is a recording of a known speaker, either for the template or as probe.
+type labelledRecording struct {
+	Speaker string
+	Role    role
+	Name    string
+	Load    func() ([]byte, error)
+	// Vector is reused instead of extracting the recording again if Extractor is the configured one.
+	Vector    model.Vector
+	Extractor model.Extractor
+}
+
+// loadDirectory reads recordings laid out as <dir>/<speaker>/enroll/* and <dir>/<speaker>/probe/*.
+func loadDirectory(dir string) ([]*labelledRecording, error) {
+	speakers, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("error reading dataset directory: %v", err)
+	}
+
+	recordings := []*labelledRecording{}
+	for _, speaker := range speakers {
+		if !speaker.IsDir() {
+			continue
+		}
+		for _, recordingRole := range []role{roleEnroll, roleProbe} {
+			files, err := os.ReadDir(filepath.Join(dir, speaker.Name(), string(recordingRole)))
+			if os.IsNotExist(err) {
+				continue
+			} else if err != nil {
+				return nil, fmt.Errorf("error reading %v recordings of %v: %v", recordingRole, speaker.Name(), err)
+			}
+			for _, file := range files {
+				if file.IsDir() || strings.HasPrefix(file.Name(), ".") {
+					continue
+				}
+				recordings = append(recordings, fileRecording(speaker.Name(), recordingRole, filepath.Join(dir, speaker.Name(), string(recordingRole), file.Name())))
+			}
+		}
+	}
+
+	return recordings, nil
+}
+
+// loadManifest reads a csv with the columns speaker, role and path. Relative paths are resolved
+// against the directory of the manifest, a header row starting with speaker is skipped.
+func loadManifest(manifestPath string) ([]*labelledRecording, error) {
+	file, err := os.Open(manifestPath)
+	if err != nil {
+		return nil, fmt.Errorf("error opening manifest: %v", err)
+	}
+	defer file.Close()
+
+	reader := csv.NewReader(file)
+	reader.FieldsPerRecord = 3
+	reader.TrimLeadingSpace = true
+
+	recordings := []*labelledRecording{}
+	for line := 1; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("error reading manifest: %v", err)
+		}
+		if line == 1 && strings.EqualFold(record[0], "speaker") {
+			continue
+		}
+
+		recordingRole := role(strings.ToLower(record[1]))
+		if recordingRole != roleEnroll && recordingRole != roleProbe {
+			return nil, fmt.Errorf("invalid role %v in manifest line %v", record[1], line)
+		}
+		path := record[2]
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(filepath.Dir(manifestPath), path)
+		}
+		recordings = append(recordings, fileRecording(record[0], recordingRole, path))
+	}
+
+	return recordings, nil
+}
+
+func fileRecording(speaker string, recordingRole role, path string) *labelledRecording {
+	return &labelledRecording{
+		Speaker: speaker,
+		Role:    recordingRole,
+		Name:    path,
+		Load: func() ([]byte, error) {
+			return os.ReadFile(path)
+		},
+	}
+}
+
+// speakersOf returns the sorted speakers of the recordings.
+func speakersOf(recordings []*labelledRecording) []string {
+	seen := map[string]bool{}
+	speakers := []string{}
+	for _, recording := range recordings {
+		if !seen[recording.Speaker] {
+			seen[recording.Speaker] = true
+			speakers = append(speakers, recording.Speaker)
+		}
+	}
+	sort.Strings(speakers)
+	return speakers
+}
@@ -0,0 +1,148 @@
+package eval
+
+import (
+	"context"
+	"fmt"
+	"ht/model"
+	"ht/server/database"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+type EvalDBHandlerFunctions interface {
+	SelectDecidedIdentificationAttempts(limit int) ([]*model.IdentificationAttempt, error)
+	SelectEnrollmentReferenceSamples(userRids []uuid.UUID) ([]*model.ReferenceSample, error)
+}
+
+// EvalDBHandler only reads, attempts and reference samples can live in different databases.
+type EvalDBHandler struct {
+	identificationDb *database.Database
+	userDb           *database.Database
+}
+
+func newEvalDBHandler(identificationDb *database.Database, userDb *database.Database) *EvalDBHandler {
+	return &EvalDBHandler{
+		identificationDb: identificationDb,
+		userDb:           userDb,
+	}
+}
+
+// SelectDecidedIdentificationAttempts returns the latest accepted or rejected attempts with a recording.
+func (r EvalDBHandler) SelectDecidedIdentificationAttempts(limit int) ([]*model.IdentificationAttempt, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+
+	var identificationAttempts []*model.IdentificationAttempt
+
+	rows, err := r.identificationDb.Instance.QueryContext(
+		ctx,
+		`SELECT
+			id,
+			rid,
+			user_rid,
+			recording,
+			recording_mfcc,
+			COALESCE(extractor, ''),
+			state,
+			score,
+			created_at
+		FROM
+			identification_attempt
+		WHERE
+			state IN ($1, $2)
+			AND recording IS NOT NULL
+		ORDER BY
+			id DESC
+		LIMIT $3`,
+		model.IdentificationAttemptStateAccepted,
+		model.IdentificationAttemptStateRejected,
+		limit,
+	)
+	if err != nil {
+		return []*model.IdentificationAttempt{}, fmt.Errorf("error selecting identification attempts: %v", err)
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		identificationAttempt := &model.IdentificationAttempt{}
+		err := rows.Scan(
+			&identificationAttempt.ID,
+			&identificationAttempt.RID,
+			&identificationAttempt.UserRID,
+			&identificationAttempt.Recording,
+			&identificationAttempt.RecordingMfcc,
+			&identificationAttempt.Extractor,
+			&identificationAttempt.State,
+			&identificationAttempt.Score,
+			&identificationAttempt.CreatedAt,
+		)
+		if err != nil {
+			return []*model.IdentificationAttempt{}, fmt.Errorf("error scanning identification attempt: %v", err)
+		}
+		identificationAttempts = append(identificationAttempts, identificationAttempt)
+	}
+
+	return identificationAttempts, rows.Err()
+}
+
+// SelectEnrollmentReferenceSamples returns the enrollment samples of the users. Adapted samples
+// are left out, they are recordings of the attempts which are replayed.
+func (r EvalDBHandler) SelectEnrollmentReferenceSamples(userRids []uuid.UUID) ([]*model.ReferenceSample, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+
+	var referenceSamples []*model.ReferenceSample
+
+	rids := make([]string, len(userRids))
+	for i, userRid := range userRids {
+		rids[i] = userRid.String()
+	}
+
+	rows, err := r.userDb.Instance.QueryContext(
+		ctx,
+		`SELECT
+			id,
+			rid,
+			user_rid,
+			recording,
+			recording_mfcc,
+			COALESCE(extractor, '')
+		FROM
+			reference_sample
+		WHERE
+			user_rid = ANY($1::uuid[])
+			AND source = $2
+			AND recording IS NOT NULL
+		ORDER BY
+			user_rid,
+			step ASC`,
+		pq.Array(rids),
+		model.ReferenceSampleSourceEnrollment,
+	)
+	if err != nil {
+		return []*model.ReferenceSample{}, fmt.Errorf("error selecting reference samples: %v", err)
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		referenceSample := &model.ReferenceSample{}
+		err := rows.Scan(
+			&referenceSample.ID,
+			&referenceSample.RID,
+			&referenceSample.UserRID,
+			&referenceSample.Recording,
+			&referenceSample.RecordingMfcc,
+			&referenceSample.Extractor,
+		)
+		if err != nil {
+			return []*model.ReferenceSample{}, fmt.Errorf("error scanning reference sample: %v", err)
+		}
+		referenceSamples = append(referenceSamples, referenceSample)
+	}
+
+	return referenceSamples, rows.Err()
+}
@@ -0,0 +1,268 @@
+// Package eval measures the matching offline on labelled recordings or replayed identification attempts.
+package eval
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"ht/model"
+	"ht/server/jobs"
+	"ht/server/services/identification"
+	"ht/server/voice"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/joho/godotenv"
+)
+
+const extractionTimeout = 2 * time.Minute
+
+// Report is the result of an evaluation, rates are fractions in [0, 1].
+type Report struct {
+	Extractor      model.Extractor           `json:"extractor"`
+	Metric         model.DistanceMetric      `json:"metric"`
+	Aggregation    model.MatchingAggregation `json:"aggregation"`
+	K              int                       `json:"k"`
+	Speakers       int                       `json:"speakers"`
+	Recordings     int                       `json:"recordings"`
+	Failures       int                       `json:"failures"`
+	GenuineTrials  int                       `json:"genuine_trials"`
+	ImpostorTrials int                       `json:"impostor_trials"`
+	EER            float64                   `json:"eer"`
+	EERThreshold   float64                   `json:"eer_threshold"`
+	// Recommended is the EER point or, with a target FAR, the lowest FRR within it.
+	Recommended *DETPoint `json:"recommended"`
+	// Configured are the rates of the current MATCHING_THRESHOLD.
+	Configured *DETPoint   `json:"configured"`
+	DET        []*DETPoint `json:"det"`
+}
+
+// Run evaluates the configured extractor and matching policy, args are the arguments after eval.
+func Run(args []string) error {
+	flags := flag.NewFlagSet("eval", flag.ContinueOnError)
+	dir := flags.String("dir", "", "directory with <speaker>/enroll/* and <speaker>/probe/* recordings")
+	manifest := flags.String("manifest", "", "csv with speaker,role,path rows, role is enroll or probe")
+	replay := flags.Bool("replay", false, "replay decided attempts of identification_attempt against the enrollment of their users")
+	limit := flags.Int("limit", 500, "number of latest attempts to replay")
+	targetFar := flags.Float64("target-far", -1, "recommend the threshold with the lowest FRR at most this FAR instead of the EER")
+	jsonPath := flags.String("json", "-", "path of the json report, - for stdout")
+	csvPath := flags.String("csv", "", "path of the csv with the DET curve, - for stdout")
+	points := flags.Int("points", 100, "maximum number of DET points reported")
+	err := flags.Parse(args)
+	if errors.Is(err, flag.ErrHelp) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	sources := 0
+	for _, set := range []bool{*dir != "", *manifest != "", *replay} {
+		if set {
+			sources++
+		}
+	}
+	if sources != 1 {
+		return fmt.Errorf("exactly one of -dir, -manifest or -replay is required")
+	}
+
+	err = godotenv.Load()
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+
+	logger := log.New(os.Stderr, "eval: ", log.LstdFlags)
+
+	policy, err := identification.NewMatchingPolicyFromEnv()
+	if err != nil {
+		return err
+	}
+	jobsClient, err := jobs.NewClientFromEnv()
+	if err != nil {
+		return err
+	}
+	extractor, err := voice.NewVoiceMatcherFromEnv(jobsClient)
+	if err != nil {
+		return err
+	}
+
+	var recordings []*labelledRecording
+	switch {
+	case *dir != "":
+		recordings, err = loadDirectory(*dir)
+	case *manifest != "":
+		recordings, err = loadManifest(*manifest)
+	default:
+		recordings, err = loadReplay(newEvalDBHandlerFromEnv(), *limit)
+	}
+	if err != nil {
+		return err
+	}
+	logger.Printf("evaluating %v recordings with %v", len(recordings), extractor.Extractor())
+
+	report, err := evaluate(logger, extractor, policy, recordings, *targetFar)
+	if err != nil {
+		return err
+	}
+	report.DET = downsample(report.DET, *points)
+
+	err = writeOutput(*jsonPath, func(w io.Writer) error {
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(report)
+	})
+	if err != nil {
+		return err
+	}
+
+	if *csvPath != "" {
+		err = writeOutput(*csvPath, func(w io.Writer) error {
+			return writeCsv(w, report.DET)
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// evaluate extracts the features of all recordings and scores every probe against the
+// template of every speaker, probes of the same speaker are genuine, all others impostor trials.
+func evaluate(logger *log.Logger, extractor voice.FeatureExtractor, policy *identification.MatchingPolicy, recordings []*labelledRecording, targetFar float64) (*Report, error) {
+	report := &Report{
+		Extractor:   extractor.Extractor(),
+		Metric:      policy.Metric,
+		Aggregation: policy.Aggregation,
+		K:           policy.K,
+		Speakers:    len(speakersOf(recordings)),
+		Recordings:  len(recordings),
+	}
+
+	templates := map[string][]model.Vector{}
+	probes := []*labelledRecording{}
+	for _, recording := range recordings {
+		vector, err := extract(extractor, recording)
+		if err != nil {
+			var extractionError *voice.ExtractionError
+			if !errors.As(err, &extractionError) {
+				return nil, fmt.Errorf("error extracting %v: %v", recording.Name, err)
+			}
+			logger.Printf("skipping %v: %v", recording.Name, err)
+			report.Failures++
+			continue
+		}
+
+		recording.Vector = vector
+		if recording.Role == roleEnroll {
+			templates[recording.Speaker] = append(templates[recording.Speaker], vector)
+		} else {
+			probes = append(probes, recording)
+		}
+	}
+
+	genuine := []float64{}
+	impostor := []float64{}
+	for _, probe := range probes {
+		for speaker, template := range templates {
+			distances := make([]float64, 0, len(template))
+			for _, reference := range template {
+				distance, err := policy.Metric.Distance(probe.Vector, reference)
+				if err != nil {
+					return nil, fmt.Errorf("error comparing %v: %v", probe.Name, err)
+				}
+				distances = append(distances, distance)
+			}
+
+			score, err := policy.Score(distances)
+			if err != nil {
+				logger.Printf("skipping %v against %v: %v", probe.Name, speaker, err)
+				continue
+			}
+			if speaker == probe.Speaker {
+				genuine = append(genuine, score)
+			} else {
+				impostor = append(impostor, score)
+			}
+		}
+	}
+	report.GenuineTrials = len(genuine)
+	report.ImpostorTrials = len(impostor)
+
+	rates, err := newErrorRates(genuine, impostor)
+	if err != nil {
+		return nil, err
+	}
+
+	report.DET = rates.curve()
+	eer, eerPoint := equalErrorRate(report.DET)
+	report.EER = eer
+	report.EERThreshold = eerPoint.Threshold
+	report.Recommended = eerPoint
+	if targetFar >= 0 {
+		report.Recommended = thresholdForFAR(report.DET, targetFar)
+	}
+	report.Configured = rates.at(policy.Threshold)
+
+	return report, nil
+}
+
+// extract reuses the stored vector if it was computed by the configured extractor.
+func extract(extractor voice.FeatureExtractor, recording *labelledRecording) (model.Vector, error) {
+	if recording.Extractor == extractor.Extractor() && len(recording.Vector) == extractor.Dimension() {
+		return recording.Vector, nil
+	}
+
+	data, err := recording.Load()
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), extractionTimeout)
+	defer cancel()
+
+	return extractor.ExtractFeatures(ctx, data)
+}
+
+func writeCsv(w io.Writer, points []*DETPoint) error {
+	writer := csv.NewWriter(w)
+	err := writer.Write([]string{"threshold", "far", "frr"})
+	if err != nil {
+		return err
+	}
+	for _, point := range points {
+		err = writer.Write([]string{
+			strconv.FormatFloat(point.Threshold, 'g', -1, 64),
+			strconv.FormatFloat(point.FAR, 'g', -1, 64),
+			strconv.FormatFloat(point.FRR, 'g', -1, 64),
+		})
+		if err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+func writeOutput(path string, write func(w io.Writer) error) error {
+	if path == "-" {
+		return write(os.Stdout)
+	}
+
+	file, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("error creating %v: %v", path, err)
+	}
+	err = write(file)
+	if err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
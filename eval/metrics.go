@@ -0,0 +1,97 @@
+package eval
+
+import (
+	"fmt"
+	"math"
+	"sort"
+)
+
+// DETPoint is the error rates of one threshold, a recording is accepted if its score is below.
+type DETPoint struct {
+	Threshold float64 `json:"threshold"`
+	FAR       float64 `json:"far"`
+	FRR       float64 `json:"frr"`
+}
+
+// errorRates holds the sorted genuine and impostor scores of all trials.
+type errorRates struct {
+	genuine  []float64
+	impostor []float64
+}
+
+func newErrorRates(genuine []float64, impostor []float64) (*errorRates, error) {
+	if len(genuine) == 0 || len(impostor) == 0 {
+		return nil, fmt.Errorf("got %v genuine and %v impostor trials, both are needed", len(genuine), len(impostor))
+	}
+
+	rates := &errorRates{
+		genuine:  append([]float64{}, genuine...),
+		impostor: append([]float64{}, impostor...),
+	}
+	sort.Float64s(rates.genuine)
+	sort.Float64s(rates.impostor)
+	return rates, nil
+}
+
+// at returns the rates of the threshold, impostors below are falsely accepted
+// and genuine recordings at or above are falsely rejected.
+func (r *errorRates) at(threshold float64) *DETPoint {
+	acceptedImpostors := sort.SearchFloat64s(r.impostor, threshold)
+	acceptedGenuine := sort.SearchFloat64s(r.genuine, threshold)
+	return &DETPoint{
+		Threshold: threshold,
+		FAR:       float64(acceptedImpostors) / float64(len(r.impostor)),
+		FRR:       float64(len(r.genuine)-acceptedGenuine) / float64(len(r.genuine)),
+	}
+}
+
+// curve returns the rates of every distinct score as threshold plus one above all scores.
+func (r *errorRates) curve() []*DETPoint {
+	scores := append(append([]float64{}, r.genuine...), r.impostor...)
+	sort.Float64s(scores)
+
+	points := []*DETPoint{}
+	for i, score := range scores {
+		if i > 0 && score == scores[i-1] {
+			continue
+		}
+		points = append(points, r.at(score))
+	}
+	return append(points, r.at(math.Nextafter(scores[len(scores)-1], math.Inf(1))))
+}
+
+// equalErrorRate returns the point where FAR and FRR are closest, the rate is their mean.
+func equalErrorRate(points []*DETPoint) (float64, *DETPoint) {
+	best := points[0]
+	for _, point := range points[1:] {
+		if math.Abs(point.FAR-point.FRR) < math.Abs(best.FAR-best.FRR) {
+			best = point
+		}
+	}
+	return (best.FAR + best.FRR) / 2, best
+}
+
+// thresholdForFAR returns the point with the lowest FRR among the ones with at most the target FAR,
+// of points with the same FRR the one with the lowest FAR.
+func thresholdForFAR(points []*DETPoint, targetFar float64) *DETPoint {
+	best := points[0]
+	for _, point := range points {
+		if point.FAR <= targetFar && (point.FRR < best.FRR || point.FRR == best.FRR && point.FAR < best.FAR) {
+			best = point
+		}
+	}
+	return best
+}
+
+// downsample keeps at most max points evenly spread over the curve, including both ends.
+func downsample(points []*DETPoint, max int) []*DETPoint {
+	if max < 2 || len(points) <= max {
+		return points
+	}
+
+	sampled := make([]*DETPoint, 0, max)
+	for i := 0; i < max; i++ {
+		sampled = append(sampled, points[i*(len(points)-1)/(max-1)])
+	}
+	return sampled
+}
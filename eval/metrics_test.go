@@ -0,0 +1,152 @@
+package eval
+
+import (
+	"math"
+	"testing"
+)
+
+func TestErrorRatesAt(t *testing.T) {
+	rates, err := newErrorRates([]float64{4, 1, 3, 2}, []float64{6, 3, 5, 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		threshold float64
+		far       float64
+		frr       float64
+	}{
+		{"below all scores", 0.5, 0, 1},
+		{"score equal to the threshold is rejected", 1, 0, 1},
+		{"between scores", 1.5, 0, 0.75},
+		{"overlapping scores", 4, 0.25, 0.25},
+		{"above all scores", 7, 1, 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			point := rates.at(test.threshold)
+			if point.Threshold != test.threshold || point.FAR != test.far || point.FRR != test.frr {
+				t.Errorf("at(%v) = %+v, expected FAR %v and FRR %v", test.threshold, point, test.far, test.frr)
+			}
+		})
+	}
+}
+
+func TestNewErrorRates(t *testing.T) {
+	tests := []struct {
+		name     string
+		genuine  []float64
+		impostor []float64
+		wantErr  bool
+	}{
+		{"both trials", []float64{1}, []float64{2}, false},
+		{"no genuine trials", nil, []float64{2}, true},
+		{"no impostor trials", []float64{1}, nil, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := newErrorRates(test.genuine, test.impostor)
+			if (err != nil) != test.wantErr {
+				t.Errorf("newErrorRates() error = %v, wantErr %v", err, test.wantErr)
+			}
+		})
+	}
+}
+
+func TestEqualErrorRate(t *testing.T) {
+	tests := []struct {
+		name      string
+		genuine   []float64
+		impostor  []float64
+		eer       float64
+		threshold float64
+		points    int
+	}{
+		{"separated", []float64{1, 2, 3}, []float64{4, 5, 6}, 0, 4, 7},
+		{"overlapping", []float64{1, 2, 3, 4}, []float64{3, 4, 5, 6}, 0.25, 4, 7},
+		{"duplicate scores", []float64{1, 1, 2}, []float64{2, 3, 3}, 1.0 / 6, 2, 4},
+		{"swapped", []float64{4, 5, 6}, []float64{1, 2, 3}, 1, 4, 7},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rates, err := newErrorRates(test.genuine, test.impostor)
+			if err != nil {
+				t.Fatal(err)
+			}
+			points := rates.curve()
+			if len(points) != test.points {
+				t.Errorf("curve has %v points, expected %v", len(points), test.points)
+			}
+
+			eer, point := equalErrorRate(points)
+			if math.Abs(eer-test.eer) > 1e-9 || point.Threshold != test.threshold {
+				t.Errorf("equalErrorRate() = %v at %v, expected %v at %v", eer, point.Threshold, test.eer, test.threshold)
+			}
+		})
+	}
+}
+
+func TestThresholdForFAR(t *testing.T) {
+	rates, err := newErrorRates([]float64{1, 2, 3, 4}, []float64{3, 4, 5, 6})
+	if err != nil {
+		t.Fatal(err)
+	}
+	points := rates.curve()
+
+	tests := []struct {
+		name      string
+		targetFar float64
+		threshold float64
+		frr       float64
+	}{
+		{"no false accepts", 0, 3, 0.5},
+		{"a quarter of false accepts", 0.25, 4, 0.25},
+		{"half of false accepts", 0.5, 5, 0},
+		{"any false accepts", 1, 5, 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			point := thresholdForFAR(points, test.targetFar)
+			if point.Threshold != test.threshold || point.FRR != test.frr {
+				t.Errorf("thresholdForFAR(%v) = %+v, expected threshold %v with FRR %v", test.targetFar, point, test.threshold, test.frr)
+			}
+		})
+	}
+}
+
+func TestDownsample(t *testing.T) {
+	points := make([]*DETPoint, 7)
+	for i := range points {
+		points[i] = &DETPoint{Threshold: float64(i)}
+	}
+
+	tests := []struct {
+		name       string
+		max        int
+		thresholds []float64
+	}{
+		{"fewer points than the maximum", 10, []float64{0, 1, 2, 3, 4, 5, 6}},
+		{"both ends are kept", 3, []float64{0, 3, 6}},
+		{"evenly spread", 4, []float64{0, 2, 4, 6}},
+		{"maximum too small", 1, []float64{0, 1, 2, 3, 4, 5, 6}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			sampled := downsample(points, test.max)
+			if len(sampled) != len(test.thresholds) {
+				t.Fatalf("got %v points, expected %v", len(sampled), len(test.thresholds))
+			}
+			for i, point := range sampled {
+				if point.Threshold != test.thresholds[i] {
+					t.Errorf("point %v has threshold %v, expected %v", i, point.Threshold, test.thresholds[i])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,103 @@
+package eval
+
+import (
+	"fmt"
+	"ht/helper"
+	"ht/model"
+	"ht/server/database"
+
+	"github.com/google/uuid"
+)
+
+func newEvalDBHandlerFromEnv() *EvalDBHandler {
+	identificationDb := database.NewDatabase(
+		"eval identificationAttempt",
+		&database.DatabaseConfiguration{
+			Host:     helper.GetEnvVariable("DB_IDENTIFICATION_HOST"),
+			Port:     helper.GetEnvVariable("DB_IDENTIFICATION_PORT"),
+			Database: helper.GetEnvVariable("DB_IDENTIFICATION_DATABASE"),
+			Username: helper.GetEnvVariable("DB_IDENTIFICATION_USERNAME"),
+			Password: helper.GetEnvVariable("DB_IDENTIFICATION_PASSWORD"),
+			Schema:   helper.GetEnvVariable("DB_IDENTIFICATION_SCHEMA"),
+		},
+	)
+	userDb := database.NewDatabase(
+		"eval user",
+		&database.DatabaseConfiguration{
+			Host:     helper.GetEnvVariable("DB_USER_HOST"),
+			Port:     helper.GetEnvVariable("DB_USER_PORT"),
+			Database: helper.GetEnvVariable("DB_USER_DATABASE"),
+			Username: helper.GetEnvVariable("DB_USER_USERNAME"),
+			Password: helper.GetEnvVariable("DB_USER_PASSWORD"),
+			Schema:   helper.GetEnvVariable("DB_USER_SCHEMA"),
+		},
+	)
+	return newEvalDBHandler(identificationDb, userDb)
+}
+
+// loadReplay turns the latest decided identification attempts into probes and the enrollment
+// of their users into templates. The attempt is assumed to be spoken by the logged in user,
+// users are replaced by pseudonyms so the report can be shared.
+func loadReplay(evalDb EvalDBHandlerFunctions, limit int) ([]*labelledRecording, error) {
+	identificationAttempts, err := evalDb.SelectDecidedIdentificationAttempts(limit)
+	if err != nil {
+		return nil, err
+	}
+
+	pseudonyms := map[uuid.UUID]string{}
+	userRids := []uuid.UUID{}
+	pseudonymOf := func(userRid uuid.UUID) string {
+		if pseudonym, ok := pseudonyms[userRid]; ok {
+			return pseudonym
+		}
+		userRids = append(userRids, userRid)
+		pseudonyms[userRid] = fmt.Sprintf("user-%03d", len(userRids))
+		return pseudonyms[userRid]
+	}
+
+	recordings := []*labelledRecording{}
+	for _, identificationAttempt := range identificationAttempts {
+		recordings = append(recordings, storedRecording(
+			pseudonymOf(identificationAttempt.UserRID),
+			roleProbe,
+			fmt.Sprintf("attempt-%v", identificationAttempt.ID),
+			identificationAttempt.Recording,
+			identificationAttempt.RecordingMfcc,
+			identificationAttempt.Extractor,
+		))
+	}
+
+	if len(userRids) == 0 {
+		return recordings, nil
+	}
+
+	referenceSamples, err := evalDb.SelectEnrollmentReferenceSamples(userRids)
+	if err != nil {
+		return nil, err
+	}
+	for _, referenceSample := range referenceSamples {
+		recordings = append(recordings, storedRecording(
+			pseudonyms[referenceSample.UserRID],
+			roleEnroll,
+			fmt.Sprintf("reference-%v", referenceSample.ID),
+			referenceSample.Recording,
+			referenceSample.RecordingMfcc,
+			referenceSample.Extractor,
+		))
+	}
+
+	return recordings, nil
+}
+
+func storedRecording(speaker string, recordingRole role, name string, recording []byte, vector model.Vector, extractor model.Extractor) *labelledRecording {
+	return &labelledRecording{
+		Speaker: speaker,
+		Role:    recordingRole,
+		Name:    name,
+		Load: func() ([]byte, error) {
+			return recording, nil
+		},
+		Vector:    vector,
+		Extractor: extractor,
+	}
+}
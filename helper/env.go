@@ -25,3 +25,13 @@ func GetEnvVariable(name string) string {
 	}
 	return envVariable
 }
+
+// GetEnvVariableWithDefault returns the env variable or the default value if it is not set.
+// It is meant for optional, non secret configuration and does not remove the variable.
+func GetEnvVariableWithDefault(name string, defaultValue string) string {
+	envVariable := os.Getenv(name)
+	if len(strings.TrimSpace(envVariable)) == 0 {
+		return defaultValue
+	}
+	return strings.TrimSpace(envVariable)
+}
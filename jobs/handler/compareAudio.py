@@ -1,84 +1,103 @@
+import base64
 import logging
+from typing import List
 from uuid import UUID
 
-from fastapi import APIRouter, FastAPI, HTTPException
+from fastapi import APIRouter, BackgroundTasks, FastAPI, HTTPException
 from pydantic import BaseModel
-from tasks.db_helper import load_user_db_config, load_identification_db_config
-from tasks.db_identification_attempt import get_latest_identification_attempt, update_latest_identification_attempt
-from tasks.db_user import get_user, update_user, get_vector_dist
+from tasks.callback import post_callback
 
-from tasks.compare import convert_blob_to_librosa, preprocess_recording, extract_features
+from tasks.compare import EXTRACTOR, convert_blob_to_librosa, preprocess_recording, extract_features
 
 
 router = APIRouter()
 logger = logging.getLogger(__name__)
 
 
-class ProcessReferenceRecordingsRequest(BaseModel):
+def recording_to_mfcc(recording: str) -> List[float]:
+    """
+    Extract the features of a base64 encoded recording
+    """
+    recording, sr = convert_blob_to_librosa(base64.b64decode(recording))
+    preprocessed_recording, sr = preprocess_recording(recording, sr)
+    return extract_features(preprocessed_recording, sr).tolist()
+
+
+class ReferenceRecording(BaseModel):
     rid: UUID
+    recording: str
+    version: str
 
 
-@router.post("/jobs/processReferenceRecordings")
-async def process_reference_recordings(request: ProcessReferenceRecordingsRequest):
-    """
-    Process the reference recordings the user recorded on the website
-    """
-    try:
-        dbConfigUser = load_user_db_config()
+class ProcessReferenceRecordingsRequest(BaseModel):
+    user_rid: UUID
+    samples: List[ReferenceRecording]
+    callback_url: str
 
-        recordings = await get_user(dbConfigUser, request.rid)
 
-        preprocessed_recordings = []
-        for (recording, sr) in recordings:
-            preprocessed_recordings.append(preprocess_recording(recording, sr))
+def extract_reference_features(request: ProcessReferenceRecordingsRequest):
+    results = []
+    for sample in request.samples:
+        try:
+            results.append({"rid": str(sample.rid), "version": sample.version, "recording_mfcc": recording_to_mfcc(sample.recording), "error": ""})
+        except Exception as e:
+            logger.error(str(e))
+            results.append({"rid": str(sample.rid), "version": sample.version, "recording_mfcc": [], "error": str(e)})
 
-        mfccs = []
-        for (recording, sr) in preprocessed_recordings:
-            mfccs.append(extract_features(recording, sr))
+    post_callback(request.callback_url, {"user_rid": str(request.user_rid), "samples": results, "extractor": EXTRACTOR})
 
-        await update_user(dbConfigUser, request.rid, mfccs)
-    except Exception as e:
-        logger.error(str(e))
-        raise HTTPException(status_code=500, detail=str(e))
+
+@router.post("/jobs/processReferenceRecordings", status_code=202)
+async def process_reference_recordings(request: ProcessReferenceRecordingsRequest, background_tasks: BackgroundTasks):
+    """
+    Process the reference recordings the user recorded on the website.
+    The features are posted to the callback url of the Go server.
+    """
+    background_tasks.add_task(extract_reference_features, request)
 
 
 class IdentifyRequest(BaseModel):
     user_rid: UUID
+    rid: UUID
+    recording: str
+    callback_url: str
 
 
-@router.post("/jobs/identify")
-async def identify(request: IdentifyRequest):
-    """
-    To identify the user at login
-    """
+def extract_identification_features(request: IdentifyRequest):
     try:
-        dbConfigUser = load_user_db_config()
-        dbConfigIdentification = load_identification_db_config()
-        
-        attempt = await get_latest_identification_attempt(dbConfigIdentification, request.user_rid)
+        result = {"rid": str(request.rid), "recording_mfcc": recording_to_mfcc(request.recording), "extractor": EXTRACTOR, "error": ""}
+    except Exception as e:
+        logger.error(str(e))
+        result = {"rid": str(request.rid), "recording_mfcc": [], "extractor": EXTRACTOR, "error": str(e)}
 
-        recording, sr = convert_blob_to_librosa(attempt.recording)
+    post_callback(request.callback_url, result)
 
-        preprocessed_recording, sr = preprocess_recording(recording, sr)
 
-        mfcc = extract_features(preprocessed_recording, sr)
+@router.post("/jobs/identify", status_code=202)
+async def identify(request: IdentifyRequest, background_tasks: BackgroundTasks):
+    """
+    Extract the features of the identification attempt.
+    The features are posted to the callback url of the Go server, which makes the decision.
+    """
+    background_tasks.add_task(extract_identification_features, request)
 
-        dist = await get_vector_dist(dbConfigUser, request.user_rid, mfcc)
 
-        # TODO adjust threshold
-        logger.info(f"distance of identification: {dist}")
-        identified = False
-        if dist < 5:
-            identified = True
+class ExtractFeaturesRequest(BaseModel):
+    recording: str
 
-        await update_latest_identification_attempt(dbConfigIdentification, attempt.rid, identified, mfcc)
 
-        attempt = await get_latest_identification_attempt(dbConfigIdentification, request.user_rid)
-        print(attempt.toString())
+@router.post("/jobs/extractFeatures")
+def extract_features_sync(request: ExtractFeaturesRequest):
+    """
+    Extract the features of a recording synchronously, used by the voice login.
+    Recordings that can not be decoded are answered with 422.
+    """
+    try:
+        return {"recording_mfcc": recording_to_mfcc(request.recording), "extractor": EXTRACTOR}
     except Exception as e:
         logger.error(str(e))
-        raise HTTPException(status_code=500, detail=str(e))
+        raise HTTPException(status_code=422, detail=str(e))
 
 
 app = FastAPI()
-app.include_router(router)
\ No newline at end of file
+app.include_router(router)
@@ -0,0 +1,51 @@
+import hashlib
+import hmac
+import json
+import logging
+import os
+import time
+from typing import Dict
+
+import httpx
+
+
+logger = logging.getLogger(__name__)
+
+MAX_TRIES = 3
+
+
+def sign(secret: bytes, timestamp: int, body: bytes) -> str:
+    """
+    HMAC-SHA256 of the timestamp and body separated by a dot, as verified by the Go server
+    """
+    return hmac.new(secret, str(timestamp).encode() + b"." + body, hashlib.sha256).hexdigest()
+
+
+def post_callback(url: str, payload: Dict[str, any]):
+    """
+    Post a signed result to the callback endpoint of the Go server.
+    Every try is signed again, the server rejects replayed signatures.
+    """
+    secret = os.environ.get("JOBS_CALLBACK_SECRET", "").encode()
+    if not secret:
+        raise Exception("JOBS_CALLBACK_SECRET is not set")
+
+    body = json.dumps(payload).encode()
+    for attempt in range(1, MAX_TRIES + 1):
+        timestamp = int(time.time())
+        headers = {
+            "Content-Type": "application/json",
+            "X-Jobs-Timestamp": str(timestamp),
+            "X-Jobs-Signature": sign(secret, timestamp, body),
+        }
+        try:
+            response = httpx.post(url, content=body, headers=headers, timeout=30)
+            if response.status_code < 500:
+                if response.status_code >= 300:
+                    logger.error(f"callback {url} rejected with {response.status_code}: {response.text}")
+                return
+            logger.error(f"callback {url} failed with {response.status_code}: {response.text}")
+        except httpx.HTTPError as e:
+            logger.error(f"callback {url} failed: {str(e)}")
+        time.sleep(2 ** attempt)
+    raise Exception(f"callback {url} failed {MAX_TRIES} times")
@@ -1,13 +1,20 @@
+import os
+import tempfile
 from typing import Tuple
 import numpy as np
 import librosa
 from pydub import AudioSegment
 
 
+# vectors are tagged with the extractor, bump the version when the features change
+EXTRACTOR = "librosa-mfcc@1"
+DIMENSION = int(os.environ.get("VOICE_DIMENSION", "40"))
+
+
 def extract_features(y: np.ndarray, sr: float) -> np.ndarray:
     if y is None or sr is None or sr == 0:
         return np.array([])
-    mfccs = librosa.feature.mfcc(y=y, sr=sr, n_mfcc=40) 
+    mfccs = librosa.feature.mfcc(y=y, sr=sr, n_mfcc=DIMENSION) 
     mfccs_mean = np.mean(mfccs.T, axis=0) 
     return mfccs_mean 
 
@@ -22,9 +29,14 @@ def preprocess_recording(y: np.ndarray, sr: float) -> Tuple[np.ndarray, float]:
 def convert_blob_to_librosa(blob):
     if not blob:
         return (None, None)
-    with open('temp.webm', 'ab') as f:
-        f.write(blob)
-        webm: AudioSegment = AudioSegment.from_file('temp.webm', format='webm')
-        webm.export('temp.wav', format='wav')
-        y, sr = librosa.load('temp.wav', sr=None)
+    # background tasks run concurrently, every conversion gets its own files
+    with tempfile.TemporaryDirectory() as directory:
+        input_path = os.path.join(directory, 'temp.input')
+        wav_path = os.path.join(directory, 'temp.wav')
+        with open(input_path, 'wb') as f:
+            f.write(blob)
+        # browsers upload wav, older recordings are webm, ffmpeg detects the format
+        audio: AudioSegment = AudioSegment.from_file(input_path)
+        audio.export(wav_path, format='wav')
+        y, sr = librosa.load(wav_path, sr=None)
     return y, sr
@@ -2,8 +2,19 @@ package main
 
 import (
 	"ht/api"
+	"ht/eval"
+	"log"
+	"os"
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "eval" {
+		err := eval.Run(os.Args[2:])
+		if err != nil {
+			log.Fatal(err.Error())
+		}
+		return
+	}
+
 	api.StartServer()
 }
@@ -0,0 +1,61 @@
+package model
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type AccountRecoveryState string
+
+const (
+	// AccountRecoveryStateChallenged is a recovery waiting for the recording of its challenge sentence.
+	AccountRecoveryStateChallenged AccountRecoveryState = "challenged"
+	// AccountRecoveryStatePending is a recovery whose voice was verified, it can be completed after the delay.
+	AccountRecoveryStatePending AccountRecoveryState = "pending"
+	// AccountRecoveryStateCompleted is a recovery which set the new credentials.
+	AccountRecoveryStateCompleted AccountRecoveryState = "completed"
+	// AccountRecoveryStateCancelled is a recovery cancelled from the email address of the account.
+	AccountRecoveryStateCancelled AccountRecoveryState = "cancelled"
+	// AccountRecoveryStateFailed is a recovery with too many failed verifications.
+	AccountRecoveryStateFailed AccountRecoveryState = "failed"
+	// AccountRecoveryStateExpired is a recovery which was not completed in time or replaced by a new one.
+	AccountRecoveryStateExpired AccountRecoveryState = "expired"
+)
+
+// IsOpen returns true if the recovery can still be verified or completed.
+func (r AccountRecoveryState) IsOpen() bool {
+	return r == AccountRecoveryStateChallenged || r == AccountRecoveryStatePending
+}
+
+// AccountRecovery lets users who lost access to their email address set new credentials by voice.
+// The voice is verified against a fresh challenge sentence with a stricter threshold, the new credentials
+// can only be set after a delay, during which the recovery can be cancelled from the old email address.
+type AccountRecovery struct {
+	ID       int                  `json:"id"`
+	RID      uuid.UUID            `json:"rid"`
+	UserRID  uuid.UUID            `json:"user_rid"`
+	State    AccountRecoveryState `json:"state"`
+	Sentence string               `json:"sentence"`
+	// Failures counts failed voice verifications and wrong codes.
+	Failures int     `json:"failures"`
+	Score    float64 `json:"score"`
+	// Decoy is started for an email which can not be recovered, it looks like any other recovery but never verifies.
+	Decoy bool `json:"-"`
+	// ChallengedAt is the time the sentence was issued, AvailableAt and ExpiresAt limit the completion.
+	ChallengedAt time.Time `json:"challenged_at"`
+	AvailableAt  time.Time `json:"available_at"`
+	ExpiresAt    time.Time `json:"expires_at"`
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+}
+
+// IsAvailable returns true if the pending recovery can be completed at the time.
+func (r *AccountRecovery) IsAvailable(now time.Time) bool {
+	return r.State == AccountRecoveryStatePending && !now.Before(r.AvailableAt) && now.Before(r.ExpiresAt)
+}
+
+// IsExpired returns true if the pending recovery was not completed in time.
+func (r *AccountRecovery) IsExpired(now time.Time) bool {
+	return r.State == AccountRecoveryStatePending && !now.Before(r.ExpiresAt)
+}
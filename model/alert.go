@@ -0,0 +1,34 @@
+package model
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type AlertType string
+
+const (
+	// AlertTypeWatchlistHit is raised for enrollments and identification attempts matching the fraud watchlist.
+	AlertTypeWatchlistHit AlertType = "watchlist_hit"
+	// AlertTypeDuress is raised for identifications with the duress sentence of a user, who might be coerced.
+	AlertTypeDuress AlertType = "duress"
+)
+
+// AlertPriority tells the receiver how urgently the alert has to be handled.
+type AlertPriority string
+
+const (
+	AlertPriorityNormal AlertPriority = "normal"
+	AlertPriorityHigh   AlertPriority = "high"
+)
+
+// Alert is sent to the fraud team for events which need attention right away.
+type Alert struct {
+	RID       uuid.UUID      `json:"rid"`
+	Type      AlertType      `json:"type"`
+	Priority  AlertPriority  `json:"priority"`
+	UserRID   uuid.UUID      `json:"user_rid"`
+	Details   map[string]any `json:"details"`
+	CreatedAt time.Time      `json:"created_at"`
+}
@@ -0,0 +1,58 @@
+package model
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type AuditAction string
+
+const (
+	AuditActionTemplateAdapted            AuditAction = "template_adapted"
+	AuditActionTemplateSampleDropped      AuditAction = "template_sample_dropped"
+	AuditActionTemplateAdaptationReverted AuditAction = "template_adaptation_reverted"
+	AuditActionTemplateAdaptationToggled  AuditAction = "template_adaptation_toggled"
+	AuditActionTemplateRefreshed          AuditAction = "template_refreshed"
+	AuditActionTemplateRefreshDue         AuditAction = "template_refresh_due"
+	AuditActionTemplateRefreshReverted    AuditAction = "template_refresh_reverted"
+	AuditActionVoiceLockedOut             AuditAction = "voice_locked_out"
+	AuditActionVoiceUnlocked              AuditAction = "voice_unlocked"
+	AuditActionVoiceProfileCreated        AuditAction = "voice_profile_created"
+	AuditActionVoiceProfileRenamed        AuditAction = "voice_profile_renamed"
+	AuditActionVoiceProfileRetrained      AuditAction = "voice_profile_retrained"
+	AuditActionVoiceProfileDeleted        AuditAction = "voice_profile_deleted"
+	AuditActionAssistedVerification       AuditAction = "assisted_verification"
+	AuditActionAssistedVerificationResult AuditAction = "assisted_verification_result"
+	AuditActionBatchVerificationCreated   AuditAction = "batch_verification_created"
+	AuditActionBatchVerificationResult    AuditAction = "batch_verification_result"
+	AuditActionBatchResultDownloaded      AuditAction = "batch_result_downloaded"
+	AuditActionDuplicateVoiceDetected     AuditAction = "duplicate_voice_detected"
+	AuditActionDuplicateReviewResolved    AuditAction = "duplicate_review_resolved"
+	AuditActionWatchlistEntryAdded        AuditAction = "watchlist_entry_added"
+	AuditActionWatchlistEntryDeactivated  AuditAction = "watchlist_entry_deactivated"
+	AuditActionWatchlistHit               AuditAction = "watchlist_hit"
+	AuditActionUserStatusChanged          AuditAction = "user_status_changed"
+	AuditActionDuressSignalled            AuditAction = "duress_signalled"
+	AuditActionAccountRecoveryStarted     AuditAction = "account_recovery_started"
+	AuditActionAccountRecoveryFailed      AuditAction = "account_recovery_failed"
+	AuditActionAccountRecoveryVerified    AuditAction = "account_recovery_verified"
+	AuditActionAccountRecoveryCancelled   AuditAction = "account_recovery_cancelled"
+	AuditActionAccountRecoveryCompleted   AuditAction = "account_recovery_completed"
+	AuditActionLoginFallbackUsed          AuditAction = "login_fallback_used"
+	AuditActionLoginFallbackFailed        AuditAction = "login_fallback_failed"
+	AuditActionEmailChanged               AuditAction = "email_changed"
+	AuditActionAccountDeleted             AuditAction = "account_deleted"
+)
+
+// AuditEvent is an entry of the audit trail. The actor is the user who did the action,
+// the subject the user affected by it. Both are the same for actions of a user on their own account.
+type AuditEvent struct {
+	ID         int            `json:"id"`
+	RID        uuid.UUID      `json:"rid"`
+	ActorRID   uuid.UUID      `json:"actor_rid"`
+	SubjectRID uuid.UUID      `json:"subject_rid"`
+	Action     AuditAction    `json:"action"`
+	Details    map[string]any `json:"details"`
+	CreatedAt  time.Time      `json:"created_at"`
+}
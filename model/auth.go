@@ -6,11 +6,28 @@ import (
 	"github.com/google/uuid"
 )
 
+type Role string
+
+const (
+	RoleUser  Role = "user"
+	RoleAdmin Role = "admin"
+	// RoleAgent may verify callers against their voice in the agent console.
+	RoleAgent Role = "agent"
+	// RoleFraud may verify batches of recorded interactions against their claimed identities.
+	RoleFraud Role = "fraud"
+)
+
 type Session struct {
 	Authenticated bool
 	UserID        uuid.UUID
 	EmailVerified bool
 	CreatedAt     time.Time
+	// Restricted is set for sessions started under duress, they can not change the account.
+	Restricted bool
+	// VoiceVerifiedAt is the time the voice of the user was last accepted in this session.
+	VoiceVerifiedAt time.Time
+	// SecondFactorPending is set for password logins which still need the voice of the user.
+	SecondFactorPending bool
 }
 
 type Auth struct {
@@ -28,6 +45,7 @@ type Auth struct {
 	EmailVerificationCodeHash    string    `json:"-"`
 	EmailVerificationRequestDate time.Time `json:"-"`
 	EmailToChangeTo              string    `json:"-"`
+	Role                         Role      `json:"role"`
 	CreatedAt                    time.Time `json:"created_at"`
 	UpdatedAt                    time.Time `json:"updated_at"`
 	// input fields not saved
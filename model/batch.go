@@ -0,0 +1,85 @@
+package model
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type BatchState string
+
+const (
+	// BatchStateQueued is a batch waiting for a batch worker.
+	BatchStateQueued BatchState = "queued"
+	// BatchStateRunning is a batch whose items are being verified.
+	BatchStateRunning BatchState = "running"
+	// BatchStateCompleted is a batch with a verdict for every item.
+	BatchStateCompleted BatchState = "completed"
+	// BatchStateFailed is a batch that could not be processed, items without verdict are left pending.
+	BatchStateFailed BatchState = "failed"
+)
+
+// IsFinal returns true if the batch will not be processed anymore.
+func (r BatchState) IsFinal() bool {
+	return r == BatchStateCompleted || r == BatchStateFailed
+}
+
+// Batch is a bulk verification of offline recordings against claimed identities, e.g. recorded
+// calls checked by the fraud team. It is processed on the batch queue, apart from interactive jobs.
+type Batch struct {
+	ID             int        `json:"id"`
+	RID            uuid.UUID  `json:"rid"`
+	ActorRID       uuid.UUID  `json:"actor_rid"`
+	Name           string     `json:"name"`
+	State          BatchState `json:"state"`
+	ItemCount      int        `json:"item_count"`
+	ProcessedCount int        `json:"processed_count"`
+	AcceptedCount  int        `json:"accepted_count"`
+	RejectedCount  int        `json:"rejected_count"`
+	ErrorCount     int        `json:"error_count"`
+	Error          string     `json:"error"`
+	CompletedAt    time.Time  `json:"completed_at"`
+	CreatedAt      time.Time  `json:"created_at"`
+	UpdatedAt      time.Time  `json:"updated_at"`
+}
+
+// Progress returns the processed fraction of the items.
+func (r *Batch) Progress() float64 {
+	if r.ItemCount == 0 {
+		return 1
+	}
+	return float64(r.ProcessedCount) / float64(r.ItemCount)
+}
+
+type BatchItemVerdict string
+
+const (
+	// BatchItemVerdictPending is an item not verified yet.
+	BatchItemVerdictPending BatchItemVerdict = "pending"
+	// BatchItemVerdictAccepted is an item whose recording matches the claimed identity.
+	BatchItemVerdictAccepted BatchItemVerdict = "accepted"
+	// BatchItemVerdictRejected is an item whose recording does not match the claimed identity.
+	BatchItemVerdictRejected BatchItemVerdict = "rejected"
+	// BatchItemVerdictError is an item that could not be verified, e.g. an unknown identity.
+	BatchItemVerdictError BatchItemVerdict = "error"
+)
+
+// BatchItem is one line of the manifest of a batch. The recording is dropped once it is verified.
+type BatchItem struct {
+	ID           int              `json:"id"`
+	RID          uuid.UUID        `json:"rid"`
+	BatchRID     uuid.UUID        `json:"batch_rid"`
+	Position     int              `json:"position"`
+	Reference    string           `json:"reference"`
+	ClaimedEmail string           `json:"claimed_email"`
+	File         string           `json:"file"`
+	Recording    []byte           `json:"recording"`
+	Channel      Channel          `json:"channel"`
+	Verdict      BatchItemVerdict `json:"verdict"`
+	Score        float64          `json:"score"`
+	Threshold    float64          `json:"threshold"`
+	ProfileRID   uuid.UUID        `json:"profile_rid"`
+	Error        string           `json:"error"`
+	CreatedAt    time.Time        `json:"created_at"`
+	UpdatedAt    time.Time        `json:"updated_at"`
+}
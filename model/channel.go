@@ -0,0 +1,23 @@
+package model
+
+// Channel is the audio bandwidth a recording was captured with. Features of recordings
+// of different channels differ even for the same speaker.
+type Channel string
+
+const (
+	// ChannelWideband is a recording of the browser, usually 16 kHz or more.
+	ChannelWideband Channel = "wideband"
+	// ChannelNarrowband is a telephony recording with 8 kHz, e.g. G.711 µ-law or A-law of a call center.
+	ChannelNarrowband Channel = "narrowband"
+)
+
+// NarrowbandMaxSampleRate is the highest sample rate a recording is treated as narrowband with.
+const NarrowbandMaxSampleRate = 8000
+
+// ChannelForSampleRate returns the channel of a recording with the sample rate.
+func ChannelForSampleRate(sampleRate int) Channel {
+	if sampleRate <= NarrowbandMaxSampleRate {
+		return ChannelNarrowband
+	}
+	return ChannelWideband
+}
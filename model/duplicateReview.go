@@ -0,0 +1,113 @@
+package model
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// DuplicateAction is applied to an account whose enrolled voice matches the voice of other accounts.
+type DuplicateAction string
+
+const (
+	// DuplicateActionAllow only puts the account into the review queue.
+	DuplicateActionAllow DuplicateAction = "allow"
+	// DuplicateActionHold holds the voice identification of the account until the review is resolved.
+	DuplicateActionHold DuplicateAction = "hold"
+	// DuplicateActionBlock blocks the voice identification of the account, the review can release it.
+	DuplicateActionBlock DuplicateAction = "block"
+)
+
+// Status returns the status of an account the action is applied to.
+func (r DuplicateAction) Status() UserStatus {
+	switch r {
+	case DuplicateActionHold:
+		return UserStatusHeld
+	case DuplicateActionBlock:
+		return UserStatusBlocked
+	default:
+		return UserStatusActive
+	}
+}
+
+func (r DuplicateAction) Validate() error {
+	switch r {
+	case DuplicateActionAllow, DuplicateActionHold, DuplicateActionBlock:
+		return nil
+	}
+	return fmt.Errorf("unknown duplicate action: %v", r)
+}
+
+type DuplicateReviewState string
+
+const (
+	DuplicateReviewStateOpen DuplicateReviewState = "open"
+	// DuplicateReviewStateConfirmed is a review whose account was found to be a duplicate, it is blocked.
+	DuplicateReviewStateConfirmed DuplicateReviewState = "confirmed"
+	// DuplicateReviewStateDismissed is a review whose account was found to be legitimate, it is active again.
+	DuplicateReviewStateDismissed DuplicateReviewState = "dismissed"
+)
+
+// DuplicateMatch is another account with a voice close to the enrolled one.
+type DuplicateMatch struct {
+	UserRID  uuid.UUID `json:"user_rid"`
+	Distance float64   `json:"distance"`
+	// Samples is the number of enrolled samples the account was close to.
+	Samples int `json:"samples"`
+}
+
+// DuplicateMatches are the linked accounts of a review ordered by distance.
+// They are stored as JSONB.
+type DuplicateMatches []*DuplicateMatch
+
+// Value implements driver.Valuer.
+func (r DuplicateMatches) Value() (driver.Value, error) {
+	if r == nil {
+		return []byte("[]"), nil
+	}
+	return json.Marshal(r)
+}
+
+// Scan implements sql.Scanner, NULL is scanned into no matches.
+func (r *DuplicateMatches) Scan(src any) error {
+	*r = DuplicateMatches{}
+	switch value := src.(type) {
+	case nil:
+		return nil
+	case []byte:
+		return json.Unmarshal(value, r)
+	case string:
+		return json.Unmarshal([]byte(value), r)
+	default:
+		return fmt.Errorf("invalid type for duplicate matches: %T", src)
+	}
+}
+
+// DuplicateReview is an enrollment whose voice matches the voice of other accounts,
+// queued for the fraud team to confirm or dismiss.
+type DuplicateReview struct {
+	ID         int                  `json:"id"`
+	RID        uuid.UUID            `json:"rid"`
+	UserRID    uuid.UUID            `json:"user_rid"`
+	ProfileRID uuid.UUID            `json:"profile_rid"`
+	Action     DuplicateAction      `json:"action"`
+	State      DuplicateReviewState `json:"state"`
+	Matches    DuplicateMatches     `json:"matches"`
+	// ReviewerRID is the user who resolved the review, Comment the reason given.
+	ReviewerRID uuid.UUID `json:"reviewer_rid"`
+	Comment     string    `json:"comment"`
+	ReviewedAt  time.Time `json:"reviewed_at"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// Distance returns the distance of the closest linked account.
+func (r *DuplicateReview) Distance() float64 {
+	if len(r.Matches) == 0 {
+		return 0
+	}
+	return r.Matches[0].Distance
+}
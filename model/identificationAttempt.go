@@ -6,13 +6,113 @@ import (
 	"github.com/google/uuid"
 )
 
+type IdentificationAttemptState string
+
+const (
+	// IdentificationAttemptStatePending is a recorded attempt waiting for processing.
+	IdentificationAttemptStatePending IdentificationAttemptState = "pending"
+	// IdentificationAttemptStateProcessing is an attempt the features are extracted and matched for.
+	IdentificationAttemptStateProcessing IdentificationAttemptState = "processing"
+	// IdentificationAttemptStateAccepted is an attempt matching the references of the user.
+	IdentificationAttemptStateAccepted IdentificationAttemptState = "accepted"
+	// IdentificationAttemptStateRejected is an attempt not matching the references of the user.
+	IdentificationAttemptStateRejected IdentificationAttemptState = "rejected"
+	// IdentificationAttemptStateStepUp is a matching attempt whose risk requires a one-time code.
+	IdentificationAttemptStateStepUp IdentificationAttemptState = "step_up"
+	// IdentificationAttemptStateExpired is an attempt that was not decided in time.
+	IdentificationAttemptStateExpired IdentificationAttemptState = "expired"
+	// IdentificationAttemptStateError is an attempt that could not be processed.
+	IdentificationAttemptStateError IdentificationAttemptState = "error"
+)
+
+var identificationAttemptTransitions = map[IdentificationAttemptState][]IdentificationAttemptState{
+	IdentificationAttemptStatePending: {
+		IdentificationAttemptStateProcessing,
+		IdentificationAttemptStateExpired,
+		IdentificationAttemptStateError,
+	},
+	IdentificationAttemptStateProcessing: {
+		IdentificationAttemptStateAccepted,
+		IdentificationAttemptStateRejected,
+		IdentificationAttemptStateStepUp,
+		IdentificationAttemptStateExpired,
+		IdentificationAttemptStateError,
+	},
+	IdentificationAttemptStateStepUp: {
+		IdentificationAttemptStateAccepted,
+		IdentificationAttemptStateRejected,
+		IdentificationAttemptStateExpired,
+	},
+}
+
+// CanTransitionTo returns true if an attempt in this state is allowed to change to the next state.
+func (r IdentificationAttemptState) CanTransitionTo(next IdentificationAttemptState) bool {
+	for _, allowed := range identificationAttemptTransitions[r] {
+		if allowed == next {
+			return true
+		}
+	}
+	return false
+}
+
+// IsFinal returns true if the state can not change anymore.
+func (r IdentificationAttemptState) IsFinal() bool {
+	return len(identificationAttemptTransitions[r]) == 0
+}
+
 type IdentificationAttempt struct {
-	ID         int       `json:"id"`
-	RID        uuid.UUID `json:"rid"`
-	UserRID    uuid.UUID `json:"user_rid"`
-	Recording  []byte    `json:"recording"`
-	Identified bool      `json:"identified"`
-	Used       bool      `json:"used"`
-	CreatedAt  time.Time `json:"created_at"`
-	UpdatedAt  time.Time `json:"updated_at"`
+	ID                int                        `json:"id"`
+	RID               uuid.UUID                  `json:"rid"`
+	UserRID           uuid.UUID                  `json:"user_rid"`
+	Recording         []byte                     `json:"recording"`
+	RecordingMfcc     Vector                     `json:"recording_mfcc"`
+	Extractor         Extractor                  `json:"extractor"`
+	Channel           Channel                    `json:"channel"`
+	State             IdentificationAttemptState `json:"state"`
+	Score             float64                    `json:"score"`
+	IPAddress         string                     `json:"ip_address"`
+	Country           string                     `json:"country"`
+	DeviceFingerprint string                     `json:"device_fingerprint"`
+	RiskScore         float64                    `json:"risk_score"`
+	RiskAction        RiskAction                 `json:"risk_action"`
+	RiskFactors       RiskFactors                `json:"risk_factors"`
+	ProfileRID        uuid.UUID                  `json:"profile_rid"`
+	AgentRID          uuid.UUID                  `json:"agent_rid"`
+	// Duress is set if the accepted recording said the duress sentence of the user, who still sees the normal result.
+	Duress bool `json:"-"`
+	// WatchlistEntryRID is the fraud watchlist entry the voice matched, it is never shown to the user.
+	WatchlistEntryRID uuid.UUID `json:"-"`
+	WatchlistDistance float64   `json:"-"`
+	Error             string    `json:"error"`
+	JobRID            uuid.UUID `json:"job_rid"`
+	ProcessingAt      time.Time `json:"processing_at"`
+	StepUpAt          time.Time `json:"step_up_at"`
+	AcceptedAt        time.Time `json:"accepted_at"`
+	RejectedAt        time.Time `json:"rejected_at"`
+	ExpiredAt         time.Time `json:"expired_at"`
+	ErrorAt           time.Time `json:"error_at"`
+	CreatedAt         time.Time `json:"created_at"`
+	UpdatedAt         time.Time `json:"updated_at"`
+}
+
+// IsAccepted returns true if the attempt matched the references of the user.
+func (r *IdentificationAttempt) IsAccepted() bool {
+	return r.State == IdentificationAttemptStateAccepted
+}
+
+// IsWatchlisted returns true if the voice of the attempt matched the fraud watchlist.
+func (r *IdentificationAttempt) IsWatchlisted() bool {
+	return r.WatchlistEntryRID != uuid.Nil
+}
+
+// IsAssisted returns true if an agent verified the user with the attempt in the agent console.
+func (r *IdentificationAttempt) IsAssisted() bool {
+	return r.AgentRID != uuid.Nil
+}
+
+// IdentificationAttemptStateChange is published whenever an attempt changes its state.
+type IdentificationAttemptStateChange struct {
+	RID     uuid.UUID                  `json:"rid"`
+	UserRID uuid.UUID                  `json:"user_rid"`
+	State   IdentificationAttemptState `json:"state"`
 }
@@ -0,0 +1,66 @@
+package model
+
+import "testing"
+
+func TestIdentificationAttemptStateCanTransitionTo(t *testing.T) {
+	tests := []struct {
+		from     IdentificationAttemptState
+		to       IdentificationAttemptState
+		expected bool
+	}{
+		{IdentificationAttemptStatePending, IdentificationAttemptStateProcessing, true},
+		{IdentificationAttemptStatePending, IdentificationAttemptStateExpired, true},
+		{IdentificationAttemptStatePending, IdentificationAttemptStateError, true},
+		{IdentificationAttemptStatePending, IdentificationAttemptStateAccepted, false},
+		{IdentificationAttemptStatePending, IdentificationAttemptStateRejected, false},
+		{IdentificationAttemptStatePending, IdentificationAttemptStateStepUp, false},
+		{IdentificationAttemptStateProcessing, IdentificationAttemptStateAccepted, true},
+		{IdentificationAttemptStateProcessing, IdentificationAttemptStateRejected, true},
+		{IdentificationAttemptStateProcessing, IdentificationAttemptStateStepUp, true},
+		{IdentificationAttemptStateProcessing, IdentificationAttemptStateExpired, true},
+		{IdentificationAttemptStateProcessing, IdentificationAttemptStateError, true},
+		{IdentificationAttemptStateProcessing, IdentificationAttemptStatePending, false},
+		{IdentificationAttemptStateProcessing, IdentificationAttemptStateProcessing, false},
+		{IdentificationAttemptStateStepUp, IdentificationAttemptStateAccepted, true},
+		{IdentificationAttemptStateStepUp, IdentificationAttemptStateRejected, true},
+		{IdentificationAttemptStateStepUp, IdentificationAttemptStateExpired, true},
+		{IdentificationAttemptStateStepUp, IdentificationAttemptStateError, false},
+		{IdentificationAttemptStateStepUp, IdentificationAttemptStateProcessing, false},
+		{IdentificationAttemptStateAccepted, IdentificationAttemptStateRejected, false},
+		{IdentificationAttemptStateRejected, IdentificationAttemptStateAccepted, false},
+		{IdentificationAttemptStateExpired, IdentificationAttemptStateProcessing, false},
+		{IdentificationAttemptStateError, IdentificationAttemptStatePending, false},
+		{IdentificationAttemptState("unknown"), IdentificationAttemptStateAccepted, false},
+	}
+
+	for _, test := range tests {
+		t.Run(string(test.from)+" to "+string(test.to), func(t *testing.T) {
+			if got := test.from.CanTransitionTo(test.to); got != test.expected {
+				t.Errorf("%v.CanTransitionTo(%v) = %v, expected %v", test.from, test.to, got, test.expected)
+			}
+		})
+	}
+}
+
+func TestIdentificationAttemptStateIsFinal(t *testing.T) {
+	tests := []struct {
+		state    IdentificationAttemptState
+		expected bool
+	}{
+		{IdentificationAttemptStatePending, false},
+		{IdentificationAttemptStateProcessing, false},
+		{IdentificationAttemptStateStepUp, false},
+		{IdentificationAttemptStateAccepted, true},
+		{IdentificationAttemptStateRejected, true},
+		{IdentificationAttemptStateExpired, true},
+		{IdentificationAttemptStateError, true},
+	}
+
+	for _, test := range tests {
+		t.Run(string(test.state), func(t *testing.T) {
+			if got := test.state.IsFinal(); got != test.expected {
+				t.Errorf("%v.IsFinal() = %v, expected %v", test.state, got, test.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,88 @@
+package model
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type JobType string
+
+const (
+	// JobTypeProcessReferenceRecordings extracts the features of the reference samples of a user.
+	JobTypeProcessReferenceRecordings JobType = "process_reference_recordings"
+	// JobTypeIdentify extracts the features of an identification attempt and evaluates it.
+	JobTypeIdentify JobType = "identify"
+	// JobTypeReembedReferenceSamples recomputes the features of reference samples of other extractors.
+	JobTypeReembedReferenceSamples JobType = "reembed_reference_samples"
+	// JobTypeVerifyBatch verifies the next items of a batch of offline recordings.
+	JobTypeVerifyBatch JobType = "verify_batch"
+)
+
+// JobQueue separates the workers of job types, so bulk jobs can not delay the interactive ones.
+type JobQueue string
+
+const (
+	// JobQueueInteractive runs the jobs users are waiting for, it is the default.
+	JobQueueInteractive JobQueue = "interactive"
+	// JobQueueBatch runs bulk jobs on their own workers.
+	JobQueueBatch JobQueue = "batch"
+)
+
+type JobState string
+
+const (
+	// JobStateQueued is a job waiting for a worker, either new or scheduled for a retry.
+	JobStateQueued JobState = "queued"
+	// JobStateRunning is a job claimed by a worker.
+	JobStateRunning JobState = "running"
+	// JobStateSucceeded is a job that finished without error.
+	JobStateSucceeded JobState = "succeeded"
+	// JobStateDead is a job that failed on every attempt and is not retried anymore.
+	JobStateDead JobState = "dead"
+)
+
+type Job struct {
+	ID          int             `json:"id"`
+	RID         uuid.UUID       `json:"rid"`
+	Type        JobType         `json:"type"`
+	Payload     json.RawMessage `json:"payload"`
+	State       JobState        `json:"state"`
+	Attempts    int             `json:"attempts"`
+	MaxAttempts int             `json:"max_attempts"`
+	RunAt       time.Time       `json:"run_at"`
+	LockedAt    time.Time       `json:"locked_at"`
+	LastError   string          `json:"last_error"`
+	CreatedAt   time.Time       `json:"created_at"`
+	UpdatedAt   time.Time       `json:"updated_at"`
+}
+
+// IsFinished returns true if the job will not run again.
+func (r *Job) IsFinished() bool {
+	return r.State == JobStateSucceeded || r.State == JobStateDead
+}
+
+// ProcessReferenceRecordingsPayload is the payload of JobTypeProcessReferenceRecordings.
+type ProcessReferenceRecordingsPayload struct {
+	UserRID uuid.UUID `json:"user_rid"`
+}
+
+// IdentifyPayload is the payload of JobTypeIdentify.
+type IdentifyPayload struct {
+	UserRID    uuid.UUID `json:"user_rid"`
+	AttemptRID uuid.UUID `json:"attempt_rid"`
+}
+
+// VerifyBatchPayload is the payload of JobTypeVerifyBatch.
+// Every job verifies a chunk of the pending items and enqueues the next one.
+type VerifyBatchPayload struct {
+	BatchRID uuid.UUID `json:"batch_rid"`
+}
+
+// ReembedReferenceSamplesPayload is the payload of JobTypeReembedReferenceSamples.
+// Every job processes one batch after AfterID and enqueues the next one.
+type ReembedReferenceSamplesPayload struct {
+	Extractor Extractor `json:"extractor"`
+	AfterID   int       `json:"after_id"`
+}
@@ -0,0 +1,88 @@
+package model
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/google/uuid"
+)
+
+// DistanceMetric is the pgvector distance used to compare a recording with the references.
+type DistanceMetric string
+
+const (
+	DistanceMetricL2     DistanceMetric = "l2"
+	DistanceMetricCosine DistanceMetric = "cosine"
+)
+
+// Operator returns the pgvector operator of the metric.
+func (r DistanceMetric) Operator() (string, error) {
+	switch r {
+	case DistanceMetricL2:
+		return "<->", nil
+	case DistanceMetricCosine:
+		return "<=>", nil
+	default:
+		return "", fmt.Errorf("invalid distance metric: %v", r)
+	}
+}
+
+// Distance computes the distance of two vectors like the pgvector operator of the metric.
+func (r DistanceMetric) Distance(a Vector, b Vector) (float64, error) {
+	if len(a) != len(b) {
+		return 0, fmt.Errorf("different vector dimensions %v and %v", len(a), len(b))
+	}
+
+	switch r {
+	case DistanceMetricL2:
+		sum := 0.0
+		for i := range a {
+			difference := float64(a[i]) - float64(b[i])
+			sum += difference * difference
+		}
+		return math.Sqrt(sum), nil
+	case DistanceMetricCosine:
+		dot, normA, normB := 0.0, 0.0, 0.0
+		for i := range a {
+			dot += float64(a[i]) * float64(b[i])
+			normA += float64(a[i]) * float64(a[i])
+			normB += float64(b[i]) * float64(b[i])
+		}
+		if normA == 0 || normB == 0 {
+			return math.NaN(), nil
+		}
+		return 1 - dot/(math.Sqrt(normA)*math.Sqrt(normB)), nil
+	default:
+		return 0, fmt.Errorf("invalid distance metric: %v", r)
+	}
+}
+
+// OperatorClass returns the pgvector operator class of the metric for indexes.
+func (r DistanceMetric) OperatorClass() (string, error) {
+	switch r {
+	case DistanceMetricL2:
+		return "vector_l2_ops", nil
+	case DistanceMetricCosine:
+		return "vector_cosine_ops", nil
+	default:
+		return "", fmt.Errorf("invalid distance metric: %v", r)
+	}
+}
+
+// VoiceCandidate is a user found by the nearest neighbour search over all references,
+// Distance is the distance of their closest reference sample.
+type VoiceCandidate struct {
+	UserRID  uuid.UUID
+	Distance float64
+	// Duress is set if the recording said the duress sentence of the user.
+	Duress bool
+}
+
+// MatchingAggregation defines how the distances to all references are combined into one score.
+type MatchingAggregation string
+
+const (
+	MatchingAggregationMin  MatchingAggregation = "min"
+	MatchingAggregationMean MatchingAggregation = "mean"
+	MatchingAggregationKOfN MatchingAggregation = "k_of_n"
+)
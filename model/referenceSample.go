@@ -0,0 +1,75 @@
+package model
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type ReferenceSampleSource string
+
+const (
+	// ReferenceSampleSourceEnrollment is a sample recorded in the onboarding.
+	ReferenceSampleSourceEnrollment ReferenceSampleSource = "enrollment"
+	// ReferenceSampleSourceAdapted is a confident identification attempt added to the references.
+	ReferenceSampleSourceAdapted ReferenceSampleSource = "adapted"
+)
+
+// ReferenceSample is one reference recording of a voice profile of a user. Only enrollment samples have a step.
+type ReferenceSample struct {
+	ID                  int                   `json:"id"`
+	RID                 uuid.UUID             `json:"rid"`
+	UserRID             uuid.UUID             `json:"user_rid"`
+	ProfileRID          uuid.UUID             `json:"profile_rid"`
+	Step                int                   `json:"step"`
+	Source              ReferenceSampleSource `json:"source"`
+	AttemptRID          uuid.UUID             `json:"attempt_rid"`
+	Recording           []byte                `json:"recording"`
+	RecordingNormalised []byte                `json:"recording_normalised"`
+	RecordingMfcc       Vector                `json:"recording_mfcc"`
+	Extractor           Extractor             `json:"extractor"`
+	Channel             Channel               `json:"channel"`
+	CreatedAt           time.Time             `json:"created_at"`
+	UpdatedAt           time.Time             `json:"updated_at"`
+}
+
+// ReferenceSampleFeatures are the extracted features of a reference sample. Version is the
+// updated_at of the sample at extraction time, features of re-recorded samples are discarded.
+type ReferenceSampleFeatures struct {
+	RID           uuid.UUID
+	Version       time.Time
+	RecordingMfcc Vector
+	Extractor     Extractor
+	Error         string
+}
+
+// EnrollmentStatus describes how far a user got in recording the reference samples of a profile.
+type EnrollmentStatus struct {
+	ProfileRID  uuid.UUID
+	SampleCount int
+	MinSamples  int
+	MaxSamples  int
+}
+
+// IsComplete returns true if the user recorded at least the minimum number of samples.
+func (r *EnrollmentStatus) IsComplete() bool {
+	return r.SampleCount >= r.MinSamples
+}
+
+// CanRecordMore returns true if the maximum number of samples is not reached yet.
+func (r *EnrollmentStatus) CanRecordMore() bool {
+	return r.SampleCount < r.MaxSamples
+}
+
+// NextStep returns the step of the next sample to record, capped at the maximum.
+func (r *EnrollmentStatus) NextStep() int {
+	if r.SampleCount >= r.MaxSamples {
+		return r.MaxSamples
+	}
+	return r.SampleCount + 1
+}
+
+// IsValidStep returns true if the step can be recorded, steps can be re-recorded but not skipped.
+func (r *EnrollmentStatus) IsValidStep(step int) bool {
+	return step >= 1 && step <= r.MaxSamples && step <= r.SampleCount+1
+}
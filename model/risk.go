@@ -0,0 +1,79 @@
+package model
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// RiskAction is the consequence of the risk of an identification attempt, ordered by severity.
+type RiskAction string
+
+const (
+	// RiskActionAllow decides the attempt on the voice alone.
+	RiskActionAllow RiskAction = "allow"
+	// RiskActionTighten lowers the voice threshold of the attempt.
+	RiskActionTighten RiskAction = "tighten"
+	// RiskActionStepUp requires a one-time code after a matching voice.
+	RiskActionStepUp RiskAction = "step_up"
+	// RiskActionBlock rejects the attempt regardless of the voice.
+	RiskActionBlock RiskAction = "block"
+)
+
+var riskActionSeverity = map[RiskAction]int{
+	RiskActionAllow:   0,
+	RiskActionTighten: 1,
+	RiskActionStepUp:  2,
+	RiskActionBlock:   3,
+}
+
+// AtLeast returns true if the action is as severe as the other one, unknown actions are treated as allow.
+func (r RiskAction) AtLeast(other RiskAction) bool {
+	return riskActionSeverity[r] >= riskActionSeverity[other]
+}
+
+// RiskFactors are the weighted contributions of the signals to the risk score.
+// They are stored as JSONB.
+type RiskFactors map[string]float64
+
+// Value implements driver.Valuer.
+func (r RiskFactors) Value() (driver.Value, error) {
+	if r == nil {
+		return []byte("{}"), nil
+	}
+	return json.Marshal(r)
+}
+
+// Scan implements sql.Scanner, NULL is scanned into empty factors.
+func (r *RiskFactors) Scan(src any) error {
+	*r = RiskFactors{}
+	switch value := src.(type) {
+	case nil:
+		return nil
+	case []byte:
+		return json.Unmarshal(value, r)
+	case string:
+		return json.Unmarshal([]byte(value), r)
+	default:
+		return fmt.Errorf("invalid type for risk factors: %T", src)
+	}
+}
+
+// RiskSignals are the facts about an identification attempt its risk is assessed on.
+type RiskSignals struct {
+	IPAddress         string
+	Country           string
+	DeviceFingerprint string
+	// LastSuccess is the latest accepted attempt of the user, nil if there is none.
+	LastSuccess *IdentificationAttempt
+	// RecentFailures is the number of rejected attempts of the user within the failure window.
+	RecentFailures int
+	Now            time.Time
+}
+
+type RiskAssessment struct {
+	Score   float64     `json:"score"`
+	Action  RiskAction  `json:"action"`
+	Factors RiskFactors `json:"factors"`
+}
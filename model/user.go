@@ -1,23 +1,59 @@
 package model
 
 import (
+	"fmt"
 	"time"
 
 	"github.com/google/uuid"
 )
 
+// UserStatus restricts the voice identification of an account, e.g. while it is reviewed as duplicate.
+type UserStatus string
+
+const (
+	UserStatusActive UserStatus = "active"
+	// UserStatusHeld is an account that may not identify until its review is resolved.
+	UserStatusHeld    UserStatus = "held"
+	UserStatusBlocked UserStatus = "blocked"
+)
+
+// MayIdentify returns true if the account may identify with its voice.
+func (s UserStatus) MayIdentify() bool {
+	return s != UserStatusHeld && s != UserStatusBlocked
+}
+
+func (s UserStatus) Validate() error {
+	switch s {
+	case UserStatusActive, UserStatusHeld, UserStatusBlocked:
+		return nil
+	}
+	return fmt.Errorf("unknown user status: %v", s)
+}
+
 type User struct {
-	ID                   int       `json:"id"`
-	RID                  uuid.UUID `json:"rid"`
-	Recording1           []byte    `json:"recording_1"`
-	Recording2           []byte    `json:"recording_2"`
-	Recording3           []byte    `json:"recording_3"`
-	Recording1Normalised []byte    `json:"recording_1_normalised"`
-	Recording2Normalised []byte    `json:"recording_2_normalised"`
-	Recording3Normalised []byte    `json:"recording_3_normalised"`
-	// Recording1Mfcc       []float32 `json:"recording_1_mfcc"`
-	// Recording2Mfcc       []float32 `json:"recording_2_mfcc"`
-	// Recording3Mfcc       []float32 `json:"recording_3_mfcc"`
-	CreatedAt time.Time `json:"created_at"`
-	UpdatedAt time.Time `json:"updated_at"`
+	ID     int        `json:"id"`
+	RID    uuid.UUID  `json:"rid"`
+	Status UserStatus `json:"status"`
+	// AdaptationEnabled is the opt-in of the user to add confident identifications to their references.
+	AdaptationEnabled bool `json:"adaptation_enabled"`
+	// VoiceLoginCode is a short code the user can enter to narrow the search of the voice login.
+	VoiceLoginCode            string    `json:"voice_login_code"`
+	TemplateRefreshedAt       time.Time `json:"template_refreshed_at"`
+	TemplateRefreshPromptedAt time.Time `json:"template_refresh_prompted_at"`
+	// TemplateRefreshDismissedAt is when an admin withdrew the refresh prompt, it is not shown again for the max age.
+	TemplateRefreshDismissedAt time.Time `json:"template_refresh_dismissed_at"`
+	CreatedAt                  time.Time `json:"created_at"`
+	UpdatedAt                  time.Time `json:"updated_at"`
+}
+
+// TemplateAge describes if the reference samples of a user are old enough to be refreshed.
+type TemplateAge struct {
+	RefreshedAt time.Time
+	DismissedAt time.Time
+	MaxAge      time.Duration
+}
+
+// RefreshDue returns true if a max age is configured and exceeded, both since the last refresh and the last dismissal.
+func (r *TemplateAge) RefreshDue() bool {
+	return r.MaxAge > 0 && time.Since(r.RefreshedAt) > r.MaxAge && time.Since(r.DismissedAt) > r.MaxAge
 }
@@ -0,0 +1,33 @@
+package model
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTemplateAgeRefreshDue(t *testing.T) {
+	now := time.Now()
+	maxAge := 24 * time.Hour
+	tests := []struct {
+		name        string
+		refreshedAt time.Time
+		dismissedAt time.Time
+		maxAge      time.Duration
+		expected    bool
+	}{
+		{"fresh", now.Add(-time.Hour), time.Time{}, maxAge, false},
+		{"too old", now.Add(-48 * time.Hour), time.Time{}, maxAge, true},
+		{"too old but dismissed", now.Add(-48 * time.Hour), now.Add(-time.Hour), maxAge, false},
+		{"dismissed long ago", now.Add(-96 * time.Hour), now.Add(-48 * time.Hour), maxAge, true},
+		{"no max age", now.Add(-48 * time.Hour), time.Time{}, 0, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			templateAge := &TemplateAge{RefreshedAt: test.refreshedAt, DismissedAt: test.dismissedAt, MaxAge: test.maxAge}
+			if got := templateAge.RefreshDue(); got != test.expected {
+				t.Errorf("RefreshDue() = %v, expected %v", got, test.expected)
+			}
+		})
+	}
+}
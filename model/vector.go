@@ -0,0 +1,79 @@
+package model
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Extractor identifies the feature extractor and its version as name@version.
+// Vectors of different extractors are not comparable.
+type Extractor string
+
+// LegacyExtractor produced the vectors stored before they were tagged.
+const LegacyExtractor Extractor = "librosa-mfcc@1"
+
+func NewExtractor(name string, version string) Extractor {
+	return Extractor(name + "@" + version)
+}
+
+// Vector is a pgvector value. It is written and read in the
+// text representation of pgvector, e.g. `[1,2,3]`.
+type Vector []float32
+
+func (v Vector) IsEmpty() bool {
+	return len(v) == 0
+}
+
+func (v Vector) String() string {
+	values := make([]string, len(v))
+	for i, value := range v {
+		values[i] = strconv.FormatFloat(float64(value), 'f', -1, 32)
+	}
+	return "[" + strings.Join(values, ",") + "]"
+}
+
+// Value implements driver.Valuer, an empty vector is stored as NULL.
+func (v Vector) Value() (driver.Value, error) {
+	if v.IsEmpty() {
+		return nil, nil
+	}
+	return v.String(), nil
+}
+
+// Scan implements sql.Scanner, NULL is scanned into an empty vector.
+func (v *Vector) Scan(src any) error {
+	var text string
+	switch value := src.(type) {
+	case nil:
+		*v = Vector{}
+		return nil
+	case []byte:
+		text = string(value)
+	case string:
+		text = value
+	default:
+		return fmt.Errorf("invalid type for vector: %T", src)
+	}
+
+	text = strings.TrimSpace(text)
+	text = strings.TrimPrefix(text, "[")
+	text = strings.TrimSuffix(text, "]")
+	if len(text) == 0 {
+		*v = Vector{}
+		return nil
+	}
+
+	parts := strings.Split(text, ",")
+	vector := make(Vector, len(parts))
+	for i, part := range parts {
+		value, err := strconv.ParseFloat(strings.TrimSpace(part), 32)
+		if err != nil {
+			return fmt.Errorf("invalid vector value %v: %v", part, err)
+		}
+		vector[i] = float32(value)
+	}
+	*v = vector
+	return nil
+}
@@ -0,0 +1,43 @@
+package model
+
+import "github.com/google/uuid"
+
+// VoiceActivityState is the state of a streamed recording reported to the recorder.
+type VoiceActivityState string
+
+const (
+	// VoiceActivityStateListening is reported until the speaker starts speaking.
+	VoiceActivityStateListening VoiceActivityState = "listening"
+	VoiceActivityStateSpeaking  VoiceActivityState = "speaking"
+	// VoiceActivityStateSilent is reported while the speaker did not speak for a while.
+	VoiceActivityStateSilent VoiceActivityState = "silent"
+	// VoiceActivityStateTooLoud is reported for clipped audio, the speaker should move away from the microphone.
+	VoiceActivityStateTooLoud VoiceActivityState = "too_loud"
+	// VoiceActivityStateComplete is reported once enough speech is captured, the recording ends.
+	VoiceActivityStateComplete VoiceActivityState = "complete"
+	// VoiceActivityStateInsufficient is reported if the recording reached its maximum duration without enough speech.
+	VoiceActivityStateInsufficient VoiceActivityState = "insufficient"
+	// VoiceActivityStateError is reported if the recording could not be streamed or the attempt not be created.
+	VoiceActivityStateError VoiceActivityState = "error"
+)
+
+// IsFinal returns true if the recording ended in the state.
+func (s VoiceActivityState) IsFinal() bool {
+	return s == VoiceActivityStateComplete || s == VoiceActivityStateInsufficient || s == VoiceActivityStateError
+}
+
+// VoiceActivity is sent to the recorder of a streamed recording after every chunk.
+type VoiceActivity struct {
+	State VoiceActivityState `json:"state"`
+	// SpeechMs is the captured speech, DurationMs the length of the recording so far.
+	SpeechMs   int64 `json:"speech_ms"`
+	DurationMs int64 `json:"duration_ms"`
+	// MinSpeechMs is the speech needed to complete the recording, MaxDurationMs its maximum length.
+	MinSpeechMs   int64 `json:"min_speech_ms"`
+	MaxDurationMs int64 `json:"max_duration_ms"`
+	// AttemptRID is the identification attempt created of the complete recording.
+	AttemptRID uuid.UUID `json:"attempt_rid"`
+	// Status is the http status of the error, e.g. 429 for locked out or rate limited users.
+	Status int    `json:"status,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
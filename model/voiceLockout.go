@@ -0,0 +1,30 @@
+package model
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// VoiceLockout blocks the voice identification of a user after too many rejections,
+// until the user unlocks it with a code sent by email or an admin unlocks it.
+type VoiceLockout struct {
+	ID                    int       `json:"id"`
+	RID                   uuid.UUID `json:"rid"`
+	UserRID               uuid.UUID `json:"user_rid"`
+	Reason                string    `json:"reason"`
+	UnlockCodeRequestedAt time.Time `json:"-"`
+	CreatedAt             time.Time `json:"created_at"`
+}
+
+// IdentificationAllowance is what is left of the identification limits of a user.
+type IdentificationAllowance struct {
+	// RemainingAttempts is the number of attempts before the rate limit or the lockout applies.
+	RemainingAttempts int
+	// RetryAt is the time the next attempt is allowed again if the rate limit is reached.
+	RetryAt time.Time
+	// Lockout is the active lockout, nil if the user is not locked out.
+	Lockout *VoiceLockout
+	// Status is the status of the account, held or blocked accounts may not identify at all.
+	Status UserStatus
+}
@@ -0,0 +1,38 @@
+package model
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// DefaultVoiceProfileName is the name of the profile created for the first enrollment of a user.
+	DefaultVoiceProfileName = "Default"
+	// DuressVoiceProfileName is the name of the duress profile, which is recorded with the secret duress sentence.
+	DuressVoiceProfileName = "Duress"
+)
+
+// VoiceProfile is a named set of reference samples of a user, e.g. for one device or environment.
+// Only active profiles, whose enrollment was completed once, are matched.
+// An identification matching the duress profile looks successful, but signals that the user is coerced.
+type VoiceProfile struct {
+	ID          int       `json:"id"`
+	RID         uuid.UUID `json:"rid"`
+	UserRID     uuid.UUID `json:"user_rid"`
+	Name        string    `json:"name"`
+	Active      bool      `json:"active"`
+	Duress      bool      `json:"duress"`
+	SampleCount int       `json:"sample_count"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// ProfileDistances are the distances of a vector to the reference samples of one profile.
+// CrossChannel is set if the profile has no samples of the channel of the vector and the
+// samples of the other channel were compared instead.
+type ProfileDistances struct {
+	ProfileRID   uuid.UUID
+	Distances    []float64
+	CrossChannel bool
+}
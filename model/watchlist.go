@@ -0,0 +1,104 @@
+package model
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// WatchlistReason is the reason code a voice was put on the fraud watchlist with.
+type WatchlistReason string
+
+const (
+	WatchlistReasonAccountTakeover   WatchlistReason = "account_takeover"
+	WatchlistReasonSyntheticVoice    WatchlistReason = "synthetic_voice"
+	WatchlistReasonIdentityFraud     WatchlistReason = "identity_fraud"
+	WatchlistReasonSocialEngineering WatchlistReason = "social_engineering"
+	WatchlistReasonOther             WatchlistReason = "other"
+)
+
+var WatchlistReasons = []WatchlistReason{
+	WatchlistReasonAccountTakeover,
+	WatchlistReasonSyntheticVoice,
+	WatchlistReasonIdentityFraud,
+	WatchlistReasonSocialEngineering,
+	WatchlistReasonOther,
+}
+
+func (r WatchlistReason) Validate() error {
+	for _, reason := range WatchlistReasons {
+		if r == reason {
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown watchlist reason: %v", r)
+}
+
+// WatchlistAction is applied to enrollments and identification attempts matching a watchlisted voice.
+type WatchlistAction string
+
+const (
+	// WatchlistActionReject rejects the attempt and blocks an enrolled account.
+	WatchlistActionReject WatchlistAction = "reject"
+	// WatchlistActionEscalate requires a one-time code after a matching voice and holds an enrolled account.
+	WatchlistActionEscalate WatchlistAction = "escalate"
+)
+
+func (r WatchlistAction) Validate() error {
+	switch r {
+	case WatchlistActionReject, WatchlistActionEscalate:
+		return nil
+	}
+	return fmt.Errorf("unknown watchlist action: %v", r)
+}
+
+// RiskAction returns the risk action an identification attempt matching the watchlist is raised to.
+func (r WatchlistAction) RiskAction() RiskAction {
+	if r == WatchlistActionEscalate {
+		return RiskActionStepUp
+	}
+	return RiskActionBlock
+}
+
+// Status returns the status of an account whose enrollment matches the watchlist.
+func (r WatchlistAction) Status() UserStatus {
+	if r == WatchlistActionEscalate {
+		return UserStatusHeld
+	}
+	return UserStatusBlocked
+}
+
+// WatchlistSource is where the templates of a watchlist entry were taken from.
+type WatchlistSource string
+
+const (
+	// WatchlistSourceAccount are the enrolled samples of an account.
+	WatchlistSourceAccount WatchlistSource = "account"
+	// WatchlistSourceAttempt is the recording of an identification attempt.
+	WatchlistSourceAttempt WatchlistSource = "attempt"
+)
+
+// WatchlistEntry is a voice of a confirmed fraudster. Its templates are only visible to admins.
+type WatchlistEntry struct {
+	ID        int             `json:"id"`
+	RID       uuid.UUID       `json:"rid"`
+	Reason    WatchlistReason `json:"reason"`
+	Note      string          `json:"note"`
+	Source    WatchlistSource `json:"source"`
+	SourceRID uuid.UUID       `json:"source_rid"`
+	Active    bool            `json:"active"`
+	// Templates is the number of vectors stored for the entry.
+	Templates int       `json:"templates"`
+	CreatedBy uuid.UUID `json:"created_by"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// WatchlistHit is the closest active watchlist entry of a screened voice below the threshold.
+type WatchlistHit struct {
+	EntryRID uuid.UUID       `json:"entry_rid"`
+	Reason   WatchlistReason `json:"reason"`
+	Distance float64         `json:"distance"`
+	Action   WatchlistAction `json:"action"`
+}
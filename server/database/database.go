@@ -4,6 +4,7 @@ import (
 	"context"
 	"database/sql"
 	"fmt"
+	"hash/fnv"
 	"log"
 	"os"
 	"strconv"
@@ -20,6 +21,7 @@ type Database struct {
 	Name     string
 	Logger   *log.Logger
 	Instance *sql.DB
+	connStr  string
 }
 
 func NewDatabase(name string, dbConfig *DatabaseConfiguration) *Database {
@@ -34,7 +36,7 @@ func NewDatabase(name string, dbConfig *DatabaseConfiguration) *Database {
 		}
 		return db
 	} else {
-		return &Database{name, logger, nil}
+		return &Database{Name: name, Logger: logger}
 	}
 }
 
@@ -86,6 +88,24 @@ func (d *Database) ConnectToDatabase(dbConfig *DatabaseConfiguration, logger *lo
 	})
 
 	d.Instance = db
+	d.connStr = connStr
+}
+
+// NewListener opens a dedicated connection listening for notifications on the given channel.
+func (d *Database) NewListener(channel string) (*pq.Listener, error) {
+	listener := pq.NewListener(d.connStr, 10*time.Second, time.Minute, func(event pq.ListenerEventType, err error) {
+		if err != nil {
+			d.Logger.Printf("listener %v: %v", channel, err)
+		}
+	})
+
+	err := listener.Listen(channel)
+	if err != nil {
+		listener.Close()
+		return nil, fmt.Errorf("error listening on %v: %v", channel, err)
+	}
+
+	return listener, nil
 }
 
 func (d *Database) CheckTableExistance(tableName string) (bool, error) {
@@ -157,6 +177,51 @@ func (d *Database) CreateCombinedIndex(tableName string, columnName1 string, col
 	return nil
 }
 
+// CreateVectorIndex creates an hnsw index for nearest neighbour searches with the operator class
+// over the rows where filterColumn equals filterValue. The column is cast to the dimension, as
+// hnsw needs a fixed one, queries have to use the same cast and filter to use the index.
+func (d *Database) CreateVectorIndex(tableName string, columnName string, dimension int, operatorClass string, filterColumn string, filterValue string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+
+	hash := fnv.New32a()
+	hash.Write([]byte(fmt.Sprintf("%v:%v:%v", filterColumn, filterValue, dimension)))
+
+	tableNameQuoted := pq.QuoteIdentifier(tableName)
+	indexQuoted := pq.QuoteIdentifier(fmt.Sprintf("idx_%s_%s_%s_%x", tableName, columnName, operatorClass, hash.Sum32()))
+	columnNameQuoted := pq.QuoteIdentifier(columnName)
+	filterColumnQuoted := pq.QuoteIdentifier(filterColumn)
+	_, err := d.Instance.ExecContext(
+		ctx,
+		fmt.Sprintf(`CREATE INDEX IF NOT EXISTS %s ON %s USING hnsw ((%s::vector(%d)) %s) WHERE %s = %s`, indexQuoted, tableNameQuoted, columnNameQuoted, dimension, operatorClass, filterColumnQuoted, pq.QuoteLiteral(filterValue)),
+	)
+	if err != nil {
+		return fmt.Errorf("error creating %s index: %#v", indexQuoted, err)
+	}
+	return nil
+}
+
+// VectorDimension returns the dimension of a pgvector column, or 0 if it has none.
+func (d *Database) VectorDimension(ctx context.Context, tableName string, columnName string) (int, error) {
+	dimension := 0
+	err := d.Instance.QueryRowContext(
+		ctx,
+		`SELECT
+			GREATEST(a.atttypmod, 0)
+		FROM
+			pg_attribute AS a
+		WHERE
+			a.attrelid = to_regclass($1)
+			AND a.attname = $2`,
+		pq.QuoteIdentifier(tableName),
+		columnName,
+	).Scan(&dimension)
+	if err != nil {
+		return 0, fmt.Errorf("error selecting dimension of %s.%s: %v", tableName, columnName, err)
+	}
+	return dimension, nil
+}
+
 func (d *Database) CreateUniqueCombinedIndex(tableName string, columnName1 string, columnName2 string) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
@@ -0,0 +1,78 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// circuitBreaker stops calls to the jobs service after consecutive failures.
+// After the cooldown one trial call is let through, closing the breaker again on success.
+type circuitBreaker struct {
+	mutex         sync.Mutex
+	threshold     int
+	cooldown      time.Duration
+	failures      int
+	openedAt      time.Time
+	trialInFlight bool
+}
+
+func newCircuitBreaker(threshold int, cooldown time.Duration) *circuitBreaker {
+	return &circuitBreaker{
+		threshold: threshold,
+		cooldown:  cooldown,
+	}
+}
+
+func (r *circuitBreaker) allow() bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.threshold <= 0 || r.failures < r.threshold {
+		return true
+	}
+	if time.Since(r.openedAt) < r.cooldown || r.trialInFlight {
+		return false
+	}
+
+	r.trialInFlight = true
+	return true
+}
+
+// isOpen returns true while calls are refused, unlike allow it does not take the trial call.
+func (r *circuitBreaker) isOpen() bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	return r.threshold > 0 && r.failures >= r.threshold && (time.Since(r.openedAt) < r.cooldown || r.trialInFlight)
+}
+
+func (r *circuitBreaker) record(err error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.trialInFlight = false
+	if !countsAsFailure(err) {
+		r.failures = 0
+		return
+	}
+
+	r.failures++
+	if r.failures >= r.threshold {
+		r.openedAt = time.Now()
+	}
+}
+
+// countsAsFailure ignores client errors, they say nothing about the health of the jobs service.
+func countsAsFailure(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) {
+		return false
+	}
+	statusError := &StatusError{}
+	if errors.As(err, &statusError) {
+		return statusError.StatusCode >= http.StatusInternalServerError
+	}
+	return true
+}
@@ -0,0 +1,108 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestCountsAsFailure(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		expected bool
+	}{
+		{"success", nil, false},
+		{"cancelled by the caller", context.Canceled, false},
+		{"wrapped cancel", fmt.Errorf("post: %w", context.Canceled), false},
+		{"deadline exceeded", context.DeadlineExceeded, true},
+		{"network error", errors.New("connection refused"), true},
+		{"client error", &StatusError{StatusCode: http.StatusUnprocessableEntity}, false},
+		{"server error", &StatusError{StatusCode: http.StatusInternalServerError}, true},
+		{"unavailable", fmt.Errorf("identify: %w", &StatusError{StatusCode: http.StatusServiceUnavailable}), true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := countsAsFailure(test.err); got != test.expected {
+				t.Errorf("countsAsFailure(%v) = %v, expected %v", test.err, got, test.expected)
+			}
+		})
+	}
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	failure := errors.New("connection refused")
+	type step struct {
+		// elapsed moves the time the breaker opened into the past before the step
+		elapsed time.Duration
+		isOpen  bool
+		allow   bool
+		// done records the result of the latest allowed call after the step, nil is a success
+		done   bool
+		result error
+	}
+
+	tests := []struct {
+		name      string
+		threshold int
+		steps     []step
+	}{
+		{"closed below the threshold", 3, []step{
+			{allow: true, done: true, result: failure},
+			{allow: true, done: true, result: failure},
+			{allow: true, done: true},
+			{allow: true, done: true, result: failure},
+			{allow: true, done: true, result: failure},
+			{allow: true},
+		}},
+		{"opens at the threshold", 2, []step{
+			{allow: true, done: true, result: failure},
+			{allow: true, done: true, result: failure},
+			{isOpen: true, allow: false},
+		}},
+		{"one trial after the cooldown closes on success", 2, []step{
+			{allow: true, done: true, result: failure},
+			{allow: true, done: true, result: failure},
+			{elapsed: time.Minute, allow: true},
+			{isOpen: true, allow: false, done: true},
+			{allow: true},
+		}},
+		{"failed trial opens again", 1, []step{
+			{allow: true, done: true, result: failure},
+			{elapsed: time.Minute, allow: true, done: true, result: failure},
+			{isOpen: true, allow: false},
+		}},
+		{"client errors keep it closed", 1, []step{
+			{allow: true, done: true, result: &StatusError{StatusCode: http.StatusBadRequest}},
+			{allow: true},
+		}},
+		{"disabled", 0, []step{
+			{allow: true, done: true, result: failure},
+			{allow: true, done: true, result: failure},
+			{allow: true},
+		}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			breaker := newCircuitBreaker(test.threshold, 30*time.Second)
+			for i, step := range test.steps {
+				breaker.openedAt = breaker.openedAt.Add(-step.elapsed)
+
+				if isOpen := breaker.isOpen(); isOpen != step.isOpen {
+					t.Errorf("step %v: isOpen() = %v, expected %v", i, isOpen, step.isOpen)
+				}
+				if allow := breaker.allow(); allow != step.allow {
+					t.Fatalf("step %v: allow() = %v, expected %v", i, allow, step.allow)
+				}
+				if step.done {
+					breaker.record(step.result)
+				}
+			}
+		})
+	}
+}
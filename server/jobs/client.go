@@ -0,0 +1,350 @@
+package jobs
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"ht/helper"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrCircuitOpen is returned without calling the jobs service while the circuit breaker is open.
+var ErrCircuitOpen = errors.New("jobs service circuit breaker open")
+
+// StatusError is returned for non-2xx responses of the jobs service.
+type StatusError struct {
+	StatusCode int
+	Body       string
+}
+
+func (r *StatusError) Error() string {
+	return fmt.Sprintf("jobs service returned status %v: %v", r.StatusCode, r.Body)
+}
+
+// Config configures the client, zero values are replaced by the defaults of NewClientFromEnv.
+type Config struct {
+	// BaseURL of the jobs service, e.g. http://localhost:3000.
+	BaseURL string
+	// CallbackURL is the base url of this server the jobs service posts results to.
+	CallbackURL string
+	// TLS is used for https base urls, nil uses the system roots.
+	TLS *tls.Config
+	// Timeout is the default timeout of a single call, including retries.
+	Timeout time.Duration
+	// MaxRetries is the number of retries of idempotent calls after the first try.
+	MaxRetries int
+	// RetryBackoff is the wait before the first retry, doubled for every further retry.
+	RetryBackoff time.Duration
+	// BreakerThreshold is the number of consecutive failures opening the circuit breaker.
+	BreakerThreshold int
+	// BreakerCooldown is the time the breaker stays open before letting a trial call through.
+	BreakerCooldown time.Duration
+}
+
+// Client is a typed client for the python jobs service.
+type Client struct {
+	config     *Config
+	httpClient *http.Client
+	breaker    *circuitBreaker
+}
+
+func NewClient(config *Config) *Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = config.TLS
+
+	return &Client{
+		config:     config,
+		httpClient: &http.Client{Transport: transport},
+		breaker:    newCircuitBreaker(config.BreakerThreshold, config.BreakerCooldown),
+	}
+}
+
+// NewClientFromEnv reads JOBS_URL, falling back to localhost and JOBS_PORT, the optional
+// JOBS_TLS_CA_FILE and the timeout, retry and breaker settings.
+func NewClientFromEnv() (*Client, error) {
+	baseUrl := helper.GetEnvVariableWithDefault("JOBS_URL", "")
+	if len(baseUrl) == 0 {
+		baseUrl = fmt.Sprintf("http://localhost:%v", helper.GetEnvVariableWithoutDelete("JOBS_PORT"))
+	}
+	return newClientFromEnv(baseUrl)
+}
+
+// NewBatchClientFromEnv reads JOBS_BATCH_URL for a separate deployment of the jobs service
+// for bulk calls, falling back to the interactive one. Either way it has its own connections
+// and circuit breaker, so failing bulk calls do not open the breaker of interactive ones.
+func NewBatchClientFromEnv() (*Client, error) {
+	baseUrl := helper.GetEnvVariableWithDefault("JOBS_BATCH_URL", "")
+	if len(baseUrl) == 0 {
+		return NewClientFromEnv()
+	}
+	return newClientFromEnv(baseUrl)
+}
+
+func newClientFromEnv(baseUrl string) (*Client, error) {
+	timeoutSeconds, err := strconv.Atoi(helper.GetEnvVariableWithDefault("JOBS_TIMEOUT_SECONDS", "60"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid JOBS_TIMEOUT_SECONDS: %v", err)
+	}
+	maxRetries, err := strconv.Atoi(helper.GetEnvVariableWithDefault("JOBS_MAX_RETRIES", "2"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid JOBS_MAX_RETRIES: %v", err)
+	}
+	breakerThreshold, err := strconv.Atoi(helper.GetEnvVariableWithDefault("JOBS_BREAKER_THRESHOLD", "5"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid JOBS_BREAKER_THRESHOLD: %v", err)
+	}
+	breakerCooldownSeconds, err := strconv.Atoi(helper.GetEnvVariableWithDefault("JOBS_BREAKER_COOLDOWN_SECONDS", "30"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid JOBS_BREAKER_COOLDOWN_SECONDS: %v", err)
+	}
+
+	callbackUrl := helper.GetEnvVariableWithDefault("JOBS_CALLBACK_URL", "")
+	if len(callbackUrl) == 0 {
+		callbackUrl = fmt.Sprintf("http://localhost:%v", helper.GetEnvVariableWithoutDelete("SERVER_PORT"))
+	}
+
+	var tlsConfig *tls.Config
+	caFile := helper.GetEnvVariableWithDefault("JOBS_TLS_CA_FILE", "")
+	if len(caFile) > 0 {
+		ca, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("error reading JOBS_TLS_CA_FILE: %v", err)
+		}
+		rootCAs := x509.NewCertPool()
+		if !rootCAs.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("no certificates found in JOBS_TLS_CA_FILE")
+		}
+		tlsConfig = &tls.Config{RootCAs: rootCAs, MinVersion: tls.VersionTLS12}
+	}
+
+	return NewClient(&Config{
+		BaseURL:          strings.TrimSuffix(baseUrl, "/"),
+		CallbackURL:      strings.TrimSuffix(callbackUrl, "/"),
+		TLS:              tlsConfig,
+		Timeout:          time.Duration(timeoutSeconds) * time.Second,
+		MaxRetries:       maxRetries,
+		RetryBackoff:     500 * time.Millisecond,
+		BreakerThreshold: breakerThreshold,
+		BreakerCooldown:  time.Duration(breakerCooldownSeconds) * time.Second,
+	}), nil
+}
+
+// ReferenceRecording is a reference sample to extract the features of.
+type ReferenceRecording struct {
+	RID       string `json:"rid"`
+	Recording []byte `json:"recording"`
+	// Version is echoed in the callback, results for re-recorded samples are discarded.
+	Version string `json:"version"`
+}
+
+type ProcessReferenceRecordingsRequest struct {
+	UserRID     string                `json:"user_rid"`
+	Samples     []*ReferenceRecording `json:"samples"`
+	CallbackURL string                `json:"callback_url"`
+}
+
+type IdentifyRequest struct {
+	UserRID string `json:"user_rid"`
+	// RID is the rid of the identification attempt.
+	RID         string `json:"rid"`
+	Recording   []byte `json:"recording"`
+	CallbackURL string `json:"callback_url"`
+}
+
+// ReferenceSampleFeatures is the result for one sample of ProcessReferenceRecordingsRequest.
+type ReferenceSampleFeatures struct {
+	RID           string    `json:"rid"`
+	Version       string    `json:"version"`
+	RecordingMfcc []float32 `json:"recording_mfcc"`
+	Error         string    `json:"error"`
+}
+
+// ReferenceSamplesCallback is posted to /callback/referenceSamples.
+type ReferenceSamplesCallback struct {
+	UserRID   string                     `json:"user_rid"`
+	Samples   []*ReferenceSampleFeatures `json:"samples"`
+	Extractor string                     `json:"extractor"`
+}
+
+// IdentificationAttemptCallback is posted to /callback/identificationAttempt.
+type IdentificationAttemptCallback struct {
+	RID           string    `json:"rid"`
+	RecordingMfcc []float32 `json:"recording_mfcc"`
+	Extractor     string    `json:"extractor"`
+	Error         string    `json:"error"`
+}
+
+type ExtractFeaturesRequest struct {
+	Recording []byte `json:"recording"`
+}
+
+type ExtractFeaturesResponse struct {
+	RecordingMfcc []float32 `json:"recording_mfcc"`
+	// Extractor is the name@version of the extractor of the jobs service.
+	Extractor string `json:"extractor"`
+}
+
+// ProcessReferenceRecordings queues the feature extraction of reference samples,
+// the results are posted to the callback endpoint. It is not retried, as a lost response
+// would queue the extraction twice, the calling job is retried instead.
+func (r *Client) ProcessReferenceRecordings(ctx context.Context, request *ProcessReferenceRecordingsRequest) error {
+	request.CallbackURL = r.config.CallbackURL + "/callback/referenceSamples"
+	return r.call(ctx, "/jobs/processReferenceRecordings", request, nil, false)
+}
+
+// Identify queues the feature extraction of an identification attempt,
+// the result is posted to the callback endpoint. Like ProcessReferenceRecordings it is not retried.
+func (r *Client) Identify(ctx context.Context, request *IdentifyRequest) error {
+	request.CallbackURL = r.config.CallbackURL + "/callback/identificationAttempt"
+	return r.call(ctx, "/jobs/identify", request, nil, false)
+}
+
+// ExtractFeatures returns the features of the recording synchronously, for interactive calls
+// without a stored recording. Recordings that can not be decoded are answered with status 422.
+func (r *Client) ExtractFeatures(ctx context.Context, recording []byte) (*ExtractFeaturesResponse, error) {
+	response := &ExtractFeaturesResponse{}
+	err := r.call(ctx, "/jobs/extractFeatures", &ExtractFeaturesRequest{Recording: recording}, response, true)
+	if err != nil {
+		return nil, err
+	}
+	return response, nil
+}
+
+// CreateSentence returns a new sentence for the user to read out.
+func (r *Client) CreateSentence(ctx context.Context) (string, error) {
+	sentence := ""
+	err := r.call(ctx, "/jobs/createSentence", struct{}{}, &sentence, true)
+	if err != nil {
+		return "", err
+	}
+	return sentence, nil
+}
+
+// IsCircuitOpen returns true while calls are refused with ErrCircuitOpen.
+func (r *Client) IsCircuitOpen() bool {
+	return r.breaker.isOpen()
+}
+
+// Health probes the health endpoint of the jobs service, it ignores the circuit breaker.
+func (r *Client) Health(ctx context.Context) error {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, r.config.BaseURL+"/", nil)
+	if err != nil {
+		return err
+	}
+
+	response, err := r.httpClient.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode >= http.StatusMultipleChoices {
+		body, _ := io.ReadAll(response.Body)
+		return &StatusError{StatusCode: response.StatusCode, Body: string(body)}
+	}
+	return nil
+}
+
+func (r *Client) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if _, ok := ctx.Deadline(); ok || r.config.Timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, r.config.Timeout)
+}
+
+// call posts the request as json and decodes the response into response if not nil.
+// Idempotent calls are retried on network errors and 5xx responses.
+func (r *Client) call(ctx context.Context, path string, request any, response any, idempotent bool) error {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	body, err := json.Marshal(request)
+	if err != nil {
+		return fmt.Errorf("error marshaling %v request: %v", path, err)
+	}
+
+	retries := 0
+	if idempotent {
+		retries = r.config.MaxRetries
+	}
+
+	backoff := r.config.RetryBackoff
+	for try := 0; ; try++ {
+		err = r.post(ctx, path, body, response)
+		if err == nil || try >= retries || !isRetryable(err) {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%v: %v", err, ctx.Err())
+		case <-time.After(backoff):
+			backoff *= 2
+		}
+	}
+}
+
+func (r *Client) post(ctx context.Context, path string, body []byte, response any) error {
+	if !r.breaker.allow() {
+		return ErrCircuitOpen
+	}
+
+	err := r.doPost(ctx, path, body, response)
+	r.breaker.record(err)
+	return err
+}
+
+func (r *Client) doPost(ctx context.Context, path string, body []byte, response any) error {
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, r.config.BaseURL+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+
+	httpResponse, err := r.httpClient.Do(request)
+	if err != nil {
+		return err
+	}
+	defer httpResponse.Body.Close()
+
+	responseBody, err := io.ReadAll(httpResponse.Body)
+	if err != nil {
+		return err
+	}
+
+	if httpResponse.StatusCode >= http.StatusMultipleChoices {
+		return &StatusError{StatusCode: httpResponse.StatusCode, Body: string(responseBody)}
+	}
+
+	if response != nil {
+		err = json.Unmarshal(responseBody, response)
+		if err != nil {
+			return fmt.Errorf("error unmarshaling %v response: %v", path, err)
+		}
+	}
+	return nil
+}
+
+// isRetryable returns false for errors a retry will not fix, client errors and an open breaker.
+func isRetryable(err error) bool {
+	if errors.Is(err, ErrCircuitOpen) || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	statusError := &StatusError{}
+	if errors.As(err, &statusError) {
+		return statusError.StatusCode >= http.StatusInternalServerError
+	}
+	return true
+}
@@ -0,0 +1,78 @@
+package jobs
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+const (
+	HEADER_TIMESTAMP = "X-Jobs-Timestamp"
+	HEADER_SIGNATURE = "X-Jobs-Signature"
+)
+
+var ErrInvalidSignature = errors.New("invalid jobs callback signature")
+
+// Sign returns the hex encoded HMAC-SHA256 of the timestamp and body, separated by a dot.
+func Sign(secret []byte, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// ReplayStore remembers the signatures of accepted callbacks for all server instances.
+type ReplayStore interface {
+	// RememberCallbackSignature stores the signature until expiresAt, it returns false if it is stored already.
+	RememberCallbackSignature(signature string, expiresAt time.Time) (bool, error)
+}
+
+// CallbackVerifier authenticates callbacks of the jobs service. A callback is accepted once,
+// if its signature matches and its timestamp is within the replay window.
+type CallbackVerifier struct {
+	secret      []byte
+	window      time.Duration
+	replayStore ReplayStore
+}
+
+func NewCallbackVerifier(secret []byte, window time.Duration, replayStore ReplayStore) *CallbackVerifier {
+	return &CallbackVerifier{
+		secret:      secret,
+		window:      window,
+		replayStore: replayStore,
+	}
+}
+
+func (r *CallbackVerifier) Verify(timestampHeader string, signature string, body []byte) error {
+	timestamp, err := strconv.ParseInt(timestampHeader, 10, 64)
+	if err != nil {
+		return fmt.Errorf("%w: invalid timestamp", ErrInvalidSignature)
+	}
+
+	now := time.Now()
+	sentAt := time.Unix(timestamp, 0)
+	if sentAt.Before(now.Add(-r.window)) || sentAt.After(now.Add(r.window)) {
+		return fmt.Errorf("%w: timestamp outside of replay window", ErrInvalidSignature)
+	}
+
+	expected := Sign(r.secret, timestamp, body)
+	if !hmac.Equal([]byte(expected), []byte(signature)) {
+		return ErrInvalidSignature
+	}
+
+	// once its timestamp left the window the signature is rejected by the timestamp check already
+	fresh, err := r.replayStore.RememberCallbackSignature(signature, sentAt.Add(r.window))
+	if err != nil {
+		return fmt.Errorf("error remembering callback signature: %v", err)
+	}
+	if !fresh {
+		return fmt.Errorf("%w: replayed callback", ErrInvalidSignature)
+	}
+
+	return nil
+}
@@ -0,0 +1,107 @@
+package jobs
+
+import (
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestCallbackVerifierVerify(t *testing.T) {
+	secret := []byte("secret")
+	body := []byte(`{"rid":"1"}`)
+	now := time.Now().Unix()
+
+	tests := []struct {
+		name      string
+		timestamp string
+		signature string
+		body      []byte
+		wantErr   bool
+	}{
+		{"valid", strconv.FormatInt(now, 10), Sign(secret, now, body), body, false},
+		{"valid at the edge of the window", strconv.FormatInt(now-50, 10), Sign(secret, now-50, body), body, false},
+		{"other body", strconv.FormatInt(now, 10), Sign(secret, now, body), []byte(`{"rid":"2"}`), true},
+		{"other secret", strconv.FormatInt(now, 10), Sign([]byte("other"), now, body), body, true},
+		{"signature of another timestamp", strconv.FormatInt(now, 10), Sign(secret, now-1, body), body, true},
+		{"too old", strconv.FormatInt(now-120, 10), Sign(secret, now-120, body), body, true},
+		{"from the future", strconv.FormatInt(now+120, 10), Sign(secret, now+120, body), body, true},
+		{"invalid timestamp", "yesterday", Sign(secret, now, body), body, true},
+		{"missing signature", strconv.FormatInt(now, 10), "", body, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			verifier := NewCallbackVerifier(secret, time.Minute, memoryReplayStore{})
+			err := verifier.Verify(test.timestamp, test.signature, test.body)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("Verify() error = %v, wantErr %v", err, test.wantErr)
+			}
+			if err != nil && !errors.Is(err, ErrInvalidSignature) {
+				t.Errorf("Verify() error = %v, expected %v", err, ErrInvalidSignature)
+			}
+		})
+	}
+}
+
+// memoryReplayStore remembers signatures like the callback_signature table, without removing expired ones.
+type memoryReplayStore map[string]time.Time
+
+func (r memoryReplayStore) RememberCallbackSignature(signature string, expiresAt time.Time) (bool, error) {
+	if _, ok := r[signature]; ok {
+		return false, nil
+	}
+	r[signature] = expiresAt
+	return true, nil
+}
+
+type failingReplayStore struct{}
+
+func (r failingReplayStore) RememberCallbackSignature(signature string, expiresAt time.Time) (bool, error) {
+	return false, errors.New("connection refused")
+}
+
+func TestCallbackVerifierReplay(t *testing.T) {
+	secret := []byte("secret")
+	store := memoryReplayStore{}
+	verifier := NewCallbackVerifier(secret, time.Minute, store)
+	now := time.Now().Unix()
+
+	tests := []struct {
+		name    string
+		body    []byte
+		wantErr bool
+	}{
+		{"first callback", []byte("first"), false},
+		{"replayed callback", []byte("first"), true},
+		{"second callback", []byte("second"), false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := verifier.Verify(strconv.FormatInt(now, 10), Sign(secret, now, test.body), test.body)
+			if (err != nil) != test.wantErr {
+				t.Errorf("Verify() error = %v, wantErr %v", err, test.wantErr)
+			}
+			if err != nil && !errors.Is(err, ErrInvalidSignature) {
+				t.Errorf("Verify() error = %v, expected %v", err, ErrInvalidSignature)
+			}
+		})
+	}
+
+	// signatures are kept until their timestamp can not pass the window anymore
+	expiresAt := store[Sign(secret, now, []byte("first"))]
+	if !expiresAt.Equal(time.Unix(now, 0).Add(time.Minute)) {
+		t.Errorf("signature expires at %v, expected %v", expiresAt, time.Unix(now, 0).Add(time.Minute))
+	}
+	if len(store) != 2 {
+		t.Errorf("got %v remembered signatures, expected 2", len(store))
+	}
+
+	// callbacks which can not be checked for a replay are not accepted, but not invalid either
+	verifier = NewCallbackVerifier(secret, time.Minute, failingReplayStore{})
+	err := verifier.Verify(strconv.FormatInt(now, 10), Sign(secret, now, []byte("third")), []byte("third"))
+	if err == nil || errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("Verify() error with a failing store = %v, expected an error other than %v", err, ErrInvalidSignature)
+	}
+}
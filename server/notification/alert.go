@@ -0,0 +1,121 @@
+package notification
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"ht/helper"
+	"ht/model"
+	"ht/server/jobs"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	HEADER_ALERT_TIMESTAMP = "X-Alert-Timestamp"
+	HEADER_ALERT_SIGNATURE = "X-Alert-Signature"
+)
+
+// Alerter raises alerts for the fraud team.
+type Alerter interface {
+	Alert(alert *model.Alert) error
+}
+
+// NewAlerterFromEnv returns a webhook alerter if ALERT_WEBHOOK_URL is set, otherwise an alerter which only logs.
+// ALERT_WEBHOOK_SECRET signs the alerts like the callbacks of the jobs service, ALERT_WEBHOOK_TIMEOUT_SECONDS defaults to 5.
+func NewAlerterFromEnv() (Alerter, error) {
+	url := helper.GetEnvVariableWithDefault("ALERT_WEBHOOK_URL", "")
+	if len(url) == 0 {
+		return NewLogAlerter(), nil
+	}
+
+	timeoutSeconds, err := strconv.Atoi(helper.GetEnvVariableWithDefault("ALERT_WEBHOOK_TIMEOUT_SECONDS", "5"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid ALERT_WEBHOOK_TIMEOUT_SECONDS: %v", err)
+	}
+	if timeoutSeconds < 1 {
+		return nil, fmt.Errorf("ALERT_WEBHOOK_TIMEOUT_SECONDS has to be at least 1")
+	}
+
+	return &WebhookAlerter{
+		url:        url,
+		secret:     []byte(helper.GetEnvVariableWithDefault("ALERT_WEBHOOK_SECRET", "")),
+		httpClient: &http.Client{Timeout: time.Duration(timeoutSeconds) * time.Second},
+	}, nil
+}
+
+// WebhookAlerter posts the alerts as JSON to a webhook.
+type WebhookAlerter struct {
+	url        string
+	secret     []byte
+	httpClient *http.Client
+}
+
+func (r *WebhookAlerter) Alert(alert *model.Alert) error {
+	prepareAlert(alert)
+	body, err := json.Marshal(alert)
+	if err != nil {
+		return err
+	}
+
+	request, err := http.NewRequestWithContext(context.Background(), http.MethodPost, r.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	if len(r.secret) > 0 {
+		timestamp := time.Now().Unix()
+		request.Header.Set(HEADER_ALERT_TIMESTAMP, strconv.FormatInt(timestamp, 10))
+		request.Header.Set(HEADER_ALERT_SIGNATURE, jobs.Sign(r.secret, timestamp, body))
+	}
+
+	response, err := r.httpClient.Do(request)
+	if err != nil {
+		return fmt.Errorf("error sending alert %v: %v", alert.RID, err)
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode >= 300 {
+		return fmt.Errorf("error sending alert %v: webhook returned %v", alert.RID, response.Status)
+	}
+	return nil
+}
+
+// LogAlerter writes the alerts to the log, for development without webhook.
+type LogAlerter struct {
+	logger *log.Logger
+}
+
+func NewLogAlerter() *LogAlerter {
+	return &LogAlerter{
+		logger: log.New(os.Stdout, "alert: ", log.LstdFlags),
+	}
+}
+
+func (r *LogAlerter) Alert(alert *model.Alert) error {
+	prepareAlert(alert)
+	r.logger.Printf("%v %v (%v) for user %v: %v", alert.RID, alert.Type, alert.Priority, alert.UserRID, alert.Details)
+	return nil
+}
+
+// prepareAlert sets the rid, priority and time of a new alert, the receiver can deduplicate by the rid.
+func prepareAlert(alert *model.Alert) {
+	if alert.RID == uuid.Nil {
+		alert.RID = uuid.New()
+	}
+	if len(alert.Priority) == 0 {
+		alert.Priority = model.AlertPriorityNormal
+	}
+	if alert.CreatedAt.IsZero() {
+		alert.CreatedAt = time.Now()
+	}
+	if alert.Details == nil {
+		alert.Details = map[string]any{}
+	}
+}
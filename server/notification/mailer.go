@@ -0,0 +1,88 @@
+package notification
+
+import (
+	"fmt"
+	"ht/helper"
+	"log"
+	"net"
+	"net/smtp"
+	"os"
+	"strings"
+	"time"
+)
+
+// Mailer sends plain text emails.
+type Mailer interface {
+	Send(to string, subject string, body string) error
+}
+
+// NewMailerFromEnv returns an SMTP mailer if SMTP_HOST is set, otherwise a mailer which only logs.
+// SMTP_PORT defaults to 587, SMTP_USERNAME and SMTP_PASSWORD are optional, SMTP_FROM is the sender.
+func NewMailerFromEnv() (Mailer, error) {
+	host := helper.GetEnvVariableWithDefault("SMTP_HOST", "")
+	if len(host) == 0 {
+		return NewLogMailer(), nil
+	}
+
+	from := helper.GetEnvVariableWithDefault("SMTP_FROM", "")
+	if len(from) == 0 {
+		return nil, fmt.Errorf("SMTP_FROM is required with SMTP_HOST")
+	}
+
+	mailer := &SMTPMailer{
+		address: net.JoinHostPort(host, helper.GetEnvVariableWithDefault("SMTP_PORT", "587")),
+		from:    from,
+	}
+	username := helper.GetEnvVariableWithDefault("SMTP_USERNAME", "")
+	if len(username) > 0 {
+		mailer.auth = smtp.PlainAuth("", username, helper.GetEnvVariable("SMTP_PASSWORD"), host)
+	}
+
+	return mailer, nil
+}
+
+type SMTPMailer struct {
+	address string
+	from    string
+	auth    smtp.Auth
+}
+
+func (r *SMTPMailer) Send(to string, subject string, body string) error {
+	// header injection through the recipient or subject is not possible with single lines
+	if strings.ContainsAny(to+subject, "\r\n") {
+		return fmt.Errorf("invalid recipient or subject")
+	}
+
+	message := strings.Join([]string{
+		"From: " + r.from,
+		"To: " + to,
+		"Subject: " + subject,
+		"Date: " + time.Now().Format(time.RFC1123Z),
+		"MIME-Version: 1.0",
+		"Content-Type: text/plain; charset=utf-8",
+		"",
+		body,
+	}, "\r\n")
+
+	err := smtp.SendMail(r.address, r.auth, r.from, []string{to}, []byte(message))
+	if err != nil {
+		return fmt.Errorf("error sending email: %v", err)
+	}
+	return nil
+}
+
+// LogMailer writes the emails to the log, for development without mail server.
+type LogMailer struct {
+	logger *log.Logger
+}
+
+func NewLogMailer() *LogMailer {
+	return &LogMailer{
+		logger: log.New(os.Stdout, "mail: ", log.LstdFlags),
+	}
+}
+
+func (r *LogMailer) Send(to string, subject string, body string) error {
+	r.logger.Printf("to %v: %v\n%v", to, subject, body)
+	return nil
+}
@@ -0,0 +1,117 @@
+package risk
+
+import (
+	"fmt"
+	"ht/helper"
+	"ht/model"
+	"math"
+	"slices"
+	"strconv"
+)
+
+// Engine assesses the risk of identification attempts from signals besides the voice.
+type Engine struct {
+	// Enabled assesses attempts, without every attempt is allowed.
+	Enabled      bool
+	Rules        *Rules
+	ipReputation *IPReputation
+	geoIP        *GeoIP
+}
+
+// NewEngineFromEnv reads RISK_ENABLED, the optional RISK_RULES_FILE (json, see Rules),
+// RISK_IP_REPUTATION_FILE and RISK_GEOIP_DATABASE. Signals without a source are 0.
+func NewEngineFromEnv() (*Engine, error) {
+	enabled, err := strconv.ParseBool(helper.GetEnvVariableWithDefault("RISK_ENABLED", "false"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid RISK_ENABLED: %v", err)
+	}
+
+	engine := &Engine{
+		Enabled: enabled,
+		Rules:   DefaultRules(),
+	}
+
+	if path := helper.GetEnvVariableWithDefault("RISK_RULES_FILE", ""); len(path) > 0 {
+		engine.Rules, err = LoadRules(path)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if path := helper.GetEnvVariableWithDefault("RISK_IP_REPUTATION_FILE", ""); len(path) > 0 {
+		engine.ipReputation, err = LoadIPReputation(path)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if path := helper.GetEnvVariableWithDefault("RISK_GEOIP_DATABASE", ""); len(path) > 0 {
+		engine.geoIP, err = OpenGeoIP(path)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return engine, nil
+}
+
+// Country returns the ISO code of the country of the address, empty without GeoIP database.
+func (r *Engine) Country(address string) string {
+	return r.geoIP.Country(address)
+}
+
+// Assess scores the signals and picks the action of the rules for the score.
+func (r *Engine) Assess(signals *model.RiskSignals) *model.RiskAssessment {
+	if !r.Enabled {
+		return &model.RiskAssessment{Action: model.RiskActionAllow, Factors: model.RiskFactors{}}
+	}
+
+	values := map[string]float64{
+		FactorIPReputation:    r.ipReputation.Score(signals.IPAddress),
+		FactorFailureVelocity: math.Min(1, float64(signals.RecentFailures)/float64(r.Rules.FailureLimit)),
+	}
+	if len(signals.Country) > 0 && slices.Contains(r.Rules.BlockedCountries, signals.Country) {
+		values[FactorBlockedCountry] = 1
+	}
+	if signals.LastSuccess == nil {
+		values[FactorTimeSinceSuccess] = 1
+	} else {
+		staleAfter := float64(r.Rules.StaleSuccessDays) * 24
+		values[FactorTimeSinceSuccess] = math.Min(1, signals.Now.Sub(signals.LastSuccess.AcceptedAt).Hours()/staleAfter)
+		if len(signals.LastSuccess.Country) > 0 && len(signals.Country) > 0 && signals.LastSuccess.Country != signals.Country {
+			values[FactorCountryChange] = 1
+		}
+		if len(signals.LastSuccess.DeviceFingerprint) > 0 && len(signals.DeviceFingerprint) > 0 && signals.LastSuccess.DeviceFingerprint != signals.DeviceFingerprint {
+			values[FactorDeviceChange] = 1
+		}
+	}
+
+	assessment := &model.RiskAssessment{Factors: model.RiskFactors{}}
+	for factor, value := range values {
+		contribution := value * r.Rules.Weights[factor]
+		if contribution > 0 {
+			assessment.Factors[factor] = contribution
+			assessment.Score += contribution
+		}
+	}
+	assessment.Score = math.Min(1, assessment.Score)
+
+	switch {
+	case assessment.Score >= r.Rules.BlockAt:
+		assessment.Action = model.RiskActionBlock
+	case assessment.Score >= r.Rules.StepUpAt:
+		assessment.Action = model.RiskActionStepUp
+	case assessment.Score >= r.Rules.TightenAt:
+		assessment.Action = model.RiskActionTighten
+	default:
+		assessment.Action = model.RiskActionAllow
+	}
+
+	return assessment
+}
+
+// Threshold returns the voice threshold for an attempt with the action, which is tightened from tighten on.
+func (r *Engine) Threshold(action model.RiskAction, threshold float64) float64 {
+	if action.AtLeast(model.RiskActionTighten) {
+		return threshold * r.Rules.TightenFactor
+	}
+	return threshold
+}
@@ -0,0 +1,48 @@
+package risk
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// GeoIP resolves addresses to countries with a local MaxMind database,
+// e.g. GeoLite2-Country.mmdb.
+type GeoIP struct {
+	reader *maxminddb.Reader
+}
+
+func OpenGeoIP(path string) (*GeoIP, error) {
+	reader, err := maxminddb.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("error opening geoip database: %v", err)
+	}
+	return &GeoIP{reader: reader}, nil
+}
+
+// Country returns the ISO code of the country of the address, empty if it is unknown.
+func (r *GeoIP) Country(address string) string {
+	ip := net.ParseIP(address)
+	if r == nil || ip == nil {
+		return ""
+	}
+
+	record := &struct {
+		Country struct {
+			ISOCode string `maxminddb:"iso_code"`
+		} `maxminddb:"country"`
+	}{}
+	err := r.reader.Lookup(ip, record)
+	if err != nil {
+		return ""
+	}
+	return record.Country.ISOCode
+}
+
+func (r *GeoIP) Close() error {
+	if r == nil {
+		return nil
+	}
+	return r.reader.Close()
+}
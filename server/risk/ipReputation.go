@@ -0,0 +1,82 @@
+package risk
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+type ipReputationEntry struct {
+	network *net.IPNet
+	score   float64
+}
+
+// IPReputation is a local list of bad addresses and networks.
+type IPReputation struct {
+	entries []*ipReputationEntry
+}
+
+// LoadIPReputation reads a list with one address or CIDR network per line, optionally followed
+// by a score in [0, 1] which defaults to 1. Empty lines and lines starting with # are skipped.
+func LoadIPReputation(path string) (*IPReputation, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("error opening ip reputation list: %v", err)
+	}
+	defer file.Close()
+
+	reputation := &IPReputation{}
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+
+		entry := &ipReputationEntry{score: 1}
+		if strings.Contains(fields[0], "/") {
+			_, entry.network, err = net.ParseCIDR(fields[0])
+			if err != nil {
+				return nil, fmt.Errorf("invalid network in ip reputation line %v: %v", line, err)
+			}
+		} else {
+			ip := net.ParseIP(fields[0])
+			if ip == nil {
+				return nil, fmt.Errorf("invalid address in ip reputation line %v: %v", line, fields[0])
+			}
+			bits := 8 * len(ip.To16())
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 32
+			}
+			entry.network = &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}
+		}
+		if len(fields) > 1 {
+			entry.score, err = strconv.ParseFloat(fields[1], 64)
+			if err != nil || entry.score < 0 || entry.score > 1 {
+				return nil, fmt.Errorf("invalid score in ip reputation line %v: %v", line, fields[1])
+			}
+		}
+		reputation.entries = append(reputation.entries, entry)
+	}
+
+	return reputation, scanner.Err()
+}
+
+// Score returns the highest score of the entries containing the address, 0 if none does.
+func (r *IPReputation) Score(address string) float64 {
+	ip := net.ParseIP(address)
+	if r == nil || ip == nil {
+		return 0
+	}
+
+	score := 0.0
+	for _, entry := range r.entries {
+		if entry.network.Contains(ip) && entry.score > score {
+			score = entry.score
+		}
+	}
+	return score
+}
@@ -0,0 +1,113 @@
+package risk
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	FactorIPReputation     = "ip_reputation"
+	FactorBlockedCountry   = "blocked_country"
+	FactorCountryChange    = "country_change"
+	FactorDeviceChange     = "device_change"
+	FactorTimeSinceSuccess = "time_since_success"
+	FactorFailureVelocity  = "failure_velocity"
+)
+
+// Rules configure how the signals are weighted and which score leads to which action.
+// Every factor is in [0, 1] and multiplied with its weight, the score is their sum capped at 1.
+type Rules struct {
+	Weights map[string]float64 `json:"weights"`
+	// BlockedCountries are ISO country codes, attempts from them get the blocked_country factor.
+	BlockedCountries []string `json:"blocked_countries"`
+	// FailureWindowMinutes is the window rejected attempts are counted in,
+	// FailureLimit rejections within it give the full failure_velocity factor.
+	FailureWindowMinutes int `json:"failure_window_minutes"`
+	FailureLimit         int `json:"failure_limit"`
+	// StaleSuccessDays is the age of the last success giving the full time_since_success factor.
+	StaleSuccessDays int `json:"stale_success_days"`
+	// TightenAt, StepUpAt and BlockAt are the scores from which on the action applies.
+	TightenAt float64 `json:"tighten_at"`
+	StepUpAt  float64 `json:"step_up_at"`
+	BlockAt   float64 `json:"block_at"`
+	// TightenFactor is multiplied with the voice threshold from TightenAt on.
+	TightenFactor float64 `json:"tighten_factor"`
+	// StepUpTimeoutMinutes is the time the user has to enter the one-time code.
+	StepUpTimeoutMinutes int `json:"step_up_timeout_minutes"`
+}
+
+func DefaultRules() *Rules {
+	return &Rules{
+		Weights: map[string]float64{
+			FactorIPReputation:     0.6,
+			FactorBlockedCountry:   1,
+			FactorCountryChange:    0.3,
+			FactorDeviceChange:     0.2,
+			FactorTimeSinceSuccess: 0.1,
+			FactorFailureVelocity:  0.4,
+		},
+		BlockedCountries:     []string{},
+		FailureWindowMinutes: 15,
+		FailureLimit:         5,
+		StaleSuccessDays:     30,
+		TightenAt:            0.3,
+		StepUpAt:             0.6,
+		BlockAt:              0.9,
+		TightenFactor:        0.8,
+		StepUpTimeoutMinutes: 10,
+	}
+}
+
+// LoadRules reads rules from a json file.
+func LoadRules(path string) (*Rules, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading risk rules: %v", err)
+	}
+
+	// the defaults are kept for missing fields, configured weights are merged into the default weights
+	rules := DefaultRules()
+	err = json.Unmarshal(data, rules)
+	if err != nil {
+		return nil, fmt.Errorf("invalid risk rules: %v", err)
+	}
+
+	return rules, rules.Validate()
+}
+
+func (r *Rules) Validate() error {
+	for factor, weight := range r.Weights {
+		switch factor {
+		case FactorIPReputation, FactorBlockedCountry, FactorCountryChange, FactorDeviceChange, FactorTimeSinceSuccess, FactorFailureVelocity:
+		default:
+			return fmt.Errorf("unknown risk factor: %v", factor)
+		}
+		if weight < 0 {
+			return fmt.Errorf("weight of %v has to be at least 0", factor)
+		}
+	}
+	for i, country := range r.BlockedCountries {
+		r.BlockedCountries[i] = strings.ToUpper(strings.TrimSpace(country))
+	}
+	if r.FailureWindowMinutes < 1 || r.FailureLimit < 1 || r.StaleSuccessDays < 1 || r.StepUpTimeoutMinutes < 1 {
+		return fmt.Errorf("risk windows and limits have to be at least 1")
+	}
+	if r.TightenAt > r.StepUpAt || r.StepUpAt > r.BlockAt {
+		return fmt.Errorf("risk thresholds have to be ordered tighten_at <= step_up_at <= block_at")
+	}
+	if r.TightenFactor <= 0 || r.TightenFactor > 1 {
+		return fmt.Errorf("tighten_factor has to be in (0, 1]")
+	}
+	return nil
+}
+
+func (r *Rules) FailureWindow() time.Duration {
+	return time.Duration(r.FailureWindowMinutes) * time.Minute
+}
+
+func (r *Rules) StepUpTimeout() time.Duration {
+	return time.Duration(r.StepUpTimeoutMinutes) * time.Minute
+}
@@ -1,13 +1,23 @@
 package server
 
 import (
+	"context"
 	"fmt"
 	"ht/helper"
 	"ht/server/database"
+	"ht/server/jobs"
+	"ht/server/notification"
+	"ht/server/services/audit"
 	"ht/server/services/auth"
+	"ht/server/services/batch"
 	"ht/server/services/identification"
+	"ht/server/services/job"
+	"ht/server/services/recovery"
 	"ht/server/services/user"
+	"ht/server/voice"
+	"log"
 	"net/http"
+	"time"
 
 	"github.com/antonlindstrom/pgstore"
 	"github.com/gorilla/sessions"
@@ -19,11 +29,16 @@ type Server struct {
 	SessionStore *pgstore.PGStore
 	sessionDb    *database.DatabaseConfiguration
 	// services
+	AuditService          *audit.AuditService
+	JobService            *job.JobService
 	AuthService           *auth.AuthService
 	UserService           *user.UserService
 	IdentificationService *identification.IdentificationAttemptService
-	// jobs
-	JobsPort string
+	BatchService          *batch.BatchService
+	RecoveryService       *recovery.RecoveryService
+	// voice
+	VoiceMatcher         voice.VoiceMatcher
+	JobsCallbackVerifier *jobs.CallbackVerifier
 }
 
 func NewServer() (*Server, error) {
@@ -46,14 +61,57 @@ func NewServer() (*Server, error) {
 		SameSite: http.SameSiteLaxMode,
 	}
 
+	jobsClient, err := jobs.NewClientFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	voiceMatcher, err := voice.NewVoiceMatcherFromEnv(jobsClient)
+	if err != nil {
+		return nil, err
+	}
+	// the jobs service might start later, calls are queued and retried
+	if remoteMatcher, ok := voiceMatcher.(*voice.RemoteMatcher); ok {
+		err = remoteMatcher.Health(context.Background())
+		if err != nil {
+			log.Printf("jobs service not healthy: %v", err)
+		}
+	}
+
+	// bulk verification gets its own connection to the jobs service
+	batchJobsClient, err := jobs.NewBatchClientFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	batchVoiceMatcher, err := voice.NewVoiceMatcherFromEnv(batchJobsClient)
+	if err != nil {
+		return nil, err
+	}
+
+	// watchlist hits alert the fraud team
+	alerter, err := notification.NewAlerterFromEnv()
+	if err != nil {
+		return nil, err
+	}
+
+	auditService := audit.NewAuditService()
+	jobService := job.NewJobService()
+	userService := user.NewUserService(auditService, jobService, voiceMatcher, alerter)
+	authService := auth.NewAuthService(sessionStore)
+	identificationService := identification.NewIdentificationAttemptService(userService, jobService, voiceMatcher, auditService, authService, alerter)
+
 	return &Server{
 		SessionStore: sessionStore,
 		sessionDb:    sessionDb,
 		// services
-		AuthService:           auth.NewAuthService(sessionStore),
-		UserService:           user.NewUserService(),
-		IdentificationService: identification.NewIdentificationAttemptService(),
-		// jobs
-		JobsPort: helper.GetEnvVariableWithoutDelete("JOBS_PORT"),
+		AuditService:          auditService,
+		JobService:            jobService,
+		AuthService:           authService,
+		UserService:           userService,
+		IdentificationService: identificationService,
+		BatchService:          batch.NewBatchService(identificationService, authService, jobService, auditService, batchVoiceMatcher),
+		RecoveryService:       recovery.NewRecoveryService(identificationService, userService, authService, auditService, voiceMatcher),
+		// voice
+		VoiceMatcher:         voiceMatcher,
+		JobsCallbackVerifier: jobs.NewCallbackVerifier([]byte(helper.GetEnvVariable("JOBS_CALLBACK_SECRET")), 5*time.Minute, jobService),
 	}, nil
 }
@@ -0,0 +1,289 @@
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"ht/model"
+	"ht/server/database"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type AuditEventDBHandlerFunctions interface {
+	CreateTable() error
+	DropTable() error
+	InsertAuditEvent(auditEvent *model.AuditEvent) (*model.AuditEvent, error)
+	SelectAuditEvent(rid uuid.UUID) (*model.AuditEvent, error)
+	SelectAllAuditEventsBySubjectRID(subjectRid uuid.UUID, lastId int, entries int) ([]*model.AuditEvent, error)
+	SelectAllAuditEventsByActorRID(actorRid uuid.UUID, lastId int, entries int) ([]*model.AuditEvent, error)
+}
+
+type AuditEventDBHandler struct {
+	db *database.Database
+}
+
+func newAuditEventDBHandler(dbConnection *database.Database) *AuditEventDBHandler {
+	return &AuditEventDBHandler{
+		db: dbConnection,
+	}
+}
+
+func (r AuditEventDBHandler) CreateTable() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	_, err := r.db.Instance.ExecContext(
+		ctx,
+		`CREATE TABLE IF NOT EXISTS audit_event (
+			id BIGINT GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+			rid UUID UNIQUE DEFAULT gen_random_uuid(),
+			actor_rid UUID,
+			subject_rid UUID,
+			action TEXT NOT NULL,
+			details JSONB DEFAULT '{}',
+			created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+		);`,
+	)
+	if err != nil {
+		return fmt.Errorf("error creating audit_event table: %v", err)
+	}
+
+	err = r.db.CreateIndexes("audit_event", "rid", "subject_rid", "actor_rid", "action")
+	if err != nil {
+		return err
+	}
+
+	r.db.Logger.Println("created table audit_event")
+	return nil
+}
+
+func (r AuditEventDBHandler) DropTable() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	query := `DROP TABLE IF EXISTS audit_event`
+	_, err := r.db.Instance.ExecContext(ctx, query)
+	if err != nil {
+		return fmt.Errorf("error dropping audit_event table: %#v", err)
+	}
+
+	r.db.Logger.Printf("dropped table audit_event")
+	return nil
+}
+
+func (r AuditEventDBHandler) InsertAuditEvent(auditEvent *model.AuditEvent) (*model.AuditEvent, error) {
+	newAuditEvent := &model.AuditEvent{}
+
+	details, err := json.Marshal(auditEvent.Details)
+	if err != nil {
+		return nil, fmt.Errorf("error marshaling audit details: %v", err)
+	}
+
+	row := r.db.Instance.QueryRow(
+		`INSERT INTO audit_event (actor_rid, subject_rid, action, details)
+			VALUES ($1, $2, $3, $4)
+		RETURNING
+			id,
+			rid,
+			actor_rid,
+			subject_rid,
+			action,
+			details,
+			created_at;`,
+		auditEvent.ActorRID,
+		auditEvent.SubjectRID,
+		auditEvent.Action,
+		details,
+	)
+
+	details = []byte{}
+	err = row.Scan(
+		&newAuditEvent.ID,
+		&newAuditEvent.RID,
+		&newAuditEvent.ActorRID,
+		&newAuditEvent.SubjectRID,
+		&newAuditEvent.Action,
+		&details,
+		&newAuditEvent.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	err = json.Unmarshal(details, &newAuditEvent.Details)
+	if err != nil {
+		return nil, err
+	}
+
+	return newAuditEvent, nil
+}
+
+func (r AuditEventDBHandler) SelectAuditEvent(rid uuid.UUID) (*model.AuditEvent, error) {
+	auditEvent := &model.AuditEvent{}
+	details := []byte{}
+
+	row := r.db.Instance.QueryRow(
+		`SELECT
+			id,
+			rid,
+			actor_rid,
+			subject_rid,
+			action,
+			details,
+			created_at
+		FROM
+			audit_event
+		WHERE
+			rid = $1`,
+		rid,
+	)
+	err := row.Scan(
+		&auditEvent.ID,
+		&auditEvent.RID,
+		&auditEvent.ActorRID,
+		&auditEvent.SubjectRID,
+		&auditEvent.Action,
+		&details,
+		&auditEvent.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	err = json.Unmarshal(details, &auditEvent.Details)
+	if err != nil {
+		return nil, err
+	}
+
+	return auditEvent, nil
+}
+
+func (r AuditEventDBHandler) SelectAllAuditEventsBySubjectRID(subjectRid uuid.UUID, lastId int, entries int) ([]*model.AuditEvent, error) {
+	var auditEvents []*model.AuditEvent
+
+	rows, err := r.db.Instance.Query(
+		`SELECT
+			id,
+			rid,
+			actor_rid,
+			subject_rid,
+			action,
+			details,
+			created_at
+		FROM
+			audit_event
+		WHERE
+			subject_rid = $1
+			AND (0 = $2
+				OR created_at < (
+					SELECT
+						a.created_at
+					FROM
+						audit_event AS a
+					WHERE
+						a.id = $2))
+		ORDER BY
+			created_at DESC
+		LIMIT $3`,
+		subjectRid,
+		lastId,
+		entries,
+	)
+	if err != nil {
+		return []*model.AuditEvent{}, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		auditEvent := &model.AuditEvent{}
+		details := []byte{}
+		err := rows.Scan(
+			&auditEvent.ID,
+			&auditEvent.RID,
+			&auditEvent.ActorRID,
+			&auditEvent.SubjectRID,
+			&auditEvent.Action,
+			&details,
+			&auditEvent.CreatedAt,
+		)
+		if err != nil {
+			return []*model.AuditEvent{}, err
+		}
+
+		err = json.Unmarshal(details, &auditEvent.Details)
+		if err != nil {
+			return []*model.AuditEvent{}, err
+		}
+
+		auditEvents = append(auditEvents, auditEvent)
+	}
+
+	return auditEvents, nil
+}
+
+func (r AuditEventDBHandler) SelectAllAuditEventsByActorRID(actorRid uuid.UUID, lastId int, entries int) ([]*model.AuditEvent, error) {
+	var auditEvents []*model.AuditEvent
+
+	rows, err := r.db.Instance.Query(
+		`SELECT
+			id,
+			rid,
+			actor_rid,
+			subject_rid,
+			action,
+			details,
+			created_at
+		FROM
+			audit_event
+		WHERE
+			actor_rid = $1
+			AND (0 = $2
+				OR created_at < (
+					SELECT
+						a.created_at
+					FROM
+						audit_event AS a
+					WHERE
+						a.id = $2))
+		ORDER BY
+			created_at DESC
+		LIMIT $3`,
+		actorRid,
+		lastId,
+		entries,
+	)
+	if err != nil {
+		return []*model.AuditEvent{}, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		auditEvent := &model.AuditEvent{}
+		details := []byte{}
+		err := rows.Scan(
+			&auditEvent.ID,
+			&auditEvent.RID,
+			&auditEvent.ActorRID,
+			&auditEvent.SubjectRID,
+			&auditEvent.Action,
+			&details,
+			&auditEvent.CreatedAt,
+		)
+		if err != nil {
+			return []*model.AuditEvent{}, err
+		}
+
+		err = json.Unmarshal(details, &auditEvent.Details)
+		if err != nil {
+			return []*model.AuditEvent{}, err
+		}
+
+		auditEvents = append(auditEvents, auditEvent)
+	}
+
+	return auditEvents, nil
+}
@@ -0,0 +1,74 @@
+package audit
+
+import (
+	"ht/helper"
+	"ht/model"
+	"ht/server/database"
+	"log"
+	"os"
+
+	"github.com/google/uuid"
+)
+
+type AuditService struct {
+	logger  *log.Logger
+	auditDb AuditEventDBHandlerFunctions
+}
+
+func NewAuditService() *AuditService {
+	logger := log.New(os.Stdout, "audit: ", log.LstdFlags)
+	dbConnection := database.NewDatabase(
+		"audit",
+		&database.DatabaseConfiguration{
+			Host:     helper.GetEnvVariable("DB_AUDIT_HOST"),
+			Port:     helper.GetEnvVariable("DB_AUDIT_PORT"),
+			Database: helper.GetEnvVariable("DB_AUDIT_DATABASE"),
+			Username: helper.GetEnvVariable("DB_AUDIT_USERNAME"),
+			Password: helper.GetEnvVariable("DB_AUDIT_PASSWORD"),
+			Schema:   helper.GetEnvVariable("DB_AUDIT_SCHEMA"),
+		},
+	)
+	var auditDb AuditEventDBHandlerFunctions = newAuditEventDBHandler(dbConnection)
+
+	// creates main audit event table
+	err := auditDb.CreateTable()
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+
+	newAuditService := &AuditService{
+		logger:  logger,
+		auditDb: auditDb,
+	}
+
+	return newAuditService
+}
+
+// Record writes an event to the audit trail. Audit failures are logged and returned,
+// callers decide if the action has to be aborted.
+func (r *AuditService) Record(actorRid uuid.UUID, subjectRid uuid.UUID, action model.AuditAction, details map[string]any) error {
+	if details == nil {
+		details = map[string]any{}
+	}
+
+	_, err := r.auditDb.InsertAuditEvent(&model.AuditEvent{
+		ActorRID:   actorRid,
+		SubjectRID: subjectRid,
+		Action:     action,
+		Details:    details,
+	})
+	if err != nil {
+		r.logger.Printf("error recording audit event %v for %v: %v", action, subjectRid, err)
+		return err
+	}
+
+	return nil
+}
+
+func (r *AuditService) GetAuditEventsBySubject(subjectRid uuid.UUID, lastId int, entries int) ([]*model.AuditEvent, error) {
+	return r.auditDb.SelectAllAuditEventsBySubjectRID(subjectRid, lastId, entries)
+}
+
+func (r *AuditService) GetAuditEventsByActor(actorRid uuid.UUID, lastId int, entries int) ([]*model.AuditEvent, error) {
+	return r.auditDb.SelectAllAuditEventsByActorRID(actorRid, lastId, entries)
+}
@@ -2,6 +2,7 @@ package auth
 
 import (
 	"context"
+	"database/sql"
 	"fmt"
 	"ht/model"
 	"ht/server/database"
@@ -17,12 +18,16 @@ type AuthDBHandlerFunctions interface {
 	CountAuthByEmail(email string) (int, error)
 	CheckEmailVerificationCodeValid(rid uuid.UUID, code string) bool
 	CheckPasswordResetCodeValid(rid uuid.UUID, code string) bool
+	UpdateLoginCode(rid uuid.UUID, code string) error
+	ConsumeLoginCode(rid uuid.UUID, code string, requestedAfter time.Time) bool
+	UpdateLoginCodeFailure(rid uuid.UUID, maxFailures int) (int, error)
 	InsertAuth(auth *model.Auth) (*model.Auth, error)
 	UpdateAuth(auth *model.Auth) (*model.Auth, error)
 	DeleteAuth(rid uuid.UUID) error
 	SelectAuth(rid uuid.UUID) (*model.Auth, error)
 	SelectAuthByEmail(email string) (*model.Auth, error)
 	SelectAuthByEmailAndPassword(email string, password string) (*model.Auth, error)
+	UpdateRoleByEmail(email string, role model.Role) error
 	SelectAllAuth(lastId int, entries int) ([]*model.Auth, error)
 	SelectAllAuthBySearch(search string, lastId int, entries int) ([]*model.Auth, error)
 }
@@ -58,9 +63,15 @@ func (r AuthDBHandler) CreateTable() error {
 			email_verification_request_date TIMESTAMP DEFAULT '2000-01-01T01:23:45Z',
 			email_verified boolean DEFAULT FALSE,
 			email_to_change_to TEXT DEFAULT '',
+			role TEXT DEFAULT 'user',
 			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
 			updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
-		)`,
+		);
+
+		ALTER TABLE auth ADD COLUMN IF NOT EXISTS role TEXT DEFAULT 'user';
+		ALTER TABLE auth ADD COLUMN IF NOT EXISTS login_code_hash TEXT DEFAULT '';
+		ALTER TABLE auth ADD COLUMN IF NOT EXISTS login_code_requested_at TIMESTAMP WITH TIME ZONE DEFAULT '2000-01-01T01:23:45Z';
+		ALTER TABLE auth ADD COLUMN IF NOT EXISTS login_code_failures INTEGER DEFAULT 0;`,
 	)
 	if err != nil {
 		return fmt.Errorf("error creating auth table: %#v", err)
@@ -136,6 +147,81 @@ func (r AuthDBHandler) CheckPasswordResetCodeValid(rid uuid.UUID, code string) b
 	return exists
 }
 
+// UpdateLoginCode stores the hash of the code which completes a login without the voice.
+func (r AuthDBHandler) UpdateLoginCode(rid uuid.UUID, code string) error {
+	_, err := r.db.Instance.Exec(
+		`UPDATE
+			auth
+		SET
+			login_code_hash = crypt($1, gen_salt('bf', 6)),
+			login_code_requested_at = CURRENT_TIMESTAMP,
+			login_code_failures = 0,
+			updated_at = CURRENT_TIMESTAMP
+		WHERE
+			rid = $2`,
+		code,
+		rid,
+	)
+	return err
+}
+
+// ConsumeLoginCode returns true if the code was requested after the time and removes it, so it is only used once.
+func (r AuthDBHandler) ConsumeLoginCode(rid uuid.UUID, code string, requestedAfter time.Time) bool {
+	consumed := false
+
+	err := r.db.Instance.QueryRow(
+		`UPDATE
+			auth
+		SET
+			login_code_hash = '',
+			updated_at = CURRENT_TIMESTAMP
+		WHERE
+			rid = $1
+			AND login_code_hash <> ''
+			AND login_code_requested_at > $3
+			AND login_code_hash = crypt($2, login_code_hash)
+		RETURNING
+			TRUE`,
+		rid,
+		code,
+		requestedAfter,
+	).Scan(&consumed)
+	if err != nil {
+		return false
+	}
+
+	return consumed
+}
+
+// UpdateLoginCodeFailure counts a wrong code against the stored login code and removes the code
+// once maxFailures is reached. It returns the failures of the code, 0 if there is no code.
+func (r AuthDBHandler) UpdateLoginCodeFailure(rid uuid.UUID, maxFailures int) (int, error) {
+	failures := 0
+
+	err := r.db.Instance.QueryRow(
+		`UPDATE
+			auth
+		SET
+			login_code_failures = login_code_failures + 1,
+			login_code_hash = CASE WHEN login_code_failures + 1 >= $2 THEN '' ELSE login_code_hash END,
+			updated_at = CURRENT_TIMESTAMP
+		WHERE
+			rid = $1
+			AND login_code_hash <> ''
+		RETURNING
+			login_code_failures`,
+		rid,
+		maxFailures,
+	).Scan(&failures)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+
+	return failures, nil
+}
+
 func (r AuthDBHandler) InsertAuth(auth *model.Auth) (*model.Auth, error) {
 	row := r.db.Instance.QueryRow(
 		`INSERT INTO auth (email,
@@ -206,12 +292,12 @@ func (r AuthDBHandler) UpdateAuth(auth *model.Auth) (*model.Auth, error) {
 						ELSE password_hash 
 					END,
 			password_reset_code_hash = CASE 
-						WHEN password_reset_code_hash <> $5 THEN crypt($4, gen_salt('bf', 6)) 
+						WHEN password_reset_code_hash <> $5 THEN crypt($5, gen_salt('bf', 6)) 
 						ELSE password_reset_code_hash 
 					END,
 			password_reset_request_date = $6,
 			email_verification_code_hash = CASE 
-						WHEN email_verification_code_hash <> $7 THEN crypt($4, gen_salt('bf', 6)) 
+						WHEN email_verification_code_hash <> $7 THEN crypt($7, gen_salt('bf', 6)) 
 						ELSE email_verification_code_hash 
 					END,
 			email_verification_request_date = $8,
@@ -263,6 +349,25 @@ func (r AuthDBHandler) UpdateAuth(auth *model.Auth) (*model.Auth, error) {
 	return auth, nil
 }
 
+func (r AuthDBHandler) UpdateRoleByEmail(email string, role model.Role) error {
+	_, err := r.db.Instance.Exec(
+		`UPDATE
+			auth
+		SET
+			role = $1,
+			updated_at = CURRENT_TIMESTAMP
+		WHERE
+			email = lower($2)`,
+		role,
+		email,
+	)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
 func (r AuthDBHandler) DeleteAuth(rid uuid.UUID) error {
 	_, err := r.db.Instance.Exec(
 		`DELETE FROM auth
@@ -293,6 +398,7 @@ func (r AuthDBHandler) SelectAuth(rid uuid.UUID) (*model.Auth, error) {
 			email_verification_request_date,
 			email_verified,
 			email_to_change_to,
+			role,
 			created_at,
 			updated_at
 		FROM
@@ -314,6 +420,7 @@ func (r AuthDBHandler) SelectAuth(rid uuid.UUID) (*model.Auth, error) {
 		&auth.EmailVerificationRequestDate,
 		&auth.EmailVerified,
 		&auth.EmailToChangeTo,
+		&auth.Role,
 		&auth.CreatedAt,
 		&auth.UpdatedAt,
 	)
@@ -341,6 +448,7 @@ func (r AuthDBHandler) SelectAuthByEmail(email string) (*model.Auth, error) {
 			email_verification_request_date,
 			email_verified,
 			email_to_change_to,
+			role,
 			created_at,
 			updated_at
 		FROM
@@ -362,6 +470,7 @@ func (r AuthDBHandler) SelectAuthByEmail(email string) (*model.Auth, error) {
 		&auth.EmailVerificationRequestDate,
 		&auth.EmailVerified,
 		&auth.EmailToChangeTo,
+		&auth.Role,
 		&auth.CreatedAt,
 		&auth.UpdatedAt,
 	)
@@ -389,6 +498,7 @@ func (r AuthDBHandler) SelectAuthByEmailAndPassword(email string, password strin
 			email_verification_request_date,
 			email_verified,
 			email_to_change_to,
+			role,
 			created_at,
 			updated_at
 		FROM
@@ -413,6 +523,7 @@ func (r AuthDBHandler) SelectAuthByEmailAndPassword(email string, password strin
 		&auth.EmailVerificationRequestDate,
 		&auth.EmailVerified,
 		&auth.EmailToChangeTo,
+		&auth.Role,
 		&auth.CreatedAt,
 		&auth.UpdatedAt,
 	)
@@ -441,6 +552,7 @@ func (r AuthDBHandler) SelectAllAuth(lastId int, entries int) ([]*model.Auth, er
 			email_verification_request_date,
 			email_verified,
 			email_to_change_to,
+			role,
 			created_at,
 			updated_at
 		FROM
@@ -480,6 +592,7 @@ func (r AuthDBHandler) SelectAllAuth(lastId int, entries int) ([]*model.Auth, er
 			&auth.EmailVerificationRequestDate,
 			&auth.EmailVerified,
 			&auth.EmailToChangeTo,
+			&auth.Role,
 			&auth.CreatedAt,
 			&auth.UpdatedAt,
 		)
@@ -512,6 +625,7 @@ func (r AuthDBHandler) SelectAllAuthBySearch(search string, lastId int, entries
 			email_verification_request_date,
 			email_verified,
 			email_to_change_to,
+			role,
 			created_at,
 			updated_at
 		FROM auth
@@ -553,6 +667,7 @@ func (r AuthDBHandler) SelectAllAuthBySearch(search string, lastId int, entries
 			&auth.EmailVerificationRequestDate,
 			&auth.EmailVerified,
 			&auth.EmailToChangeTo,
+			&auth.Role,
 			&auth.CreatedAt,
 			&auth.UpdatedAt,
 		)
@@ -1,23 +1,38 @@
 package auth
 
 import (
+	"errors"
 	"fmt"
 	"ht/helper"
 	"ht/model"
 	"ht/server/database"
+	"ht/server/notification"
 	"log"
+	"net/mail"
 	"os"
+	"slices"
+	"strings"
 	"time"
 
 	"github.com/antonlindstrom/pgstore"
+	"github.com/google/uuid"
 	"github.com/labstack/echo/v4"
 	"github.com/siherrmann/validator"
 )
 
+var (
+	ErrEmailTaken                   = errors.New("the email is already used by another account")
+	ErrInvalidEmail                 = errors.New("invalid email")
+	ErrNoEmailChange                = errors.New("no email change requested")
+	ErrInvalidEmailVerificationCode = errors.New("invalid email verification code")
+)
+
 type AuthService struct {
 	logger       *log.Logger
 	authDb       AuthDBHandlerFunctions
 	sessionStore *pgstore.PGStore
+	mailer       notification.Mailer
+	loginPolicy  *LoginPolicy
 }
 
 func NewAuthService(sessionStore *pgstore.PGStore) *AuthService {
@@ -41,10 +56,55 @@ func NewAuthService(sessionStore *pgstore.PGStore) *AuthService {
 		log.Fatal(err.Error())
 	}
 
+	// grants the agent role to the configured accounts, before the admin role which includes it
+	for _, email := range strings.Split(helper.GetEnvVariableWithDefault("AGENT_EMAILS", ""), ",") {
+		if len(strings.TrimSpace(email)) == 0 {
+			continue
+		}
+		err = authDb.UpdateRoleByEmail(strings.TrimSpace(email), model.RoleAgent)
+		if err != nil {
+			log.Fatal(err.Error())
+		}
+	}
+
+	// grants the fraud role to the configured accounts, before the admin role which includes it
+	for _, email := range strings.Split(helper.GetEnvVariableWithDefault("FRAUD_EMAILS", ""), ",") {
+		if len(strings.TrimSpace(email)) == 0 {
+			continue
+		}
+		err = authDb.UpdateRoleByEmail(strings.TrimSpace(email), model.RoleFraud)
+		if err != nil {
+			log.Fatal(err.Error())
+		}
+	}
+
+	// grants the admin role to the configured accounts
+	for _, email := range strings.Split(helper.GetEnvVariableWithDefault("ADMIN_EMAILS", ""), ",") {
+		if len(strings.TrimSpace(email)) == 0 {
+			continue
+		}
+		err = authDb.UpdateRoleByEmail(strings.TrimSpace(email), model.RoleAdmin)
+		if err != nil {
+			log.Fatal(err.Error())
+		}
+	}
+
+	mailer, err := notification.NewMailerFromEnv()
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+
+	loginPolicy, err := NewLoginPolicyFromEnv()
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+
 	newAuthService := &AuthService{
 		logger:       logger,
 		authDb:       authDb,
 		sessionStore: sessionStore,
+		mailer:       mailer,
+		loginPolicy:  loginPolicy,
 	}
 
 	return newAuthService
@@ -53,6 +113,14 @@ func NewAuthService(sessionStore *pgstore.PGStore) *AuthService {
 func (s *AuthService) updateSession(c echo.Context, auth model.Auth, authenticated bool) error {
 	session, _ := s.sessionStore.Get(c.Request(), "auth")
 
+	// the voice verification belongs to the user who was verified
+	if session.Values["user_id"] != auth.RID.String() {
+		delete(session.Values, "voice_verified_at")
+		delete(session.Values, "voice_verification_return")
+		delete(session.Values, "identification_attempt_rid")
+	}
+	// a login starts with all factors, RequireSecondFactor makes it partial
+	delete(session.Values, "second_factor_pending")
 	session.Values["authenticated"] = authenticated
 	session.Values["email_verified"] = auth.EmailVerified
 	session.Values["user_id"] = auth.RID.String()
@@ -71,7 +139,12 @@ func (s *AuthService) logoutSession(c echo.Context) error {
 	session.Values["authenticated"] = false
 	session.Values["email_verified"] = false
 	session.Values["user_id"] = ""
+	session.Values["restricted"] = false
 	session.Values["created_at"] = time.Now().Unix()
+	delete(session.Values, "voice_verified_at")
+	delete(session.Values, "voice_verification_return")
+	delete(session.Values, "second_factor_pending")
+	delete(session.Values, "identification_attempt_rid")
 
 	err := session.Save(c.Request(), c.Response().Writer)
 	if err != nil {
@@ -197,7 +270,8 @@ func (h *AuthService) HandleVerifyEmail(c echo.Context) error {
 	return nil
 }
 
-func (h *AuthService) HandleLoginWithEmail(c echo.Context) error {
+// HandleLoginWithEmail logs in the account of the email and password and returns it.
+func (h *AuthService) HandleLoginWithEmail(c echo.Context) (*model.Auth, error) {
 
 	request := &struct {
 		Email    string `upd:"email, min3 max256 con@"`
@@ -205,12 +279,31 @@ func (h *AuthService) HandleLoginWithEmail(c echo.Context) error {
 	}{}
 	err := validator.UnmapOrUnmarshalRequestValidateAndUpdate(c.Request(), request)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	auth, err := h.authDb.SelectAuthByEmailAndPassword(request.Email, request.Password)
 	if err != nil {
-		return fmt.Errorf("invalid email or password")
+		return nil, fmt.Errorf("invalid email or password")
+	}
+
+	err = h.updateSession(c, *auth, true)
+	if err != nil {
+		return nil, fmt.Errorf("error updating session: %v", err)
+	}
+
+	return auth, nil
+}
+
+// LoginUser starts a session for a user identified without password, e.g. by voice.
+// Only accounts with verified email can be logged in this way.
+func (h *AuthService) LoginUser(c echo.Context, userRid uuid.UUID) error {
+	auth, err := h.authDb.SelectAuth(userRid)
+	if err != nil {
+		return fmt.Errorf("error selecting auth: %v", err)
+	}
+	if !auth.EmailVerified {
+		return fmt.Errorf("email not verified")
 	}
 
 	err = h.updateSession(c, *auth, true)
@@ -221,6 +314,90 @@ func (h *AuthService) HandleLoginWithEmail(c echo.Context) error {
 	return nil
 }
 
+// RestrictSession marks the current session as started under duress. It stays logged in,
+// but can not change the account until the user logs out.
+func (h *AuthService) RestrictSession(c echo.Context) error {
+	session, _ := h.sessionStore.Get(c.Request(), "auth")
+
+	session.Values["restricted"] = true
+
+	err := session.Save(c.Request(), c.Response().Writer)
+	if err != nil {
+		return fmt.Errorf("error saving session: %v", err)
+	}
+	return nil
+}
+
+// MarkVoiceVerified stores the time the voice of the current user was accepted, which
+// RequireRecentVoiceVerification checks. An older verification never replaces a newer one.
+func (h *AuthService) MarkVoiceVerified(c echo.Context, verifiedAt time.Time) error {
+	session, _ := h.sessionStore.Get(c.Request(), "auth")
+
+	if previous, ok := session.Values["voice_verified_at"].(int64); ok && previous >= verifiedAt.Unix() {
+		return nil
+	}
+	session.Values["voice_verified_at"] = verifiedAt.Unix()
+
+	err := session.Save(c.Request(), c.Response().Writer)
+	if err != nil {
+		return fmt.Errorf("error saving session: %v", err)
+	}
+	return nil
+}
+
+// SetVoiceVerificationReturn stores the path the user is returned to after the voice verification.
+func (h *AuthService) SetVoiceVerificationReturn(c echo.Context, path string) error {
+	session, _ := h.sessionStore.Get(c.Request(), "auth")
+
+	session.Values["voice_verification_return"] = path
+
+	err := session.Save(c.Request(), c.Response().Writer)
+	if err != nil {
+		return fmt.Errorf("error saving session: %v", err)
+	}
+	return nil
+}
+
+// TakeVoiceVerificationReturn returns and removes the path stored by SetVoiceVerificationReturn,
+// it is empty if the verification was not requested by a sensitive action.
+func (h *AuthService) TakeVoiceVerificationReturn(c echo.Context) (string, error) {
+	session, _ := h.sessionStore.Get(c.Request(), "auth")
+
+	path, ok := session.Values["voice_verification_return"].(string)
+	if !ok {
+		return "", nil
+	}
+	delete(session.Values, "voice_verification_return")
+
+	err := session.Save(c.Request(), c.Response().Writer)
+	if err != nil {
+		return "", fmt.Errorf("error saving session: %v", err)
+	}
+	return path, nil
+}
+
+// SetIdentificationAttempt stores the identification attempt made in the current session,
+// only its result verifies the voice of the session.
+func (h *AuthService) SetIdentificationAttempt(c echo.Context, attemptRid uuid.UUID) error {
+	session, _ := h.sessionStore.Get(c.Request(), "auth")
+
+	session.Values["identification_attempt_rid"] = attemptRid.String()
+
+	err := session.Save(c.Request(), c.Response().Writer)
+	if err != nil {
+		return fmt.Errorf("error saving session: %v", err)
+	}
+	return nil
+}
+
+// IsSessionIdentificationAttempt returns true if the attempt was made in the current session.
+func (h *AuthService) IsSessionIdentificationAttempt(c echo.Context, attemptRid uuid.UUID) bool {
+	session, _ := h.sessionStore.Get(c.Request(), "auth")
+
+	rid, ok := session.Values["identification_attempt_rid"].(string)
+	return ok && rid == attemptRid.String()
+}
+
 func (h *AuthService) HandleRequestPasswordReset(c echo.Context) error {
 
 	request := &struct {
@@ -305,6 +482,144 @@ func (h *AuthService) HandleResetPassword(c echo.Context) error {
 	return nil
 }
 
+// RecoverAccount sets the new password and the optional new email of an account recovered by voice.
+// A new email has to be verified again on the next login. The old email address is told about the recovery.
+func (h *AuthService) RecoverAccount(userRid uuid.UUID, email string, password string) (*model.Auth, error) {
+	auth, err := h.authDb.SelectAuth(userRid)
+	if err != nil {
+		return nil, fmt.Errorf("error selecting auth: %v", err)
+	}
+	previousEmail := auth.Email
+
+	email = strings.ToLower(strings.TrimSpace(email))
+	emailChanged := len(email) > 0 && email != auth.Email
+	if emailChanged {
+		count, err := h.authDb.CountAuthByEmail(email)
+		if err != nil {
+			return nil, fmt.Errorf("error counting auth: %v", err)
+		}
+		if count > 0 {
+			return nil, ErrEmailTaken
+		}
+		auth.Email = email
+		auth.EmailVerified = false
+	}
+
+	auth.PasswordHash = password
+	auth.PasswordTemp = ""
+	auth.PasswordTempRequestDate = time.Time{}
+
+	auth, err = h.authDb.UpdateAuth(auth)
+	if err != nil {
+		return nil, fmt.Errorf("error updating auth: %v", err)
+	}
+
+	body := "Your password was reset by a voice recovery of your account."
+	if emailChanged {
+		body = fmt.Sprintf("Your password was reset and your email changed to %v by a voice recovery of your account.", auth.Email)
+	}
+	err = h.mailer.Send(previousEmail, "Your account was recovered", body+" If this was not you, please contact support right away.")
+	if err != nil {
+		h.logger.Printf("error notifying %v about the recovery: %v", previousEmail, err)
+	}
+
+	return auth, nil
+}
+
+// ChangeEmail requests to change the email of the current user. The current email stays until the new one
+// is confirmed with the code sent to it, so a mistyped address does not lock the user out.
+func (h *AuthService) ChangeEmail(c echo.Context, email string) (*model.Auth, error) {
+	userRid := helper.GetCurrentUserRID(c.Request().Context())
+
+	email = strings.ToLower(strings.TrimSpace(email))
+	_, err := mail.ParseAddress(email)
+	if err != nil || len(email) > 256 {
+		return nil, ErrInvalidEmail
+	}
+
+	auth, err := h.authDb.SelectAuth(userRid)
+	if err != nil {
+		return nil, fmt.Errorf("error selecting auth: %v", err)
+	}
+	if email == auth.Email {
+		return auth, nil
+	}
+
+	count, err := h.authDb.CountAuthByEmail(email)
+	if err != nil {
+		return nil, fmt.Errorf("error counting auth: %v", err)
+	}
+	if count > 0 {
+		return nil, ErrEmailTaken
+	}
+
+	emailVerificationCode, err := helper.CreateRandomString(6, helper.LettersAndNumbers)
+	if err != nil {
+		return nil, fmt.Errorf("error creating email verification code: %v", err)
+	}
+	auth.EmailToChangeTo = email
+	auth.EmailVerificationCodeHash = emailVerificationCode
+	auth.EmailVerificationRequestDate = time.Now()
+
+	auth, err = h.authDb.UpdateAuth(auth)
+	if err != nil {
+		return nil, fmt.Errorf("error updating auth: %v", err)
+	}
+
+	err = h.mailer.Send(auth.EmailToChangeTo, "Verify your new email", fmt.Sprintf("Your email verification code is %v.", emailVerificationCode))
+	if err != nil {
+		h.logger.Printf("error sending the verification code to %v: %v", auth.EmailToChangeTo, err)
+	}
+
+	return auth, nil
+}
+
+// ConfirmEmailChange changes the email of the current user to the requested one if the code sent to it is valid.
+// The previous email is told about the change.
+func (h *AuthService) ConfirmEmailChange(c echo.Context, code string) (*model.Auth, error) {
+	userRid := helper.GetCurrentUserRID(c.Request().Context())
+
+	auth, err := h.authDb.SelectAuth(userRid)
+	if err != nil {
+		return nil, fmt.Errorf("error selecting auth: %v", err)
+	}
+	if len(auth.EmailToChangeTo) == 0 {
+		return nil, ErrNoEmailChange
+	}
+
+	valid := h.authDb.CheckEmailVerificationCodeValid(userRid, strings.TrimSpace(code))
+	if !valid {
+		return nil, ErrInvalidEmailVerificationCode
+	}
+
+	// the address may have been taken since the change was requested
+	count, err := h.authDb.CountAuthByEmail(auth.EmailToChangeTo)
+	if err != nil {
+		return nil, fmt.Errorf("error counting auth: %v", err)
+	}
+	if count > 0 {
+		return nil, ErrEmailTaken
+	}
+
+	previousEmail := auth.Email
+	auth.Email = auth.EmailToChangeTo
+	auth.EmailToChangeTo = ""
+	auth.EmailVerified = true
+	auth.EmailVerificationCodeHash = ""
+
+	auth, err = h.authDb.UpdateAuth(auth)
+	if err != nil {
+		return nil, fmt.Errorf("error updating auth: %v", err)
+	}
+
+	err = h.mailer.Send(previousEmail, "Your email was changed", fmt.Sprintf("The email of your account was changed to %v. If this was not you, please contact support right away.", auth.Email))
+	if err != nil {
+		h.logger.Printf("error notifying %v about the email change: %v", previousEmail, err)
+	}
+
+	return auth, nil
+}
+
 func (h *AuthService) HandleLogout(c echo.Context) error {
 	err := h.logoutSession(c)
 	if err != nil {
@@ -314,8 +629,8 @@ func (h *AuthService) HandleLogout(c echo.Context) error {
 	return nil
 }
 
+// HandleDeleteAuth deletes the account of the current user and logs the session out.
 func (h *AuthService) HandleDeleteAuth(c echo.Context) error {
-	// TODO check access
 	h.logger.Println("deleting auth definition")
 
 	userRid := helper.GetCurrentUserRID(c.Request().Context())
@@ -325,7 +640,7 @@ func (h *AuthService) HandleDeleteAuth(c echo.Context) error {
 		return err
 	}
 
-	return nil
+	return h.logoutSession(c)
 }
 
 func (h *AuthService) HandleGetAuth(c echo.Context) (*model.Auth, error) {
@@ -341,3 +656,47 @@ func (h *AuthService) HandleGetAuth(c echo.Context) (*model.Auth, error) {
 
 	return auth, nil
 }
+
+// HasRole checks the role stored with the account, not the session,
+// so that revoked roles take effect immediately.
+func (h *AuthService) HasRole(userRid uuid.UUID, role model.Role) bool {
+	auth, err := h.authDb.SelectAuth(userRid)
+	if err != nil {
+		return false
+	}
+	return auth.Role == role
+}
+
+// HasAnyRole checks the role stored with the account like HasRole against several roles.
+func (h *AuthService) HasAnyRole(userRid uuid.UUID, roles ...model.Role) bool {
+	auth, err := h.authDb.SelectAuth(userRid)
+	if err != nil {
+		return false
+	}
+	return slices.Contains(roles, auth.Role)
+}
+
+func (h *AuthService) GetAuth(rid uuid.UUID) (*model.Auth, error) {
+	auth, err := h.authDb.SelectAuth(rid)
+	if err != nil {
+		return nil, err
+	}
+	return auth, nil
+}
+
+func (h *AuthService) GetAuthByEmail(email string) (*model.Auth, error) {
+	auth, err := h.authDb.SelectAuthByEmail(email)
+	if err != nil {
+		return nil, err
+	}
+	return auth, nil
+}
+
+// NotifyUser sends an email to the address of the account.
+func (h *AuthService) NotifyUser(userRid uuid.UUID, subject string, body string) error {
+	auth, err := h.authDb.SelectAuth(userRid)
+	if err != nil {
+		return fmt.Errorf("error selecting auth: %v", err)
+	}
+	return h.mailer.Send(auth.Email, subject, body)
+}
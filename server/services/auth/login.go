@@ -0,0 +1,139 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"ht/helper"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+)
+
+var (
+	ErrInvalidLoginCode = errors.New("invalid login code")
+	// ErrLoginCodeBurned is returned with the wrong code which used up the tries of the login code.
+	ErrLoginCodeBurned = errors.New("login code burned after too many failures")
+)
+
+const (
+	loginCodeTimeout = 15 * time.Minute
+	// maxLoginCodeFailures is the number of wrong codes after which the login code is removed.
+	maxLoginCodeFailures = 5
+)
+
+// LoginPolicy decides which factors complete a login.
+type LoginPolicy struct {
+	// VoiceRequired makes a password login partial until the voice of the user is accepted.
+	// Users without an enrolled voice are logged in with the password alone.
+	VoiceRequired bool
+	// EmailFallback lets users whose voice identification is locked or down complete the login with an emailed code.
+	EmailFallback bool
+}
+
+func NewLoginPolicyFromEnv() (*LoginPolicy, error) {
+	voiceRequired, err := strconv.ParseBool(helper.GetEnvVariableWithDefault("LOGIN_VOICE_REQUIRED", "false"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid LOGIN_VOICE_REQUIRED: %v", err)
+	}
+	emailFallback, err := strconv.ParseBool(helper.GetEnvVariableWithDefault("LOGIN_EMAIL_FALLBACK", "true"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid LOGIN_EMAIL_FALLBACK: %v", err)
+	}
+
+	return &LoginPolicy{
+		VoiceRequired: voiceRequired,
+		EmailFallback: emailFallback,
+	}, nil
+}
+
+func (h *AuthService) IsVoiceRequired() bool {
+	return h.loginPolicy.VoiceRequired
+}
+
+func (h *AuthService) IsEmailFallbackEnabled() bool {
+	return h.loginPolicy.EmailFallback
+}
+
+// IsSecondFactorPending returns true if the current session still needs the voice of the user.
+func (h *AuthService) IsSecondFactorPending(c echo.Context) bool {
+	session, _ := h.sessionStore.Get(c.Request(), "auth")
+
+	pending, ok := session.Values["second_factor_pending"].(bool)
+	return ok && pending
+}
+
+// RequireSecondFactor makes the current session partial, it only reaches the identification
+// until CompleteSecondFactor upgrades it.
+func (h *AuthService) RequireSecondFactor(c echo.Context) error {
+	session, _ := h.sessionStore.Get(c.Request(), "auth")
+
+	session.Values["second_factor_pending"] = true
+
+	err := session.Save(c.Request(), c.Response().Writer)
+	if err != nil {
+		return fmt.Errorf("error saving session: %v", err)
+	}
+	return nil
+}
+
+// CompleteSecondFactor upgrades a partial session to a full one with the voice accepted at the time.
+// Voices accepted before the login do not count.
+func (h *AuthService) CompleteSecondFactor(c echo.Context, acceptedAt time.Time) error {
+	session, _ := h.sessionStore.Get(c.Request(), "auth")
+
+	if pending, ok := session.Values["second_factor_pending"].(bool); !ok || !pending {
+		return nil
+	}
+	if createdAt, ok := session.Values["created_at"].(int64); !ok || acceptedAt.Unix() < createdAt {
+		return nil
+	}
+	delete(session.Values, "second_factor_pending")
+
+	err := session.Save(c.Request(), c.Response().Writer)
+	if err != nil {
+		return fmt.Errorf("error saving session: %v", err)
+	}
+	return nil
+}
+
+// RequestLoginCode sends a code to the email of the user, which completes the login instead of the voice.
+func (h *AuthService) RequestLoginCode(userRid uuid.UUID) error {
+	code, err := helper.CreateRandomString(6, helper.OnlyNumbers)
+	if err != nil {
+		return fmt.Errorf("error creating login code: %v", err)
+	}
+
+	err = h.authDb.UpdateLoginCode(userRid, code)
+	if err != nil {
+		return fmt.Errorf("error storing login code: %v", err)
+	}
+
+	return h.NotifyUser(
+		userRid,
+		"Your login code",
+		fmt.Sprintf("Your code to complete the login is %v. It is valid for %v minutes. If this was not you, please change your password.", code, loginCodeTimeout.Minutes()),
+	)
+}
+
+// CompleteSecondFactorWithCode upgrades the partial session of the current user with the code sent by RequestLoginCode.
+// Every wrong code counts against the login code, after maxLoginCodeFailures it is removed and ErrLoginCodeBurned
+// is returned, a new code has to be requested.
+func (h *AuthService) CompleteSecondFactorWithCode(c echo.Context, code string) error {
+	userRid := helper.GetCurrentUserRID(c.Request().Context())
+
+	if !h.authDb.ConsumeLoginCode(userRid, strings.TrimSpace(code), time.Now().Add(-loginCodeTimeout)) {
+		failures, err := h.authDb.UpdateLoginCodeFailure(userRid, maxLoginCodeFailures)
+		if err != nil {
+			return fmt.Errorf("error counting login code failure: %v", err)
+		}
+		if failures >= maxLoginCodeFailures {
+			return ErrLoginCodeBurned
+		}
+		return ErrInvalidLoginCode
+	}
+
+	return h.CompleteSecondFactor(c, time.Now())
+}
@@ -0,0 +1,126 @@
+package batch
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"ht/model"
+	"ht/server/voice"
+	"io"
+	"path"
+	"strings"
+)
+
+// manifestColumns are the required columns of the manifest, encoding is optional.
+var manifestColumns = []string{"reference", "email", "file"}
+
+// ErrInvalidManifest is returned for manifests that can not be read as a whole.
+var ErrInvalidManifest = errors.New("invalid manifest")
+
+// readItems reads the csv manifest with a header of reference, email, file and an optional encoding
+// and takes the recordings from the zip archive. Lines whose recording is missing or can not be read
+// become items with an error verdict, so the result still has a line for every line of the manifest.
+// The recordings are limited to maxTotalBytes together, archives of highly compressed files are refused.
+func readItems(manifest []byte, archive []byte, maxItems int, maxRecordingBytes int64, maxTotalBytes int64) ([]*model.BatchItem, error) {
+	reader := csv.NewReader(bytes.NewReader(manifest))
+	reader.TrimLeadingSpace = true
+	lines, err := reader.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidManifest, err)
+	}
+	if len(lines) < 2 {
+		return nil, fmt.Errorf("%w: no items", ErrInvalidManifest)
+	}
+	if len(lines)-1 > maxItems {
+		return nil, fmt.Errorf("%w: %v items, at most %v are allowed", ErrInvalidManifest, len(lines)-1, maxItems)
+	}
+
+	columns := map[string]int{}
+	for i, column := range lines[0] {
+		columns[strings.ToLower(strings.TrimSpace(column))] = i
+	}
+	for _, column := range manifestColumns {
+		if _, ok := columns[column]; !ok {
+			return nil, fmt.Errorf("%w: missing column %v", ErrInvalidManifest, column)
+		}
+	}
+
+	zipReader, err := zip.NewReader(bytes.NewReader(archive), int64(len(archive)))
+	if err != nil {
+		return nil, fmt.Errorf("invalid archive: %v", err)
+	}
+	files := map[string]*zip.File{}
+	for _, file := range zipReader.File {
+		if !file.FileInfo().IsDir() {
+			files[path.Clean(file.Name)] = file
+		}
+	}
+
+	items := make([]*model.BatchItem, 0, len(lines)-1)
+	totalBytes := int64(0)
+	for i, line := range lines[1:] {
+		item := &model.BatchItem{
+			Position:     i + 1,
+			Reference:    column(line, columns, "reference"),
+			ClaimedEmail: strings.ToLower(column(line, columns, "email")),
+			File:         column(line, columns, "file"),
+			Verdict:      model.BatchItemVerdictPending,
+		}
+
+		recording, err := readRecording(files, item.File, maxRecordingBytes)
+		if err == nil {
+			item.Recording, item.Channel, err = voice.NormaliseRecording(recording, voice.Encoding(column(line, columns, "encoding")))
+		}
+		if err != nil {
+			item.Verdict = model.BatchItemVerdictError
+			item.Error = err.Error()
+			item.Channel = model.ChannelWideband
+		}
+
+		totalBytes += int64(len(item.Recording))
+		if totalBytes > maxTotalBytes {
+			return nil, fmt.Errorf("the recordings exceed %v bytes together", maxTotalBytes)
+		}
+
+		items = append(items, item)
+	}
+
+	return items, nil
+}
+
+func column(line []string, columns map[string]int, name string) string {
+	i, ok := columns[name]
+	if !ok || i >= len(line) {
+		return ""
+	}
+	return strings.TrimSpace(line[i])
+}
+
+// readRecording reads the file of the archive, refusing files above the size limit
+// before decompressing them completely.
+func readRecording(files map[string]*zip.File, name string, maxRecordingBytes int64) ([]byte, error) {
+	if len(name) == 0 {
+		return nil, fmt.Errorf("no file")
+	}
+	file, ok := files[path.Clean(name)]
+	if !ok {
+		return nil, fmt.Errorf("file %v not found in archive", name)
+	}
+
+	reader, err := file.Open()
+	if err != nil {
+		return nil, fmt.Errorf("error opening %v: %v", name, err)
+	}
+	defer reader.Close()
+
+	recording, err := io.ReadAll(io.LimitReader(reader, maxRecordingBytes+1))
+	if err != nil {
+		return nil, fmt.Errorf("error reading %v: %v", name, err)
+	}
+	if int64(len(recording)) > maxRecordingBytes {
+		return nil, fmt.Errorf("file %v exceeds %v bytes", name, maxRecordingBytes)
+	}
+	return recording, nil
+}
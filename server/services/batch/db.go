@@ -0,0 +1,571 @@
+package batch
+
+import (
+	"context"
+	"fmt"
+	"ht/model"
+	"ht/server/database"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type BatchDBHandlerFunctions interface {
+	CreateTable() error
+	DropTable() error
+	InsertBatch(batch *model.Batch, items []*model.BatchItem) (*model.Batch, error)
+	UpdateBatch(batch *model.Batch) (*model.Batch, error)
+	SelectBatch(rid uuid.UUID) (*model.Batch, error)
+	SelectAllBatches(lastId int, entries int) ([]*model.Batch, error)
+	SelectPendingBatchItems(batchRid uuid.UUID, limit int) ([]*model.BatchItem, error)
+	SelectBatchItems(batchRid uuid.UUID) ([]*model.BatchItem, error)
+	UpdateBatchItemVerdict(item *model.BatchItem) error
+	CountBatchItemVerdicts(batchRid uuid.UUID) (map[model.BatchItemVerdict]int, error)
+}
+
+type BatchDBHandler struct {
+	db *database.Database
+}
+
+func newBatchDBHandler(dbConnection *database.Database) *BatchDBHandler {
+	return &BatchDBHandler{
+		db: dbConnection,
+	}
+}
+
+func (r BatchDBHandler) CreateTable() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	_, err := r.db.Instance.ExecContext(
+		ctx,
+		`CREATE TABLE IF NOT EXISTS batch (
+			id BIGINT GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+			rid UUID UNIQUE DEFAULT gen_random_uuid(),
+			actor_rid UUID NOT NULL,
+			name TEXT DEFAULT '',
+			state TEXT NOT NULL DEFAULT 'queued',
+			item_count INT DEFAULT 0,
+			processed_count INT DEFAULT 0,
+			accepted_count INT DEFAULT 0,
+			rejected_count INT DEFAULT 0,
+			error_count INT DEFAULT 0,
+			error TEXT DEFAULT '',
+			completed_at TIMESTAMP WITH TIME ZONE DEFAULT '2000-01-01T01:23:45Z',
+			created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+			updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+		);
+
+		CREATE TABLE IF NOT EXISTS batch_item (
+			id BIGINT GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+			rid UUID UNIQUE DEFAULT gen_random_uuid(),
+			batch_rid UUID NOT NULL REFERENCES batch (rid) ON DELETE CASCADE,
+			position INT NOT NULL,
+			reference TEXT DEFAULT '',
+			claimed_email TEXT DEFAULT '',
+			file TEXT DEFAULT '',
+			recording BYTEA,
+			channel TEXT NOT NULL DEFAULT 'wideband',
+			verdict TEXT NOT NULL DEFAULT 'pending',
+			score DOUBLE PRECISION DEFAULT 0,
+			threshold DOUBLE PRECISION DEFAULT 0,
+			profile_rid UUID,
+			error TEXT DEFAULT '',
+			created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+			updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+		);`,
+	)
+	if err != nil {
+		return fmt.Errorf("error creating batch tables: %v", err)
+	}
+
+	err = r.db.CreateIndex("batch", "rid")
+	if err != nil {
+		return err
+	}
+
+	err = r.db.CreateIndex("batch_item", "rid")
+	if err != nil {
+		return err
+	}
+
+	err = r.db.CreateCombinedIndex("batch_item", "batch_rid", "verdict")
+	if err != nil {
+		return err
+	}
+
+	r.db.Logger.Println("created tables batch and batch_item")
+	return nil
+}
+
+func (r BatchDBHandler) DropTable() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	query := `DROP TABLE IF EXISTS batch_item;
+		DROP TABLE IF EXISTS batch`
+	_, err := r.db.Instance.ExecContext(ctx, query)
+	if err != nil {
+		return fmt.Errorf("error dropping batch tables: %#v", err)
+	}
+
+	r.db.Logger.Printf("dropped tables batch and batch_item")
+	return nil
+}
+
+// InsertBatch inserts the batch with all its items in one transaction, so workers never see a partial batch.
+func (r BatchDBHandler) InsertBatch(batch *model.Batch, items []*model.BatchItem) (*model.Batch, error) {
+	newBatch := &model.Batch{}
+
+	tx, err := r.db.Instance.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	row := tx.QueryRow(
+		`INSERT INTO batch (actor_rid, name, item_count)
+			VALUES ($1, $2, $3)
+		RETURNING
+			id,
+			rid,
+			actor_rid,
+			name,
+			state,
+			item_count,
+			processed_count,
+			accepted_count,
+			rejected_count,
+			error_count,
+			error,
+			completed_at,
+			created_at,
+			updated_at;`,
+		batch.ActorRID,
+		batch.Name,
+		len(items),
+	)
+
+	err = row.Scan(
+		&newBatch.ID,
+		&newBatch.RID,
+		&newBatch.ActorRID,
+		&newBatch.Name,
+		&newBatch.State,
+		&newBatch.ItemCount,
+		&newBatch.ProcessedCount,
+		&newBatch.AcceptedCount,
+		&newBatch.RejectedCount,
+		&newBatch.ErrorCount,
+		&newBatch.Error,
+		&newBatch.CompletedAt,
+		&newBatch.CreatedAt,
+		&newBatch.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	statement, err := tx.Prepare(
+		`INSERT INTO batch_item (batch_rid, position, reference, claimed_email, file, recording, channel, verdict, error)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer statement.Close()
+
+	for _, item := range items {
+		_, err = statement.Exec(
+			newBatch.RID,
+			item.Position,
+			item.Reference,
+			item.ClaimedEmail,
+			item.File,
+			item.Recording,
+			item.Channel,
+			item.Verdict,
+			item.Error,
+		)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	return newBatch, nil
+}
+
+// UpdateBatch writes the state, counts and error of the batch.
+func (r BatchDBHandler) UpdateBatch(batch *model.Batch) (*model.Batch, error) {
+	batchUpdated := &model.Batch{}
+
+	row := r.db.Instance.QueryRow(
+		`UPDATE
+			batch
+		SET
+			state = $1,
+			processed_count = $2,
+			accepted_count = $3,
+			rejected_count = $4,
+			error_count = $5,
+			error = $6,
+			completed_at = CASE WHEN $1 IN ('completed', 'failed') THEN CURRENT_TIMESTAMP ELSE completed_at END,
+			updated_at = CURRENT_TIMESTAMP
+		WHERE
+			rid = $7
+		RETURNING
+			id,
+			rid,
+			actor_rid,
+			name,
+			state,
+			item_count,
+			processed_count,
+			accepted_count,
+			rejected_count,
+			error_count,
+			error,
+			completed_at,
+			created_at,
+			updated_at`,
+		batch.State,
+		batch.ProcessedCount,
+		batch.AcceptedCount,
+		batch.RejectedCount,
+		batch.ErrorCount,
+		batch.Error,
+		batch.RID,
+	)
+
+	err := row.Scan(
+		&batchUpdated.ID,
+		&batchUpdated.RID,
+		&batchUpdated.ActorRID,
+		&batchUpdated.Name,
+		&batchUpdated.State,
+		&batchUpdated.ItemCount,
+		&batchUpdated.ProcessedCount,
+		&batchUpdated.AcceptedCount,
+		&batchUpdated.RejectedCount,
+		&batchUpdated.ErrorCount,
+		&batchUpdated.Error,
+		&batchUpdated.CompletedAt,
+		&batchUpdated.CreatedAt,
+		&batchUpdated.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return batchUpdated, nil
+}
+
+func (r BatchDBHandler) SelectBatch(rid uuid.UUID) (*model.Batch, error) {
+	batch := &model.Batch{}
+
+	row := r.db.Instance.QueryRow(
+		`SELECT
+			id,
+			rid,
+			actor_rid,
+			name,
+			state,
+			item_count,
+			processed_count,
+			accepted_count,
+			rejected_count,
+			error_count,
+			error,
+			completed_at,
+			created_at,
+			updated_at
+		FROM
+			batch
+		WHERE
+			rid = $1`,
+		rid,
+	)
+	err := row.Scan(
+		&batch.ID,
+		&batch.RID,
+		&batch.ActorRID,
+		&batch.Name,
+		&batch.State,
+		&batch.ItemCount,
+		&batch.ProcessedCount,
+		&batch.AcceptedCount,
+		&batch.RejectedCount,
+		&batch.ErrorCount,
+		&batch.Error,
+		&batch.CompletedAt,
+		&batch.CreatedAt,
+		&batch.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return batch, nil
+}
+
+func (r BatchDBHandler) SelectAllBatches(lastId int, entries int) ([]*model.Batch, error) {
+	batches := []*model.Batch{}
+
+	rows, err := r.db.Instance.Query(
+		`SELECT
+			id,
+			rid,
+			actor_rid,
+			name,
+			state,
+			item_count,
+			processed_count,
+			accepted_count,
+			rejected_count,
+			error_count,
+			error,
+			completed_at,
+			created_at,
+			updated_at
+		FROM
+			batch
+		WHERE
+			0 = $1
+			OR id < $1
+		ORDER BY
+			id DESC
+		LIMIT $2`,
+		lastId,
+		entries,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		batch := &model.Batch{}
+		err := rows.Scan(
+			&batch.ID,
+			&batch.RID,
+			&batch.ActorRID,
+			&batch.Name,
+			&batch.State,
+			&batch.ItemCount,
+			&batch.ProcessedCount,
+			&batch.AcceptedCount,
+			&batch.RejectedCount,
+			&batch.ErrorCount,
+			&batch.Error,
+			&batch.CompletedAt,
+			&batch.CreatedAt,
+			&batch.UpdatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		batches = append(batches, batch)
+	}
+
+	return batches, rows.Err()
+}
+
+// SelectPendingBatchItems returns the next limit items without verdict with their recordings, ordered by position.
+func (r BatchDBHandler) SelectPendingBatchItems(batchRid uuid.UUID, limit int) ([]*model.BatchItem, error) {
+	items := []*model.BatchItem{}
+
+	rows, err := r.db.Instance.Query(
+		`SELECT
+			id,
+			rid,
+			batch_rid,
+			position,
+			reference,
+			claimed_email,
+			file,
+			recording,
+			channel,
+			verdict,
+			score,
+			threshold,
+			profile_rid,
+			error,
+			created_at,
+			updated_at
+		FROM
+			batch_item
+		WHERE
+			batch_rid = $1
+			AND verdict = 'pending'
+		ORDER BY
+			position ASC
+		LIMIT $2`,
+		batchRid,
+		limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		item := &model.BatchItem{}
+		err := rows.Scan(
+			&item.ID,
+			&item.RID,
+			&item.BatchRID,
+			&item.Position,
+			&item.Reference,
+			&item.ClaimedEmail,
+			&item.File,
+			&item.Recording,
+			&item.Channel,
+			&item.Verdict,
+			&item.Score,
+			&item.Threshold,
+			&item.ProfileRID,
+			&item.Error,
+			&item.CreatedAt,
+			&item.UpdatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		items = append(items, item)
+	}
+
+	return items, rows.Err()
+}
+
+// SelectBatchItems returns all items of the batch without their recordings, ordered by position.
+func (r BatchDBHandler) SelectBatchItems(batchRid uuid.UUID) ([]*model.BatchItem, error) {
+	items := []*model.BatchItem{}
+
+	rows, err := r.db.Instance.Query(
+		`SELECT
+			id,
+			rid,
+			batch_rid,
+			position,
+			reference,
+			claimed_email,
+			file,
+			channel,
+			verdict,
+			score,
+			threshold,
+			profile_rid,
+			error,
+			created_at,
+			updated_at
+		FROM
+			batch_item
+		WHERE
+			batch_rid = $1
+		ORDER BY
+			position ASC`,
+		batchRid,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		item := &model.BatchItem{}
+		err := rows.Scan(
+			&item.ID,
+			&item.RID,
+			&item.BatchRID,
+			&item.Position,
+			&item.Reference,
+			&item.ClaimedEmail,
+			&item.File,
+			&item.Channel,
+			&item.Verdict,
+			&item.Score,
+			&item.Threshold,
+			&item.ProfileRID,
+			&item.Error,
+			&item.CreatedAt,
+			&item.UpdatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		items = append(items, item)
+	}
+
+	return items, rows.Err()
+}
+
+// UpdateBatchItemVerdict writes the verdict of the item and drops its recording, it is not needed anymore.
+func (r BatchDBHandler) UpdateBatchItemVerdict(item *model.BatchItem) error {
+	_, err := r.db.Instance.Exec(
+		`UPDATE
+			batch_item
+		SET
+			verdict = $1,
+			score = $2,
+			threshold = $3,
+			profile_rid = $4,
+			error = $5,
+			recording = NULL,
+			updated_at = CURRENT_TIMESTAMP
+		WHERE
+			rid = $6`,
+		item.Verdict,
+		item.Score,
+		item.Threshold,
+		uuid.NullUUID{UUID: item.ProfileRID, Valid: item.ProfileRID != uuid.Nil},
+		item.Error,
+		item.RID,
+	)
+	return err
+}
+
+// CountBatchItemVerdicts counts the items of the batch per verdict.
+func (r BatchDBHandler) CountBatchItemVerdicts(batchRid uuid.UUID) (map[model.BatchItemVerdict]int, error) {
+	counts := map[model.BatchItemVerdict]int{}
+
+	rows, err := r.db.Instance.Query(
+		`SELECT
+			verdict,
+			COUNT(*)
+		FROM
+			batch_item
+		WHERE
+			batch_rid = $1
+		GROUP BY
+			verdict`,
+		batchRid,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		verdict := model.BatchItemVerdict("")
+		count := 0
+		err := rows.Scan(&verdict, &count)
+		if err != nil {
+			return nil, err
+		}
+		counts[verdict] = count
+	}
+
+	return counts, rows.Err()
+}
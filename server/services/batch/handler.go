@@ -0,0 +1,378 @@
+package batch
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"ht/helper"
+	"ht/model"
+	"ht/server/database"
+	"ht/server/services/audit"
+	"ht/server/services/auth"
+	"ht/server/services/identification"
+	"ht/server/services/job"
+	"ht/server/voice"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+)
+
+// MAX_RECORDING_SIZE_MB limits single recordings of an archive like the interactive uploads.
+const MAX_RECORDING_SIZE_MB = 5
+
+var ErrBatchNotFound = errors.New("batch not found")
+
+type BatchService struct {
+	logger                *log.Logger
+	batchDb               BatchDBHandlerFunctions
+	jobService            *job.JobService
+	auditService          *audit.AuditService
+	authService           *auth.AuthService
+	identificationService *identification.IdentificationAttemptService
+	featureExtractor      voice.FeatureExtractor
+	maxSizeMb             int
+	maxItems              int
+	chunkSize             int
+}
+
+// NewBatchService verifies batches on the batch queue of the job service. The feature extractor should
+// have its own connection to the jobs service, see jobs.NewBatchClientFromEnv, so bulk extraction does
+// not compete with interactive identifications.
+func NewBatchService(identificationService *identification.IdentificationAttemptService, authService *auth.AuthService, jobService *job.JobService, auditService *audit.AuditService, featureExtractor voice.FeatureExtractor) *BatchService {
+	logger := log.New(os.Stdout, "batch: ", log.LstdFlags)
+	dbConnection := database.NewDatabase(
+		"batch",
+		&database.DatabaseConfiguration{
+			Host:     helper.GetEnvVariable("DB_BATCH_HOST"),
+			Port:     helper.GetEnvVariable("DB_BATCH_PORT"),
+			Database: helper.GetEnvVariable("DB_BATCH_DATABASE"),
+			Username: helper.GetEnvVariable("DB_BATCH_USERNAME"),
+			Password: helper.GetEnvVariable("DB_BATCH_PASSWORD"),
+			Schema:   helper.GetEnvVariable("DB_BATCH_SCHEMA"),
+		},
+	)
+	var batchDb BatchDBHandlerFunctions = newBatchDBHandler(dbConnection)
+
+	// creates main batch tables
+	err := batchDb.CreateTable()
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+
+	maxSizeMb, err := strconv.Atoi(helper.GetEnvVariableWithDefault("BATCH_MAX_SIZE_MB", "200"))
+	if err != nil {
+		log.Fatalf("invalid BATCH_MAX_SIZE_MB: %v", err)
+	}
+	maxItems, err := strconv.Atoi(helper.GetEnvVariableWithDefault("BATCH_MAX_ITEMS", "10000"))
+	if err != nil {
+		log.Fatalf("invalid BATCH_MAX_ITEMS: %v", err)
+	}
+	chunkSize, err := strconv.Atoi(helper.GetEnvVariableWithDefault("BATCH_CHUNK_SIZE", "50"))
+	if err != nil {
+		log.Fatalf("invalid BATCH_CHUNK_SIZE: %v", err)
+	}
+	if maxSizeMb < 1 || maxItems < 1 || chunkSize < 1 {
+		log.Fatal("BATCH_MAX_SIZE_MB, BATCH_MAX_ITEMS and BATCH_CHUNK_SIZE have to be at least 1")
+	}
+
+	newBatchService := &BatchService{
+		logger:                logger,
+		batchDb:               batchDb,
+		jobService:            jobService,
+		auditService:          auditService,
+		authService:           authService,
+		identificationService: identificationService,
+		featureExtractor:      featureExtractor,
+		maxSizeMb:             maxSizeMb,
+		maxItems:              maxItems,
+		chunkSize:             chunkSize,
+	}
+
+	jobService.RegisterHandler(model.JobTypeVerifyBatch, &job.JobHandler{
+		Handle: newBatchService.handleVerifyBatchJob,
+		OnDead: newBatchService.handleDeadVerifyBatchJob,
+		Queue:  model.JobQueueBatch,
+	})
+
+	return newBatchService
+}
+
+// CreateBatch reads the posted manifest and archive of recordings into a batch of the current user
+// and enqueues its verification.
+func (r *BatchService) CreateBatch(c echo.Context) (*model.Batch, error) {
+	actorRid := helper.GetCurrentUserRID(c.Request().Context())
+
+	c.Request().Body = http.MaxBytesReader(c.Response(), c.Request().Body, int64(r.maxSizeMb)<<20)
+	if err := c.Request().ParseMultipartForm(32 << 20); err != nil {
+		return nil, echo.NewHTTPError(http.StatusRequestEntityTooLarge, fmt.Sprintf("the upload has to be smaller than %v MB", r.maxSizeMb))
+	}
+
+	manifest, err := readFormFile(c, "manifest")
+	if err != nil {
+		return nil, echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("manifest missing: %v", err))
+	}
+	archive, err := readFormFile(c, "archive")
+	if err != nil {
+		return nil, echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("archive missing: %v", err))
+	}
+
+	items, err := readItems(manifest, archive, r.maxItems, MAX_RECORDING_SIZE_MB<<20, int64(r.maxSizeMb)<<20)
+	if err != nil {
+		return nil, echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	batch, err := r.batchDb.InsertBatch(&model.Batch{
+		ActorRID: actorRid,
+		Name:     strings.TrimSpace(c.FormValue("name")),
+	}, items)
+	if err != nil {
+		return nil, fmt.Errorf("error inserting batch: %v", err)
+	}
+
+	err = r.auditService.Record(actorRid, actorRid, model.AuditActionBatchVerificationCreated, map[string]any{
+		"batch_rid":  batch.RID,
+		"name":       batch.Name,
+		"item_count": batch.ItemCount,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = r.jobService.Enqueue(model.JobTypeVerifyBatch, &model.VerifyBatchPayload{BatchRID: batch.RID})
+	if err != nil {
+		return nil, err
+	}
+
+	return batch, nil
+}
+
+func readFormFile(c echo.Context, name string) ([]byte, error) {
+	file, _, err := c.Request().FormFile(name)
+	if err != nil {
+		return nil, err
+	}
+
+	defer file.Close()
+
+	return io.ReadAll(file)
+}
+
+func (r *BatchService) GetBatch(rid uuid.UUID) (*model.Batch, error) {
+	batch, err := r.batchDb.SelectBatch(rid)
+	if err == sql.ErrNoRows {
+		return nil, ErrBatchNotFound
+	} else if err != nil {
+		return nil, fmt.Errorf("error selecting batch: %v", err)
+	}
+	return batch, nil
+}
+
+func (r *BatchService) GetBatches(lastId int, entries int) ([]*model.Batch, error) {
+	batches, err := r.batchDb.SelectAllBatches(lastId, entries)
+	if err != nil {
+		return nil, fmt.Errorf("error selecting batches: %v", err)
+	}
+	return batches, nil
+}
+
+// GetBatchResult returns the csv result file of the batch with a line per item of the manifest.
+// Items not verified yet are listed as pending. The download is recorded in the audit trail.
+func (r *BatchService) GetBatchResult(actorRid uuid.UUID, rid uuid.UUID) (*model.Batch, []byte, error) {
+	batch, err := r.GetBatch(rid)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	items, err := r.batchDb.SelectBatchItems(batch.RID)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error selecting batch items: %v", err)
+	}
+
+	buf := bytes.NewBuffer(nil)
+	writer := csv.NewWriter(buf)
+	err = writer.Write([]string{"reference", "email", "file", "channel", "verdict", "score", "threshold", "profile_rid", "error"})
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, item := range items {
+		profileRid := ""
+		if item.ProfileRID != uuid.Nil {
+			profileRid = item.ProfileRID.String()
+		}
+		err = writer.Write([]string{
+			item.Reference,
+			item.ClaimedEmail,
+			item.File,
+			string(item.Channel),
+			string(item.Verdict),
+			strconv.FormatFloat(item.Score, 'f', -1, 64),
+			strconv.FormatFloat(item.Threshold, 'f', -1, 64),
+			profileRid,
+			item.Error,
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	writer.Flush()
+	if err = writer.Error(); err != nil {
+		return nil, nil, err
+	}
+
+	err = r.auditService.Record(actorRid, actorRid, model.AuditActionBatchResultDownloaded, map[string]any{
+		"batch_rid": batch.RID,
+		"state":     batch.State,
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return batch, buf.Bytes(), nil
+}
+
+// handleVerifyBatchJob verifies the next chunk of pending items and enqueues the next chunk,
+// so a batch never holds a batch worker for long. Failures of the jobs service are retried,
+// items that can not be verified get an error verdict.
+func (r *BatchService) handleVerifyBatchJob(batchJob *model.Job) error {
+	payload := &model.VerifyBatchPayload{}
+	err := json.Unmarshal(batchJob.Payload, payload)
+	if err != nil {
+		return err
+	}
+
+	batch, err := r.GetBatch(payload.BatchRID)
+	if err != nil {
+		return err
+	}
+	if batch.State.IsFinal() {
+		return nil
+	}
+
+	items, err := r.batchDb.SelectPendingBatchItems(batch.RID, r.chunkSize)
+	if err != nil {
+		return fmt.Errorf("error selecting pending batch items: %v", err)
+	}
+
+	for _, item := range items {
+		err = r.verifyItem(batch, item)
+		if err != nil {
+			// counts the items of this chunk verified so far before retrying
+			_ = r.updateProgress(batch, model.BatchStateRunning)
+			return err
+		}
+	}
+
+	if len(items) < r.chunkSize {
+		return r.updateProgress(batch, model.BatchStateCompleted)
+	}
+
+	err = r.updateProgress(batch, model.BatchStateRunning)
+	if err != nil {
+		return err
+	}
+
+	_, err = r.jobService.Enqueue(model.JobTypeVerifyBatch, payload)
+	return err
+}
+
+func (r *BatchService) handleDeadVerifyBatchJob(batchJob *model.Job) {
+	payload := &model.VerifyBatchPayload{}
+	err := json.Unmarshal(batchJob.Payload, payload)
+	if err != nil {
+		r.logger.Printf("invalid payload of dead verify batch job %v: %v", batchJob.RID, err)
+		return
+	}
+
+	batch, err := r.GetBatch(payload.BatchRID)
+	if err != nil {
+		r.logger.Printf("error selecting batch %v of dead job: %v", payload.BatchRID, err)
+		return
+	}
+
+	batch.Error = batchJob.LastError
+	err = r.updateProgress(batch, model.BatchStateFailed)
+	if err != nil {
+		r.logger.Printf("error failing batch %v: %v", batch.RID, err)
+	}
+}
+
+// verifyItem decides the item against the voice profiles of the claimed identity. It only returns
+// an error for failures worth retrying, the item stays pending then.
+func (r *BatchService) verifyItem(batch *model.Batch, item *model.BatchItem) error {
+	claimed, err := r.authService.GetAuthByEmail(item.ClaimedEmail)
+	if err != nil {
+		item.Verdict = model.BatchItemVerdictError
+		item.Error = "unknown identity"
+		return r.batchDb.UpdateBatchItemVerdict(item)
+	}
+
+	vector, err := r.featureExtractor.ExtractFeatures(context.Background(), item.Recording)
+	extractionError := &voice.ExtractionError{}
+	if errors.As(err, &extractionError) {
+		item.Verdict = model.BatchItemVerdictError
+		item.Error = extractionError.Error()
+		return r.batchDb.UpdateBatchItemVerdict(item)
+	} else if err != nil {
+		return fmt.Errorf("error extracting features of batch item %v: %v", item.RID, err)
+	}
+
+	decision, err := r.identificationService.VerifyRecording(claimed.RID, vector, r.featureExtractor.Extractor(), item.Channel)
+	if err != nil {
+		item.Verdict = model.BatchItemVerdictError
+		item.Error = err.Error()
+	} else {
+		item.Verdict = model.BatchItemVerdictRejected
+		if decision.Accepted {
+			item.Verdict = model.BatchItemVerdictAccepted
+		}
+		item.Score = decision.Score
+		item.Threshold = decision.Threshold
+		item.ProfileRID = decision.ProfileRID
+	}
+
+	err = r.batchDb.UpdateBatchItemVerdict(item)
+	if err != nil {
+		return fmt.Errorf("error updating batch item %v: %v", item.RID, err)
+	}
+
+	return r.auditService.Record(batch.ActorRID, claimed.RID, model.AuditActionBatchVerificationResult, map[string]any{
+		"batch_rid":   batch.RID,
+		"item_rid":    item.RID,
+		"reference":   item.Reference,
+		"verdict":     item.Verdict,
+		"score":       item.Score,
+		"threshold":   item.Threshold,
+		"profile_rid": item.ProfileRID,
+		"error":       item.Error,
+	})
+}
+
+// updateProgress recounts the verdicts of the items and writes them with the state.
+func (r *BatchService) updateProgress(batch *model.Batch, state model.BatchState) error {
+	counts, err := r.batchDb.CountBatchItemVerdicts(batch.RID)
+	if err != nil {
+		return fmt.Errorf("error counting batch item verdicts: %v", err)
+	}
+
+	batch.State = state
+	batch.AcceptedCount = counts[model.BatchItemVerdictAccepted]
+	batch.RejectedCount = counts[model.BatchItemVerdictRejected]
+	batch.ErrorCount = counts[model.BatchItemVerdictError]
+	batch.ProcessedCount = batch.AcceptedCount + batch.RejectedCount + batch.ErrorCount
+
+	_, err = r.batchDb.UpdateBatch(batch)
+	if err != nil {
+		return fmt.Errorf("error updating batch: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,79 @@
+package identification
+
+import (
+	"database/sql"
+	"errors"
+	"ht/helper"
+	"ht/model"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+)
+
+var ErrAssistedAttemptNotFound = errors.New("assisted verification not found")
+
+// CreateAssistedIdentificationAttempt verifies the posted call snippet of the customer for the current agent.
+// The attempt is scored like one of the customer themselves, but neither counts towards their lockout nor adapts
+// their template. The request comes from the agent, so only the history of the customer is assessed for the risk.
+func (r *IdentificationAttemptService) CreateAssistedIdentificationAttempt(c echo.Context, customerRid uuid.UUID) (*model.IdentificationAttempt, error) {
+	agentRid := helper.GetCurrentUserRID(c.Request().Context())
+
+	recording, channel, err := readRecording(c)
+	if err != nil {
+		return nil, err
+	}
+
+	identificationAttempt := &model.IdentificationAttempt{
+		UserRID:   customerRid,
+		AgentRID:  agentRid,
+		Recording: recording,
+		Channel:   channel,
+	}
+
+	err = r.assessRiskSignals(identificationAttempt, &model.RiskSignals{Now: time.Now()})
+	if err != nil {
+		return nil, err
+	}
+
+	identificationAttempt, err = r.identificationAttemptDb.InsertIdentificationAttempt(identificationAttempt)
+	if err != nil {
+		return nil, err
+	}
+
+	err = r.auditService.Record(agentRid, customerRid, model.AuditActionAssistedVerification, map[string]any{
+		"attempt_rid": identificationAttempt.RID,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return r.startProcessing(identificationAttempt)
+}
+
+// GetAssistedIdentificationAttempt returns an attempt of the agent console, attempts of users themselves are not found.
+func (r *IdentificationAttemptService) GetAssistedIdentificationAttempt(rid uuid.UUID) (*model.IdentificationAttempt, error) {
+	identificationAttempt, err := r.identificationAttemptDb.SelectIdentificationAttempt(rid)
+	if err == sql.ErrNoRows {
+		return nil, ErrAssistedAttemptNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	if !identificationAttempt.IsAssisted() {
+		return nil, ErrAssistedAttemptNotFound
+	}
+	return identificationAttempt, nil
+}
+
+// recordAssistedVerificationResult records the verdict against the agent and the customer.
+func (r *IdentificationAttemptService) recordAssistedVerificationResult(identificationAttempt *model.IdentificationAttempt, threshold float64) error {
+	return r.auditService.Record(identificationAttempt.AgentRID, identificationAttempt.UserRID, model.AuditActionAssistedVerificationResult, map[string]any{
+		"attempt_rid": identificationAttempt.RID,
+		"state":       identificationAttempt.State,
+		"score":       identificationAttempt.Score,
+		"threshold":   threshold,
+		"profile_rid": identificationAttempt.ProfileRID,
+		"risk_score":  identificationAttempt.RiskScore,
+		"risk_action": identificationAttempt.RiskAction,
+	})
+}
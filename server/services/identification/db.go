@@ -2,6 +2,8 @@ package identification
 
 import (
 	"context"
+	"database/sql"
+	"errors"
 	"fmt"
 	"ht/model"
 	"ht/server/database"
@@ -11,13 +13,26 @@ import (
 	"github.com/google/uuid"
 )
 
+var ErrInvalidTransition = errors.New("invalid identification attempt state transition")
+
 type IdentificationAttemptDBHandlerFunctions interface {
 	CreateTable() error
 	DropTable() error
 	InsertIdentificationAttempt(identificationAttempt *model.IdentificationAttempt) (*model.IdentificationAttempt, error)
 	UpdateIdentificationAttempt(identificationAttempt *model.IdentificationAttempt) (*model.IdentificationAttempt, error)
+	UpdateIdentificationAttemptFeatures(rid uuid.UUID, recordingMfcc model.Vector, extractor model.Extractor) error
+	UpdateIdentificationAttemptState(identificationAttempt *model.IdentificationAttempt, state model.IdentificationAttemptState) (*model.IdentificationAttempt, error)
+	UpdateIdentificationAttemptStepUpCode(rid uuid.UUID, code string) error
+	UpdateIdentificationAttemptWatchlistHit(rid uuid.UUID, watchlistHit *model.WatchlistHit, riskAction model.RiskAction) error
+	CheckStepUpCodeValid(rid uuid.UUID, code string) bool
+	ExpireIdentificationAttempts(createdBefore time.Time, stepUpBefore time.Time) (int64, error)
+	DeleteIdentificationAttemptRecordings(userRid uuid.UUID) error
 	SelectIdentificationAttempt(rid uuid.UUID) (*model.IdentificationAttempt, error)
 	SelectLatestIdentificationAttemptByUserRID(userRid uuid.UUID) (*model.IdentificationAttempt, error)
+	SelectLatestAcceptedIdentificationAttemptByUserRID(userRid uuid.UUID) (*model.IdentificationAttempt, error)
+	CountIdentificationAttemptsByUserRIDAndState(userRid uuid.UUID, state model.IdentificationAttemptState, since time.Time) (int, error)
+	CountIdentificationAttemptsByUserRID(userRid uuid.UUID, since time.Time) (int, time.Time, error)
+	CountConsecutiveRejections(userRid uuid.UUID, since time.Time) (int, error)
 	SelectAllIdentificationAttempts(lastId int, entries int) ([]*model.IdentificationAttempt, error)
 	SelectAllIdentificationAttemptsBySearch(search string, lastId int, entries int) ([]*model.IdentificationAttempt, error)
 }
@@ -39,32 +54,227 @@ func (r IdentificationAttemptDBHandler) CreateTable() error {
 	_, err := r.db.Instance.ExecContext(
 		ctx,
 		`CREATE EXTENSION IF NOT EXISTS vector;
+		CREATE EXTENSION IF NOT EXISTS pgcrypto;
 		
 		CREATE TABLE IF NOT EXISTS identification_attempt (
 			id BIGINT GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
 			rid UUID UNIQUE DEFAULT gen_random_uuid(),
 			user_rid UUID NOT NULL,
 			recording BYTEA,
-			recording_mfcc VECTOR(40),
-			identified BOOLEAN DEFAULT FALSE,
-			used BOOLEAN DEFAULT FALSE,
+			recording_mfcc VECTOR,
+			extractor TEXT,
+			channel TEXT NOT NULL DEFAULT 'wideband',
+			state TEXT NOT NULL DEFAULT 'pending',
+			score DOUBLE PRECISION DEFAULT 0,
+			ip_address TEXT DEFAULT '',
+			country TEXT DEFAULT '',
+			device_fingerprint TEXT DEFAULT '',
+			risk_score DOUBLE PRECISION DEFAULT 0,
+			risk_action TEXT DEFAULT 'allow',
+			risk_factors JSONB DEFAULT '{}',
+			profile_rid UUID,
+			agent_rid UUID,
+			watchlist_entry_rid UUID,
+			watchlist_distance DOUBLE PRECISION DEFAULT 0,
+			duress BOOLEAN DEFAULT FALSE,
+			step_up_code_hash TEXT DEFAULT '',
+			error TEXT DEFAULT '',
+			job_rid UUID,
+			processing_at TIMESTAMP WITH TIME ZONE DEFAULT '2000-01-01T01:23:45Z',
+			step_up_at TIMESTAMP WITH TIME ZONE DEFAULT '2000-01-01T01:23:45Z',
+			accepted_at TIMESTAMP WITH TIME ZONE DEFAULT '2000-01-01T01:23:45Z',
+			rejected_at TIMESTAMP WITH TIME ZONE DEFAULT '2000-01-01T01:23:45Z',
+			expired_at TIMESTAMP WITH TIME ZONE DEFAULT '2000-01-01T01:23:45Z',
+			error_at TIMESTAMP WITH TIME ZONE DEFAULT '2000-01-01T01:23:45Z',
 			created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
 			updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
-		);`,
+		);
+
+		ALTER TABLE identification_attempt ADD COLUMN IF NOT EXISTS score DOUBLE PRECISION DEFAULT 0;
+		ALTER TABLE identification_attempt ADD COLUMN IF NOT EXISTS state TEXT NOT NULL DEFAULT 'pending';
+		ALTER TABLE identification_attempt ADD COLUMN IF NOT EXISTS error TEXT DEFAULT '';
+		ALTER TABLE identification_attempt ADD COLUMN IF NOT EXISTS job_rid UUID;
+		ALTER TABLE identification_attempt ADD COLUMN IF NOT EXISTS processing_at TIMESTAMP WITH TIME ZONE DEFAULT '2000-01-01T01:23:45Z';
+		ALTER TABLE identification_attempt ADD COLUMN IF NOT EXISTS accepted_at TIMESTAMP WITH TIME ZONE DEFAULT '2000-01-01T01:23:45Z';
+		ALTER TABLE identification_attempt ADD COLUMN IF NOT EXISTS rejected_at TIMESTAMP WITH TIME ZONE DEFAULT '2000-01-01T01:23:45Z';
+		ALTER TABLE identification_attempt ADD COLUMN IF NOT EXISTS expired_at TIMESTAMP WITH TIME ZONE DEFAULT '2000-01-01T01:23:45Z';
+		ALTER TABLE identification_attempt ADD COLUMN IF NOT EXISTS error_at TIMESTAMP WITH TIME ZONE DEFAULT '2000-01-01T01:23:45Z';
+		ALTER TABLE identification_attempt ADD COLUMN IF NOT EXISTS extractor TEXT;
+		ALTER TABLE identification_attempt ADD COLUMN IF NOT EXISTS ip_address TEXT DEFAULT '';
+		ALTER TABLE identification_attempt ADD COLUMN IF NOT EXISTS country TEXT DEFAULT '';
+		ALTER TABLE identification_attempt ADD COLUMN IF NOT EXISTS device_fingerprint TEXT DEFAULT '';
+		ALTER TABLE identification_attempt ADD COLUMN IF NOT EXISTS risk_score DOUBLE PRECISION DEFAULT 0;
+		ALTER TABLE identification_attempt ADD COLUMN IF NOT EXISTS risk_action TEXT DEFAULT 'allow';
+		ALTER TABLE identification_attempt ADD COLUMN IF NOT EXISTS risk_factors JSONB DEFAULT '{}';
+		ALTER TABLE identification_attempt ADD COLUMN IF NOT EXISTS profile_rid UUID;
+		ALTER TABLE identification_attempt ADD COLUMN IF NOT EXISTS agent_rid UUID;
+		ALTER TABLE identification_attempt ADD COLUMN IF NOT EXISTS step_up_code_hash TEXT DEFAULT '';
+		ALTER TABLE identification_attempt ADD COLUMN IF NOT EXISTS channel TEXT NOT NULL DEFAULT 'wideband';
+		ALTER TABLE identification_attempt ADD COLUMN IF NOT EXISTS watchlist_entry_rid UUID;
+		ALTER TABLE identification_attempt ADD COLUMN IF NOT EXISTS watchlist_distance DOUBLE PRECISION DEFAULT 0;
+		ALTER TABLE identification_attempt ADD COLUMN IF NOT EXISTS duress BOOLEAN DEFAULT FALSE;
+		ALTER TABLE identification_attempt ADD COLUMN IF NOT EXISTS step_up_at TIMESTAMP WITH TIME ZONE DEFAULT '2000-01-01T01:23:45Z';`,
 	)
 	if err != nil {
 		return fmt.Errorf("error creating identificationAttempt table: %v", err)
 	}
 
+	err = r.migrateUsedFlag(ctx)
+	if err != nil {
+		return err
+	}
+
+	err = r.migrateExtractor(ctx)
+	if err != nil {
+		return err
+	}
+
+	// notifies every server instance about state changes, see stateListener,
+	// attempts of the agent console are not shown to the user
+	_, err = r.db.Instance.ExecContext(
+		ctx,
+		`CREATE OR REPLACE FUNCTION notify_identification_attempt_state() RETURNS trigger AS $$
+		BEGIN
+			IF TG_OP = 'UPDATE' AND OLD.state = NEW.state THEN
+				RETURN NEW;
+			END IF;
+			IF NEW.agent_rid IS NOT NULL THEN
+				RETURN NEW;
+			END IF;
+			PERFORM pg_notify(
+				'`+STATE_CHANNEL+`',
+				json_build_object('rid', NEW.rid, 'user_rid', NEW.user_rid, 'state', NEW.state)::text
+			);
+			RETURN NEW;
+		END;
+		$$ LANGUAGE plpgsql;
+
+		DROP TRIGGER IF EXISTS identification_attempt_state_notify ON identification_attempt;
+		CREATE TRIGGER identification_attempt_state_notify
+			AFTER INSERT OR UPDATE OF state ON identification_attempt
+			FOR EACH ROW EXECUTE FUNCTION notify_identification_attempt_state();`,
+	)
+	if err != nil {
+		return fmt.Errorf("error creating identificationAttempt state trigger: %v", err)
+	}
+
 	err = r.db.CreateIndex("identification_attempt", "rid")
 	if err != nil {
 		return err
 	}
 
+	err = r.db.CreateCombinedIndex("identification_attempt", "state", "created_at")
+	if err != nil {
+		return err
+	}
+
 	r.db.Logger.Println("created table identificationAttempt")
 	return nil
 }
 
+// migrateUsedFlag maps the old identified and used flags to states and drops them.
+// Attempts that were neither used nor identified were never decided and expire.
+func (r IdentificationAttemptDBHandler) migrateUsedFlag(ctx context.Context) error {
+	exists := false
+	err := r.db.Instance.QueryRowContext(
+		ctx,
+		`SELECT EXISTS (
+			SELECT 1
+			FROM information_schema.columns
+			WHERE table_schema = current_schema()
+				AND table_name = 'identification_attempt'
+				AND column_name = 'used'
+		)`,
+	).Scan(&exists)
+	if err != nil {
+		return fmt.Errorf("error checking identification_attempt used column: %v", err)
+	}
+	if !exists {
+		return nil
+	}
+
+	tx, err := r.db.Instance.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(
+		ctx,
+		`UPDATE
+			identification_attempt
+		SET
+			state = CASE
+				WHEN identified THEN 'accepted'
+				WHEN used THEN 'rejected'
+				ELSE 'expired'
+			END,
+			accepted_at = CASE WHEN identified THEN updated_at ELSE accepted_at END,
+			rejected_at = CASE WHEN used AND NOT identified THEN updated_at ELSE rejected_at END,
+			expired_at = CASE WHEN NOT used AND NOT identified THEN updated_at ELSE expired_at END;`,
+	)
+	if err != nil {
+		return fmt.Errorf("error migrating identification_attempt states: %v", err)
+	}
+
+	_, err = tx.ExecContext(
+		ctx,
+		`ALTER TABLE identification_attempt
+			DROP COLUMN IF EXISTS identified,
+			DROP COLUMN IF EXISTS used;`,
+	)
+	if err != nil {
+		return fmt.Errorf("error dropping identification_attempt used column: %v", err)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+
+	r.db.Logger.Println("migrated identification_attempt used flag to states")
+	return nil
+}
+
+// migrateExtractor drops the fixed dimension of recording_mfcc and tags the existing vectors with the legacy extractor.
+func (r IdentificationAttemptDBHandler) migrateExtractor(ctx context.Context) error {
+	dimension, err := r.db.VectorDimension(ctx, "identification_attempt", "recording_mfcc")
+	if err != nil {
+		return err
+	}
+	if dimension == 0 {
+		return nil
+	}
+
+	tx, err := r.db.Instance.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `ALTER TABLE identification_attempt ALTER COLUMN recording_mfcc TYPE VECTOR`)
+	if err != nil {
+		return fmt.Errorf("error dropping identification_attempt vector dimension: %v", err)
+	}
+
+	_, err = tx.ExecContext(
+		ctx,
+		`UPDATE
+			identification_attempt
+		SET
+			extractor = $1
+		WHERE
+			recording_mfcc IS NOT NULL
+			AND extractor IS NULL`,
+		model.LegacyExtractor,
+	)
+	if err != nil {
+		return fmt.Errorf("error tagging identification_attempt vectors: %v", err)
+	}
+
+	return tx.Commit()
+}
+
 func (r IdentificationAttemptDBHandler) DropTable() error {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
@@ -83,19 +293,49 @@ func (r IdentificationAttemptDBHandler) InsertIdentificationAttempt(identificati
 	newIdentificationAttempt := &model.IdentificationAttempt{}
 
 	row := r.db.Instance.QueryRow(
-		`INSERT INTO identification_attempt (user_rid, recording)
-			VALUES ($1, $2)
+		`INSERT INTO identification_attempt (user_rid, recording, ip_address, country, device_fingerprint, risk_score, risk_action, risk_factors, agent_rid, channel)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
 		RETURNING
 			id,
 			rid,
 			user_rid,
 			recording,
-			identified,
-			used,
+			recording_mfcc,
+			COALESCE(extractor, ''),
+			channel,
+			state,
+			score,
+			ip_address,
+			country,
+			device_fingerprint,
+			risk_score,
+			risk_action,
+			risk_factors,
+			profile_rid,
+			agent_rid,
+			watchlist_entry_rid,
+			watchlist_distance,
+			duress,
+			error,
+			COALESCE(job_rid, '00000000-0000-0000-0000-000000000000'),
+			processing_at,
+			step_up_at,
+			accepted_at,
+			rejected_at,
+			expired_at,
+			error_at,
 			created_at,
 			updated_at;`,
 		identificationAttempt.UserRID,
 		identificationAttempt.Recording,
+		identificationAttempt.IPAddress,
+		identificationAttempt.Country,
+		identificationAttempt.DeviceFingerprint,
+		identificationAttempt.RiskScore,
+		identificationAttempt.RiskAction,
+		identificationAttempt.RiskFactors,
+		uuid.NullUUID{UUID: identificationAttempt.AgentRID, Valid: identificationAttempt.IsAssisted()},
+		identificationAttempt.Channel,
 	)
 
 	err := row.Scan(
@@ -103,8 +343,30 @@ func (r IdentificationAttemptDBHandler) InsertIdentificationAttempt(identificati
 		&newIdentificationAttempt.RID,
 		&newIdentificationAttempt.UserRID,
 		&newIdentificationAttempt.Recording,
-		&newIdentificationAttempt.Identified,
-		&newIdentificationAttempt.Used,
+		&newIdentificationAttempt.RecordingMfcc,
+		&newIdentificationAttempt.Extractor,
+		&newIdentificationAttempt.Channel,
+		&newIdentificationAttempt.State,
+		&newIdentificationAttempt.Score,
+		&newIdentificationAttempt.IPAddress,
+		&newIdentificationAttempt.Country,
+		&newIdentificationAttempt.DeviceFingerprint,
+		&newIdentificationAttempt.RiskScore,
+		&newIdentificationAttempt.RiskAction,
+		&newIdentificationAttempt.RiskFactors,
+		&newIdentificationAttempt.ProfileRID,
+		&newIdentificationAttempt.AgentRID,
+		&newIdentificationAttempt.WatchlistEntryRID,
+		&newIdentificationAttempt.WatchlistDistance,
+		&newIdentificationAttempt.Duress,
+		&newIdentificationAttempt.Error,
+		&newIdentificationAttempt.JobRID,
+		&newIdentificationAttempt.ProcessingAt,
+		&newIdentificationAttempt.StepUpAt,
+		&newIdentificationAttempt.AcceptedAt,
+		&newIdentificationAttempt.RejectedAt,
+		&newIdentificationAttempt.ExpiredAt,
+		&newIdentificationAttempt.ErrorAt,
 		&newIdentificationAttempt.CreatedAt,
 		&newIdentificationAttempt.UpdatedAt,
 	)
@@ -123,8 +385,8 @@ func (r IdentificationAttemptDBHandler) UpdateIdentificationAttempt(identificati
 			identification_attempt
 		SET
 			recording = $1,
-            identified = $2,
-			used = $3,
+			score = $2,
+			job_rid = $3,
 			updated_at = CURRENT_TIMESTAMP
 		WHERE
 			rid = $4
@@ -133,13 +395,35 @@ func (r IdentificationAttemptDBHandler) UpdateIdentificationAttempt(identificati
 			rid,
 			user_rid,
 			recording,
-			identified,
-			used,
+			recording_mfcc,
+			COALESCE(extractor, ''),
+			channel,
+			state,
+			score,
+			ip_address,
+			country,
+			device_fingerprint,
+			risk_score,
+			risk_action,
+			risk_factors,
+			profile_rid,
+			agent_rid,
+			watchlist_entry_rid,
+			watchlist_distance,
+			duress,
+			error,
+			COALESCE(job_rid, '00000000-0000-0000-0000-000000000000'),
+			processing_at,
+			step_up_at,
+			accepted_at,
+			rejected_at,
+			expired_at,
+			error_at,
 			created_at,
 			updated_at;`,
 		identificationAttempt.Recording,
-		identificationAttempt.Identified,
-		identificationAttempt.Used,
+		identificationAttempt.Score,
+		identificationAttempt.JobRID,
 		identificationAttempt.RID,
 	)
 
@@ -148,8 +432,30 @@ func (r IdentificationAttemptDBHandler) UpdateIdentificationAttempt(identificati
 		&identificationAttemptUpdated.RID,
 		&identificationAttemptUpdated.UserRID,
 		&identificationAttemptUpdated.Recording,
-		&identificationAttemptUpdated.Identified,
-		&identificationAttemptUpdated.Used,
+		&identificationAttemptUpdated.RecordingMfcc,
+		&identificationAttemptUpdated.Extractor,
+		&identificationAttemptUpdated.Channel,
+		&identificationAttemptUpdated.State,
+		&identificationAttemptUpdated.Score,
+		&identificationAttemptUpdated.IPAddress,
+		&identificationAttemptUpdated.Country,
+		&identificationAttemptUpdated.DeviceFingerprint,
+		&identificationAttemptUpdated.RiskScore,
+		&identificationAttemptUpdated.RiskAction,
+		&identificationAttemptUpdated.RiskFactors,
+		&identificationAttemptUpdated.ProfileRID,
+		&identificationAttemptUpdated.AgentRID,
+		&identificationAttemptUpdated.WatchlistEntryRID,
+		&identificationAttemptUpdated.WatchlistDistance,
+		&identificationAttemptUpdated.Duress,
+		&identificationAttemptUpdated.Error,
+		&identificationAttemptUpdated.JobRID,
+		&identificationAttemptUpdated.ProcessingAt,
+		&identificationAttemptUpdated.StepUpAt,
+		&identificationAttemptUpdated.AcceptedAt,
+		&identificationAttemptUpdated.RejectedAt,
+		&identificationAttemptUpdated.ExpiredAt,
+		&identificationAttemptUpdated.ErrorAt,
 		&identificationAttemptUpdated.CreatedAt,
 		&identificationAttemptUpdated.UpdatedAt,
 	)
@@ -160,6 +466,186 @@ func (r IdentificationAttemptDBHandler) UpdateIdentificationAttempt(identificati
 	return identificationAttemptUpdated, err
 }
 
+// UpdateIdentificationAttemptFeatures stores the extracted features of a processing attempt.
+func (r IdentificationAttemptDBHandler) UpdateIdentificationAttemptFeatures(rid uuid.UUID, recordingMfcc model.Vector, extractor model.Extractor) error {
+	result, err := r.db.Instance.Exec(
+		`UPDATE
+			identification_attempt
+		SET
+			recording_mfcc = $1,
+			extractor = $2,
+			updated_at = CURRENT_TIMESTAMP
+		WHERE
+			rid = $3
+			AND state = 'processing'`,
+		recordingMfcc,
+		extractor,
+		rid,
+	)
+	if err != nil {
+		return err
+	}
+
+	updated, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if updated == 0 {
+		return fmt.Errorf("%w: attempt %v is not processing", ErrInvalidTransition, rid)
+	}
+	return nil
+}
+
+// UpdateIdentificationAttemptState moves the attempt from its current state to the given state,
+// together with the score and error of the attempt. The transition is only written if it is allowed
+// and the attempt is still in the state it was read in, otherwise ErrInvalidTransition is returned.
+func (r IdentificationAttemptDBHandler) UpdateIdentificationAttemptState(identificationAttempt *model.IdentificationAttempt, state model.IdentificationAttemptState) (*model.IdentificationAttempt, error) {
+	if !identificationAttempt.State.CanTransitionTo(state) {
+		return nil, fmt.Errorf("%w: %v to %v", ErrInvalidTransition, identificationAttempt.State, state)
+	}
+
+	identificationAttemptUpdated := &model.IdentificationAttempt{}
+
+	row := r.db.Instance.QueryRow(
+		`UPDATE
+			identification_attempt
+		SET
+			state = $1,
+			score = $2,
+			error = $3,
+			profile_rid = COALESCE($6, profile_rid),
+			duress = $7,
+			processing_at = CASE WHEN $1 = 'processing' THEN CURRENT_TIMESTAMP ELSE processing_at END,
+			step_up_at = CASE WHEN $1 = 'step_up' THEN CURRENT_TIMESTAMP ELSE step_up_at END,
+			accepted_at = CASE WHEN $1 = 'accepted' THEN CURRENT_TIMESTAMP ELSE accepted_at END,
+			rejected_at = CASE WHEN $1 = 'rejected' THEN CURRENT_TIMESTAMP ELSE rejected_at END,
+			expired_at = CASE WHEN $1 = 'expired' THEN CURRENT_TIMESTAMP ELSE expired_at END,
+			error_at = CASE WHEN $1 = 'error' THEN CURRENT_TIMESTAMP ELSE error_at END,
+			updated_at = CURRENT_TIMESTAMP
+		WHERE
+			rid = $4
+			AND state = $5
+		RETURNING
+			id,
+			rid,
+			user_rid,
+			recording,
+			recording_mfcc,
+			COALESCE(extractor, ''),
+			channel,
+			state,
+			score,
+			ip_address,
+			country,
+			device_fingerprint,
+			risk_score,
+			risk_action,
+			risk_factors,
+			profile_rid,
+			agent_rid,
+			watchlist_entry_rid,
+			watchlist_distance,
+			duress,
+			error,
+			COALESCE(job_rid, '00000000-0000-0000-0000-000000000000'),
+			processing_at,
+			step_up_at,
+			accepted_at,
+			rejected_at,
+			expired_at,
+			error_at,
+			created_at,
+			updated_at;`,
+		state,
+		identificationAttempt.Score,
+		identificationAttempt.Error,
+		identificationAttempt.RID,
+		identificationAttempt.State,
+		uuid.NullUUID{UUID: identificationAttempt.ProfileRID, Valid: identificationAttempt.ProfileRID != uuid.Nil},
+		identificationAttempt.Duress,
+	)
+
+	err := row.Scan(
+		&identificationAttemptUpdated.ID,
+		&identificationAttemptUpdated.RID,
+		&identificationAttemptUpdated.UserRID,
+		&identificationAttemptUpdated.Recording,
+		&identificationAttemptUpdated.RecordingMfcc,
+		&identificationAttemptUpdated.Extractor,
+		&identificationAttemptUpdated.Channel,
+		&identificationAttemptUpdated.State,
+		&identificationAttemptUpdated.Score,
+		&identificationAttemptUpdated.IPAddress,
+		&identificationAttemptUpdated.Country,
+		&identificationAttemptUpdated.DeviceFingerprint,
+		&identificationAttemptUpdated.RiskScore,
+		&identificationAttemptUpdated.RiskAction,
+		&identificationAttemptUpdated.RiskFactors,
+		&identificationAttemptUpdated.ProfileRID,
+		&identificationAttemptUpdated.AgentRID,
+		&identificationAttemptUpdated.WatchlistEntryRID,
+		&identificationAttemptUpdated.WatchlistDistance,
+		&identificationAttemptUpdated.Duress,
+		&identificationAttemptUpdated.Error,
+		&identificationAttemptUpdated.JobRID,
+		&identificationAttemptUpdated.ProcessingAt,
+		&identificationAttemptUpdated.StepUpAt,
+		&identificationAttemptUpdated.AcceptedAt,
+		&identificationAttemptUpdated.RejectedAt,
+		&identificationAttemptUpdated.ExpiredAt,
+		&identificationAttemptUpdated.ErrorAt,
+		&identificationAttemptUpdated.CreatedAt,
+		&identificationAttemptUpdated.UpdatedAt,
+	)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("%w: attempt %v is no longer %v", ErrInvalidTransition, identificationAttempt.RID, identificationAttempt.State)
+	} else if err != nil {
+		return nil, err
+	}
+
+	return identificationAttemptUpdated, nil
+}
+
+// DeleteIdentificationAttemptRecordings removes the recordings and features of all attempts of the user.
+// The attempts themselves stay for the audit trail.
+func (r IdentificationAttemptDBHandler) DeleteIdentificationAttemptRecordings(userRid uuid.UUID) error {
+	_, err := r.db.Instance.Exec(
+		`UPDATE
+			identification_attempt
+		SET
+			recording = NULL,
+			recording_mfcc = NULL,
+			updated_at = CURRENT_TIMESTAMP
+		WHERE
+			user_rid = $1`,
+		userRid,
+	)
+	return err
+}
+
+// ExpireIdentificationAttempts expires all undecided attempts created before the given time
+// and all attempts waiting for a step up code since before stepUpBefore.
+func (r IdentificationAttemptDBHandler) ExpireIdentificationAttempts(createdBefore time.Time, stepUpBefore time.Time) (int64, error) {
+	result, err := r.db.Instance.Exec(
+		`UPDATE
+			identification_attempt
+		SET
+			state = 'expired',
+			expired_at = CURRENT_TIMESTAMP,
+			updated_at = CURRENT_TIMESTAMP
+		WHERE
+			(state IN ('pending', 'processing') AND created_at < $1)
+			OR (state = 'step_up' AND step_up_at < $2)`,
+		createdBefore,
+		stepUpBefore,
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
+
 func (r IdentificationAttemptDBHandler) DeleteIdentificationAttempt(rid uuid.UUID) error {
 	_, err := r.db.Instance.Exec(
 		`DELETE FROM identification_attempt
@@ -182,8 +668,30 @@ func (r IdentificationAttemptDBHandler) SelectIdentificationAttempt(rid uuid.UUI
 			rid,
 			user_rid,
 			recording,
-			identified,
-			used,
+			recording_mfcc,
+			COALESCE(extractor, ''),
+			channel,
+			state,
+			score,
+			ip_address,
+			country,
+			device_fingerprint,
+			risk_score,
+			risk_action,
+			risk_factors,
+			profile_rid,
+			agent_rid,
+			watchlist_entry_rid,
+			watchlist_distance,
+			duress,
+			error,
+			COALESCE(job_rid, '00000000-0000-0000-0000-000000000000'),
+			processing_at,
+			step_up_at,
+			accepted_at,
+			rejected_at,
+			expired_at,
+			error_at,
 			created_at,
 			updated_at
 		FROM
@@ -197,8 +705,30 @@ func (r IdentificationAttemptDBHandler) SelectIdentificationAttempt(rid uuid.UUI
 		&identificationAttempt.RID,
 		&identificationAttempt.UserRID,
 		&identificationAttempt.Recording,
-		&identificationAttempt.Identified,
-		&identificationAttempt.Used,
+		&identificationAttempt.RecordingMfcc,
+		&identificationAttempt.Extractor,
+		&identificationAttempt.Channel,
+		&identificationAttempt.State,
+		&identificationAttempt.Score,
+		&identificationAttempt.IPAddress,
+		&identificationAttempt.Country,
+		&identificationAttempt.DeviceFingerprint,
+		&identificationAttempt.RiskScore,
+		&identificationAttempt.RiskAction,
+		&identificationAttempt.RiskFactors,
+		&identificationAttempt.ProfileRID,
+		&identificationAttempt.AgentRID,
+		&identificationAttempt.WatchlistEntryRID,
+		&identificationAttempt.WatchlistDistance,
+		&identificationAttempt.Duress,
+		&identificationAttempt.Error,
+		&identificationAttempt.JobRID,
+		&identificationAttempt.ProcessingAt,
+		&identificationAttempt.StepUpAt,
+		&identificationAttempt.AcceptedAt,
+		&identificationAttempt.RejectedAt,
+		&identificationAttempt.ExpiredAt,
+		&identificationAttempt.ErrorAt,
 		&identificationAttempt.CreatedAt,
 		&identificationAttempt.UpdatedAt,
 	)
@@ -209,6 +739,8 @@ func (r IdentificationAttemptDBHandler) SelectIdentificationAttempt(rid uuid.UUI
 	return identificationAttempt, nil
 }
 
+// SelectLatestIdentificationAttemptByUserRID returns the latest own attempt of the user,
+// attempts of the agent console are skipped.
 func (r IdentificationAttemptDBHandler) SelectLatestIdentificationAttemptByUserRID(userRid uuid.UUID) (*model.IdentificationAttempt, error) {
 	identificationAttempt := &model.IdentificationAttempt{}
 
@@ -218,14 +750,37 @@ func (r IdentificationAttemptDBHandler) SelectLatestIdentificationAttemptByUserR
 			rid,
 			user_rid,
 			recording,
-			identified,
-			used,
+			recording_mfcc,
+			COALESCE(extractor, ''),
+			channel,
+			state,
+			score,
+			ip_address,
+			country,
+			device_fingerprint,
+			risk_score,
+			risk_action,
+			risk_factors,
+			profile_rid,
+			agent_rid,
+			watchlist_entry_rid,
+			watchlist_distance,
+			duress,
+			error,
+			COALESCE(job_rid, '00000000-0000-0000-0000-000000000000'),
+			processing_at,
+			step_up_at,
+			accepted_at,
+			rejected_at,
+			expired_at,
+			error_at,
 			created_at,
 			updated_at
 		FROM
 			identification_attempt
 		WHERE
 			user_rid = $1
+			AND agent_rid IS NULL
 		ORDER BY
 			created_at DESC
 		LIMIT 1`,
@@ -236,8 +791,30 @@ func (r IdentificationAttemptDBHandler) SelectLatestIdentificationAttemptByUserR
 		&identificationAttempt.RID,
 		&identificationAttempt.UserRID,
 		&identificationAttempt.Recording,
-		&identificationAttempt.Identified,
-		&identificationAttempt.Used,
+		&identificationAttempt.RecordingMfcc,
+		&identificationAttempt.Extractor,
+		&identificationAttempt.Channel,
+		&identificationAttempt.State,
+		&identificationAttempt.Score,
+		&identificationAttempt.IPAddress,
+		&identificationAttempt.Country,
+		&identificationAttempt.DeviceFingerprint,
+		&identificationAttempt.RiskScore,
+		&identificationAttempt.RiskAction,
+		&identificationAttempt.RiskFactors,
+		&identificationAttempt.ProfileRID,
+		&identificationAttempt.AgentRID,
+		&identificationAttempt.WatchlistEntryRID,
+		&identificationAttempt.WatchlistDistance,
+		&identificationAttempt.Duress,
+		&identificationAttempt.Error,
+		&identificationAttempt.JobRID,
+		&identificationAttempt.ProcessingAt,
+		&identificationAttempt.StepUpAt,
+		&identificationAttempt.AcceptedAt,
+		&identificationAttempt.RejectedAt,
+		&identificationAttempt.ExpiredAt,
+		&identificationAttempt.ErrorAt,
 		&identificationAttempt.CreatedAt,
 		&identificationAttempt.UpdatedAt,
 	)
@@ -248,6 +825,216 @@ func (r IdentificationAttemptDBHandler) SelectLatestIdentificationAttemptByUserR
 	return identificationAttempt, nil
 }
 
+// SelectLatestAcceptedIdentificationAttemptByUserRID returns the last successful own identification of the user.
+func (r IdentificationAttemptDBHandler) SelectLatestAcceptedIdentificationAttemptByUserRID(userRid uuid.UUID) (*model.IdentificationAttempt, error) {
+	identificationAttempt := &model.IdentificationAttempt{}
+
+	row := r.db.Instance.QueryRow(
+		`SELECT
+			id,
+			rid,
+			user_rid,
+			recording,
+			recording_mfcc,
+			COALESCE(extractor, ''),
+			channel,
+			state,
+			score,
+			ip_address,
+			country,
+			device_fingerprint,
+			risk_score,
+			risk_action,
+			risk_factors,
+			profile_rid,
+			agent_rid,
+			watchlist_entry_rid,
+			watchlist_distance,
+			duress,
+			error,
+			COALESCE(job_rid, '00000000-0000-0000-0000-000000000000'),
+			processing_at,
+			step_up_at,
+			accepted_at,
+			rejected_at,
+			expired_at,
+			error_at,
+			created_at,
+			updated_at
+		FROM
+			identification_attempt
+		WHERE
+			user_rid = $1
+			AND agent_rid IS NULL
+			AND state = 'accepted'
+		ORDER BY
+			accepted_at DESC
+		LIMIT 1`,
+		userRid,
+	)
+	err := row.Scan(
+		&identificationAttempt.ID,
+		&identificationAttempt.RID,
+		&identificationAttempt.UserRID,
+		&identificationAttempt.Recording,
+		&identificationAttempt.RecordingMfcc,
+		&identificationAttempt.Extractor,
+		&identificationAttempt.Channel,
+		&identificationAttempt.State,
+		&identificationAttempt.Score,
+		&identificationAttempt.IPAddress,
+		&identificationAttempt.Country,
+		&identificationAttempt.DeviceFingerprint,
+		&identificationAttempt.RiskScore,
+		&identificationAttempt.RiskAction,
+		&identificationAttempt.RiskFactors,
+		&identificationAttempt.ProfileRID,
+		&identificationAttempt.AgentRID,
+		&identificationAttempt.WatchlistEntryRID,
+		&identificationAttempt.WatchlistDistance,
+		&identificationAttempt.Duress,
+		&identificationAttempt.Error,
+		&identificationAttempt.JobRID,
+		&identificationAttempt.ProcessingAt,
+		&identificationAttempt.StepUpAt,
+		&identificationAttempt.AcceptedAt,
+		&identificationAttempt.RejectedAt,
+		&identificationAttempt.ExpiredAt,
+		&identificationAttempt.ErrorAt,
+		&identificationAttempt.CreatedAt,
+		&identificationAttempt.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return identificationAttempt, nil
+}
+
+// CountIdentificationAttemptsByUserRIDAndState counts the own attempts of the user in the state created since the given time.
+func (r IdentificationAttemptDBHandler) CountIdentificationAttemptsByUserRIDAndState(userRid uuid.UUID, state model.IdentificationAttemptState, since time.Time) (int, error) {
+	count := 0
+
+	err := r.db.Instance.QueryRow(
+		`SELECT
+			COUNT(*)
+		FROM
+			identification_attempt
+		WHERE
+			user_rid = $1
+			AND agent_rid IS NULL
+			AND state = $2
+			AND created_at >= $3`,
+		userRid,
+		state,
+		since,
+	).Scan(&count)
+
+	return count, err
+}
+
+// CountIdentificationAttemptsByUserRID counts the own attempts of the user created since the given time
+// and returns the creation time of the oldest of them.
+func (r IdentificationAttemptDBHandler) CountIdentificationAttemptsByUserRID(userRid uuid.UUID, since time.Time) (int, time.Time, error) {
+	count := 0
+	oldest := time.Time{}
+
+	err := r.db.Instance.QueryRow(
+		`SELECT
+			COUNT(*),
+			COALESCE(MIN(created_at), CURRENT_TIMESTAMP)
+		FROM
+			identification_attempt
+		WHERE
+			user_rid = $1
+			AND agent_rid IS NULL
+			AND created_at >= $2`,
+		userRid,
+		since,
+	).Scan(&count, &oldest)
+
+	return count, oldest, err
+}
+
+// CountConsecutiveRejections counts the rejected own attempts of the user after their last accepted attempt
+// and after the given time.
+func (r IdentificationAttemptDBHandler) CountConsecutiveRejections(userRid uuid.UUID, since time.Time) (int, error) {
+	count := 0
+
+	err := r.db.Instance.QueryRow(
+		`SELECT
+			COUNT(*)
+		FROM
+			identification_attempt
+		WHERE
+			user_rid = $1
+			AND agent_rid IS NULL
+			AND state = 'rejected'
+			AND created_at > GREATEST(
+				$2::timestamptz,
+				COALESCE((SELECT MAX(created_at) FROM identification_attempt WHERE user_rid = $1 AND agent_rid IS NULL AND state = 'accepted'), $2::timestamptz)
+			)`,
+		userRid,
+		since,
+	).Scan(&count)
+
+	return count, err
+}
+
+// UpdateIdentificationAttemptStepUpCode stores the hash of the one-time code of an attempt waiting for a step up.
+func (r IdentificationAttemptDBHandler) UpdateIdentificationAttemptStepUpCode(rid uuid.UUID, code string) error {
+	_, err := r.db.Instance.Exec(
+		`UPDATE
+			identification_attempt
+		SET
+			step_up_code_hash = crypt($1, gen_salt('bf', 6)),
+			updated_at = CURRENT_TIMESTAMP
+		WHERE
+			rid = $2
+			AND state = 'step_up'`,
+		code,
+		rid,
+	)
+	return err
+}
+
+// UpdateIdentificationAttemptWatchlistHit records the watchlist entry the processing attempt matched
+// together with the risk action it was raised to.
+func (r IdentificationAttemptDBHandler) UpdateIdentificationAttemptWatchlistHit(rid uuid.UUID, watchlistHit *model.WatchlistHit, riskAction model.RiskAction) error {
+	_, err := r.db.Instance.Exec(
+		`UPDATE
+			identification_attempt
+		SET
+			watchlist_entry_rid = $1,
+			watchlist_distance = $2,
+			risk_action = $3,
+			updated_at = CURRENT_TIMESTAMP
+		WHERE
+			rid = $4
+			AND state = 'processing'`,
+		watchlistHit.EntryRID,
+		watchlistHit.Distance,
+		riskAction,
+		rid,
+	)
+	return err
+}
+
+func (r IdentificationAttemptDBHandler) CheckStepUpCodeValid(rid uuid.UUID, code string) bool {
+	exists := false
+
+	err := r.db.Instance.QueryRow(
+		`SELECT EXISTS (SELECT 1 FROM identification_attempt WHERE rid = $1 AND state = 'step_up' AND step_up_code_hash <> '' AND step_up_code_hash = crypt($2, step_up_code_hash));`,
+		rid,
+		code,
+	).Scan(&exists)
+	if err != nil {
+		r.db.Logger.Println(err)
+		return false
+	}
+
+	return exists
+}
 func (r IdentificationAttemptDBHandler) SelectAllIdentificationAttempts(lastId int, entries int) ([]*model.IdentificationAttempt, error) {
 	var identificationAttempts []*model.IdentificationAttempt
 
@@ -257,8 +1044,30 @@ func (r IdentificationAttemptDBHandler) SelectAllIdentificationAttempts(lastId i
 			rid,
 			user_rid,
 			recording,
-			identified,
-			used,
+			recording_mfcc,
+			COALESCE(extractor, ''),
+			channel,
+			state,
+			score,
+			ip_address,
+			country,
+			device_fingerprint,
+			risk_score,
+			risk_action,
+			risk_factors,
+			profile_rid,
+			agent_rid,
+			watchlist_entry_rid,
+			watchlist_distance,
+			duress,
+			error,
+			COALESCE(job_rid, '00000000-0000-0000-0000-000000000000'),
+			processing_at,
+			step_up_at,
+			accepted_at,
+			rejected_at,
+			expired_at,
+			error_at,
 			created_at,
 			updated_at
 		FROM
@@ -290,8 +1099,30 @@ func (r IdentificationAttemptDBHandler) SelectAllIdentificationAttempts(lastId i
 			&identificationAttempt.RID,
 			&identificationAttempt.UserRID,
 			&identificationAttempt.Recording,
-			&identificationAttempt.Identified,
-			&identificationAttempt.Used,
+			&identificationAttempt.RecordingMfcc,
+			&identificationAttempt.Extractor,
+			&identificationAttempt.Channel,
+			&identificationAttempt.State,
+			&identificationAttempt.Score,
+			&identificationAttempt.IPAddress,
+			&identificationAttempt.Country,
+			&identificationAttempt.DeviceFingerprint,
+			&identificationAttempt.RiskScore,
+			&identificationAttempt.RiskAction,
+			&identificationAttempt.RiskFactors,
+			&identificationAttempt.ProfileRID,
+			&identificationAttempt.AgentRID,
+			&identificationAttempt.WatchlistEntryRID,
+			&identificationAttempt.WatchlistDistance,
+			&identificationAttempt.Duress,
+			&identificationAttempt.Error,
+			&identificationAttempt.JobRID,
+			&identificationAttempt.ProcessingAt,
+			&identificationAttempt.StepUpAt,
+			&identificationAttempt.AcceptedAt,
+			&identificationAttempt.RejectedAt,
+			&identificationAttempt.ExpiredAt,
+			&identificationAttempt.ErrorAt,
 			&identificationAttempt.CreatedAt,
 			&identificationAttempt.UpdatedAt,
 		)
@@ -316,8 +1147,30 @@ func (r IdentificationAttemptDBHandler) SelectAllIdentificationAttemptsBySearch(
 			rid,
 			user_rid,
 			recording,
-			identified,
-			used,
+			recording_mfcc,
+			COALESCE(extractor, ''),
+			channel,
+			state,
+			score,
+			ip_address,
+			country,
+			device_fingerprint,
+			risk_score,
+			risk_action,
+			risk_factors,
+			profile_rid,
+			agent_rid,
+			watchlist_entry_rid,
+			watchlist_distance,
+			duress,
+			error,
+			COALESCE(job_rid, '00000000-0000-0000-0000-000000000000'),
+			processing_at,
+			step_up_at,
+			accepted_at,
+			rejected_at,
+			expired_at,
+			error_at,
 			created_at,
 			updated_at
 		FROM identification_attempt 
@@ -352,8 +1205,30 @@ func (r IdentificationAttemptDBHandler) SelectAllIdentificationAttemptsBySearch(
 			&identificationAttempt.RID,
 			&identificationAttempt.UserRID,
 			&identificationAttempt.Recording,
-			&identificationAttempt.Identified,
-			&identificationAttempt.Used,
+			&identificationAttempt.RecordingMfcc,
+			&identificationAttempt.Extractor,
+			&identificationAttempt.Channel,
+			&identificationAttempt.State,
+			&identificationAttempt.Score,
+			&identificationAttempt.IPAddress,
+			&identificationAttempt.Country,
+			&identificationAttempt.DeviceFingerprint,
+			&identificationAttempt.RiskScore,
+			&identificationAttempt.RiskAction,
+			&identificationAttempt.RiskFactors,
+			&identificationAttempt.ProfileRID,
+			&identificationAttempt.AgentRID,
+			&identificationAttempt.WatchlistEntryRID,
+			&identificationAttempt.WatchlistDistance,
+			&identificationAttempt.Duress,
+			&identificationAttempt.Error,
+			&identificationAttempt.JobRID,
+			&identificationAttempt.ProcessingAt,
+			&identificationAttempt.StepUpAt,
+			&identificationAttempt.AcceptedAt,
+			&identificationAttempt.RejectedAt,
+			&identificationAttempt.ExpiredAt,
+			&identificationAttempt.ErrorAt,
 			&identificationAttempt.CreatedAt,
 			&identificationAttempt.UpdatedAt,
 		)
@@ -0,0 +1,93 @@
+package identification
+
+import (
+	"fmt"
+	"ht/helper"
+	"ht/model"
+	"ht/server/voice"
+	"math"
+	"strconv"
+
+	"github.com/google/uuid"
+)
+
+// DuressPolicy decides when a recording said the duress sentence. The voice of the duress profile is the
+// same as the one of the other profiles, so only the phrase tells them apart.
+type DuressPolicy struct {
+	// PhraseThreshold is the largest phrase distance to any recording of the duress profile which counts as the duress sentence.
+	PhraseThreshold float64
+}
+
+func NewDuressPolicyFromEnv() (*DuressPolicy, error) {
+	phraseThreshold, err := strconv.ParseFloat(helper.GetEnvVariableWithDefault("DURESS_PHRASE_THRESHOLD", "2.5"), 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid DURESS_PHRASE_THRESHOLD: %v", err)
+	}
+	if phraseThreshold <= 0 {
+		return nil, fmt.Errorf("DURESS_PHRASE_THRESHOLD has to be positive")
+	}
+
+	return &DuressPolicy{
+		PhraseThreshold: phraseThreshold,
+	}, nil
+}
+
+// MatchesDuressPhrase returns true if the recording said the secret sentence of the duress profile of the user.
+// It does not compare the voice, callers only check it for recordings whose voice was accepted.
+// Recordings which can not be compared, e.g. not wav, count as the duress sentence, so a broken
+// recording can not hide a duress signal.
+func (r *IdentificationAttemptService) MatchesDuressPhrase(userRid uuid.UUID, recording []byte) (bool, error) {
+	duressRecordings, err := r.referenceStore.GetDuressRecordings(userRid)
+	if err != nil {
+		return false, err
+	}
+	if len(duressRecordings) == 0 {
+		return false, nil
+	}
+
+	phrase, err := voice.ExtractPhrase(recording)
+	if err != nil {
+		r.logger.Printf("error extracting phrase of user %v, treating it as duress: %v", userRid, err)
+		return true, nil
+	}
+
+	closest := math.Inf(1)
+	for _, duressRecording := range duressRecordings {
+		duressPhrase, err := voice.ExtractPhrase(duressRecording)
+		if err != nil {
+			r.logger.Printf("error extracting duress phrase of user %v: %v", userRid, err)
+			continue
+		}
+		distance, err := voice.PhraseDistance(phrase, duressPhrase)
+		if err != nil {
+			return false, err
+		}
+		closest = math.Min(closest, distance)
+	}
+	if math.IsInf(closest, 1) {
+		r.logger.Printf("no duress phrase of user %v comparable, treating it as duress", userRid)
+		return true, nil
+	}
+
+	return closest <= r.duressPolicy.PhraseThreshold, nil
+}
+
+// reportDuress raises a high priority alert for the identification of the user with the duress sentence
+// and records it in the audit trail of the account. The user sees the normal result, so whoever
+// coerces the user is not warned.
+func (r *IdentificationAttemptService) reportDuress(actorRid uuid.UUID, userRid uuid.UUID, details map[string]any) error {
+	r.logger.Printf("user %v identified with the duress sentence", userRid)
+
+	err := r.alerter.Alert(&model.Alert{Type: model.AlertTypeDuress, Priority: model.AlertPriorityHigh, UserRID: userRid, Details: details})
+	if err != nil {
+		r.logger.Printf("error alerting duress of user %v: %v", userRid, err)
+	}
+
+	return r.auditService.Record(actorRid, userRid, model.AuditActionDuressSignalled, details)
+}
+
+// ReportDuress reports a duress signal of the user outside of an identification attempt, e.g. a voice recovery
+// with the duress sentence.
+func (r *IdentificationAttemptService) ReportDuress(userRid uuid.UUID, details map[string]any) error {
+	return r.reportDuress(userRid, userRid, details)
+}
@@ -2,26 +2,66 @@ package identification
 
 import (
 	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"ht/helper"
 	"ht/model"
 	"ht/server/database"
+	"ht/server/notification"
+	"ht/server/risk"
+	"ht/server/services/audit"
+	"ht/server/services/job"
+	"ht/server/voice"
 	"io"
 	"log"
 	"net/http"
 	"os"
+	"strconv"
+	"time"
 
+	"github.com/google/uuid"
 	"github.com/labstack/echo/v4"
 )
 
 const MAX_SIZE_MB = 5
 
+// ReferenceStore gives access to the reference recordings of a user,
+// which are owned by the user service.
+type ReferenceStore interface {
+	GetUser(userRid uuid.UUID) (*model.User, error)
+	GetProfileDistances(userRid uuid.UUID, vector model.Vector, metric model.DistanceMetric, extractor model.Extractor, channel model.Channel) ([]*model.ProfileDistances, error)
+	GetMatchThreshold(userRid uuid.UUID) (float64, error)
+	AdaptTemplate(userRid uuid.UUID, identificationAttempt *model.IdentificationAttempt, threshold float64) error
+	SearchNearestUsers(vector model.Vector, metric model.DistanceMetric, extractor model.Extractor, voiceLoginCode string, limit int) ([]*model.VoiceCandidate, error)
+	// ScreenWatchlist returns the hit of the vector on the fraud watchlist, or nil if it matches no entry.
+	ScreenWatchlist(vector model.Vector, extractor model.Extractor) (*model.WatchlistHit, error)
+	// GetDuressRecordings returns the recordings of the duress sentence of the user, none without duress profile.
+	GetDuressRecordings(userRid uuid.UUID) ([][]byte, error)
+}
+
 type IdentificationAttemptService struct {
 	logger                  *log.Logger
 	identificationAttemptDb IdentificationAttemptDBHandlerFunctions
-	jobsPort                string
+	referenceStore          ReferenceStore
+	jobService              *job.JobService
+	stateListener           *stateListener
+	matchingPolicy          *MatchingPolicy
+	attemptTimeout          time.Duration
+	featureExtractor        voice.FeatureExtractor
+	voiceLoginPolicy        *VoiceLoginPolicy
+	riskEngine              *risk.Engine
+	voiceLockoutDb          VoiceLockoutDBHandlerFunctions
+	lockoutPolicy           *LockoutPolicy
+	duressPolicy            *DuressPolicy
+	vadConfig               *voice.VADConfig
+	auditService            *audit.AuditService
+	userNotifier            UserNotifier
+	alerter                 notification.Alerter
 }
 
-func NewIdentificationAttemptService() *IdentificationAttemptService {
+func NewIdentificationAttemptService(referenceStore ReferenceStore, jobService *job.JobService, featureExtractor voice.FeatureExtractor, auditService *audit.AuditService, userNotifier UserNotifier, alerter notification.Alerter) *IdentificationAttemptService {
 	logger := log.New(os.Stdout, "identificationAttempt: ", log.LstdFlags)
 	dbConnection := database.NewDatabase(
 		"identificationAttempt",
@@ -36,30 +76,138 @@ func NewIdentificationAttemptService() *IdentificationAttemptService {
 	)
 	var identificationAttemptDb IdentificationAttemptDBHandlerFunctions = newIdentificationAttemptDBHandler(dbConnection)
 
+	var voiceLockoutDb VoiceLockoutDBHandlerFunctions = newVoiceLockoutDBHandler(dbConnection)
+
 	// creates main identificationAttempt table
 	err := identificationAttemptDb.CreateTable()
 	if err != nil {
 		log.Fatal(err.Error())
 	}
 
+	err = voiceLockoutDb.CreateTable()
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+
+	matchingPolicy, err := NewMatchingPolicyFromEnv()
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+
+	voiceLoginPolicy, err := NewVoiceLoginPolicyFromEnv()
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+
+	riskEngine, err := risk.NewEngineFromEnv()
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+
+	lockoutPolicy, err := NewLockoutPolicyFromEnv()
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+
+	duressPolicy, err := NewDuressPolicyFromEnv()
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+
+	vadConfig, err := voice.NewVADConfigFromEnv()
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+
+	attemptTimeoutSeconds, err := strconv.Atoi(helper.GetEnvVariableWithDefault("IDENTIFICATION_ATTEMPT_TIMEOUT_SECONDS", "120"))
+	if err != nil {
+		log.Fatalf("invalid IDENTIFICATION_ATTEMPT_TIMEOUT_SECONDS: %v", err)
+	}
+	if attemptTimeoutSeconds < 1 {
+		log.Fatal("IDENTIFICATION_ATTEMPT_TIMEOUT_SECONDS has to be at least 1")
+	}
+
+	listener, err := dbConnection.NewListener(STATE_CHANNEL)
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+
 	newIdentificationAttemptService := &IdentificationAttemptService{
 		logger:                  logger,
 		identificationAttemptDb: identificationAttemptDb,
-		jobsPort:                helper.GetEnvVariableWithoutDelete("JOBS_PORT"),
+		referenceStore:          referenceStore,
+		jobService:              jobService,
+		stateListener:           newStateListener(logger, listener),
+		matchingPolicy:          matchingPolicy,
+		attemptTimeout:          time.Duration(attemptTimeoutSeconds) * time.Second,
+		featureExtractor:        featureExtractor,
+		voiceLoginPolicy:        voiceLoginPolicy,
+		riskEngine:              riskEngine,
+		voiceLockoutDb:          voiceLockoutDb,
+		lockoutPolicy:           lockoutPolicy,
+		duressPolicy:            duressPolicy,
+		vadConfig:               vadConfig,
+		auditService:            auditService,
+		userNotifier:            userNotifier,
+		alerter:                 alerter,
 	}
 
+	jobService.RegisterHandler(model.JobTypeIdentify, &job.JobHandler{
+		Handle: newIdentificationAttemptService.handleIdentifyJob,
+		OnDead: newIdentificationAttemptService.handleDeadIdentifyJob,
+	})
+
 	return newIdentificationAttemptService
 }
 
+// CreateIdentificationAttempt stores the posted recording as pending attempt of the current user.
+// It returns ErrAccountRestricted, ErrLockedOut or ErrRateLimited if the user may not identify now.
 func (r *IdentificationAttemptService) CreateIdentificationAttempt(c echo.Context) (*model.IdentificationAttempt, error) {
-	if err := c.Request().ParseMultipartForm(MAX_SIZE_MB << 20); err != nil {
+	err := r.CheckAllowance(helper.GetCurrentUserRID(c.Request().Context()))
+	if err != nil {
+		return nil, err
+	}
+
+	recording, channel, err := readRecording(c)
+	if err != nil {
 		return nil, err
 	}
 
+	return r.insertIdentificationAttempt(c, recording, channel)
+}
+
+// insertIdentificationAttempt assesses the risk of the request and stores the recording as pending attempt of the current user.
+func (r *IdentificationAttemptService) insertIdentificationAttempt(c echo.Context, recording []byte, channel model.Channel) (*model.IdentificationAttempt, error) {
+	identificationAttempt := &model.IdentificationAttempt{
+		UserRID:   helper.GetCurrentUserRID(c.Request().Context()),
+		Recording: recording,
+		Channel:   channel,
+	}
+
+	err := r.assessRisk(c, identificationAttempt)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := r.identificationAttemptDb.InsertIdentificationAttempt(identificationAttempt)
+	if err != nil {
+		return nil, err
+	}
+
+	return data, nil
+}
+
+// readRecording reads the posted recording form file and returns it with its channel.
+// The optional encoding form value marks headerless telephony audio, see voice.Encoding.
+func readRecording(c echo.Context) ([]byte, model.Channel, error) {
+	if err := c.Request().ParseMultipartForm(MAX_SIZE_MB << 20); err != nil {
+		return nil, "", err
+	}
+
 	c.Request().Body = http.MaxBytesReader(c.Response(), c.Request().Body, MAX_SIZE_MB<<20)
 	file, _, err := c.Request().FormFile("recording")
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
 
 	defer file.Close()
@@ -67,45 +215,368 @@ func (r *IdentificationAttemptService) CreateIdentificationAttempt(c echo.Contex
 	buf := bytes.NewBuffer(nil)
 	_, err = io.Copy(buf, file)
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
 
-	identificationAttempt := &model.IdentificationAttempt{
-		UserRID:   helper.GetCurrentUserRID(c.Request().Context()),
-		Recording: buf.Bytes(),
+	recording, channel, err := voice.NormaliseRecording(buf.Bytes(), voice.Encoding(c.FormValue("encoding")))
+	if err != nil {
+		return nil, "", echo.NewHTTPError(http.StatusBadRequest, err.Error())
 	}
 
-	data, err := r.identificationAttemptDb.InsertIdentificationAttempt(identificationAttempt)
+	return recording, channel, nil
+}
+
+// GetIdentificationAttempt returns the attempt with the rid, e.g. for putting its voice on the watchlist.
+func (r *IdentificationAttemptService) GetIdentificationAttempt(rid uuid.UUID) (*model.IdentificationAttempt, error) {
+	return r.identificationAttemptDb.SelectIdentificationAttempt(rid)
+}
+
+func (r *IdentificationAttemptService) GetLatestIdentificationAttempt(c echo.Context) (*model.IdentificationAttempt, error) {
+	userId := helper.GetCurrentUserRID(c.Request().Context())
+	identificationAttempt, err := r.identificationAttemptDb.SelectLatestIdentificationAttemptByUserRID(userId)
 	if err != nil {
 		return nil, err
 	}
 
-	return data, nil
+	return identificationAttempt, nil
 }
 
-func (r *IdentificationAttemptService) UpdateIdentificationAttemptUsed(c echo.Context) (*model.IdentificationAttempt, error) {
+// StartProcessingLatestIdentificationAttempt moves the latest pending attempt of the current user to processing
+// and enqueues the identify job for it. It returns ErrInvalidTransition if the attempt is already processed.
+func (r *IdentificationAttemptService) StartProcessingLatestIdentificationAttempt(c echo.Context) (*model.IdentificationAttempt, error) {
 	userId := helper.GetCurrentUserRID(c.Request().Context())
 	identificationAttempt, err := r.identificationAttemptDb.SelectLatestIdentificationAttemptByUserRID(userId)
 	if err != nil {
 		return nil, err
 	}
 
-	identificationAttempt.Used = true
+	return r.startProcessing(identificationAttempt)
+}
 
-	identificationAttempt, err = r.identificationAttemptDb.UpdateIdentificationAttempt(identificationAttempt)
+// startProcessing moves the pending attempt to processing and enqueues the identify job for it.
+func (r *IdentificationAttemptService) startProcessing(identificationAttempt *model.IdentificationAttempt) (*model.IdentificationAttempt, error) {
+	identificationAttempt, err := r.identificationAttemptDb.UpdateIdentificationAttemptState(identificationAttempt, model.IdentificationAttemptStateProcessing)
 	if err != nil {
 		return nil, err
 	}
 
+	identifyJob, err := r.jobService.Enqueue(model.JobTypeIdentify, &model.IdentifyPayload{
+		UserRID:    identificationAttempt.UserRID,
+		AttemptRID: identificationAttempt.RID,
+	})
+	if err != nil {
+		return r.FailIdentificationAttempt(identificationAttempt, err)
+	}
+
+	identificationAttempt.JobRID = identifyJob.RID
+	return r.identificationAttemptDb.UpdateIdentificationAttempt(identificationAttempt)
+}
+
+// GetIdentificationAttemptJob returns the identify job of the attempt.
+func (r *IdentificationAttemptService) GetIdentificationAttemptJob(identificationAttempt *model.IdentificationAttempt) (*model.Job, error) {
+	return r.jobService.GetJob(identificationAttempt.JobRID)
+}
+
+// FailIdentificationAttempt moves the attempt to the error state, keeping the cause for the screen and logs.
+func (r *IdentificationAttemptService) FailIdentificationAttempt(identificationAttempt *model.IdentificationAttempt, cause error) (*model.IdentificationAttempt, error) {
+	r.logger.Printf("identification attempt %v failed: %v", identificationAttempt.RID, cause)
+
+	identificationAttempt.Error = cause.Error()
+	return r.identificationAttemptDb.UpdateIdentificationAttemptState(identificationAttempt, model.IdentificationAttemptStateError)
+}
+
+// handleIdentifyJob extracts the features of the attempt and evaluates it. Remote extractors
+// post the features to CompleteIdentificationAttempt later. Attempts that are not processing anymore are skipped.
+func (r *IdentificationAttemptService) handleIdentifyJob(identifyJob *model.Job) error {
+	payload := &model.IdentifyPayload{}
+	err := json.Unmarshal(identifyJob.Payload, payload)
+	if err != nil {
+		return err
+	}
+
+	identificationAttempt, err := r.identificationAttemptDb.SelectIdentificationAttempt(payload.AttemptRID)
+	if err != nil {
+		return err
+	}
+	if identificationAttempt.State != model.IdentificationAttemptStateProcessing {
+		r.logger.Printf("skipping identify job for identification attempt %v in state %v", identificationAttempt.RID, identificationAttempt.State)
+		return nil
+	}
+
+	mfcc, err := r.featureExtractor.ExtractAttemptFeatures(context.Background(), identificationAttempt)
+	extractionError := &voice.ExtractionError{}
+	if errors.Is(err, voice.ErrFeaturesPending) {
+		return nil
+	} else if errors.As(err, &extractionError) {
+		_, err = r.CompleteIdentificationAttempt(identificationAttempt.RID, nil, "", extractionError.Cause.Error())
+		return err
+	} else if err != nil {
+		return err
+	}
+
+	_, err = r.CompleteIdentificationAttempt(identificationAttempt.RID, mfcc, r.featureExtractor.Extractor(), "")
+	return err
+}
+
+// CompleteIdentificationAttempt persists the extracted features and evaluates the attempt,
+// a non-empty extraction error or features of another extractor move it to the error state instead.
+func (r *IdentificationAttemptService) CompleteIdentificationAttempt(rid uuid.UUID, mfcc model.Vector, extractor model.Extractor, extractionError string) (*model.IdentificationAttempt, error) {
+	identificationAttempt, err := r.identificationAttemptDb.SelectIdentificationAttempt(rid)
+	if err != nil {
+		return nil, err
+	}
+	if identificationAttempt.State != model.IdentificationAttemptStateProcessing {
+		return nil, fmt.Errorf("%w: attempt %v is %v", ErrInvalidTransition, rid, identificationAttempt.State)
+	}
+
+	if len(extractionError) > 0 {
+		return r.FailIdentificationAttempt(identificationAttempt, fmt.Errorf("feature extraction failed: %v", extractionError))
+	}
+	if extractor != r.featureExtractor.Extractor() {
+		return r.FailIdentificationAttempt(identificationAttempt, fmt.Errorf("features extracted by %v, expected %v", extractor, r.featureExtractor.Extractor()))
+	}
+	if len(mfcc) != r.featureExtractor.Dimension() {
+		return r.FailIdentificationAttempt(identificationAttempt, fmt.Errorf("got %v features, expected %v", len(mfcc), r.featureExtractor.Dimension()))
+	}
+
+	err = r.identificationAttemptDb.UpdateIdentificationAttemptFeatures(rid, mfcc, extractor)
+	if err != nil {
+		return nil, err
+	}
+
+	identificationAttempt, err = r.EvaluateIdentificationAttempt(identificationAttempt)
+	if err != nil {
+		return nil, err
+	}
+	r.logger.Printf("identification attempt %v is %v", identificationAttempt.RID, identificationAttempt.State)
+
 	return identificationAttempt, nil
 }
 
-func (r *IdentificationAttemptService) GetLatestIdentificationAttempt(c echo.Context) (*model.IdentificationAttempt, error) {
-	userId := helper.GetCurrentUserRID(c.Request().Context())
-	identificationAttempt, err := r.identificationAttemptDb.SelectLatestIdentificationAttemptByUserRID(userId)
+func (r *IdentificationAttemptService) handleDeadIdentifyJob(identifyJob *model.Job) {
+	payload := &model.IdentifyPayload{}
+	err := json.Unmarshal(identifyJob.Payload, payload)
+	if err != nil {
+		r.logger.Printf("invalid identify job payload %v: %v", identifyJob.RID, err)
+		return
+	}
+
+	identificationAttempt, err := r.identificationAttemptDb.SelectIdentificationAttempt(payload.AttemptRID)
+	if err != nil {
+		r.logger.Printf("error selecting identification attempt %v: %v", payload.AttemptRID, err)
+		return
+	}
+	if identificationAttempt.State.IsFinal() {
+		return
+	}
+
+	_, err = r.FailIdentificationAttempt(identificationAttempt, fmt.Errorf("identify job failed: %v", identifyJob.LastError))
+	if err != nil {
+		r.logger.Printf("error failing identification attempt %v: %v", identificationAttempt.RID, err)
+	}
+}
+
+// SubscribeStateChanges streams the state changes of all attempts of the user until unsubscribe is called.
+func (r *IdentificationAttemptService) SubscribeStateChanges(userRid uuid.UUID) (<-chan *model.IdentificationAttemptStateChange, func()) {
+	return r.stateListener.subscribe(userRid)
+}
+
+// EvaluateIdentificationAttempt compares the extracted features of the processing attempt
+// with the reference recordings and moves it to the decision of the matching policy and the risk action,
+// which a hit on the fraud watchlist raises. A match of the duress profile is decided like any other profile.
+// Attempts that can not be evaluated are moved to the error state.
+func (r *IdentificationAttemptService) EvaluateIdentificationAttempt(identificationAttempt *model.IdentificationAttempt) (*model.IdentificationAttempt, error) {
+	identificationAttempt, err := r.identificationAttemptDb.SelectIdentificationAttempt(identificationAttempt.RID)
 	if err != nil {
 		return nil, err
 	}
 
+	score, accepted, threshold, err := r.scoreIdentificationAttempt(identificationAttempt)
+	if err != nil {
+		_, failErr := r.FailIdentificationAttempt(identificationAttempt, err)
+		if failErr != nil {
+			return nil, failErr
+		}
+		return nil, err
+	}
+	watchlistHit, err := r.screenWatchlist(identificationAttempt)
+	if err != nil {
+		_, failErr := r.FailIdentificationAttempt(identificationAttempt, err)
+		if failErr != nil {
+			return nil, failErr
+		}
+		return nil, err
+	}
+	r.logger.Printf("identification attempt %v scored %v on profile %v with threshold %v and risk %v (%v)", identificationAttempt.RID, score, identificationAttempt.ProfileRID, threshold, identificationAttempt.RiskScore, identificationAttempt.RiskAction)
+
+	state := r.decideState(identificationAttempt, accepted)
+	identificationAttempt.Score = score
+	identificationAttempt, err = r.identificationAttemptDb.UpdateIdentificationAttemptState(identificationAttempt, state)
+	if err != nil {
+		return nil, err
+	}
+
+	// the user can request another code, a failed email must not fail the decided attempt
+	if state == model.IdentificationAttemptStateStepUp {
+		err = r.sendStepUpCode(identificationAttempt)
+		if err != nil {
+			r.logger.Printf("error sending step up code of identification attempt %v: %v", identificationAttempt.RID, err)
+		}
+	}
+
+	if watchlistHit != nil {
+		err = r.reportWatchlistHit(r.attemptActor(identificationAttempt), identificationAttempt.UserRID, watchlistHit, map[string]any{
+			"attempt_rid": identificationAttempt.RID,
+			"state":       identificationAttempt.State,
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if identificationAttempt.Duress {
+		err = r.reportDuress(r.attemptActor(identificationAttempt), identificationAttempt.UserRID, map[string]any{
+			"attempt_rid": identificationAttempt.RID,
+			"profile_rid": identificationAttempt.ProfileRID,
+			"state":       identificationAttempt.State,
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if identificationAttempt.IsAssisted() {
+		return identificationAttempt, r.recordAssistedVerificationResult(identificationAttempt, threshold)
+	}
+
+	// lockout and adaptation failures must not fail the identification itself
+	if state == model.IdentificationAttemptStateRejected {
+		err = r.lockOutIfExceeded(identificationAttempt.UserRID)
+		if err != nil {
+			r.logger.Printf("error checking lockout of user %v: %v", identificationAttempt.UserRID, err)
+		}
+	}
+
+	err = r.referenceStore.AdaptTemplate(identificationAttempt.UserRID, identificationAttempt, threshold)
+	if err != nil {
+		r.logger.Printf("error adapting template of user %v: %v", identificationAttempt.UserRID, err)
+	}
+
 	return identificationAttempt, nil
 }
+
+// attemptActor returns the user who made the attempt, the agent for assisted attempts.
+func (r *IdentificationAttemptService) attemptActor(identificationAttempt *model.IdentificationAttempt) uuid.UUID {
+	if identificationAttempt.IsAssisted() {
+		return identificationAttempt.AgentRID
+	}
+	return identificationAttempt.UserRID
+}
+
+// scoreIdentificationAttempt scores the attempt against the active profiles of the user
+// and sets the best matching profile on the attempt. An accepted attempt is checked for the duress sentence.
+func (r *IdentificationAttemptService) scoreIdentificationAttempt(identificationAttempt *model.IdentificationAttempt) (float64, bool, float64, error) {
+	if identificationAttempt.RecordingMfcc.IsEmpty() {
+		return 0, false, 0, fmt.Errorf("no features extracted for identification attempt %v", identificationAttempt.RID)
+	}
+	// vectors of different extractors are not comparable
+	if identificationAttempt.Extractor != r.featureExtractor.Extractor() {
+		return 0, false, 0, fmt.Errorf("identification attempt %v was extracted by %v, expected %v", identificationAttempt.RID, identificationAttempt.Extractor, r.featureExtractor.Extractor())
+	}
+
+	decision, err := r.decideProfiles(identificationAttempt.UserRID, identificationAttempt.RecordingMfcc, identificationAttempt.Extractor, identificationAttempt.Channel, identificationAttempt.RiskAction)
+	if err != nil {
+		return 0, false, 0, err
+	}
+	identificationAttempt.ProfileRID = decision.ProfileRID
+	if decision.Accepted {
+		identificationAttempt.Duress, err = r.MatchesDuressPhrase(identificationAttempt.UserRID, identificationAttempt.Recording)
+		if err != nil {
+			return 0, false, 0, err
+		}
+	}
+
+	return decision.Score, decision.Accepted, decision.Threshold, nil
+}
+
+// VerifyRecording decides the features of an offline recording against the active profiles of the user.
+// Unlike an attempt, it has no risk signals and neither counts towards the lockout nor adapts the template.
+func (r *IdentificationAttemptService) VerifyRecording(userRid uuid.UUID, vector model.Vector, extractor model.Extractor, channel model.Channel) (*ProfileDecision, error) {
+	if vector.IsEmpty() {
+		return nil, fmt.Errorf("no features extracted")
+	}
+	// vectors of different extractors are not comparable
+	if extractor != r.featureExtractor.Extractor() {
+		return nil, fmt.Errorf("recording was extracted by %v, expected %v", extractor, r.featureExtractor.Extractor())
+	}
+
+	return r.decideProfiles(userRid, vector, extractor, channel, model.RiskActionAllow)
+}
+
+// decideProfiles decides the vector with the threshold of the user, tightened by the risk action.
+func (r *IdentificationAttemptService) decideProfiles(userRid uuid.UUID, vector model.Vector, extractor model.Extractor, channel model.Channel, riskAction model.RiskAction) (*ProfileDecision, error) {
+	profileDistances, err := r.referenceStore.GetProfileDistances(userRid, vector, r.matchingPolicy.Metric, extractor, channel)
+	if err != nil {
+		return nil, err
+	}
+
+	userThreshold, err := r.referenceStore.GetMatchThreshold(userRid)
+	if err != nil {
+		return nil, err
+	}
+
+	threshold := r.riskEngine.Threshold(riskAction, r.matchingPolicy.ThresholdForUser(userThreshold))
+	return r.matchingPolicy.DecideProfiles(profileDistances, threshold)
+}
+
+// DeleteRecordings removes the voice of the user from all their identification attempts, e.g. when the
+// account is deleted. The decisions of the attempts are kept.
+func (r *IdentificationAttemptService) DeleteRecordings(userRid uuid.UUID) error {
+	err := r.identificationAttemptDb.DeleteIdentificationAttemptRecordings(userRid)
+	if err != nil {
+		return fmt.Errorf("error deleting identification attempt recordings: %v", err)
+	}
+	return nil
+}
+
+// ExpireIdentificationAttempts expires all attempts that were not decided within the attempt timeout
+// and all attempts without step up code within the step up timeout.
+func (r *IdentificationAttemptService) ExpireIdentificationAttempts() error {
+	now := time.Now()
+	expired, err := r.identificationAttemptDb.ExpireIdentificationAttempts(now.Add(-r.attemptTimeout), now.Add(-r.riskEngine.Rules.StepUpTimeout()))
+	if err != nil {
+		return fmt.Errorf("error expiring identification attempts: %v", err)
+	}
+	if expired > 0 {
+		r.logger.Printf("expired %v identification attempts", expired)
+	}
+	return nil
+}
+
+// Cleanup runs ExpireIdentificationAttempts periodically. Stop it with StopCleanup.
+func (r *IdentificationAttemptService) Cleanup(interval time.Duration) (chan<- struct{}, <-chan struct{}) {
+	quit, done := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-quit:
+				return
+			case <-ticker.C:
+				err := r.ExpireIdentificationAttempts()
+				if err != nil {
+					r.logger.Println(err.Error())
+				}
+			}
+		}
+	}()
+	return quit, done
+}
+
+// StopCleanup stops the expiry started with Cleanup and waits for it to finish.
+func (r *IdentificationAttemptService) StopCleanup(quit chan<- struct{}, done <-chan struct{}) {
+	close(quit)
+	<-done
+}
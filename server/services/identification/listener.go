@@ -0,0 +1,97 @@
+package identification
+
+import (
+	"encoding/json"
+	"ht/model"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+// STATE_CHANNEL is the notification channel the state trigger of identification_attempt publishes to.
+const STATE_CHANNEL = "identification_attempt_state"
+
+// stateListener receives the state changes of all server instances
+// and fans them out to the subscribers of the affected user.
+type stateListener struct {
+	logger      *log.Logger
+	listener    *pq.Listener
+	mutex       sync.Mutex
+	subscribers map[uuid.UUID]map[chan *model.IdentificationAttemptStateChange]struct{}
+}
+
+func newStateListener(logger *log.Logger, listener *pq.Listener) *stateListener {
+	newStateListener := &stateListener{
+		logger:      logger,
+		listener:    listener,
+		subscribers: map[uuid.UUID]map[chan *model.IdentificationAttemptStateChange]struct{}{},
+	}
+	go newStateListener.run()
+	return newStateListener
+}
+
+func (r *stateListener) run() {
+	ping := time.NewTicker(90 * time.Second)
+	defer ping.Stop()
+
+	for {
+		select {
+		case notification, ok := <-r.listener.Notify:
+			if !ok {
+				return
+			}
+			// nil after a reconnect, notifications in between are lost
+			if notification == nil {
+				continue
+			}
+
+			stateChange := &model.IdentificationAttemptStateChange{}
+			err := json.Unmarshal([]byte(notification.Extra), stateChange)
+			if err != nil {
+				r.logger.Printf("invalid state notification %v: %v", notification.Extra, err)
+				continue
+			}
+			r.publish(stateChange)
+		case <-ping.C:
+			go r.listener.Ping()
+		}
+	}
+}
+
+func (r *stateListener) publish(stateChange *model.IdentificationAttemptStateChange) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	for subscriber := range r.subscribers[stateChange.UserRID] {
+		// slow subscribers miss changes instead of blocking all others
+		select {
+		case subscriber <- stateChange:
+		default:
+		}
+	}
+}
+
+func (r *stateListener) subscribe(userRid uuid.UUID) (<-chan *model.IdentificationAttemptStateChange, func()) {
+	subscriber := make(chan *model.IdentificationAttemptStateChange, 8)
+
+	r.mutex.Lock()
+	if r.subscribers[userRid] == nil {
+		r.subscribers[userRid] = map[chan *model.IdentificationAttemptStateChange]struct{}{}
+	}
+	r.subscribers[userRid][subscriber] = struct{}{}
+	r.mutex.Unlock()
+
+	unsubscribe := func() {
+		r.mutex.Lock()
+		defer r.mutex.Unlock()
+
+		delete(r.subscribers[userRid], subscriber)
+		if len(r.subscribers[userRid]) == 0 {
+			delete(r.subscribers, userRid)
+		}
+	}
+	return subscriber, unsubscribe
+}
@@ -0,0 +1,269 @@
+package identification
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"ht/helper"
+	"ht/model"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var (
+	ErrRateLimited       = errors.New("too many identification attempts")
+	ErrLockedOut         = errors.New("voice identification is locked")
+	ErrInvalidUnlockCode = errors.New("invalid unlock code")
+	ErrAccountRestricted = errors.New("voice identification of the account is restricted")
+)
+
+const unlockCodeTimeout = 15 * time.Minute
+
+// UserNotifier sends messages to the owner of an account, which is known by the auth service.
+type UserNotifier interface {
+	NotifyUser(userRid uuid.UUID, subject string, body string) error
+}
+
+// LockoutPolicy limits the identification attempts of a user.
+type LockoutPolicy struct {
+	// MaxAttempts is the number of attempts allowed within Window, further attempts are refused.
+	MaxAttempts int
+	Window      time.Duration
+	// MaxRejections is the number of consecutive rejections which lock the voice identification.
+	MaxRejections int
+}
+
+func NewLockoutPolicyFromEnv() (*LockoutPolicy, error) {
+	maxAttempts, err := strconv.Atoi(helper.GetEnvVariableWithDefault("IDENTIFICATION_MAX_ATTEMPTS", "10"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid IDENTIFICATION_MAX_ATTEMPTS: %v", err)
+	}
+	windowMinutes, err := strconv.Atoi(helper.GetEnvVariableWithDefault("IDENTIFICATION_ATTEMPT_WINDOW_MINUTES", "15"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid IDENTIFICATION_ATTEMPT_WINDOW_MINUTES: %v", err)
+	}
+	maxRejections, err := strconv.Atoi(helper.GetEnvVariableWithDefault("IDENTIFICATION_MAX_REJECTIONS", "5"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid IDENTIFICATION_MAX_REJECTIONS: %v", err)
+	}
+	if maxAttempts < 1 || windowMinutes < 1 || maxRejections < 1 {
+		return nil, fmt.Errorf("IDENTIFICATION_MAX_ATTEMPTS, IDENTIFICATION_ATTEMPT_WINDOW_MINUTES and IDENTIFICATION_MAX_REJECTIONS have to be at least 1")
+	}
+
+	return &LockoutPolicy{
+		MaxAttempts:   maxAttempts,
+		Window:        time.Duration(windowMinutes) * time.Minute,
+		MaxRejections: maxRejections,
+	}, nil
+}
+
+// GetAllowance returns the remaining attempts and the active lockout of the user.
+func (r *IdentificationAttemptService) GetAllowance(userRid uuid.UUID) (*model.IdentificationAllowance, error) {
+	allowance := &model.IdentificationAllowance{}
+
+	user, err := r.referenceStore.GetUser(userRid)
+	if err != nil {
+		return nil, fmt.Errorf("error selecting user: %v", err)
+	}
+	allowance.Status = user.Status
+
+	lockout, err := r.voiceLockoutDb.SelectActiveVoiceLockout(userRid)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, fmt.Errorf("error selecting voice lockout: %v", err)
+	} else if err == nil {
+		allowance.Lockout = lockout
+		return allowance, nil
+	}
+
+	now := time.Now()
+	attempts, oldest, err := r.identificationAttemptDb.CountIdentificationAttemptsByUserRID(userRid, now.Add(-r.lockoutPolicy.Window))
+	if err != nil {
+		return nil, fmt.Errorf("error counting identification attempts: %v", err)
+	}
+	lastUnlockedAt, err := r.voiceLockoutDb.SelectLastUnlockedAt(userRid)
+	if err != nil {
+		return nil, fmt.Errorf("error selecting last unlock: %v", err)
+	}
+	rejections, err := r.identificationAttemptDb.CountConsecutiveRejections(userRid, lastUnlockedAt)
+	if err != nil {
+		return nil, fmt.Errorf("error counting rejections: %v", err)
+	}
+
+	allowance.RemainingAttempts = max(0, min(r.lockoutPolicy.MaxAttempts-attempts, r.lockoutPolicy.MaxRejections-rejections))
+	if attempts >= r.lockoutPolicy.MaxAttempts {
+		allowance.RetryAt = oldest.Add(r.lockoutPolicy.Window)
+	}
+
+	return allowance, nil
+}
+
+// CheckAllowance returns ErrAccountRestricted, ErrLockedOut or ErrRateLimited if the user may not create another attempt.
+func (r *IdentificationAttemptService) CheckAllowance(userRid uuid.UUID) error {
+	allowance, err := r.GetAllowance(userRid)
+	if err != nil {
+		return err
+	}
+	if !allowance.Status.MayIdentify() {
+		return ErrAccountRestricted
+	}
+	if allowance.Lockout != nil {
+		return ErrLockedOut
+	}
+	if !allowance.RetryAt.IsZero() {
+		return fmt.Errorf("%w, try again at %v", ErrRateLimited, allowance.RetryAt.Format("15:04"))
+	}
+	return nil
+}
+
+// RecordVoiceCheck stores a voice check decided outside of the identify job, e.g. a voice recovery, as an attempt
+// of the user. It counts towards the attempt window and the consecutive rejections like any identification,
+// so a rejection may lock the voice identification. Callers check the allowance before deciding.
+func (r *IdentificationAttemptService) RecordVoiceCheck(userRid uuid.UUID, recording []byte, channel model.Channel, decision *ProfileDecision, accepted bool) (*model.IdentificationAttempt, error) {
+	return r.recordDecidedAttempt(&model.IdentificationAttempt{
+		UserRID:    userRid,
+		Recording:  recording,
+		Channel:    channel,
+		RiskAction: model.RiskActionAllow,
+		ProfileRID: decision.ProfileRID,
+		Score:      decision.Score,
+	}, accepted)
+}
+
+// recordDecidedAttempt inserts the attempt and moves it through processing to its decision.
+func (r *IdentificationAttemptService) recordDecidedAttempt(identificationAttempt *model.IdentificationAttempt, accepted bool) (*model.IdentificationAttempt, error) {
+	profileRid, score, duress := identificationAttempt.ProfileRID, identificationAttempt.Score, identificationAttempt.Duress
+
+	identificationAttempt, err := r.identificationAttemptDb.InsertIdentificationAttempt(identificationAttempt)
+	if err != nil {
+		return nil, fmt.Errorf("error inserting identification attempt: %v", err)
+	}
+	identificationAttempt, err = r.identificationAttemptDb.UpdateIdentificationAttemptState(identificationAttempt, model.IdentificationAttemptStateProcessing)
+	if err != nil {
+		return nil, fmt.Errorf("error updating identification attempt: %v", err)
+	}
+
+	state := model.IdentificationAttemptStateRejected
+	if accepted {
+		state = model.IdentificationAttemptStateAccepted
+	}
+	identificationAttempt.ProfileRID = profileRid
+	identificationAttempt.Score = score
+	identificationAttempt.Duress = duress
+	identificationAttempt, err = r.identificationAttemptDb.UpdateIdentificationAttemptState(identificationAttempt, state)
+	if err != nil {
+		return nil, fmt.Errorf("error updating identification attempt: %v", err)
+	}
+
+	if !accepted {
+		err = r.lockOutIfExceeded(identificationAttempt.UserRID)
+		if err != nil {
+			r.logger.Printf("error checking lockout of user %v: %v", identificationAttempt.UserRID, err)
+		}
+	}
+	return identificationAttempt, nil
+}
+
+// lockOutIfExceeded locks the voice identification of the user after too many consecutive rejections
+// and notifies the owner of the account.
+func (r *IdentificationAttemptService) lockOutIfExceeded(userRid uuid.UUID) error {
+	lastUnlockedAt, err := r.voiceLockoutDb.SelectLastUnlockedAt(userRid)
+	if err != nil {
+		return fmt.Errorf("error selecting last unlock: %v", err)
+	}
+	rejections, err := r.identificationAttemptDb.CountConsecutiveRejections(userRid, lastUnlockedAt)
+	if err != nil {
+		return fmt.Errorf("error counting rejections: %v", err)
+	}
+	if rejections < r.lockoutPolicy.MaxRejections {
+		return nil
+	}
+
+	lockout, err := r.voiceLockoutDb.InsertVoiceLockout(userRid, fmt.Sprintf("%v consecutive rejections", rejections))
+	if err != nil {
+		return fmt.Errorf("error inserting voice lockout: %v", err)
+	}
+	r.logger.Printf("locked voice identification of user %v after %v rejections", userRid, rejections)
+
+	err = r.auditService.Record(userRid, userRid, model.AuditActionVoiceLockedOut, map[string]any{
+		"lockout_rid": lockout.RID,
+		"rejections":  rejections,
+	})
+	if err != nil {
+		return err
+	}
+
+	return r.userNotifier.NotifyUser(
+		userRid,
+		"Voice identification locked",
+		fmt.Sprintf("Your voice identification was locked after %v failed attempts. If this was not you, please change your password. You can unlock it with a code sent to this address or ask an admin.", rejections),
+	)
+}
+
+// RequestUnlockCode sends a code to unlock the active lockout of the user to their email.
+func (r *IdentificationAttemptService) RequestUnlockCode(userRid uuid.UUID) error {
+	lockout, err := r.voiceLockoutDb.SelectActiveVoiceLockout(userRid)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("voice identification is not locked")
+	} else if err != nil {
+		return err
+	}
+
+	code, err := helper.CreateRandomString(6, helper.OnlyNumbers)
+	if err != nil {
+		return fmt.Errorf("error creating unlock code: %v", err)
+	}
+
+	err = r.voiceLockoutDb.UpdateVoiceLockoutUnlockCode(lockout.RID, code)
+	if err != nil {
+		return fmt.Errorf("error storing unlock code: %v", err)
+	}
+
+	return r.userNotifier.NotifyUser(
+		userRid,
+		"Unlock your voice identification",
+		fmt.Sprintf("Your code to unlock the voice identification is %v. It is valid for %v minutes.", code, unlockCodeTimeout.Minutes()),
+	)
+}
+
+// UnlockWithCode unlocks the active lockout of the user with the code sent by RequestUnlockCode.
+func (r *IdentificationAttemptService) UnlockWithCode(userRid uuid.UUID, code string) error {
+	lockout, err := r.voiceLockoutDb.SelectActiveVoiceLockout(userRid)
+	if err == sql.ErrNoRows {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	if !r.voiceLockoutDb.CheckUnlockCodeValid(lockout.RID, strings.TrimSpace(code), time.Now().Add(-unlockCodeTimeout)) {
+		return ErrInvalidUnlockCode
+	}
+
+	return r.unlock(userRid, userRid, lockout, "code")
+}
+
+// Unlock lets an admin unlock the active lockout of the user.
+func (r *IdentificationAttemptService) Unlock(actorRid uuid.UUID, userRid uuid.UUID) error {
+	lockout, err := r.voiceLockoutDb.SelectActiveVoiceLockout(userRid)
+	if err == sql.ErrNoRows {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	return r.unlock(actorRid, userRid, lockout, "admin")
+}
+
+func (r *IdentificationAttemptService) unlock(actorRid uuid.UUID, userRid uuid.UUID, lockout *model.VoiceLockout, method string) error {
+	err := r.voiceLockoutDb.UnlockVoiceLockout(lockout.RID, actorRid)
+	if err != nil {
+		return fmt.Errorf("error unlocking voice lockout: %v", err)
+	}
+
+	return r.auditService.Record(actorRid, userRid, model.AuditActionVoiceUnlocked, map[string]any{
+		"lockout_rid": lockout.RID,
+		"method":      method,
+	})
+}
@@ -0,0 +1,180 @@
+package identification
+
+import (
+	"fmt"
+	"ht/helper"
+	"ht/model"
+	"math"
+	"sort"
+	"strconv"
+
+	"github.com/google/uuid"
+)
+
+// MatchingPolicy decides if the distances of a recording to the
+// reference recordings of a user are close enough to accept the attempt.
+type MatchingPolicy struct {
+	Metric      model.DistanceMetric
+	Aggregation model.MatchingAggregation
+	// K is the number of references that have to be below the threshold
+	// with the k_of_n aggregation.
+	K int
+	// Threshold is the global threshold, a recording is accepted if the score is below.
+	Threshold float64
+	// PerUserThresholds enables thresholds stored on the user which override the global one.
+	PerUserThresholds bool
+	// CrossChannelFactor scales the threshold for profiles compared across channels, e.g. a phone call
+	// with a browser enrollment. Below 1 it is stricter, as the distances are less reliable.
+	CrossChannelFactor float64
+}
+
+// ProfileDecision is the decision with the best scoring profile.
+type ProfileDecision struct {
+	ProfileRID   uuid.UUID
+	Score        float64
+	Threshold    float64
+	CrossChannel bool
+	Accepted     bool
+}
+
+func NewMatchingPolicyFromEnv() (*MatchingPolicy, error) {
+	k, err := strconv.Atoi(helper.GetEnvVariableWithDefault("MATCHING_K", "1"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid MATCHING_K: %v", err)
+	}
+	threshold, err := strconv.ParseFloat(helper.GetEnvVariableWithDefault("MATCHING_THRESHOLD", "5"), 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid MATCHING_THRESHOLD: %v", err)
+	}
+	perUserThresholds, err := strconv.ParseBool(helper.GetEnvVariableWithDefault("MATCHING_PER_USER_THRESHOLDS", "false"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid MATCHING_PER_USER_THRESHOLDS: %v", err)
+	}
+	crossChannelFactor, err := strconv.ParseFloat(helper.GetEnvVariableWithDefault("MATCHING_CROSS_CHANNEL_FACTOR", "1"), 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid MATCHING_CROSS_CHANNEL_FACTOR: %v", err)
+	}
+
+	policy := &MatchingPolicy{
+		Metric:             model.DistanceMetric(helper.GetEnvVariableWithDefault("MATCHING_METRIC", string(model.DistanceMetricL2))),
+		Aggregation:        model.MatchingAggregation(helper.GetEnvVariableWithDefault("MATCHING_AGGREGATION", string(model.MatchingAggregationMean))),
+		K:                  k,
+		Threshold:          threshold,
+		PerUserThresholds:  perUserThresholds,
+		CrossChannelFactor: crossChannelFactor,
+	}
+
+	err = policy.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	return policy, nil
+}
+
+func (r *MatchingPolicy) Validate() error {
+	if _, err := r.Metric.Operator(); err != nil {
+		return err
+	}
+	switch r.Aggregation {
+	case model.MatchingAggregationMin, model.MatchingAggregationMean:
+	case model.MatchingAggregationKOfN:
+		if r.K < 1 {
+			return fmt.Errorf("k has to be at least 1 for aggregation %v", r.Aggregation)
+		}
+	default:
+		return fmt.Errorf("invalid matching aggregation: %v", r.Aggregation)
+	}
+	if r.Threshold <= 0 {
+		return fmt.Errorf("threshold has to be greater than 0")
+	}
+	if r.CrossChannelFactor <= 0 {
+		return fmt.Errorf("cross channel factor has to be greater than 0")
+	}
+	return nil
+}
+
+// ThresholdForUser returns the user threshold if per user thresholds are enabled and set.
+func (r *MatchingPolicy) ThresholdForUser(userThreshold float64) float64 {
+	if r.PerUserThresholds && userThreshold > 0 {
+		return userThreshold
+	}
+	return r.Threshold
+}
+
+// Score aggregates the distances to the references into one score.
+// For k_of_n the score is the k-th smallest distance, so the score
+// is below the threshold if at least k references are.
+func (r *MatchingPolicy) Score(distances []float64) (float64, error) {
+	valid := []float64{}
+	for _, distance := range distances {
+		if !math.IsNaN(distance) && !math.IsInf(distance, 0) {
+			valid = append(valid, distance)
+		}
+	}
+	if len(valid) == 0 {
+		return 0, fmt.Errorf("no reference recordings to compare with")
+	}
+	sort.Float64s(valid)
+
+	switch r.Aggregation {
+	case model.MatchingAggregationMin:
+		return valid[0], nil
+	case model.MatchingAggregationMean:
+		sum := 0.0
+		for _, distance := range valid {
+			sum += distance
+		}
+		return sum / float64(len(valid)), nil
+	case model.MatchingAggregationKOfN:
+		if len(valid) < r.K {
+			return 0, fmt.Errorf("only %v of %v required reference recordings available", len(valid), r.K)
+		}
+		return valid[r.K-1], nil
+	default:
+		return 0, fmt.Errorf("invalid matching aggregation: %v", r.Aggregation)
+	}
+}
+
+// DecideProfiles scores the distances of each profile separately and decides with the best scoring one,
+// so a recording only has to match the references of one device or environment. Profiles compared
+// across channels are decided with the scaled threshold, so the best profile is the one with the lowest
+// score relative to its threshold. Profiles that can not be scored are skipped, unless none can.
+func (r *MatchingPolicy) DecideProfiles(profileDistances []*model.ProfileDistances, threshold float64) (*ProfileDecision, error) {
+	if len(profileDistances) == 0 {
+		return nil, fmt.Errorf("no active voice profile to compare with")
+	}
+
+	var best *ProfileDecision
+	var scoreErr error
+	for _, profile := range profileDistances {
+		score, err := r.Score(profile.Distances)
+		if err != nil {
+			scoreErr = err
+			continue
+		}
+		decision := &ProfileDecision{
+			ProfileRID:   profile.ProfileRID,
+			Score:        score,
+			Threshold:    r.ThresholdForChannel(threshold, profile.CrossChannel),
+			CrossChannel: profile.CrossChannel,
+		}
+		if best == nil || decision.Score/decision.Threshold < best.Score/best.Threshold {
+			best = decision
+		}
+	}
+	if best == nil {
+		return nil, scoreErr
+	}
+
+	best.Accepted = best.Score < best.Threshold
+	return best, nil
+}
+
+// ThresholdForChannel scales the threshold for profiles compared across channels.
+func (r *MatchingPolicy) ThresholdForChannel(threshold float64, crossChannel bool) float64 {
+	if crossChannel {
+		return threshold * r.CrossChannelFactor
+	}
+	return threshold
+}
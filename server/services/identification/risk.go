@@ -0,0 +1,139 @@
+package identification
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"ht/helper"
+	"ht/model"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+var ErrInvalidStepUpCode = errors.New("invalid step up code")
+
+// deviceFingerprint hashes the browser headers and the device description posted by the recorder.
+func deviceFingerprint(request *http.Request) string {
+	hash := sha256.Sum256([]byte(strings.Join([]string{
+		request.UserAgent(),
+		request.Header.Get("Accept-Language"),
+		request.FormValue("device"),
+	}, "\n")))
+	return hex.EncodeToString(hash[:])
+}
+
+// assessRisk collects the signals of the request and the history of the user and sets the risk of the attempt.
+func (r *IdentificationAttemptService) assessRisk(c echo.Context, identificationAttempt *model.IdentificationAttempt) error {
+	return r.assessRiskSignals(identificationAttempt, &model.RiskSignals{
+		IPAddress:         c.RealIP(),
+		Country:           r.riskEngine.Country(c.RealIP()),
+		DeviceFingerprint: deviceFingerprint(c.Request()),
+		Now:               time.Now(),
+	})
+}
+
+// assessRiskSignals adds the history of the user to the signals and sets the risk of the attempt.
+func (r *IdentificationAttemptService) assessRiskSignals(identificationAttempt *model.IdentificationAttempt, signals *model.RiskSignals) error {
+	if r.riskEngine.Enabled {
+		lastSuccess, err := r.identificationAttemptDb.SelectLatestAcceptedIdentificationAttemptByUserRID(identificationAttempt.UserRID)
+		if err != nil && err != sql.ErrNoRows {
+			return fmt.Errorf("error selecting last accepted identification attempt: %v", err)
+		} else if err == nil {
+			signals.LastSuccess = lastSuccess
+		}
+
+		signals.RecentFailures, err = r.identificationAttemptDb.CountIdentificationAttemptsByUserRIDAndState(identificationAttempt.UserRID, model.IdentificationAttemptStateRejected, signals.Now.Add(-r.riskEngine.Rules.FailureWindow()))
+		if err != nil {
+			return fmt.Errorf("error counting rejected identification attempts: %v", err)
+		}
+	}
+
+	assessment := r.riskEngine.Assess(signals)
+
+	identificationAttempt.IPAddress = signals.IPAddress
+	identificationAttempt.Country = signals.Country
+	identificationAttempt.DeviceFingerprint = signals.DeviceFingerprint
+	identificationAttempt.RiskScore = assessment.Score
+	identificationAttempt.RiskAction = assessment.Action
+	identificationAttempt.RiskFactors = assessment.Factors
+	return nil
+}
+
+// decideState applies the risk action to the decision on the voice. Agents can not receive
+// a step up code, they see the risk with the verdict and verify the caller otherwise.
+func (r *IdentificationAttemptService) decideState(identificationAttempt *model.IdentificationAttempt, accepted bool) model.IdentificationAttemptState {
+	switch {
+	case !accepted || identificationAttempt.RiskAction == model.RiskActionBlock:
+		return model.IdentificationAttemptStateRejected
+	case identificationAttempt.RiskAction == model.RiskActionStepUp && !identificationAttempt.IsAssisted():
+		return model.IdentificationAttemptStateStepUp
+	default:
+		return model.IdentificationAttemptStateAccepted
+	}
+}
+
+// RequestStepUpCode sends a new code for the latest attempt of the current user waiting for a step up,
+// the previous code is replaced.
+func (r *IdentificationAttemptService) RequestStepUpCode(c echo.Context) error {
+	userRid := helper.GetCurrentUserRID(c.Request().Context())
+	identificationAttempt, err := r.identificationAttemptDb.SelectLatestIdentificationAttemptByUserRID(userRid)
+	if err != nil {
+		return err
+	}
+	if identificationAttempt.State != model.IdentificationAttemptStateStepUp {
+		return fmt.Errorf("%w: attempt %v is %v", ErrInvalidTransition, identificationAttempt.RID, identificationAttempt.State)
+	}
+
+	return r.sendStepUpCode(identificationAttempt)
+}
+
+// sendStepUpCode stores a new one-time code for the attempt waiting for a step up and emails it to the user.
+func (r *IdentificationAttemptService) sendStepUpCode(identificationAttempt *model.IdentificationAttempt) error {
+	code, err := helper.CreateRandomString(6, helper.OnlyNumbers)
+	if err != nil {
+		return fmt.Errorf("error creating step up code: %v", err)
+	}
+
+	err = r.identificationAttemptDb.UpdateIdentificationAttemptStepUpCode(identificationAttempt.RID, code)
+	if err != nil {
+		return fmt.Errorf("error storing step up code: %v", err)
+	}
+
+	return r.userNotifier.NotifyUser(
+		identificationAttempt.UserRID,
+		"Confirm your identification",
+		fmt.Sprintf("Your code to confirm the voice identification is %v. If this was not you, please change your password.", code),
+	)
+}
+
+// VerifyStepUp accepts the latest attempt of the current user waiting for a step up if the code is valid.
+// A wrong code rejects the attempt, the user has to record a new one.
+func (r *IdentificationAttemptService) VerifyStepUp(c echo.Context, code string) (*model.IdentificationAttempt, error) {
+	userRid := helper.GetCurrentUserRID(c.Request().Context())
+	identificationAttempt, err := r.identificationAttemptDb.SelectLatestIdentificationAttemptByUserRID(userRid)
+	if err != nil {
+		return nil, err
+	}
+	if identificationAttempt.State != model.IdentificationAttemptStateStepUp {
+		return nil, fmt.Errorf("%w: attempt %v is %v", ErrInvalidTransition, identificationAttempt.RID, identificationAttempt.State)
+	}
+
+	if !r.identificationAttemptDb.CheckStepUpCodeValid(identificationAttempt.RID, strings.TrimSpace(code)) {
+		_, err = r.identificationAttemptDb.UpdateIdentificationAttemptState(identificationAttempt, model.IdentificationAttemptStateRejected)
+		if err != nil {
+			return nil, err
+		}
+		err = r.lockOutIfExceeded(userRid)
+		if err != nil {
+			r.logger.Printf("error checking lockout of user %v: %v", userRid, err)
+		}
+		return nil, ErrInvalidStepUpCode
+	}
+
+	return r.identificationAttemptDb.UpdateIdentificationAttemptState(identificationAttempt, model.IdentificationAttemptStateAccepted)
+}
@@ -0,0 +1,50 @@
+package identification
+
+import (
+	"errors"
+	"fmt"
+	"ht/helper"
+	"ht/model"
+	"ht/server/voice"
+
+	"github.com/labstack/echo/v4"
+)
+
+var ErrInvalidRecordingStream = errors.New("invalid recording stream")
+
+// StartRecordingStream returns a recorder for a streamed recording of the current user with the sample rate.
+// It returns ErrAccountRestricted, ErrLockedOut or ErrRateLimited if the user may not identify now.
+func (r *IdentificationAttemptService) StartRecordingStream(c echo.Context, sampleRate int) (*voice.StreamRecorder, error) {
+	err := r.CheckAllowance(helper.GetCurrentUserRID(c.Request().Context()))
+	if err != nil {
+		return nil, err
+	}
+
+	recorder, err := voice.NewStreamRecorder(r.vadConfig, sampleRate)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidRecordingStream, err)
+	}
+	return recorder, nil
+}
+
+// FinishRecordingStream stores the complete streamed recording as attempt of the current user
+// and starts processing it right away, the recorder does not upload it separately.
+func (r *IdentificationAttemptService) FinishRecordingStream(c echo.Context, recorder *voice.StreamRecorder) (*model.IdentificationAttempt, error) {
+	if recorder.Activity().State != model.VoiceActivityStateComplete {
+		return nil, fmt.Errorf("%w: recording is %v", ErrInvalidRecordingStream, recorder.Activity().State)
+	}
+
+	// the allowance might have changed while recording
+	err := r.CheckAllowance(helper.GetCurrentUserRID(c.Request().Context()))
+	if err != nil {
+		return nil, err
+	}
+
+	recording, channel := recorder.Recording()
+	identificationAttempt, err := r.insertIdentificationAttempt(c, recording, channel)
+	if err != nil {
+		return nil, err
+	}
+
+	return r.startProcessing(identificationAttempt)
+}
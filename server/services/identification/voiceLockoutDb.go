@@ -0,0 +1,195 @@
+package identification
+
+import (
+	"context"
+	"fmt"
+	"ht/model"
+	"ht/server/database"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type VoiceLockoutDBHandlerFunctions interface {
+	CreateTable() error
+	DropTable() error
+	InsertVoiceLockout(userRid uuid.UUID, reason string) (*model.VoiceLockout, error)
+	SelectActiveVoiceLockout(userRid uuid.UUID) (*model.VoiceLockout, error)
+	SelectLastUnlockedAt(userRid uuid.UUID) (time.Time, error)
+	UpdateVoiceLockoutUnlockCode(rid uuid.UUID, code string) error
+	CheckUnlockCodeValid(rid uuid.UUID, code string, requestedAfter time.Time) bool
+	UnlockVoiceLockout(rid uuid.UUID, unlockedBy uuid.UUID) error
+}
+
+type VoiceLockoutDBHandler struct {
+	db *database.Database
+}
+
+func newVoiceLockoutDBHandler(dbConnection *database.Database) *VoiceLockoutDBHandler {
+	return &VoiceLockoutDBHandler{
+		db: dbConnection,
+	}
+}
+
+func (r VoiceLockoutDBHandler) CreateTable() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	_, err := r.db.Instance.ExecContext(
+		ctx,
+		`CREATE EXTENSION IF NOT EXISTS pgcrypto;
+
+		CREATE TABLE IF NOT EXISTS voice_lockout (
+			id BIGINT GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+			rid UUID UNIQUE DEFAULT gen_random_uuid(),
+			user_rid UUID NOT NULL,
+			reason TEXT DEFAULT '',
+			unlock_code_hash TEXT DEFAULT '',
+			unlock_code_requested_at TIMESTAMP WITH TIME ZONE DEFAULT '2000-01-01T01:23:45Z',
+			unlocked_at TIMESTAMP WITH TIME ZONE,
+			unlocked_by UUID,
+			created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+			updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+		);
+
+		CREATE UNIQUE INDEX IF NOT EXISTS idx_voice_lockout_active_user_rid
+			ON voice_lockout (user_rid) WHERE unlocked_at IS NULL;`,
+	)
+	if err != nil {
+		return fmt.Errorf("error creating voice_lockout table: %v", err)
+	}
+
+	r.db.Logger.Println("created table voice_lockout")
+	return nil
+}
+
+func (r VoiceLockoutDBHandler) DropTable() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	query := `DROP TABLE IF EXISTS voice_lockout`
+	_, err := r.db.Instance.ExecContext(ctx, query)
+	if err != nil {
+		return fmt.Errorf("error dropping voice_lockout table: %#v", err)
+	}
+
+	r.db.Logger.Printf("dropped table voice_lockout")
+	return nil
+}
+
+// InsertVoiceLockout locks the user out, an already active lockout is returned unchanged.
+func (r VoiceLockoutDBHandler) InsertVoiceLockout(userRid uuid.UUID, reason string) (*model.VoiceLockout, error) {
+	_, err := r.db.Instance.Exec(
+		`INSERT INTO voice_lockout (user_rid, reason)
+			VALUES ($1, $2)
+		ON CONFLICT (user_rid) WHERE unlocked_at IS NULL DO NOTHING`,
+		userRid,
+		reason,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return r.SelectActiveVoiceLockout(userRid)
+}
+
+func (r VoiceLockoutDBHandler) SelectActiveVoiceLockout(userRid uuid.UUID) (*model.VoiceLockout, error) {
+	voiceLockout := &model.VoiceLockout{}
+
+	err := r.db.Instance.QueryRow(
+		`SELECT
+			id,
+			rid,
+			user_rid,
+			reason,
+			unlock_code_requested_at,
+			created_at
+		FROM
+			voice_lockout
+		WHERE
+			user_rid = $1
+			AND unlocked_at IS NULL`,
+		userRid,
+	).Scan(
+		&voiceLockout.ID,
+		&voiceLockout.RID,
+		&voiceLockout.UserRID,
+		&voiceLockout.Reason,
+		&voiceLockout.UnlockCodeRequestedAt,
+		&voiceLockout.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return voiceLockout, nil
+}
+
+// SelectLastUnlockedAt returns the time of the last unlock of the user, the zero time if there was none.
+func (r VoiceLockoutDBHandler) SelectLastUnlockedAt(userRid uuid.UUID) (time.Time, error) {
+	unlockedAt := time.Time{}
+
+	err := r.db.Instance.QueryRow(
+		`SELECT
+			COALESCE(MAX(unlocked_at), '0001-01-01T00:00:00Z')
+		FROM
+			voice_lockout
+		WHERE
+			user_rid = $1`,
+		userRid,
+	).Scan(&unlockedAt)
+
+	return unlockedAt, err
+}
+
+func (r VoiceLockoutDBHandler) UpdateVoiceLockoutUnlockCode(rid uuid.UUID, code string) error {
+	_, err := r.db.Instance.Exec(
+		`UPDATE
+			voice_lockout
+		SET
+			unlock_code_hash = crypt($1, gen_salt('bf', 6)),
+			unlock_code_requested_at = CURRENT_TIMESTAMP,
+			updated_at = CURRENT_TIMESTAMP
+		WHERE
+			rid = $2
+			AND unlocked_at IS NULL`,
+		code,
+		rid,
+	)
+	return err
+}
+
+func (r VoiceLockoutDBHandler) CheckUnlockCodeValid(rid uuid.UUID, code string, requestedAfter time.Time) bool {
+	exists := false
+
+	err := r.db.Instance.QueryRow(
+		`SELECT EXISTS (SELECT 1 FROM voice_lockout WHERE rid = $1 AND unlocked_at IS NULL AND unlock_code_hash <> '' AND unlock_code_requested_at > $3 AND unlock_code_hash = crypt($2, unlock_code_hash));`,
+		rid,
+		code,
+		requestedAfter,
+	).Scan(&exists)
+	if err != nil {
+		r.db.Logger.Println(err)
+		return false
+	}
+
+	return exists
+}
+
+func (r VoiceLockoutDBHandler) UnlockVoiceLockout(rid uuid.UUID, unlockedBy uuid.UUID) error {
+	_, err := r.db.Instance.Exec(
+		`UPDATE
+			voice_lockout
+		SET
+			unlock_code_hash = '',
+			unlocked_at = CURRENT_TIMESTAMP,
+			unlocked_by = $1,
+			updated_at = CURRENT_TIMESTAMP
+		WHERE
+			rid = $2
+			AND unlocked_at IS NULL`,
+		unlockedBy,
+		rid,
+	)
+	return err
+}
@@ -0,0 +1,182 @@
+package identification
+
+import (
+	"errors"
+	"fmt"
+	"ht/helper"
+	"ht/model"
+	"strconv"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+)
+
+var (
+	// ErrVoiceLoginDisabled is returned if the voice login is not enabled.
+	ErrVoiceLoginDisabled = errors.New("voice login is disabled")
+	// ErrNoVoiceMatch is returned if no user is close enough and far enough ahead of the runner-up.
+	ErrNoVoiceMatch = errors.New("voice not recognised")
+)
+
+// VoiceLoginPolicy decides if the nearest user of a 1:N search is logged in.
+type VoiceLoginPolicy struct {
+	Enabled bool
+	// Threshold is the absolute distance the nearest user has to be below.
+	Threshold float64
+	// Margin is the distance the runner-up has to be further away than the nearest user.
+	Margin float64
+	// Candidates is the number of nearest reference samples searched.
+	Candidates int
+}
+
+func NewVoiceLoginPolicyFromEnv() (*VoiceLoginPolicy, error) {
+	enabled, err := strconv.ParseBool(helper.GetEnvVariableWithDefault("VOICE_LOGIN_ENABLED", "false"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid VOICE_LOGIN_ENABLED: %v", err)
+	}
+	threshold, err := strconv.ParseFloat(helper.GetEnvVariableWithDefault("VOICE_LOGIN_THRESHOLD", "3"), 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid VOICE_LOGIN_THRESHOLD: %v", err)
+	}
+	margin, err := strconv.ParseFloat(helper.GetEnvVariableWithDefault("VOICE_LOGIN_MARGIN", "1"), 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid VOICE_LOGIN_MARGIN: %v", err)
+	}
+	candidates, err := strconv.Atoi(helper.GetEnvVariableWithDefault("VOICE_LOGIN_CANDIDATES", "40"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid VOICE_LOGIN_CANDIDATES: %v", err)
+	}
+	if threshold <= 0 || margin < 0 {
+		return nil, fmt.Errorf("VOICE_LOGIN_THRESHOLD has to be positive and VOICE_LOGIN_MARGIN not negative")
+	}
+	if candidates < 2 {
+		return nil, fmt.Errorf("VOICE_LOGIN_CANDIDATES has to be at least 2")
+	}
+
+	return &VoiceLoginPolicy{
+		Enabled:    enabled,
+		Threshold:  threshold,
+		Margin:     margin,
+		Candidates: candidates,
+	}, nil
+}
+
+// Decide returns the nearest candidate if it beats the threshold and the margin over the runner-up.
+// The candidates have to be ordered by distance.
+func (r *VoiceLoginPolicy) Decide(candidates []*model.VoiceCandidate) (*model.VoiceCandidate, bool) {
+	if len(candidates) == 0 || candidates[0].Distance >= r.Threshold {
+		return nil, false
+	}
+	if len(candidates) > 1 && candidates[1].Distance-candidates[0].Distance < r.Margin {
+		return nil, false
+	}
+	return candidates[0], true
+}
+
+func (r *IdentificationAttemptService) IsVoiceLoginEnabled() bool {
+	return r.voiceLoginPolicy.Enabled
+}
+
+// IdentifySpeaker searches the user the recording belongs to over all enrolled users,
+// the optional login code narrows the search to the users with that code.
+// Every search which finds a candidate is recorded as an attempt of the nearest one, so the
+// voice login counts towards the attempt window and the lockout like any identification.
+// All failures return ErrNoVoiceMatch, the caller can not tell a locked account from an unknown voice.
+// A candidate who said the duress sentence is reported and returned with Duress set.
+func (r *IdentificationAttemptService) IdentifySpeaker(c echo.Context, recording []byte, voiceLoginCode string) (*model.VoiceCandidate, error) {
+	if !r.voiceLoginPolicy.Enabled {
+		return nil, ErrVoiceLoginDisabled
+	}
+
+	mfcc, err := r.featureExtractor.ExtractFeatures(c.Request().Context(), recording)
+	if err != nil {
+		return nil, err
+	}
+	if len(mfcc) != r.featureExtractor.Dimension() {
+		return nil, fmt.Errorf("got %v features, expected %v", len(mfcc), r.featureExtractor.Dimension())
+	}
+
+	// a watchlisted voice is not told apart from an unknown one
+	watchlistHit, err := r.referenceStore.ScreenWatchlist(mfcc, r.featureExtractor.Extractor())
+	if err != nil {
+		return nil, err
+	}
+
+	candidates, err := r.referenceStore.SearchNearestUsers(mfcc, r.matchingPolicy.Metric, r.featureExtractor.Extractor(), voiceLoginCode, r.voiceLoginPolicy.Candidates)
+	if err != nil {
+		return nil, err
+	}
+	if len(candidates) == 0 {
+		r.logger.Printf("voice login rejected without candidates")
+		return nil, ErrNoVoiceMatch
+	}
+
+	// the nearest user is the one the voice claims to be, it has to be allowed to identify before it is matched
+	nearest := candidates[0]
+	err = r.CheckAllowance(nearest.UserRID)
+	if errors.Is(err, ErrAccountRestricted) || errors.Is(err, ErrLockedOut) || errors.Is(err, ErrRateLimited) {
+		r.logger.Printf("voice login refused for user %v: %v", nearest.UserRID, err)
+		return nil, ErrNoVoiceMatch
+	} else if err != nil {
+		return nil, err
+	}
+
+	identificationAttempt := &model.IdentificationAttempt{
+		UserRID:   nearest.UserRID,
+		Recording: recording,
+		Channel:   model.ChannelWideband,
+		Score:     nearest.Distance,
+	}
+	err = r.assessRisk(c, identificationAttempt)
+	if err != nil {
+		return nil, err
+	}
+
+	candidate, ok := r.voiceLoginPolicy.Decide(candidates)
+	if watchlistHit != nil {
+		userRid := uuid.Nil
+		if ok {
+			userRid = candidate.UserRID
+		}
+		err = r.reportWatchlistHit(userRid, userRid, watchlistHit, map[string]any{
+			"voice_login": true,
+		})
+		if err != nil {
+			return nil, err
+		}
+		ok = false
+	}
+
+	// a voice login has no second factor for a step up, only an allowed risk logs in
+	accepted := ok && identificationAttempt.RiskAction == model.RiskActionAllow
+	if accepted {
+		// the login succeeds as usual, the caller restricts the session
+		identificationAttempt.Duress, err = r.MatchesDuressPhrase(candidate.UserRID, recording)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	_, err = r.recordDecidedAttempt(identificationAttempt, accepted)
+	if err != nil {
+		return nil, err
+	}
+	if !accepted {
+		r.logger.Printf("voice login rejected with %v candidates and risk %v", len(candidates), identificationAttempt.RiskAction)
+		return nil, ErrNoVoiceMatch
+	}
+	r.logger.Printf("voice login identified user %v with distance %v", candidate.UserRID, candidate.Distance)
+
+	candidate.Duress = identificationAttempt.Duress
+	if candidate.Duress {
+		err = r.reportDuress(candidate.UserRID, candidate.UserRID, map[string]any{
+			"voice_login": true,
+			"distance":    candidate.Distance,
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return candidate, nil
+}
@@ -0,0 +1,50 @@
+package identification
+
+import (
+	"fmt"
+	"ht/model"
+
+	"github.com/google/uuid"
+)
+
+// screenWatchlist screens the voice of the processing attempt against the fraud watchlist. A hit is recorded
+// on the attempt and raises its risk action to the one of the watchlist action, so decideState rejects
+// the attempt or requires a step up.
+func (r *IdentificationAttemptService) screenWatchlist(identificationAttempt *model.IdentificationAttempt) (*model.WatchlistHit, error) {
+	watchlistHit, err := r.referenceStore.ScreenWatchlist(identificationAttempt.RecordingMfcc, identificationAttempt.Extractor)
+	if err != nil || watchlistHit == nil {
+		return nil, err
+	}
+
+	riskAction := identificationAttempt.RiskAction
+	if !riskAction.AtLeast(watchlistHit.Action.RiskAction()) {
+		riskAction = watchlistHit.Action.RiskAction()
+	}
+
+	err = r.identificationAttemptDb.UpdateIdentificationAttemptWatchlistHit(identificationAttempt.RID, watchlistHit, riskAction)
+	if err != nil {
+		return nil, fmt.Errorf("error recording watchlist hit: %v", err)
+	}
+
+	identificationAttempt.WatchlistEntryRID = watchlistHit.EntryRID
+	identificationAttempt.WatchlistDistance = watchlistHit.Distance
+	identificationAttempt.RiskAction = riskAction
+	return watchlistHit, nil
+}
+
+// reportWatchlistHit alerts the fraud team about the hit and records it in the audit trail of the account.
+// The user only sees the rejection or the step up, never the watchlist.
+func (r *IdentificationAttemptService) reportWatchlistHit(actorRid uuid.UUID, userRid uuid.UUID, watchlistHit *model.WatchlistHit, details map[string]any) error {
+	details["entry_rid"] = watchlistHit.EntryRID
+	details["reason"] = watchlistHit.Reason
+	details["distance"] = watchlistHit.Distance
+	details["action"] = watchlistHit.Action
+	r.logger.Printf("voice of user %v matches watchlist entry %v, applied %v", userRid, watchlistHit.EntryRID, watchlistHit.Action)
+
+	err := r.alerter.Alert(&model.Alert{Type: model.AlertTypeWatchlistHit, UserRID: userRid, Details: details})
+	if err != nil {
+		r.logger.Printf("error alerting watchlist hit of user %v: %v", userRid, err)
+	}
+
+	return r.auditService.Record(actorRid, userRid, model.AuditActionWatchlistHit, details)
+}
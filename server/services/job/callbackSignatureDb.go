@@ -0,0 +1,90 @@
+package job
+
+import (
+	"context"
+	"fmt"
+	"ht/server/database"
+	"time"
+)
+
+type CallbackSignatureDBHandlerFunctions interface {
+	CreateTable() error
+	DropTable() error
+	InsertCallbackSignature(signature string, expiresAt time.Time) (bool, error)
+}
+
+type CallbackSignatureDBHandler struct {
+	db *database.Database
+}
+
+func newCallbackSignatureDBHandler(dbConnection *database.Database) *CallbackSignatureDBHandler {
+	return &CallbackSignatureDBHandler{
+		db: dbConnection,
+	}
+}
+
+func (r CallbackSignatureDBHandler) CreateTable() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	_, err := r.db.Instance.ExecContext(
+		ctx,
+		`CREATE TABLE IF NOT EXISTS callback_signature (
+			id BIGINT GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+			signature TEXT UNIQUE NOT NULL,
+			expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+			created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+		);`,
+	)
+	if err != nil {
+		return fmt.Errorf("error creating callback_signature table: %v", err)
+	}
+
+	err = r.db.CreateIndex("callback_signature", "expires_at")
+	if err != nil {
+		return err
+	}
+
+	r.db.Logger.Println("created table callback_signature")
+	return nil
+}
+
+func (r CallbackSignatureDBHandler) DropTable() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	query := `DROP TABLE IF EXISTS callback_signature`
+	_, err := r.db.Instance.ExecContext(ctx, query)
+	if err != nil {
+		return fmt.Errorf("error dropping callback_signature table: %#v", err)
+	}
+
+	r.db.Logger.Printf("dropped table callback_signature")
+	return nil
+}
+
+// InsertCallbackSignature stores the signature until it expires, expired signatures are removed first.
+// It returns false if the signature is stored already, the unique key decides between concurrent inserts.
+func (r CallbackSignatureDBHandler) InsertCallbackSignature(signature string, expiresAt time.Time) (bool, error) {
+	_, err := r.db.Instance.Exec(`DELETE FROM callback_signature WHERE expires_at < CURRENT_TIMESTAMP`)
+	if err != nil {
+		return false, err
+	}
+
+	result, err := r.db.Instance.Exec(
+		`INSERT INTO callback_signature (signature, expires_at)
+			VALUES ($1, $2)
+		ON CONFLICT (signature) DO NOTHING`,
+		signature,
+		expiresAt,
+	)
+	if err != nil {
+		return false, err
+	}
+
+	inserted, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return inserted == 1, nil
+}
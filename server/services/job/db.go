@@ -0,0 +1,377 @@
+package job
+
+import (
+	"context"
+	"fmt"
+	"ht/model"
+	"ht/server/database"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+type JobDBHandlerFunctions interface {
+	CreateTable() error
+	DropTable() error
+	InsertJob(job *model.Job) (*model.Job, error)
+	UpdateJob(job *model.Job) (*model.Job, error)
+	SelectJob(rid uuid.UUID) (*model.Job, error)
+	ClaimJob(jobTypes []model.JobType) (*model.Job, error)
+	RequeueStaleJobs(lockedBefore time.Time) ([]*model.Job, error)
+	CountUnfinishedJobsByType(jobType model.JobType) (int, error)
+}
+
+type JobDBHandler struct {
+	db *database.Database
+}
+
+func newJobDBHandler(dbConnection *database.Database) *JobDBHandler {
+	return &JobDBHandler{
+		db: dbConnection,
+	}
+}
+
+func (r JobDBHandler) CreateTable() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	_, err := r.db.Instance.ExecContext(
+		ctx,
+		`CREATE TABLE IF NOT EXISTS job (
+			id BIGINT GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+			rid UUID UNIQUE DEFAULT gen_random_uuid(),
+			type TEXT NOT NULL,
+			payload JSONB DEFAULT '{}',
+			state TEXT NOT NULL DEFAULT 'queued',
+			attempts INT DEFAULT 0,
+			max_attempts INT DEFAULT 5,
+			run_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+			locked_at TIMESTAMP WITH TIME ZONE DEFAULT '2000-01-01T01:23:45Z',
+			last_error TEXT DEFAULT '',
+			created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+			updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+		);`,
+	)
+	if err != nil {
+		return fmt.Errorf("error creating job table: %v", err)
+	}
+
+	err = r.db.CreateIndex("job", "rid")
+	if err != nil {
+		return err
+	}
+
+	err = r.db.CreateCombinedIndex("job", "state", "run_at")
+	if err != nil {
+		return err
+	}
+
+	r.db.Logger.Println("created table job")
+	return nil
+}
+
+func (r JobDBHandler) DropTable() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	query := `DROP TABLE IF EXISTS job`
+	_, err := r.db.Instance.ExecContext(ctx, query)
+	if err != nil {
+		return fmt.Errorf("error dropping job table: %#v", err)
+	}
+
+	r.db.Logger.Printf("dropped table job")
+	return nil
+}
+
+func (r JobDBHandler) InsertJob(job *model.Job) (*model.Job, error) {
+	newJob := &model.Job{}
+
+	row := r.db.Instance.QueryRow(
+		`INSERT INTO job (type, payload, max_attempts)
+			VALUES ($1, $2, $3)
+		RETURNING
+			id,
+			rid,
+			type,
+			payload,
+			state,
+			attempts,
+			max_attempts,
+			run_at,
+			locked_at,
+			last_error,
+			created_at,
+			updated_at;`,
+		job.Type,
+		[]byte(job.Payload),
+		job.MaxAttempts,
+	)
+
+	err := row.Scan(
+		&newJob.ID,
+		&newJob.RID,
+		&newJob.Type,
+		&newJob.Payload,
+		&newJob.State,
+		&newJob.Attempts,
+		&newJob.MaxAttempts,
+		&newJob.RunAt,
+		&newJob.LockedAt,
+		&newJob.LastError,
+		&newJob.CreatedAt,
+		&newJob.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return newJob, nil
+}
+
+// UpdateJob writes the result of a run, the state, next run and last error.
+func (r JobDBHandler) UpdateJob(job *model.Job) (*model.Job, error) {
+	jobUpdated := &model.Job{}
+
+	row := r.db.Instance.QueryRow(
+		`UPDATE
+			job
+		SET
+			state = $1,
+			run_at = $2,
+			last_error = $3,
+			updated_at = CURRENT_TIMESTAMP
+		WHERE
+			rid = $4
+		RETURNING
+			id,
+			rid,
+			type,
+			payload,
+			state,
+			attempts,
+			max_attempts,
+			run_at,
+			locked_at,
+			last_error,
+			created_at,
+			updated_at;`,
+		job.State,
+		job.RunAt,
+		job.LastError,
+		job.RID,
+	)
+
+	err := row.Scan(
+		&jobUpdated.ID,
+		&jobUpdated.RID,
+		&jobUpdated.Type,
+		&jobUpdated.Payload,
+		&jobUpdated.State,
+		&jobUpdated.Attempts,
+		&jobUpdated.MaxAttempts,
+		&jobUpdated.RunAt,
+		&jobUpdated.LockedAt,
+		&jobUpdated.LastError,
+		&jobUpdated.CreatedAt,
+		&jobUpdated.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return jobUpdated, nil
+}
+
+func (r JobDBHandler) SelectJob(rid uuid.UUID) (*model.Job, error) {
+	job := &model.Job{}
+
+	row := r.db.Instance.QueryRow(
+		`SELECT
+			id,
+			rid,
+			type,
+			payload,
+			state,
+			attempts,
+			max_attempts,
+			run_at,
+			locked_at,
+			last_error,
+			created_at,
+			updated_at
+		FROM
+			job
+		WHERE
+			rid = $1`,
+		rid,
+	)
+	err := row.Scan(
+		&job.ID,
+		&job.RID,
+		&job.Type,
+		&job.Payload,
+		&job.State,
+		&job.Attempts,
+		&job.MaxAttempts,
+		&job.RunAt,
+		&job.LockedAt,
+		&job.LastError,
+		&job.CreatedAt,
+		&job.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return job, nil
+}
+
+// ClaimJob marks the next due job of the given types as running and returns it.
+// Jobs locked by other workers are skipped, so every job is claimed once.
+// It returns sql.ErrNoRows if no job is due.
+func (r JobDBHandler) ClaimJob(jobTypes []model.JobType) (*model.Job, error) {
+	job := &model.Job{}
+
+	types := make([]string, len(jobTypes))
+	for i, jobType := range jobTypes {
+		types[i] = string(jobType)
+	}
+
+	row := r.db.Instance.QueryRow(
+		`UPDATE
+			job
+		SET
+			state = 'running',
+			attempts = attempts + 1,
+			locked_at = CURRENT_TIMESTAMP,
+			updated_at = CURRENT_TIMESTAMP
+		WHERE
+			id = (
+				SELECT
+					id
+				FROM
+					job
+				WHERE
+					state = 'queued'
+					AND run_at <= CURRENT_TIMESTAMP
+					AND type = ANY($1)
+				ORDER BY
+					run_at
+				LIMIT 1
+				FOR UPDATE SKIP LOCKED)
+		RETURNING
+			id,
+			rid,
+			type,
+			payload,
+			state,
+			attempts,
+			max_attempts,
+			run_at,
+			locked_at,
+			last_error,
+			created_at,
+			updated_at;`,
+		pq.Array(types),
+	)
+	err := row.Scan(
+		&job.ID,
+		&job.RID,
+		&job.Type,
+		&job.Payload,
+		&job.State,
+		&job.Attempts,
+		&job.MaxAttempts,
+		&job.RunAt,
+		&job.LockedAt,
+		&job.LastError,
+		&job.CreatedAt,
+		&job.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return job, nil
+}
+
+// RequeueStaleJobs puts running jobs of crashed workers back into the queue, jobs without
+// attempts left are dead. It returns the changed jobs with their new state.
+func (r JobDBHandler) RequeueStaleJobs(lockedBefore time.Time) ([]*model.Job, error) {
+	jobs := []*model.Job{}
+
+	rows, err := r.db.Instance.Query(
+		`UPDATE
+			job
+		SET
+			state = CASE WHEN attempts >= max_attempts THEN 'dead' ELSE 'queued' END,
+			last_error = 'worker lost',
+			updated_at = CURRENT_TIMESTAMP
+		WHERE
+			state = 'running'
+			AND locked_at < $1
+		RETURNING
+			id,
+			rid,
+			type,
+			payload,
+			state,
+			attempts,
+			max_attempts,
+			run_at,
+			locked_at,
+			last_error,
+			created_at,
+			updated_at;`,
+		lockedBefore,
+	)
+	if err != nil {
+		return jobs, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		job := &model.Job{}
+		err := rows.Scan(
+			&job.ID,
+			&job.RID,
+			&job.Type,
+			&job.Payload,
+			&job.State,
+			&job.Attempts,
+			&job.MaxAttempts,
+			&job.RunAt,
+			&job.LockedAt,
+			&job.LastError,
+			&job.CreatedAt,
+			&job.UpdatedAt,
+		)
+		if err != nil {
+			return []*model.Job{}, err
+		}
+		jobs = append(jobs, job)
+	}
+
+	return jobs, rows.Err()
+}
+
+// CountUnfinishedJobsByType counts the queued and running jobs of the type.
+func (r JobDBHandler) CountUnfinishedJobsByType(jobType model.JobType) (int, error) {
+	count := 0
+
+	err := r.db.Instance.QueryRow(
+		`SELECT
+			COUNT(*)
+		FROM
+			job
+		WHERE
+			type = $1
+			AND state IN ('queued', 'running')`,
+		jobType,
+	).Scan(&count)
+
+	return count, err
+}
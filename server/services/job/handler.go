@@ -0,0 +1,329 @@
+package job
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"ht/helper"
+	"ht/model"
+	"ht/server/database"
+	"log"
+	"os"
+	"runtime/debug"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// JobHandler runs a claimed job. Returning an error schedules a retry,
+// OnDead is called once all attempts failed. Jobs without queue run on the interactive one.
+type JobHandler struct {
+	Handle func(job *model.Job) error
+	OnDead func(job *model.Job)
+	Queue  model.JobQueue
+}
+
+type JobService struct {
+	logger              *log.Logger
+	jobDb               JobDBHandlerFunctions
+	callbackSignatureDb CallbackSignatureDBHandlerFunctions
+	mutex               sync.RWMutex
+	handlers            map[model.JobType]*JobHandler
+	workers             int
+	batchWorkers        int
+	maxAttempts         int
+	backoff             time.Duration
+	maxBackoff          time.Duration
+	lockTimeout         time.Duration
+}
+
+func NewJobService() *JobService {
+	logger := log.New(os.Stdout, "job: ", log.LstdFlags)
+	dbConnection := database.NewDatabase(
+		"job",
+		&database.DatabaseConfiguration{
+			Host:     helper.GetEnvVariable("DB_JOB_HOST"),
+			Port:     helper.GetEnvVariable("DB_JOB_PORT"),
+			Database: helper.GetEnvVariable("DB_JOB_DATABASE"),
+			Username: helper.GetEnvVariable("DB_JOB_USERNAME"),
+			Password: helper.GetEnvVariable("DB_JOB_PASSWORD"),
+			Schema:   helper.GetEnvVariable("DB_JOB_SCHEMA"),
+		},
+	)
+	var jobDb JobDBHandlerFunctions = newJobDBHandler(dbConnection)
+
+	var callbackSignatureDb CallbackSignatureDBHandlerFunctions = newCallbackSignatureDBHandler(dbConnection)
+
+	// creates main job table
+	err := jobDb.CreateTable()
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+
+	// creates the table of accepted callbacks of the jobs service
+	err = callbackSignatureDb.CreateTable()
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+
+	workers, err := strconv.Atoi(helper.GetEnvVariableWithDefault("JOB_WORKERS", "2"))
+	if err != nil {
+		log.Fatalf("invalid JOB_WORKERS: %v", err)
+	}
+	batchWorkers, err := strconv.Atoi(helper.GetEnvVariableWithDefault("JOB_BATCH_WORKERS", "1"))
+	if err != nil {
+		log.Fatalf("invalid JOB_BATCH_WORKERS: %v", err)
+	}
+	maxAttempts, err := strconv.Atoi(helper.GetEnvVariableWithDefault("JOB_MAX_ATTEMPTS", "5"))
+	if err != nil {
+		log.Fatalf("invalid JOB_MAX_ATTEMPTS: %v", err)
+	}
+	backoffSeconds, err := strconv.Atoi(helper.GetEnvVariableWithDefault("JOB_BACKOFF_SECONDS", "2"))
+	if err != nil {
+		log.Fatalf("invalid JOB_BACKOFF_SECONDS: %v", err)
+	}
+	if workers < 1 || batchWorkers < 1 || maxAttempts < 1 || backoffSeconds < 1 {
+		log.Fatal("JOB_WORKERS, JOB_BATCH_WORKERS, JOB_MAX_ATTEMPTS and JOB_BACKOFF_SECONDS have to be at least 1")
+	}
+
+	newJobService := &JobService{
+		logger:              logger,
+		jobDb:               jobDb,
+		callbackSignatureDb: callbackSignatureDb,
+		handlers:            map[model.JobType]*JobHandler{},
+		workers:             workers,
+		batchWorkers:        batchWorkers,
+		maxAttempts:         maxAttempts,
+		backoff:             time.Duration(backoffSeconds) * time.Second,
+		maxBackoff:          5 * time.Minute,
+		lockTimeout:         5 * time.Minute,
+	}
+
+	return newJobService
+}
+
+// RegisterHandler sets the handler for a job type, jobs without handler stay queued.
+func (r *JobService) RegisterHandler(jobType model.JobType, handler *JobHandler) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if len(handler.Queue) == 0 {
+		handler.Queue = model.JobQueueInteractive
+	}
+	r.handlers[jobType] = handler
+}
+
+// Enqueue adds a job with the payload marshaled to json, it is run by the next free worker.
+func (r *JobService) Enqueue(jobType model.JobType, payload any) (*model.Job, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("error marshaling %v payload: %v", jobType, err)
+	}
+
+	job, err := r.jobDb.InsertJob(&model.Job{
+		Type:        jobType,
+		Payload:     data,
+		MaxAttempts: r.maxAttempts,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error enqueueing %v job: %v", jobType, err)
+	}
+
+	return job, nil
+}
+
+func (r *JobService) GetJob(rid uuid.UUID) (*model.Job, error) {
+	return r.jobDb.SelectJob(rid)
+}
+
+// HasUnfinishedJob returns true if a job of the type is queued or running.
+func (r *JobService) HasUnfinishedJob(jobType model.JobType) (bool, error) {
+	count, err := r.jobDb.CountUnfinishedJobsByType(jobType)
+	if err != nil {
+		return false, fmt.Errorf("error counting unfinished %v jobs: %v", jobType, err)
+	}
+	return count > 0, nil
+}
+
+// RememberCallbackSignature stores the signature of an accepted callback of the jobs service until it expires,
+// it returns false if any server instance accepted it before.
+func (r *JobService) RememberCallbackSignature(signature string, expiresAt time.Time) (bool, error) {
+	return r.callbackSignatureDb.InsertCallbackSignature(signature, expiresAt)
+}
+
+// Work starts the workers claiming and running due jobs, each queue has its own workers.
+// Stop them with StopWork.
+func (r *JobService) Work(pollInterval time.Duration) (chan<- struct{}, <-chan struct{}) {
+	quit, done := make(chan struct{}), make(chan struct{})
+
+	var wg sync.WaitGroup
+	queueWorkers := map[model.JobQueue]int{
+		model.JobQueueInteractive: r.workers,
+		model.JobQueueBatch:       r.batchWorkers,
+	}
+	for queue, workers := range queueWorkers {
+		for i := 0; i < workers; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				r.work(queue, pollInterval, quit)
+			}()
+		}
+	}
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		r.requeueStaleJobs(quit)
+	}()
+
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	return quit, done
+}
+
+// StopWork stops the workers started with Work and waits for running jobs to finish.
+func (r *JobService) StopWork(quit chan<- struct{}, done <-chan struct{}) {
+	close(quit)
+	<-done
+}
+
+func (r *JobService) work(queue model.JobQueue, pollInterval time.Duration, quit <-chan struct{}) {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	for {
+		// drain the queue before waiting for the next poll
+		for r.runNextJob(queue) {
+			select {
+			case <-quit:
+				return
+			default:
+			}
+		}
+
+		select {
+		case <-quit:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (r *JobService) requeueStaleJobs(quit <-chan struct{}) {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-quit:
+			return
+		case <-ticker.C:
+			jobs, err := r.jobDb.RequeueStaleJobs(time.Now().Add(-r.lockTimeout))
+			if err != nil {
+				r.logger.Printf("error requeueing stale jobs: %v", err)
+				continue
+			}
+			for _, job := range jobs {
+				if job.State == model.JobStateDead {
+					r.logger.Printf("job %v %v lost its worker on the last of %v attempts", job.Type, job.RID, job.Attempts)
+					r.onDead(job)
+				} else {
+					r.logger.Printf("requeued stale job %v %v", job.Type, job.RID)
+				}
+			}
+		}
+	}
+}
+
+// runNextJob claims and runs one job of the queue, it returns false if no job was due.
+func (r *JobService) runNextJob(queue model.JobQueue) bool {
+	r.mutex.RLock()
+	jobTypes := []model.JobType{}
+	for jobType, handler := range r.handlers {
+		if handler.Queue == queue {
+			jobTypes = append(jobTypes, jobType)
+		}
+	}
+	r.mutex.RUnlock()
+	if len(jobTypes) == 0 {
+		return false
+	}
+
+	job, err := r.jobDb.ClaimJob(jobTypes)
+	if err == sql.ErrNoRows {
+		return false
+	} else if err != nil {
+		r.logger.Printf("error claiming job: %v", err)
+		return false
+	}
+
+	r.mutex.RLock()
+	handler := r.handlers[job.Type]
+	r.mutex.RUnlock()
+
+	err = r.handle(handler, job)
+	if err == nil {
+		job.State = model.JobStateSucceeded
+		job.LastError = ""
+	} else if job.Attempts < job.MaxAttempts {
+		job.State = model.JobStateQueued
+		job.RunAt = time.Now().Add(r.backoffFor(job.Attempts))
+		job.LastError = err.Error()
+		r.logger.Printf("job %v %v failed attempt %v, retrying at %v: %v", job.Type, job.RID, job.Attempts, job.RunAt, err)
+	} else {
+		job.State = model.JobStateDead
+		job.LastError = err.Error()
+		r.logger.Printf("job %v %v failed all %v attempts: %v", job.Type, job.RID, job.Attempts, err)
+	}
+
+	job, err = r.jobDb.UpdateJob(job)
+	if err != nil {
+		r.logger.Printf("error updating job: %v", err)
+		return true
+	}
+
+	if job.State == model.JobStateDead {
+		r.onDead(job)
+	}
+	return true
+}
+
+// handle runs the handler of the job, a panic fails the attempt like an error instead of stopping the worker.
+func (r *JobService) handle(handler *JobHandler, job *model.Job) (err error) {
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			r.logger.Printf("job %v %v panicked: %v\n%s", job.Type, job.RID, recovered, debug.Stack())
+			err = fmt.Errorf("panic: %v", recovered)
+		}
+	}()
+
+	return handler.Handle(job)
+}
+
+// onDead calls the OnDead of the handler of the job, if it has one.
+func (r *JobService) onDead(job *model.Job) {
+	r.mutex.RLock()
+	handler, ok := r.handlers[job.Type]
+	r.mutex.RUnlock()
+
+	if ok && handler.OnDead != nil {
+		handler.OnDead(job)
+	}
+}
+
+// backoffFor doubles the backoff with every failed attempt up to the maximum backoff.
+func (r *JobService) backoffFor(attempts int) time.Duration {
+	backoff := r.backoff
+	for i := 1; i < attempts && backoff < r.maxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > r.maxBackoff {
+		return r.maxBackoff
+	}
+	return backoff
+}
@@ -0,0 +1,326 @@
+package recovery
+
+import (
+	"context"
+	"fmt"
+	"ht/model"
+	"ht/server/database"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type AccountRecoveryDBHandlerFunctions interface {
+	CreateTable() error
+	DropTable() error
+	InsertAccountRecovery(accountRecovery *model.AccountRecovery) (*model.AccountRecovery, error)
+	UpdateAccountRecoveryState(accountRecovery *model.AccountRecovery, state model.AccountRecoveryState) (*model.AccountRecovery, error)
+	UpdateAccountRecoveryCodes(rid uuid.UUID, completionCode string, cancelCode string) error
+	CheckCompletionCodeValid(rid uuid.UUID, code string) bool
+	CheckCancelCodeValid(rid uuid.UUID, code string) bool
+	SelectAccountRecovery(rid uuid.UUID) (*model.AccountRecovery, error)
+	SelectOpenAccountRecoveryByUserRID(userRid uuid.UUID) (*model.AccountRecovery, error)
+	SelectLastFailedAccountRecoveryByUserRID(userRid uuid.UUID) (*model.AccountRecovery, error)
+}
+
+type AccountRecoveryDBHandler struct {
+	db *database.Database
+}
+
+func newAccountRecoveryDBHandler(dbConnection *database.Database) *AccountRecoveryDBHandler {
+	return &AccountRecoveryDBHandler{
+		db: dbConnection,
+	}
+}
+
+func (r AccountRecoveryDBHandler) CreateTable() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	_, err := r.db.Instance.ExecContext(
+		ctx,
+		`CREATE EXTENSION IF NOT EXISTS pgcrypto;
+
+		CREATE TABLE IF NOT EXISTS account_recovery (
+			id BIGINT GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+			rid UUID UNIQUE DEFAULT gen_random_uuid(),
+			user_rid UUID NOT NULL,
+			state TEXT NOT NULL DEFAULT 'challenged',
+			sentence TEXT NOT NULL DEFAULT '',
+			failures INT DEFAULT 0,
+			score DOUBLE PRECISION DEFAULT 0,
+			completion_code_hash TEXT DEFAULT '',
+			cancel_code_hash TEXT DEFAULT '',
+			decoy BOOLEAN NOT NULL DEFAULT FALSE,
+			challenged_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+			available_at TIMESTAMP WITH TIME ZONE DEFAULT '2000-01-01T01:23:45Z',
+			expires_at TIMESTAMP WITH TIME ZONE DEFAULT '2000-01-01T01:23:45Z',
+			created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+			updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+		);
+
+		ALTER TABLE account_recovery ADD COLUMN IF NOT EXISTS decoy BOOLEAN NOT NULL DEFAULT FALSE;
+
+		CREATE UNIQUE INDEX IF NOT EXISTS idx_account_recovery_open_user_rid
+			ON account_recovery (user_rid) WHERE state IN ('challenged', 'pending');`,
+	)
+	if err != nil {
+		return fmt.Errorf("error creating account_recovery table: %v", err)
+	}
+
+	err = r.db.CreateIndex("account_recovery", "rid")
+	if err != nil {
+		return err
+	}
+
+	r.db.Logger.Println("created table account_recovery")
+	return nil
+}
+
+func (r AccountRecoveryDBHandler) DropTable() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	query := `DROP TABLE IF EXISTS account_recovery`
+	_, err := r.db.Instance.ExecContext(ctx, query)
+	if err != nil {
+		return fmt.Errorf("error dropping account_recovery table: %#v", err)
+	}
+
+	r.db.Logger.Printf("dropped table account_recovery")
+	return nil
+}
+
+func (r AccountRecoveryDBHandler) InsertAccountRecovery(accountRecovery *model.AccountRecovery) (*model.AccountRecovery, error) {
+	row := r.db.Instance.QueryRow(
+		`INSERT INTO account_recovery (user_rid, sentence, failures, decoy)
+			VALUES ($1, $2, $3, $4)
+		RETURNING
+			id,
+			rid,
+			user_rid,
+			state,
+			sentence,
+			failures,
+			score,
+			decoy,
+			challenged_at,
+			available_at,
+			expires_at,
+			created_at,
+			updated_at`,
+		accountRecovery.UserRID,
+		accountRecovery.Sentence,
+		accountRecovery.Failures,
+		accountRecovery.Decoy,
+	)
+
+	return scanAccountRecovery(row)
+}
+
+// UpdateAccountRecoveryState moves the recovery to the state and stores its sentence, failures, score and times.
+// It returns sql.ErrNoRows if the recovery is no longer in the state it was read in.
+func (r AccountRecoveryDBHandler) UpdateAccountRecoveryState(accountRecovery *model.AccountRecovery, state model.AccountRecoveryState) (*model.AccountRecovery, error) {
+	row := r.db.Instance.QueryRow(
+		`UPDATE
+			account_recovery
+		SET
+			state = $1,
+			sentence = $2,
+			failures = $3,
+			score = $4,
+			challenged_at = $5,
+			available_at = $6,
+			expires_at = $7,
+			updated_at = CURRENT_TIMESTAMP
+		WHERE
+			rid = $8
+			AND state = $9
+		RETURNING
+			id,
+			rid,
+			user_rid,
+			state,
+			sentence,
+			failures,
+			score,
+			decoy,
+			challenged_at,
+			available_at,
+			expires_at,
+			created_at,
+			updated_at`,
+		state,
+		accountRecovery.Sentence,
+		accountRecovery.Failures,
+		accountRecovery.Score,
+		accountRecovery.ChallengedAt,
+		accountRecovery.AvailableAt,
+		accountRecovery.ExpiresAt,
+		accountRecovery.RID,
+		accountRecovery.State,
+	)
+
+	return scanAccountRecovery(row)
+}
+
+// UpdateAccountRecoveryCodes stores the hashes of the one-time codes of a pending recovery.
+func (r AccountRecoveryDBHandler) UpdateAccountRecoveryCodes(rid uuid.UUID, completionCode string, cancelCode string) error {
+	_, err := r.db.Instance.Exec(
+		`UPDATE
+			account_recovery
+		SET
+			completion_code_hash = crypt($1, gen_salt('bf', 6)),
+			cancel_code_hash = crypt($2, gen_salt('bf', 6)),
+			updated_at = CURRENT_TIMESTAMP
+		WHERE
+			rid = $3
+			AND state = 'pending'`,
+		completionCode,
+		cancelCode,
+		rid,
+	)
+	return err
+}
+
+func (r AccountRecoveryDBHandler) CheckCompletionCodeValid(rid uuid.UUID, code string) bool {
+	exists := false
+
+	err := r.db.Instance.QueryRow(
+		`SELECT EXISTS (SELECT 1 FROM account_recovery WHERE rid = $1 AND state = 'pending' AND completion_code_hash <> '' AND completion_code_hash = crypt($2, completion_code_hash));`,
+		rid,
+		code,
+	).Scan(&exists)
+	if err != nil {
+		return false
+	}
+
+	return exists
+}
+
+func (r AccountRecoveryDBHandler) CheckCancelCodeValid(rid uuid.UUID, code string) bool {
+	exists := false
+
+	err := r.db.Instance.QueryRow(
+		`SELECT EXISTS (SELECT 1 FROM account_recovery WHERE rid = $1 AND state = 'pending' AND cancel_code_hash <> '' AND cancel_code_hash = crypt($2, cancel_code_hash));`,
+		rid,
+		code,
+	).Scan(&exists)
+	if err != nil {
+		return false
+	}
+
+	return exists
+}
+
+func (r AccountRecoveryDBHandler) SelectAccountRecovery(rid uuid.UUID) (*model.AccountRecovery, error) {
+	row := r.db.Instance.QueryRow(
+		`SELECT
+			id,
+			rid,
+			user_rid,
+			state,
+			sentence,
+			failures,
+			score,
+			decoy,
+			challenged_at,
+			available_at,
+			expires_at,
+			created_at,
+			updated_at
+		FROM
+			account_recovery
+		WHERE
+			rid = $1`,
+		rid,
+	)
+
+	return scanAccountRecovery(row)
+}
+
+// SelectOpenAccountRecoveryByUserRID returns the challenged or pending recovery of the user, there is at most one.
+func (r AccountRecoveryDBHandler) SelectOpenAccountRecoveryByUserRID(userRid uuid.UUID) (*model.AccountRecovery, error) {
+	row := r.db.Instance.QueryRow(
+		`SELECT
+			id,
+			rid,
+			user_rid,
+			state,
+			sentence,
+			failures,
+			score,
+			decoy,
+			challenged_at,
+			available_at,
+			expires_at,
+			created_at,
+			updated_at
+		FROM
+			account_recovery
+		WHERE
+			user_rid = $1
+			AND state IN ('challenged', 'pending')`,
+		userRid,
+	)
+
+	return scanAccountRecovery(row)
+}
+
+// SelectLastFailedAccountRecoveryByUserRID returns the recovery of the user which failed last, its updated_at is the time it failed.
+func (r AccountRecoveryDBHandler) SelectLastFailedAccountRecoveryByUserRID(userRid uuid.UUID) (*model.AccountRecovery, error) {
+	row := r.db.Instance.QueryRow(
+		`SELECT
+			id,
+			rid,
+			user_rid,
+			state,
+			sentence,
+			failures,
+			score,
+			decoy,
+			challenged_at,
+			available_at,
+			expires_at,
+			created_at,
+			updated_at
+		FROM
+			account_recovery
+		WHERE
+			user_rid = $1
+			AND state = 'failed'
+		ORDER BY
+			updated_at DESC
+		LIMIT 1`,
+		userRid,
+	)
+
+	return scanAccountRecovery(row)
+}
+
+type accountRecoveryScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanAccountRecovery(row accountRecoveryScanner) (*model.AccountRecovery, error) {
+	accountRecovery := &model.AccountRecovery{}
+	err := row.Scan(
+		&accountRecovery.ID,
+		&accountRecovery.RID,
+		&accountRecovery.UserRID,
+		&accountRecovery.State,
+		&accountRecovery.Sentence,
+		&accountRecovery.Failures,
+		&accountRecovery.Score,
+		&accountRecovery.Decoy,
+		&accountRecovery.ChallengedAt,
+		&accountRecovery.AvailableAt,
+		&accountRecovery.ExpiresAt,
+		&accountRecovery.CreatedAt,
+		&accountRecovery.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return accountRecovery, nil
+}
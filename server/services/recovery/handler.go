@@ -0,0 +1,618 @@
+package recovery
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"ht/helper"
+	"ht/model"
+	"ht/server/database"
+	"ht/server/services/audit"
+	"ht/server/services/auth"
+	"ht/server/services/identification"
+	"ht/server/services/user"
+	"ht/server/voice"
+	"log"
+	"net/mail"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"github.com/siherrmann/validator"
+)
+
+var (
+	ErrRecoveryDisabled = errors.New("account recovery is disabled")
+	ErrRecoveryNotFound = errors.New("account recovery not found")
+	// ErrRecoveryUnavailable is returned for accounts which may not identify, the caller answers it like a
+	// failed verification so it does not tell which recoveries belong to an account.
+	ErrRecoveryUnavailable     = errors.New("account recovery is not available for this account")
+	ErrRecoveryPending         = errors.New("an account recovery is already pending")
+	ErrRecoveryInProgress      = errors.New("an account recovery is already in progress")
+	ErrRecoveryCoolingDown     = errors.New("account recovery failed recently")
+	ErrRecoveryClosed          = errors.New("account recovery is closed")
+	ErrChallengeExpired        = errors.New("challenge sentence expired")
+	ErrVoiceNotVerified        = errors.New("voice could not be verified")
+	ErrRecoveryNotAvailableYet = errors.New("account recovery can not be completed yet")
+	ErrInvalidRecoveryCode     = errors.New("invalid recovery code")
+	ErrInvalidEmail            = errors.New("invalid email")
+	ErrPasswordsDoNotMatch     = errors.New("passwords do not match")
+)
+
+// RecoveryPolicy decides how an account is recovered by voice.
+type RecoveryPolicy struct {
+	Enabled bool
+	// ThresholdFactor tightens the threshold of the user, the recording has to be closer than for an identification.
+	ThresholdFactor float64
+	// ChallengeTimeout is the time the sentence has to be recorded in.
+	ChallengeTimeout time.Duration
+	// MaxFailures is the number of failed verifications and wrong codes after which the recovery fails.
+	MaxFailures int
+	// Cooldown is the time after a failed recovery before the next one can be started.
+	Cooldown time.Duration
+	// Delay is the time between the verification and the completion, the old email address can cancel the recovery meanwhile.
+	Delay time.Duration
+	// Validity is the time after the delay the recovery can be completed in.
+	Validity time.Duration
+}
+
+func NewRecoveryPolicyFromEnv() (*RecoveryPolicy, error) {
+	enabled, err := strconv.ParseBool(helper.GetEnvVariableWithDefault("RECOVERY_ENABLED", "false"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid RECOVERY_ENABLED: %v", err)
+	}
+	thresholdFactor, err := strconv.ParseFloat(helper.GetEnvVariableWithDefault("RECOVERY_THRESHOLD_FACTOR", "0.8"), 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid RECOVERY_THRESHOLD_FACTOR: %v", err)
+	}
+	challengeMinutes, err := strconv.Atoi(helper.GetEnvVariableWithDefault("RECOVERY_CHALLENGE_MINUTES", "5"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid RECOVERY_CHALLENGE_MINUTES: %v", err)
+	}
+	maxFailures, err := strconv.Atoi(helper.GetEnvVariableWithDefault("RECOVERY_MAX_FAILURES", "3"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid RECOVERY_MAX_FAILURES: %v", err)
+	}
+	cooldownHours, err := strconv.Atoi(helper.GetEnvVariableWithDefault("RECOVERY_COOLDOWN_HOURS", "24"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid RECOVERY_COOLDOWN_HOURS: %v", err)
+	}
+	delayHours, err := strconv.Atoi(helper.GetEnvVariableWithDefault("RECOVERY_DELAY_HOURS", "24"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid RECOVERY_DELAY_HOURS: %v", err)
+	}
+	validityHours, err := strconv.Atoi(helper.GetEnvVariableWithDefault("RECOVERY_VALIDITY_HOURS", "72"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid RECOVERY_VALIDITY_HOURS: %v", err)
+	}
+	if thresholdFactor <= 0 || thresholdFactor > 1 {
+		return nil, fmt.Errorf("RECOVERY_THRESHOLD_FACTOR has to be above 0 and at most 1")
+	}
+	if challengeMinutes < 1 || maxFailures < 1 || validityHours < 1 {
+		return nil, fmt.Errorf("RECOVERY_CHALLENGE_MINUTES, RECOVERY_MAX_FAILURES and RECOVERY_VALIDITY_HOURS have to be at least 1")
+	}
+	if delayHours < 0 || cooldownHours < 0 {
+		return nil, fmt.Errorf("RECOVERY_DELAY_HOURS and RECOVERY_COOLDOWN_HOURS must not be negative")
+	}
+
+	return &RecoveryPolicy{
+		Enabled:          enabled,
+		ThresholdFactor:  thresholdFactor,
+		ChallengeTimeout: time.Duration(challengeMinutes) * time.Minute,
+		MaxFailures:      maxFailures,
+		Cooldown:         time.Duration(cooldownHours) * time.Hour,
+		Delay:            time.Duration(delayHours) * time.Hour,
+		Validity:         time.Duration(validityHours) * time.Hour,
+	}, nil
+}
+
+type RecoveryService struct {
+	logger                *log.Logger
+	accountRecoveryDb     AccountRecoveryDBHandlerFunctions
+	policy                *RecoveryPolicy
+	auditService          *audit.AuditService
+	authService           *auth.AuthService
+	identificationService *identification.IdentificationAttemptService
+	userService           *user.UserService
+	voiceMatcher          voice.VoiceMatcher
+}
+
+// NewRecoveryService lets users who lost access to their email address recover their account by voice.
+func NewRecoveryService(identificationService *identification.IdentificationAttemptService, userService *user.UserService, authService *auth.AuthService, auditService *audit.AuditService, voiceMatcher voice.VoiceMatcher) *RecoveryService {
+	logger := log.New(os.Stdout, "recovery: ", log.LstdFlags)
+	dbConnection := database.NewDatabase(
+		"recovery",
+		&database.DatabaseConfiguration{
+			Host:     helper.GetEnvVariable("DB_RECOVERY_HOST"),
+			Port:     helper.GetEnvVariable("DB_RECOVERY_PORT"),
+			Database: helper.GetEnvVariable("DB_RECOVERY_DATABASE"),
+			Username: helper.GetEnvVariable("DB_RECOVERY_USERNAME"),
+			Password: helper.GetEnvVariable("DB_RECOVERY_PASSWORD"),
+			Schema:   helper.GetEnvVariable("DB_RECOVERY_SCHEMA"),
+		},
+	)
+	var accountRecoveryDb AccountRecoveryDBHandlerFunctions = newAccountRecoveryDBHandler(dbConnection)
+
+	// creates main account recovery tables
+	err := accountRecoveryDb.CreateTable()
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+
+	policy, err := NewRecoveryPolicyFromEnv()
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+
+	return &RecoveryService{
+		logger:                logger,
+		accountRecoveryDb:     accountRecoveryDb,
+		policy:                policy,
+		auditService:          auditService,
+		authService:           authService,
+		identificationService: identificationService,
+		userService:           userService,
+		voiceMatcher:          voiceMatcher,
+	}
+}
+
+func (r *RecoveryService) IsEnabled() bool {
+	return r.policy.Enabled
+}
+
+// StartRecovery challenges the account of the email with a sentence to record. The response is the same
+// whether or not the email belongs to an account which can be recovered: otherwise a decoy recovery is
+// started, which never verifies. The email address of an existing account is told about every start.
+func (r *RecoveryService) StartRecovery(ctx context.Context, email string) (*model.AccountRecovery, error) {
+	if !r.policy.Enabled {
+		return nil, ErrRecoveryDisabled
+	}
+
+	account, err := r.authService.GetAuthByEmail(strings.ToLower(strings.TrimSpace(email)))
+	if err == sql.ErrNoRows {
+		return r.startDecoyRecovery(ctx)
+	} else if err != nil {
+		return nil, fmt.Errorf("error selecting auth: %v", err)
+	}
+
+	accountRecovery, err := r.startAccountRecovery(ctx, account.RID)
+	if errors.Is(err, ErrRecoveryUnavailable) || errors.Is(err, ErrRecoveryCoolingDown) || errors.Is(err, ErrRecoveryPending) || errors.Is(err, ErrRecoveryInProgress) {
+		r.notifyRecoveryStart(account.RID, fmt.Sprintf("A recovery of your account by voice was requested, but could not be started: %v.", err))
+		return r.startDecoyRecovery(ctx)
+	} else if err != nil {
+		return nil, err
+	}
+
+	r.notifyRecoveryStart(account.RID, "A recovery of your account by voice was started.")
+	return accountRecovery, nil
+}
+
+// startAccountRecovery starts the recovery of the user. A pending recovery has to be completed or cancelled first
+// and a challenged one recorded until its sentence expires, then it is replaced and its failures are carried over.
+// After a failed recovery the next one can only be started after the cooldown.
+func (r *RecoveryService) startAccountRecovery(ctx context.Context, userRid uuid.UUID) (*model.AccountRecovery, error) {
+	available, err := r.isVoiceAvailable(userRid)
+	if err != nil {
+		return nil, err
+	}
+	if !available {
+		return nil, ErrRecoveryUnavailable
+	}
+
+	failedRecovery, err := r.accountRecoveryDb.SelectLastFailedAccountRecoveryByUserRID(userRid)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, fmt.Errorf("error selecting failed account recovery: %v", err)
+	} else if err == nil && time.Since(failedRecovery.UpdatedAt) < r.policy.Cooldown {
+		return nil, ErrRecoveryCoolingDown
+	}
+
+	failures := 0
+	openRecovery, err := r.accountRecoveryDb.SelectOpenAccountRecoveryByUserRID(userRid)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, fmt.Errorf("error selecting open account recovery: %v", err)
+	} else if err == nil {
+		if openRecovery.State == model.AccountRecoveryStatePending && !openRecovery.IsExpired(time.Now()) {
+			return nil, ErrRecoveryPending
+		}
+		if openRecovery.State == model.AccountRecoveryStateChallenged && time.Since(openRecovery.ChallengedAt) <= r.policy.ChallengeTimeout {
+			return nil, ErrRecoveryInProgress
+		}
+		if openRecovery.State == model.AccountRecoveryStateChallenged {
+			failures = openRecovery.Failures
+		}
+		_, err = r.accountRecoveryDb.UpdateAccountRecoveryState(openRecovery, model.AccountRecoveryStateExpired)
+		if err != nil && err != sql.ErrNoRows {
+			return nil, fmt.Errorf("error expiring account recovery: %v", err)
+		}
+	}
+
+	sentence, err := r.voiceMatcher.CreateSentence(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error creating sentence: %v", err)
+	}
+
+	accountRecovery, err := r.accountRecoveryDb.InsertAccountRecovery(&model.AccountRecovery{
+		UserRID:  userRid,
+		Sentence: sentence,
+		Failures: failures,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error inserting account recovery: %v", err)
+	}
+
+	err = r.auditService.Record(userRid, userRid, model.AuditActionAccountRecoveryStarted, map[string]any{
+		"recovery_rid": accountRecovery.RID,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return accountRecovery, nil
+}
+
+// startDecoyRecovery starts a recovery for no account. Its user rid is random, so it never collides
+// with the open recovery of a user.
+func (r *RecoveryService) startDecoyRecovery(ctx context.Context) (*model.AccountRecovery, error) {
+	sentence, err := r.voiceMatcher.CreateSentence(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error creating sentence: %v", err)
+	}
+
+	accountRecovery, err := r.accountRecoveryDb.InsertAccountRecovery(&model.AccountRecovery{
+		UserRID:  uuid.New(),
+		Sentence: sentence,
+		Decoy:    true,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error inserting account recovery: %v", err)
+	}
+	return accountRecovery, nil
+}
+
+// notifyRecoveryStart tells the email address of the account about a recovery start, the owner is the only
+// one who learns whether it was started.
+func (r *RecoveryService) notifyRecoveryStart(userRid uuid.UUID, body string) {
+	err := r.authService.NotifyUser(userRid, "Recovery of your account", body+" If this was not you, please contact support right away.")
+	if err != nil {
+		r.logger.Printf("error notifying user %v about the recovery start: %v", userRid, err)
+	}
+}
+
+// isVoiceAvailable returns true if the user may identify and has an active voice profile besides the duress profile.
+func (r *RecoveryService) isVoiceAvailable(userRid uuid.UUID) (bool, error) {
+	allowance, err := r.identificationService.GetAllowance(userRid)
+	if err != nil {
+		return false, err
+	}
+	if !allowance.Status.MayIdentify() || allowance.Lockout != nil {
+		return false, nil
+	}
+
+	return r.userService.HasActiveVoiceProfile(userRid)
+}
+
+// GetAccountRecovery returns the recovery, a pending recovery which was not completed in time is expired.
+func (r *RecoveryService) GetAccountRecovery(rid uuid.UUID) (*model.AccountRecovery, error) {
+	accountRecovery, err := r.accountRecoveryDb.SelectAccountRecovery(rid)
+	if err == sql.ErrNoRows {
+		return nil, ErrRecoveryNotFound
+	} else if err != nil {
+		return nil, fmt.Errorf("error selecting account recovery: %v", err)
+	}
+
+	if accountRecovery.IsExpired(time.Now()) {
+		accountRecovery, err = r.updateState(accountRecovery, model.AccountRecoveryStateExpired)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return accountRecovery, nil
+}
+
+// ChallengeAccountRecovery returns the recovery, a challenged recovery gets a fresh sentence
+// so every recording answers a new challenge.
+func (r *RecoveryService) ChallengeAccountRecovery(ctx context.Context, rid uuid.UUID) (*model.AccountRecovery, error) {
+	accountRecovery, err := r.GetAccountRecovery(rid)
+	if err != nil {
+		return nil, err
+	}
+	if accountRecovery.State != model.AccountRecoveryStateChallenged {
+		return accountRecovery, nil
+	}
+
+	accountRecovery.Sentence, err = r.voiceMatcher.CreateSentence(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error creating sentence: %v", err)
+	}
+	accountRecovery.ChallengedAt = time.Now()
+
+	return r.updateState(accountRecovery, model.AccountRecoveryStateChallenged)
+}
+
+// VerifyAccountRecovery verifies the recording of the challenge sentence against the voice profiles
+// of the account with the stricter threshold of the policy. A verified recovery is pending: the old
+// email address is notified with a code to cancel it and the returned completion code sets the new
+// credentials after the delay. It is only returned here, the user has to keep it.
+func (r *RecoveryService) VerifyAccountRecovery(ctx context.Context, rid uuid.UUID, recording []byte) (*model.AccountRecovery, string, error) {
+	accountRecovery, err := r.GetAccountRecovery(rid)
+	if err != nil {
+		return nil, "", err
+	}
+	if accountRecovery.State != model.AccountRecoveryStateChallenged {
+		return nil, "", ErrRecoveryClosed
+	}
+	if time.Since(accountRecovery.ChallengedAt) > r.policy.ChallengeTimeout {
+		return nil, "", ErrChallengeExpired
+	}
+
+	// a decoy answers like a recording which was not verified
+	if accountRecovery.Decoy {
+		recording, _, err := voice.NormaliseRecording(recording, voice.EncodingContainer)
+		if err != nil {
+			return nil, "", err
+		}
+		_, err = r.voiceMatcher.ExtractFeatures(ctx, recording)
+		if err != nil {
+			return nil, "", err
+		}
+		err = r.recordFailure(ctx, accountRecovery, "decoy", map[string]any{})
+		if err != nil {
+			return nil, "", err
+		}
+		return nil, "", ErrVoiceNotVerified
+	}
+
+	// recoveries share the attempt window and the lockout with the identification
+	err = r.identificationService.CheckAllowance(accountRecovery.UserRID)
+	if errors.Is(err, identification.ErrAccountRestricted) || errors.Is(err, identification.ErrLockedOut) {
+		return nil, "", ErrRecoveryUnavailable
+	} else if err != nil {
+		return nil, "", err
+	}
+
+	recording, channel, err := voice.NormaliseRecording(recording, voice.EncodingContainer)
+	if err != nil {
+		return nil, "", err
+	}
+	vector, err := r.voiceMatcher.ExtractFeatures(ctx, recording)
+	if err != nil {
+		return nil, "", err
+	}
+	decision, err := r.identificationService.VerifyRecording(accountRecovery.UserRID, vector, r.voiceMatcher.Extractor(), channel)
+	if err != nil {
+		return nil, "", err
+	}
+
+	accountRecovery.Score = decision.Score
+	threshold := decision.Threshold * r.policy.ThresholdFactor
+	details := map[string]any{
+		"score":       decision.Score,
+		"threshold":   threshold,
+		"profile_rid": decision.ProfileRID,
+	}
+
+	// a recovery under duress looks like any failed verification
+	duress := false
+	if decision.Accepted {
+		duress, err = r.identificationService.MatchesDuressPhrase(accountRecovery.UserRID, recording)
+		if err != nil {
+			return nil, "", err
+		}
+	}
+	if duress {
+		err = r.identificationService.ReportDuress(accountRecovery.UserRID, map[string]any{
+			"recovery_rid": accountRecovery.RID,
+			"score":        decision.Score,
+			"threshold":    decision.Threshold,
+			"profile_rid":  decision.ProfileRID,
+		})
+		if err != nil {
+			return nil, "", err
+		}
+	}
+	verified := !duress && decision.Score < threshold
+	_, err = r.identificationService.RecordVoiceCheck(accountRecovery.UserRID, recording, channel, decision, verified)
+	if err != nil {
+		return nil, "", err
+	}
+	if !verified {
+		err = r.recordFailure(ctx, accountRecovery, "voice not verified", details)
+		if err != nil {
+			return nil, "", err
+		}
+		return nil, "", ErrVoiceNotVerified
+	}
+
+	now := time.Now()
+	accountRecovery.AvailableAt = now.Add(r.policy.Delay)
+	accountRecovery.ExpiresAt = accountRecovery.AvailableAt.Add(r.policy.Validity)
+	accountRecovery, err = r.updateState(accountRecovery, model.AccountRecoveryStatePending)
+	if err != nil {
+		return nil, "", err
+	}
+
+	completionCode, err := helper.CreateRandomString(12, helper.LettersAndNumbers)
+	if err != nil {
+		return nil, "", fmt.Errorf("error creating completion code: %v", err)
+	}
+	cancelCode, err := helper.CreateRandomString(8, helper.OnlyNumbers)
+	if err != nil {
+		return nil, "", fmt.Errorf("error creating cancel code: %v", err)
+	}
+	err = r.accountRecoveryDb.UpdateAccountRecoveryCodes(accountRecovery.RID, completionCode, cancelCode)
+	if err != nil {
+		return nil, "", fmt.Errorf("error updating account recovery codes: %v", err)
+	}
+
+	// the recovery stays pending without the notification, the audit trail shows it was not sent
+	err = r.authService.NotifyUser(accountRecovery.UserRID, "Recovery of your account", fmt.Sprintf(
+		"Your account is being recovered by voice, a new password can be set from %v. If this was not you, cancel the recovery at /recovery/%v/cancel with the code %v.",
+		accountRecovery.AvailableAt.Format("2006-01-02 15:04 MST"),
+		accountRecovery.RID,
+		cancelCode,
+	))
+	notified := err == nil
+	if err != nil {
+		r.logger.Printf("error notifying user %v about recovery %v: %v", accountRecovery.UserRID, accountRecovery.RID, err)
+	}
+
+	details["recovery_rid"] = accountRecovery.RID
+	details["available_at"] = accountRecovery.AvailableAt
+	details["notified"] = notified
+	err = r.auditService.Record(accountRecovery.UserRID, accountRecovery.UserRID, model.AuditActionAccountRecoveryVerified, details)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return accountRecovery, completionCode, nil
+}
+
+// CancelAccountRecovery cancels the pending recovery with the code sent to the old email address.
+func (r *RecoveryService) CancelAccountRecovery(ctx context.Context, rid uuid.UUID, code string) (*model.AccountRecovery, error) {
+	accountRecovery, err := r.GetAccountRecovery(rid)
+	if err != nil {
+		return nil, err
+	}
+	if accountRecovery.State != model.AccountRecoveryStatePending {
+		return nil, ErrRecoveryClosed
+	}
+
+	if !r.accountRecoveryDb.CheckCancelCodeValid(accountRecovery.RID, code) {
+		err = r.recordFailure(ctx, accountRecovery, "invalid cancel code", map[string]any{})
+		if err != nil {
+			return nil, err
+		}
+		return nil, ErrInvalidRecoveryCode
+	}
+
+	accountRecovery, err = r.updateState(accountRecovery, model.AccountRecoveryStateCancelled)
+	if err != nil {
+		return nil, err
+	}
+
+	err = r.auditService.Record(accountRecovery.UserRID, accountRecovery.UserRID, model.AuditActionAccountRecoveryCancelled, map[string]any{
+		"recovery_rid": accountRecovery.RID,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return accountRecovery, nil
+}
+
+// CompleteAccountRecovery sets the new password and optionally a new email address of the available recovery
+// with its completion code. The old email address is told about the new credentials.
+func (r *RecoveryService) CompleteAccountRecovery(c echo.Context, rid uuid.UUID) (*model.AccountRecovery, error) {
+	request := &struct {
+		NewPassword          string `upd:"new_password, min8 max30 rex'^(.*[A-Z])+(.*)$' rex'^(.*[a-z])+(.*)$' rex'^(.*\\d)+(.*)$' rex'^(.*[\x60!@#$%^&*()_+={};/':\"|\\,.<>/?~-])+(.*)$'"`
+		NewPasswordConfirmed string `upd:"new_password_confirmed, min8 max30 rex'^(.*[A-Z])+(.*)$' rex'^(.*[a-z])+(.*)$' rex'^(.*\\d)+(.*)$' rex'^(.*[\x60!@#$%^&*()_+={};/':\"|\\,.<>/?~-])+(.*)$'"`
+		Code                 string `upd:"code, min1"`
+	}{}
+	err := validator.UnmapOrUnmarshalRequestValidateAndUpdate(c.Request(), request)
+	if err != nil {
+		return nil, err
+	}
+	if request.NewPassword != request.NewPasswordConfirmed {
+		return nil, ErrPasswordsDoNotMatch
+	}
+
+	email := strings.TrimSpace(c.FormValue("email"))
+	if len(email) > 0 {
+		_, err = mail.ParseAddress(email)
+		if err != nil || len(email) > 256 {
+			return nil, ErrInvalidEmail
+		}
+	}
+
+	accountRecovery, err := r.GetAccountRecovery(rid)
+	if err != nil {
+		return nil, err
+	}
+	if accountRecovery.State != model.AccountRecoveryStatePending {
+		return nil, ErrRecoveryClosed
+	}
+	if !accountRecovery.IsAvailable(time.Now()) {
+		return nil, ErrRecoveryNotAvailableYet
+	}
+
+	if !r.accountRecoveryDb.CheckCompletionCodeValid(accountRecovery.RID, request.Code) {
+		err = r.recordFailure(c.Request().Context(), accountRecovery, "invalid completion code", map[string]any{})
+		if err != nil {
+			return nil, err
+		}
+		return nil, ErrInvalidRecoveryCode
+	}
+
+	previous, err := r.authService.GetAuth(accountRecovery.UserRID)
+	if err != nil {
+		return nil, fmt.Errorf("error selecting auth: %v", err)
+	}
+	account, err := r.authService.RecoverAccount(accountRecovery.UserRID, email, request.NewPassword)
+	if err != nil {
+		return nil, err
+	}
+
+	accountRecovery, err = r.updateState(accountRecovery, model.AccountRecoveryStateCompleted)
+	if err != nil {
+		return nil, err
+	}
+
+	err = r.auditService.Record(accountRecovery.UserRID, accountRecovery.UserRID, model.AuditActionAccountRecoveryCompleted, map[string]any{
+		"recovery_rid":     accountRecovery.RID,
+		"password_changed": true,
+		"email_changed":    account.Email != previous.Email,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return accountRecovery, nil
+}
+
+// recordFailure counts a failed verification or a wrong code of the recovery and fails it at MaxFailures.
+// A challenged recovery gets a fresh sentence for the next recording.
+func (r *RecoveryService) recordFailure(ctx context.Context, accountRecovery *model.AccountRecovery, reason string, details map[string]any) error {
+	state := accountRecovery.State
+	accountRecovery.Failures++
+	if accountRecovery.Failures >= r.policy.MaxFailures {
+		state = model.AccountRecoveryStateFailed
+	} else if state == model.AccountRecoveryStateChallenged {
+		sentence, err := r.voiceMatcher.CreateSentence(ctx)
+		if err != nil {
+			return fmt.Errorf("error creating sentence: %v", err)
+		}
+		accountRecovery.Sentence = sentence
+		accountRecovery.ChallengedAt = time.Now()
+	}
+
+	accountRecovery, err := r.updateState(accountRecovery, state)
+	if err != nil {
+		return err
+	}
+
+	// a decoy belongs to no account
+	if accountRecovery.Decoy {
+		return nil
+	}
+
+	details["recovery_rid"] = accountRecovery.RID
+	details["reason"] = reason
+	details["failures"] = accountRecovery.Failures
+	details["state"] = accountRecovery.State
+	return r.auditService.Record(accountRecovery.UserRID, accountRecovery.UserRID, model.AuditActionAccountRecoveryFailed, details)
+}
+
+// updateState returns ErrRecoveryClosed if the recovery was changed meanwhile.
+func (r *RecoveryService) updateState(accountRecovery *model.AccountRecovery, state model.AccountRecoveryState) (*model.AccountRecovery, error) {
+	accountRecovery, err := r.accountRecoveryDb.UpdateAccountRecoveryState(accountRecovery, state)
+	if err == sql.ErrNoRows {
+		return nil, ErrRecoveryClosed
+	} else if err != nil {
+		return nil, fmt.Errorf("error updating account recovery: %v", err)
+	}
+	return accountRecovery, nil
+}
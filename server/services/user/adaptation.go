@@ -0,0 +1,220 @@
+package user
+
+import (
+	"fmt"
+	"ht/helper"
+	"ht/model"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// AdaptationPolicy defines when a successful identification is added to the references of a user.
+type AdaptationPolicy struct {
+	// Enabled allows users to opt in, users without opt in are never adapted.
+	Enabled bool
+	// Confidence is the fraction of the threshold the score has to be below to be added.
+	Confidence float64
+	// MaxSamples is the number of adapted samples kept per voice profile, older ones are dropped.
+	MaxSamples int
+}
+
+func NewAdaptationPolicyFromEnv() (*AdaptationPolicy, error) {
+	enabled, err := strconv.ParseBool(helper.GetEnvVariableWithDefault("ADAPTATION_ENABLED", "false"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid ADAPTATION_ENABLED: %v", err)
+	}
+	confidence, err := strconv.ParseFloat(helper.GetEnvVariableWithDefault("ADAPTATION_CONFIDENCE", "0.5"), 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ADAPTATION_CONFIDENCE: %v", err)
+	}
+	maxSamples, err := strconv.Atoi(helper.GetEnvVariableWithDefault("ADAPTATION_MAX_SAMPLES", "3"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid ADAPTATION_MAX_SAMPLES: %v", err)
+	}
+	if confidence <= 0 || confidence > 1 {
+		return nil, fmt.Errorf("ADAPTATION_CONFIDENCE has to be in (0, 1]")
+	}
+	if maxSamples < 1 {
+		return nil, fmt.Errorf("ADAPTATION_MAX_SAMPLES has to be at least 1")
+	}
+
+	return &AdaptationPolicy{
+		Enabled:    enabled,
+		Confidence: confidence,
+		MaxSamples: maxSamples,
+	}, nil
+}
+
+// IsConfident returns true if the score is far enough below the threshold to trust the recording.
+func (r *AdaptationPolicy) IsConfident(score float64, threshold float64) bool {
+	return score < threshold*r.Confidence
+}
+
+func (r *UserService) IsAdaptationAvailable() bool {
+	return r.adaptation.Enabled
+}
+
+// AdaptTemplate adds an accepted identification attempt to the references of the matched profile,
+// if the user opted in and the score is confident enough. The oldest adapted samples
+// of the profile are dropped to keep a bounded window.
+func (r *UserService) AdaptTemplate(userRid uuid.UUID, identificationAttempt *model.IdentificationAttempt, threshold float64) error {
+	// recordings under duress are not representative of the voice of the user
+	if !r.adaptation.Enabled || !identificationAttempt.IsAccepted() || identificationAttempt.Duress || identificationAttempt.RecordingMfcc.IsEmpty() || identificationAttempt.ProfileRID == uuid.Nil {
+		return nil
+	}
+	if !r.adaptation.IsConfident(identificationAttempt.Score, threshold) {
+		return nil
+	}
+
+	user, err := r.userDb.SelectUser(userRid)
+	if err != nil {
+		return fmt.Errorf("error selecting user: %v", err)
+	}
+	if !user.AdaptationEnabled {
+		return nil
+	}
+
+	referenceSample, err := r.referenceSampleDb.InsertAdaptedReferenceSample(&model.ReferenceSample{
+		UserRID:       userRid,
+		ProfileRID:    identificationAttempt.ProfileRID,
+		AttemptRID:    identificationAttempt.RID,
+		Recording:     identificationAttempt.Recording,
+		RecordingMfcc: identificationAttempt.RecordingMfcc,
+		Extractor:     identificationAttempt.Extractor,
+		Channel:       identificationAttempt.Channel,
+	})
+	if err != nil {
+		return fmt.Errorf("error inserting adapted reference sample: %v", err)
+	}
+
+	err = r.auditService.Record(userRid, userRid, model.AuditActionTemplateAdapted, map[string]any{
+		"reference_sample_rid": referenceSample.RID,
+		"profile_rid":          identificationAttempt.ProfileRID,
+		"attempt_rid":          identificationAttempt.RID,
+		"score":                identificationAttempt.Score,
+		"threshold":            threshold,
+	})
+	if err != nil {
+		return err
+	}
+
+	droppedRids, err := r.referenceSampleDb.DeleteAdaptedReferenceSamplesExceeding(identificationAttempt.ProfileRID, r.adaptation.MaxSamples)
+	if err != nil {
+		return fmt.Errorf("error dropping old adapted reference samples: %v", err)
+	}
+	for _, droppedRid := range droppedRids {
+		err = r.auditService.Record(userRid, userRid, model.AuditActionTemplateSampleDropped, map[string]any{
+			"reference_sample_rid": droppedRid,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// SetAdaptationEnabled changes the opt in of the user, actor is the user themselves or an admin.
+func (r *UserService) SetAdaptationEnabled(actorRid uuid.UUID, userRid uuid.UUID, enabled bool) (*model.User, error) {
+	user, err := r.selectOrInsertUser(userRid)
+	if err != nil {
+		return nil, err
+	}
+	if user.AdaptationEnabled == enabled {
+		return user, nil
+	}
+
+	user.AdaptationEnabled = enabled
+	user, err = r.userDb.UpdateUser(user)
+	if err != nil {
+		return nil, err
+	}
+
+	err = r.auditService.Record(actorRid, userRid, model.AuditActionTemplateAdaptationToggled, map[string]any{"enabled": enabled})
+	if err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+// RevertAdaptation removes all adapted samples of the user, leaving only the enrollment.
+func (r *UserService) RevertAdaptation(actorRid uuid.UUID, userRid uuid.UUID) error {
+	deletedRids, err := r.referenceSampleDb.DeleteAdaptedReferenceSamplesByUserRID(userRid)
+	if err != nil {
+		return fmt.Errorf("error deleting adapted reference samples: %v", err)
+	}
+
+	return r.auditService.Record(actorRid, userRid, model.AuditActionTemplateAdaptationReverted, map[string]any{
+		"reference_sample_rids": deletedRids,
+	})
+}
+
+// GetTemplateAge returns the age of the enrollment of the user.
+func (r *UserService) GetTemplateAge(userRid uuid.UUID) (*model.TemplateAge, error) {
+	user, err := r.selectOrInsertUser(userRid)
+	if err != nil {
+		return nil, err
+	}
+
+	return &model.TemplateAge{
+		RefreshedAt: user.TemplateRefreshedAt,
+		DismissedAt: user.TemplateRefreshDismissedAt,
+		MaxAge:      r.templateMaxAge,
+	}, nil
+}
+
+// CheckTemplateRefresh returns the age of the enrollment before prompting the user to refresh it.
+// The first prompt after the last refresh or dismissal is recorded in the audit trail.
+func (r *UserService) CheckTemplateRefresh(userRid uuid.UUID) (*model.TemplateAge, error) {
+	user, err := r.selectOrInsertUser(userRid)
+	if err != nil {
+		return nil, err
+	}
+
+	templateAge := &model.TemplateAge{
+		RefreshedAt: user.TemplateRefreshedAt,
+		DismissedAt: user.TemplateRefreshDismissedAt,
+		MaxAge:      r.templateMaxAge,
+	}
+
+	promptedBefore := user.TemplateRefreshPromptedAt.Before(user.TemplateRefreshedAt) || user.TemplateRefreshPromptedAt.Before(user.TemplateRefreshDismissedAt)
+	if templateAge.RefreshDue() && promptedBefore {
+		user.TemplateRefreshPromptedAt = time.Now()
+		_, err = r.userDb.UpdateUser(user)
+		if err != nil {
+			return nil, err
+		}
+
+		err = r.auditService.Record(userRid, userRid, model.AuditActionTemplateRefreshDue, map[string]any{
+			"refreshed_at": user.TemplateRefreshedAt,
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return templateAge, nil
+}
+
+// RevertTemplateRefreshDue lets an admin withdraw a refresh prompt for another max age.
+// The template age is kept, the reference samples were not refreshed.
+func (r *UserService) RevertTemplateRefreshDue(actorRid uuid.UUID, userRid uuid.UUID) error {
+	user, err := r.selectOrInsertUser(userRid)
+	if err != nil {
+		return err
+	}
+
+	user.TemplateRefreshDismissedAt = time.Now()
+	_, err = r.userDb.UpdateUser(user)
+	if err != nil {
+		return err
+	}
+
+	return r.auditService.Record(actorRid, userRid, model.AuditActionTemplateRefreshReverted, map[string]any{
+		"refreshed_at": user.TemplateRefreshedAt,
+		"dismissed_at": user.TemplateRefreshDismissedAt,
+	})
+}
@@ -19,6 +19,9 @@ type UserDBHandlerFunctions interface {
 	SelectUser(rid uuid.UUID) (*model.User, error)
 	SelectAllUsers(lastId int, entries int) ([]*model.User, error)
 	SelectAllUsersBySearch(search string, lastId int, entries int) ([]*model.User, error)
+	SelectMatchThreshold(rid uuid.UUID) (float64, error)
+	UpdateUserStatus(rid uuid.UUID, status model.UserStatus) (*model.User, error)
+	DeleteUser(rid uuid.UUID) error
 }
 
 type UserDBHandler struct {
@@ -42,18 +45,38 @@ func (r UserDBHandler) CreateTable() error {
 		CREATE TABLE IF NOT EXISTS "user" (
 			id BIGINT GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
 			rid UUID DEFAULT gen_random_uuid() UNIQUE NOT NULL,
-			recording_1 BYTEA,
-			recording_2 BYTEA,
-			recording_3 BYTEA,
-			recording_1_normalised BYTEA,
-			recording_2_normalised BYTEA,
-			recording_3_normalised BYTEA,
-			recording_1_mfcc VECTOR(40),
-			recording_2_mfcc VECTOR(40),
-			recording_3_mfcc VECTOR(40),
+			match_threshold DOUBLE PRECISION,
+			adaptation_enabled BOOLEAN DEFAULT FALSE,
+			template_refreshed_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+			template_refresh_prompted_at TIMESTAMP WITH TIME ZONE DEFAULT '2000-01-01T01:23:45Z',
+			template_refresh_dismissed_at TIMESTAMP WITH TIME ZONE DEFAULT '2000-01-01T01:23:45Z',
+			voice_login_code TEXT NOT NULL DEFAULT upper(substr(md5(random()::text), 1, 6)),
+			status TEXT NOT NULL DEFAULT 'active',
 			created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
 			updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
-		);`,
+		);
+
+		DO $$
+		BEGIN
+			IF EXISTS (
+				SELECT 1
+				FROM information_schema.columns
+				WHERE table_schema = current_schema()
+					AND table_name = 'user'
+					AND column_name = 'login_code'
+			) THEN
+				ALTER TABLE "user" RENAME COLUMN login_code TO voice_login_code;
+				ALTER INDEX IF EXISTS idx_user_login_code RENAME TO idx_user_voice_login_code;
+			END IF;
+		END $$;
+
+		ALTER TABLE "user" ADD COLUMN IF NOT EXISTS match_threshold DOUBLE PRECISION;
+		ALTER TABLE "user" ADD COLUMN IF NOT EXISTS adaptation_enabled BOOLEAN DEFAULT FALSE;
+		ALTER TABLE "user" ADD COLUMN IF NOT EXISTS template_refreshed_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP;
+		ALTER TABLE "user" ADD COLUMN IF NOT EXISTS template_refresh_prompted_at TIMESTAMP WITH TIME ZONE DEFAULT '2000-01-01T01:23:45Z';
+		ALTER TABLE "user" ADD COLUMN IF NOT EXISTS template_refresh_dismissed_at TIMESTAMP WITH TIME ZONE DEFAULT '2000-01-01T01:23:45Z';
+		ALTER TABLE "user" ADD COLUMN IF NOT EXISTS voice_login_code TEXT NOT NULL DEFAULT upper(substr(md5(random()::text), 1, 6));
+		ALTER TABLE "user" ADD COLUMN IF NOT EXISTS status TEXT NOT NULL DEFAULT 'active';`,
 	)
 	if err != nil {
 		return fmt.Errorf("error creating user table: %v", err)
@@ -64,6 +87,11 @@ func (r UserDBHandler) CreateTable() error {
 		return err
 	}
 
+	err = r.db.CreateIndex("user", "voice_login_code")
+	if err != nil {
+		return err
+	}
+
 	r.db.Logger.Println("created table user")
 	return nil
 }
@@ -91,12 +119,12 @@ func (r UserDBHandler) InsertUser(user *model.User) (*model.User, error) {
 		RETURNING
 			id,
 			rid,
-			recording_1,
-			recording_2,
-			recording_3,
-			recording_1_normalised,
-			recording_2_normalised,
-			recording_3_normalised,
+			adaptation_enabled,
+			template_refreshed_at,
+			template_refresh_prompted_at,
+			template_refresh_dismissed_at,
+			voice_login_code,
+			status,
 			created_at,
 			updated_at;`,
 		user.RID,
@@ -105,12 +133,12 @@ func (r UserDBHandler) InsertUser(user *model.User) (*model.User, error) {
 	err := row.Scan(
 		&newUser.ID,
 		&newUser.RID,
-		&newUser.Recording1,
-		&newUser.Recording2,
-		&newUser.Recording3,
-		&newUser.Recording1Normalised,
-		&newUser.Recording2Normalised,
-		&newUser.Recording3Normalised,
+		&newUser.AdaptationEnabled,
+		&newUser.TemplateRefreshedAt,
+		&newUser.TemplateRefreshPromptedAt,
+		&newUser.TemplateRefreshDismissedAt,
+		&newUser.VoiceLoginCode,
+		&newUser.Status,
 		&newUser.CreatedAt,
 		&newUser.UpdatedAt,
 	)
@@ -128,44 +156,40 @@ func (r UserDBHandler) UpdateUser(user *model.User) (*model.User, error) {
 		`UPDATE
 			"user"
 		SET
-			recording_1 = $1,
-            recording_2 = $2,
-            recording_3 = $3,
-			recording_1_normalised = $4,
-			recording_2_normalised = $5,
-			recording_3_normalised = $6,
+			adaptation_enabled = $1,
+			template_refreshed_at = $2,
+			template_refresh_prompted_at = $3,
+			template_refresh_dismissed_at = $4,
 			updated_at = CURRENT_TIMESTAMP
 		WHERE
-			rid = $7
+			rid = $5
 		RETURNING
 			id,
 			rid,
-			recording_1,
-			recording_2,
-			recording_3,
-			recording_1_normalised,
-			recording_2_normalised,
-			recording_3_normalised,
+			adaptation_enabled,
+			template_refreshed_at,
+			template_refresh_prompted_at,
+			template_refresh_dismissed_at,
+			voice_login_code,
+			status,
 			created_at,
 			updated_at`,
-		user.Recording1,
-		user.Recording2,
-		user.Recording3,
-		user.Recording1Normalised,
-		user.Recording2Normalised,
-		user.Recording3Normalised,
+		user.AdaptationEnabled,
+		user.TemplateRefreshedAt,
+		user.TemplateRefreshPromptedAt,
+		user.TemplateRefreshDismissedAt,
 		user.RID,
 	)
 
 	err := row.Scan(
 		&userUpdated.ID,
 		&userUpdated.RID,
-		&userUpdated.Recording1,
-		&userUpdated.Recording2,
-		&userUpdated.Recording3,
-		&userUpdated.Recording1Normalised,
-		&userUpdated.Recording2Normalised,
-		&userUpdated.Recording3Normalised,
+		&userUpdated.AdaptationEnabled,
+		&userUpdated.TemplateRefreshedAt,
+		&userUpdated.TemplateRefreshPromptedAt,
+		&userUpdated.TemplateRefreshDismissedAt,
+		&userUpdated.VoiceLoginCode,
+		&userUpdated.Status,
 		&userUpdated.CreatedAt,
 		&userUpdated.UpdatedAt,
 	)
@@ -196,12 +220,12 @@ func (r UserDBHandler) SelectUser(rid uuid.UUID) (*model.User, error) {
 		`SELECT
 			id,
 			rid,
-			recording_1,
-			recording_2,
-			recording_3,
-			recording_1_normalised,
-			recording_2_normalised,
-			recording_3_normalised,
+			adaptation_enabled,
+			template_refreshed_at,
+			template_refresh_prompted_at,
+			template_refresh_dismissed_at,
+			voice_login_code,
+			status,
 			created_at,
 			updated_at
 		FROM
@@ -213,12 +237,12 @@ func (r UserDBHandler) SelectUser(rid uuid.UUID) (*model.User, error) {
 	err := row.Scan(
 		&user.ID,
 		&user.RID,
-		&user.Recording1,
-		&user.Recording2,
-		&user.Recording3,
-		&user.Recording1Normalised,
-		&user.Recording2Normalised,
-		&user.Recording3Normalised,
+		&user.AdaptationEnabled,
+		&user.TemplateRefreshedAt,
+		&user.TemplateRefreshPromptedAt,
+		&user.TemplateRefreshDismissedAt,
+		&user.VoiceLoginCode,
+		&user.Status,
 		&user.CreatedAt,
 		&user.UpdatedAt,
 	)
@@ -236,12 +260,12 @@ func (r UserDBHandler) SelectAllUsers(lastId int, entries int) ([]*model.User, e
 		`SELECT
 			id,
 			rid,
-			recording_1,
-			recording_2,
-			recording_3,
-			recording_1_normalised,
-			recording_2_normalised,
-			recording_3_normalised,
+			adaptation_enabled,
+			template_refreshed_at,
+			template_refresh_prompted_at,
+			template_refresh_dismissed_at,
+			voice_login_code,
+			status,
 			created_at,
 			updated_at
 		FROM
@@ -271,12 +295,12 @@ func (r UserDBHandler) SelectAllUsers(lastId int, entries int) ([]*model.User, e
 		err := rows.Scan(
 			&user.ID,
 			&user.RID,
-			&user.Recording1,
-			&user.Recording2,
-			&user.Recording3,
-			&user.Recording1Normalised,
-			&user.Recording2Normalised,
-			&user.Recording3Normalised,
+			&user.AdaptationEnabled,
+			&user.TemplateRefreshedAt,
+			&user.TemplateRefreshPromptedAt,
+			&user.TemplateRefreshDismissedAt,
+			&user.VoiceLoginCode,
+			&user.Status,
 			&user.CreatedAt,
 			&user.UpdatedAt,
 		)
@@ -299,12 +323,12 @@ func (r UserDBHandler) SelectAllUsersBySearch(search string, lastId int, entries
 		`SELECT
 			id,
 			rid,
-			recording_1,
-			recording_2,
-			recording_3,
-			recording_1_normalised,
-			recording_2_normalised,
-			recording_3_normalised,
+			adaptation_enabled,
+			template_refreshed_at,
+			template_refresh_prompted_at,
+			template_refresh_dismissed_at,
+			voice_login_code,
+			status,
 			created_at,
 			updated_at
 		FROM "user" 
@@ -336,12 +360,12 @@ func (r UserDBHandler) SelectAllUsersBySearch(search string, lastId int, entries
 		user := &model.User{}
 		err := rows.Scan(
 			&user.RID,
-			&user.Recording1,
-			&user.Recording2,
-			&user.Recording3,
-			&user.Recording1Normalised,
-			&user.Recording2Normalised,
-			&user.Recording3Normalised,
+			&user.AdaptationEnabled,
+			&user.TemplateRefreshedAt,
+			&user.TemplateRefreshPromptedAt,
+			&user.TemplateRefreshDismissedAt,
+			&user.VoiceLoginCode,
+			&user.Status,
 			&user.CreatedAt,
 			&user.UpdatedAt,
 		)
@@ -354,3 +378,67 @@ func (r UserDBHandler) SelectAllUsersBySearch(search string, lastId int, entries
 
 	return users, err
 }
+
+// SelectMatchThreshold returns the threshold of the user or 0 if none is set.
+func (r UserDBHandler) SelectMatchThreshold(rid uuid.UUID) (float64, error) {
+	threshold := 0.0
+
+	err := r.db.Instance.QueryRow(
+		`SELECT
+			COALESCE(match_threshold, 0)
+		FROM
+			"user"
+		WHERE
+			rid = $1`,
+		rid,
+	).Scan(&threshold)
+
+	return threshold, err
+}
+
+// UpdateUserStatus sets the status of the user. It is not part of UpdateUser,
+// so updates of a user loaded before do not revert it.
+func (r UserDBHandler) UpdateUserStatus(rid uuid.UUID, status model.UserStatus) (*model.User, error) {
+	userUpdated := &model.User{}
+
+	row := r.db.Instance.QueryRow(
+		`UPDATE
+			"user"
+		SET
+			status = $1,
+			updated_at = CURRENT_TIMESTAMP
+		WHERE
+			rid = $2
+		RETURNING
+			id,
+			rid,
+			adaptation_enabled,
+			template_refreshed_at,
+			template_refresh_prompted_at,
+			template_refresh_dismissed_at,
+			voice_login_code,
+			status,
+			created_at,
+			updated_at`,
+		status,
+		rid,
+	)
+
+	err := row.Scan(
+		&userUpdated.ID,
+		&userUpdated.RID,
+		&userUpdated.AdaptationEnabled,
+		&userUpdated.TemplateRefreshedAt,
+		&userUpdated.TemplateRefreshPromptedAt,
+		&userUpdated.TemplateRefreshDismissedAt,
+		&userUpdated.VoiceLoginCode,
+		&userUpdated.Status,
+		&userUpdated.CreatedAt,
+		&userUpdated.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return userUpdated, nil
+}
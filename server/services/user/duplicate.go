@@ -0,0 +1,213 @@
+package user
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"ht/helper"
+	"ht/model"
+	"sort"
+	"strconv"
+
+	"github.com/google/uuid"
+)
+
+var (
+	ErrDuplicateReviewNotFound = errors.New("duplicate review not found")
+	ErrDuplicateReviewResolved = errors.New("duplicate review is already resolved")
+)
+
+// DuplicatePolicy decides what happens to an account whose enrolled voice is close to the voice of other accounts.
+type DuplicatePolicy struct {
+	Enabled bool
+	Metric  model.DistanceMetric
+	// Threshold is the distance an enrolled sample has to be below to match the samples of another account.
+	Threshold float64
+	// Action is applied to accounts with matches. Accounts closer than BlockThreshold are blocked instead, if set.
+	Action         model.DuplicateAction
+	BlockThreshold float64
+	// Candidates is the number of nearest reference samples of other accounts searched per enrolled sample.
+	Candidates int
+}
+
+func NewDuplicatePolicyFromEnv() (*DuplicatePolicy, error) {
+	enabled, err := strconv.ParseBool(helper.GetEnvVariableWithDefault("DUPLICATE_DETECTION_ENABLED", "true"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid DUPLICATE_DETECTION_ENABLED: %v", err)
+	}
+	threshold, err := strconv.ParseFloat(helper.GetEnvVariableWithDefault("DUPLICATE_THRESHOLD", "3"), 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid DUPLICATE_THRESHOLD: %v", err)
+	}
+	blockThreshold, err := strconv.ParseFloat(helper.GetEnvVariableWithDefault("DUPLICATE_BLOCK_THRESHOLD", "0"), 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid DUPLICATE_BLOCK_THRESHOLD: %v", err)
+	}
+	candidates, err := strconv.Atoi(helper.GetEnvVariableWithDefault("DUPLICATE_CANDIDATES", "20"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid DUPLICATE_CANDIDATES: %v", err)
+	}
+
+	// the voices are compared like the identification compares them, unless configured otherwise
+	metric := model.DistanceMetric(helper.GetEnvVariableWithDefault("DUPLICATE_METRIC", helper.GetEnvVariableWithDefault("MATCHING_METRIC", string(model.DistanceMetricL2))))
+	_, err = metric.Operator()
+	if err != nil {
+		return nil, fmt.Errorf("invalid DUPLICATE_METRIC: %v", err)
+	}
+	action := model.DuplicateAction(helper.GetEnvVariableWithDefault("DUPLICATE_ACTION", string(model.DuplicateActionHold)))
+	err = action.Validate()
+	if err != nil {
+		return nil, fmt.Errorf("invalid DUPLICATE_ACTION: %v", err)
+	}
+	if threshold <= 0 || blockThreshold < 0 || blockThreshold > threshold {
+		return nil, fmt.Errorf("DUPLICATE_THRESHOLD has to be positive and DUPLICATE_BLOCK_THRESHOLD between 0 and DUPLICATE_THRESHOLD")
+	}
+	if candidates < 1 {
+		return nil, fmt.Errorf("DUPLICATE_CANDIDATES has to be at least 1")
+	}
+
+	return &DuplicatePolicy{
+		Enabled:        enabled,
+		Metric:         metric,
+		Threshold:      threshold,
+		Action:         action,
+		BlockThreshold: blockThreshold,
+		Candidates:     candidates,
+	}, nil
+}
+
+// Decide returns the action for an account whose closest match has the distance.
+func (r *DuplicatePolicy) Decide(distance float64) model.DuplicateAction {
+	if r.BlockThreshold > 0 && distance < r.BlockThreshold {
+		return model.DuplicateActionBlock
+	}
+	return r.Action
+}
+
+// screenDuplicates compares the enrolled vectors of the profile with the samples of all other accounts.
+// Accounts with matches are queued for review with the linked accounts and the action of the policy is applied.
+// The account has ownSamples reference samples in total.
+func (r *UserService) screenDuplicates(userRid uuid.UUID, profileRid uuid.UUID, vectors []model.Vector, ownSamples int) error {
+	if !r.duplicatePolicy.Enabled {
+		return nil
+	}
+
+	// the own samples are the nearest, they must not crowd out the other accounts
+	limit := r.duplicatePolicy.Candidates + ownSamples
+	matchesByUser := map[uuid.UUID]*model.DuplicateMatch{}
+	for _, vector := range vectors {
+		candidates, err := r.referenceSampleDb.SelectNearestUsers(vector, r.duplicatePolicy.Metric, r.featureExtractor.Extractor(), "", limit)
+		if err != nil {
+			return fmt.Errorf("error searching nearest users: %v", err)
+		}
+
+		for _, candidate := range candidates {
+			if candidate.UserRID == userRid || candidate.Distance >= r.duplicatePolicy.Threshold {
+				continue
+			}
+			match, ok := matchesByUser[candidate.UserRID]
+			if !ok {
+				match = &model.DuplicateMatch{UserRID: candidate.UserRID, Distance: candidate.Distance}
+				matchesByUser[candidate.UserRID] = match
+			}
+			match.Distance = min(match.Distance, candidate.Distance)
+			match.Samples++
+		}
+	}
+	if len(matchesByUser) == 0 {
+		return nil
+	}
+
+	matches := model.DuplicateMatches{}
+	for _, match := range matchesByUser {
+		matches = append(matches, match)
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		return matches[i].Distance < matches[j].Distance
+	})
+
+	action := r.duplicatePolicy.Decide(matches[0].Distance)
+	duplicateReview, err := r.duplicateReviewDb.UpsertDuplicateReview(&model.DuplicateReview{
+		UserRID:    userRid,
+		ProfileRID: profileRid,
+		Action:     action,
+		Matches:    matches,
+	})
+	if err != nil {
+		return fmt.Errorf("error queueing duplicate review: %v", err)
+	}
+
+	if action != model.DuplicateActionAllow {
+		_, err = r.userDb.UpdateUserStatus(userRid, action.Status())
+		if err != nil {
+			return fmt.Errorf("error updating user status: %v", err)
+		}
+	}
+	r.logger.Printf("voice of user %v matches %v other accounts, applied %v", userRid, len(matches), action)
+
+	return r.auditService.Record(userRid, userRid, model.AuditActionDuplicateVoiceDetected, map[string]any{
+		"review_rid":  duplicateReview.RID,
+		"profile_rid": profileRid,
+		"action":      action,
+		"matches":     len(matches),
+		"distance":    matches[0].Distance,
+	})
+}
+
+// GetOpenDuplicateReviews returns the review queue, oldest first.
+func (r *UserService) GetOpenDuplicateReviews(lastId int, entries int) ([]*model.DuplicateReview, error) {
+	duplicateReviews, err := r.duplicateReviewDb.SelectDuplicateReviews(model.DuplicateReviewStateOpen, lastId, entries)
+	if err != nil {
+		return nil, fmt.Errorf("error selecting duplicate reviews: %v", err)
+	}
+	return duplicateReviews, nil
+}
+
+func (r *UserService) GetDuplicateReview(rid uuid.UUID) (*model.DuplicateReview, error) {
+	duplicateReview, err := r.duplicateReviewDb.SelectDuplicateReview(rid)
+	if err == sql.ErrNoRows {
+		return nil, ErrDuplicateReviewNotFound
+	} else if err != nil {
+		return nil, fmt.Errorf("error selecting duplicate review: %v", err)
+	}
+	return duplicateReview, nil
+}
+
+// ResolveDuplicateReview closes the open review. A confirmed duplicate is blocked,
+// a dismissed one may identify again whatever action was applied.
+func (r *UserService) ResolveDuplicateReview(actorRid uuid.UUID, rid uuid.UUID, confirmed bool, comment string) (*model.DuplicateReview, error) {
+	state := model.DuplicateReviewStateDismissed
+	status := model.UserStatusActive
+	if confirmed {
+		state = model.DuplicateReviewStateConfirmed
+		status = model.UserStatusBlocked
+	}
+
+	duplicateReview, err := r.duplicateReviewDb.ResolveDuplicateReview(rid, state, actorRid, comment)
+	if err == sql.ErrNoRows {
+		_, err = r.GetDuplicateReview(rid)
+		if err != nil {
+			return nil, err
+		}
+		return nil, ErrDuplicateReviewResolved
+	} else if err != nil {
+		return nil, fmt.Errorf("error resolving duplicate review: %v", err)
+	}
+
+	_, err = r.userDb.UpdateUserStatus(duplicateReview.UserRID, status)
+	if err != nil {
+		return nil, fmt.Errorf("error updating user status: %v", err)
+	}
+
+	err = r.auditService.Record(actorRid, duplicateReview.UserRID, model.AuditActionDuplicateReviewResolved, map[string]any{
+		"review_rid": duplicateReview.RID,
+		"state":      state,
+		"status":     status,
+		"comment":    comment,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return duplicateReview, nil
+}
@@ -0,0 +1,254 @@
+package user
+
+import (
+	"context"
+	"fmt"
+	"ht/model"
+	"ht/server/database"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type DuplicateReviewDBHandlerFunctions interface {
+	CreateTable() error
+	DropTable() error
+	UpsertDuplicateReview(duplicateReview *model.DuplicateReview) (*model.DuplicateReview, error)
+	ResolveDuplicateReview(rid uuid.UUID, state model.DuplicateReviewState, reviewerRid uuid.UUID, comment string) (*model.DuplicateReview, error)
+	SelectDuplicateReview(rid uuid.UUID) (*model.DuplicateReview, error)
+	SelectDuplicateReviews(state model.DuplicateReviewState, lastId int, entries int) ([]*model.DuplicateReview, error)
+}
+
+type DuplicateReviewDBHandler struct {
+	db *database.Database
+}
+
+func newDuplicateReviewDBHandler(dbConnection *database.Database) *DuplicateReviewDBHandler {
+	return &DuplicateReviewDBHandler{
+		db: dbConnection,
+	}
+}
+
+func (r DuplicateReviewDBHandler) CreateTable() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	_, err := r.db.Instance.ExecContext(
+		ctx,
+		`CREATE TABLE IF NOT EXISTS duplicate_review (
+			id BIGINT GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+			rid UUID DEFAULT gen_random_uuid() UNIQUE NOT NULL,
+			user_rid UUID NOT NULL REFERENCES "user" (rid) ON DELETE CASCADE,
+			profile_rid UUID NOT NULL,
+			action TEXT NOT NULL,
+			state TEXT NOT NULL DEFAULT 'open',
+			matches JSONB DEFAULT '[]',
+			reviewer_rid UUID,
+			comment TEXT NOT NULL DEFAULT '',
+			reviewed_at TIMESTAMP WITH TIME ZONE DEFAULT '2000-01-01T01:23:45Z',
+			created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+			updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+		);
+
+		CREATE UNIQUE INDEX IF NOT EXISTS idx_duplicate_review_open_user_rid
+			ON duplicate_review (user_rid) WHERE state = 'open';`,
+	)
+	if err != nil {
+		return fmt.Errorf("error creating duplicate_review table: %v", err)
+	}
+
+	err = r.db.CreateIndex("duplicate_review", "rid")
+	if err != nil {
+		return err
+	}
+
+	err = r.db.CreateIndex("duplicate_review", "state")
+	if err != nil {
+		return err
+	}
+
+	r.db.Logger.Println("created table duplicate_review")
+	return nil
+}
+
+func (r DuplicateReviewDBHandler) DropTable() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	query := `DROP TABLE IF EXISTS duplicate_review`
+	_, err := r.db.Instance.ExecContext(ctx, query)
+	if err != nil {
+		return fmt.Errorf("error dropping duplicate_review table: %#v", err)
+	}
+
+	r.db.Logger.Printf("dropped table duplicate_review")
+	return nil
+}
+
+// UpsertDuplicateReview queues the review, an open review of the user is updated with the new matches instead.
+func (r DuplicateReviewDBHandler) UpsertDuplicateReview(duplicateReview *model.DuplicateReview) (*model.DuplicateReview, error) {
+	row := r.db.Instance.QueryRow(
+		`INSERT INTO duplicate_review (user_rid, profile_rid, action, matches)
+			VALUES ($1, $2, $3, $4)
+		ON CONFLICT (user_rid) WHERE state = 'open'
+			DO UPDATE SET
+				profile_rid = EXCLUDED.profile_rid,
+				action = EXCLUDED.action,
+				matches = EXCLUDED.matches,
+				updated_at = CURRENT_TIMESTAMP
+		RETURNING
+			id,
+			rid,
+			user_rid,
+			profile_rid,
+			action,
+			state,
+			matches,
+			reviewer_rid,
+			comment,
+			reviewed_at,
+			created_at,
+			updated_at`,
+		duplicateReview.UserRID,
+		duplicateReview.ProfileRID,
+		duplicateReview.Action,
+		duplicateReview.Matches,
+	)
+
+	return scanDuplicateReview(row)
+}
+
+// ResolveDuplicateReview closes the open review with the state, it returns sql.ErrNoRows if it is not open.
+func (r DuplicateReviewDBHandler) ResolveDuplicateReview(rid uuid.UUID, state model.DuplicateReviewState, reviewerRid uuid.UUID, comment string) (*model.DuplicateReview, error) {
+	row := r.db.Instance.QueryRow(
+		`UPDATE
+			duplicate_review
+		SET
+			state = $1,
+			reviewer_rid = $2,
+			comment = $3,
+			reviewed_at = CURRENT_TIMESTAMP,
+			updated_at = CURRENT_TIMESTAMP
+		WHERE
+			rid = $4
+			AND state = 'open'
+		RETURNING
+			id,
+			rid,
+			user_rid,
+			profile_rid,
+			action,
+			state,
+			matches,
+			reviewer_rid,
+			comment,
+			reviewed_at,
+			created_at,
+			updated_at`,
+		state,
+		reviewerRid,
+		comment,
+		rid,
+	)
+
+	return scanDuplicateReview(row)
+}
+
+func (r DuplicateReviewDBHandler) SelectDuplicateReview(rid uuid.UUID) (*model.DuplicateReview, error) {
+	row := r.db.Instance.QueryRow(
+		`SELECT
+			id,
+			rid,
+			user_rid,
+			profile_rid,
+			action,
+			state,
+			matches,
+			reviewer_rid,
+			comment,
+			reviewed_at,
+			created_at,
+			updated_at
+		FROM
+			duplicate_review
+		WHERE
+			rid = $1`,
+		rid,
+	)
+
+	return scanDuplicateReview(row)
+}
+
+// SelectDuplicateReviews returns the reviews in the state, the oldest open reviews are worked first.
+func (r DuplicateReviewDBHandler) SelectDuplicateReviews(state model.DuplicateReviewState, lastId int, entries int) ([]*model.DuplicateReview, error) {
+	duplicateReviews := []*model.DuplicateReview{}
+
+	rows, err := r.db.Instance.Query(
+		`SELECT
+			id,
+			rid,
+			user_rid,
+			profile_rid,
+			action,
+			state,
+			matches,
+			reviewer_rid,
+			comment,
+			reviewed_at,
+			created_at,
+			updated_at
+		FROM
+			duplicate_review
+		WHERE
+			state = $1
+			AND (0 = $2
+				OR id > $2)
+		ORDER BY
+			id ASC
+		LIMIT $3`,
+		state,
+		lastId,
+		entries,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		duplicateReview, err := scanDuplicateReview(rows)
+		if err != nil {
+			return nil, err
+		}
+		duplicateReviews = append(duplicateReviews, duplicateReview)
+	}
+
+	return duplicateReviews, rows.Err()
+}
+
+type duplicateReviewScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanDuplicateReview(row duplicateReviewScanner) (*model.DuplicateReview, error) {
+	duplicateReview := &model.DuplicateReview{}
+	err := row.Scan(
+		&duplicateReview.ID,
+		&duplicateReview.RID,
+		&duplicateReview.UserRID,
+		&duplicateReview.ProfileRID,
+		&duplicateReview.Action,
+		&duplicateReview.State,
+		&duplicateReview.Matches,
+		&duplicateReview.ReviewerRID,
+		&duplicateReview.Comment,
+		&duplicateReview.ReviewedAt,
+		&duplicateReview.CreatedAt,
+		&duplicateReview.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return duplicateReview, nil
+}
@@ -2,16 +2,26 @@ package user
 
 import (
 	"bytes"
+	"context"
 	"database/sql"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"ht/helper"
 	"ht/model"
 	"ht/server/database"
+	"ht/server/notification"
+	"ht/server/services/audit"
+	"ht/server/services/job"
+	"ht/server/voice"
 	"io"
 	"log"
 	"net/http"
 	"os"
+	"slices"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/labstack/echo/v4"
@@ -20,12 +30,27 @@ import (
 const MAX_SIZE_MB = 5
 
 type UserService struct {
-	logger   *log.Logger
-	userDb   UserDBHandlerFunctions
-	jobsPort string
+	logger            *log.Logger
+	userDb            UserDBHandlerFunctions
+	referenceSampleDb ReferenceSampleDBHandlerFunctions
+	voiceProfileDb    VoiceProfileDBHandlerFunctions
+	duplicateReviewDb DuplicateReviewDBHandlerFunctions
+	watchlistDb       WatchlistDBHandlerFunctions
+	auditService      *audit.AuditService
+	jobService        *job.JobService
+	minSamples        int
+	maxSamples        int
+	maxProfiles       int
+	adaptation        *AdaptationPolicy
+	templateMaxAge    time.Duration
+	featureExtractor  voice.FeatureExtractor
+	reembedBatchSize  int
+	duplicatePolicy   *DuplicatePolicy
+	watchlistPolicy   *WatchlistPolicy
+	alerter           notification.Alerter
 }
 
-func NewUserService() *UserService {
+func NewUserService(auditService *audit.AuditService, jobService *job.JobService, featureExtractor voice.FeatureExtractor, alerter notification.Alerter) *UserService {
 	logger := log.New(os.Stdout, "user: ", log.LstdFlags)
 	dbConnection := database.NewDatabase(
 		"user",
@@ -40,16 +65,128 @@ func NewUserService() *UserService {
 	)
 	var userDb UserDBHandlerFunctions = newUserDBHandler(dbConnection)
 
+	var voiceProfileDb VoiceProfileDBHandlerFunctions = newVoiceProfileDBHandler(dbConnection)
+
+	var referenceSampleDb ReferenceSampleDBHandlerFunctions = newReferenceSampleDBHandler(dbConnection)
+
+	var duplicateReviewDb DuplicateReviewDBHandlerFunctions = newDuplicateReviewDBHandler(dbConnection)
+
+	var watchlistDb WatchlistDBHandlerFunctions = newWatchlistDBHandler(dbConnection)
+
 	// creates main user table
 	err := userDb.CreateTable()
 	if err != nil {
 		log.Fatal(err.Error())
 	}
 
+	// creates voice profile table, needs the user table
+	err = voiceProfileDb.CreateTable()
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+
+	// creates reference sample table, needs the user and voice profile table
+	err = referenceSampleDb.CreateTable()
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+
+	// creates duplicate review table, needs the user table
+	err = duplicateReviewDb.CreateTable()
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+
+	err = watchlistDb.CreateTable()
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+
+	minSamples, err := strconv.Atoi(helper.GetEnvVariableWithDefault("ENROLLMENT_MIN_SAMPLES", "3"))
+	if err != nil {
+		log.Fatalf("invalid ENROLLMENT_MIN_SAMPLES: %v", err)
+	}
+	maxSamples, err := strconv.Atoi(helper.GetEnvVariableWithDefault("ENROLLMENT_MAX_SAMPLES", "5"))
+	if err != nil {
+		log.Fatalf("invalid ENROLLMENT_MAX_SAMPLES: %v", err)
+	}
+	if minSamples < 1 || maxSamples < minSamples {
+		log.Fatalf("invalid enrollment sample bounds: min %v, max %v", minSamples, maxSamples)
+	}
+
+	maxProfiles, err := strconv.Atoi(helper.GetEnvVariableWithDefault("VOICE_PROFILES_MAX", "5"))
+	if err != nil {
+		log.Fatalf("invalid VOICE_PROFILES_MAX: %v", err)
+	}
+	if maxProfiles < 1 {
+		log.Fatal("VOICE_PROFILES_MAX has to be at least 1")
+	}
+
+	adaptation, err := NewAdaptationPolicyFromEnv()
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+	templateMaxAgeDays, err := strconv.Atoi(helper.GetEnvVariableWithDefault("TEMPLATE_MAX_AGE_DAYS", "180"))
+	if err != nil {
+		log.Fatalf("invalid TEMPLATE_MAX_AGE_DAYS: %v", err)
+	}
+
+	// nearest neighbour search of the voice login over the vectors of the current extractor
+	err = referenceSampleDb.CreateVectorIndexes(featureExtractor.Extractor(), featureExtractor.Dimension())
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+
+	reembedBatchSize, err := strconv.Atoi(helper.GetEnvVariableWithDefault("REEMBED_BATCH_SIZE", "20"))
+	if err != nil {
+		log.Fatalf("invalid REEMBED_BATCH_SIZE: %v", err)
+	}
+	if reembedBatchSize < 1 {
+		log.Fatal("REEMBED_BATCH_SIZE has to be at least 1")
+	}
+
+	duplicatePolicy, err := NewDuplicatePolicyFromEnv()
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+
+	watchlistPolicy, err := NewWatchlistPolicyFromEnv()
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+
 	newUserService := &UserService{
-		logger:   logger,
-		userDb:   userDb,
-		jobsPort: helper.GetEnvVariableWithoutDelete("JOBS_PORT"),
+		logger:            logger,
+		userDb:            userDb,
+		referenceSampleDb: referenceSampleDb,
+		voiceProfileDb:    voiceProfileDb,
+		duplicateReviewDb: duplicateReviewDb,
+		watchlistDb:       watchlistDb,
+		auditService:      auditService,
+		jobService:        jobService,
+		minSamples:        minSamples,
+		maxSamples:        maxSamples,
+		maxProfiles:       maxProfiles,
+		adaptation:        adaptation,
+		templateMaxAge:    time.Duration(templateMaxAgeDays) * 24 * time.Hour,
+		featureExtractor:  featureExtractor,
+		reembedBatchSize:  reembedBatchSize,
+		duplicatePolicy:   duplicatePolicy,
+		watchlistPolicy:   watchlistPolicy,
+		alerter:           alerter,
+	}
+
+	jobService.RegisterHandler(model.JobTypeProcessReferenceRecordings, &job.JobHandler{
+		Handle: newUserService.handleProcessReferenceRecordingsJob,
+	})
+	jobService.RegisterHandler(model.JobTypeReembedReferenceSamples, &job.JobHandler{
+		Handle: newUserService.handleReembedReferenceSamplesJob,
+	})
+
+	// templates of another extractor are not comparable anymore, recompute them in the background
+	err = newUserService.StartReembedding()
+	if err != nil {
+		log.Fatal(err.Error())
 	}
 
 	return newUserService
@@ -69,13 +206,65 @@ func (r *UserService) selectOrInsertUser(userRid uuid.UUID) (*model.User, error)
 	return user, nil
 }
 
-func (r *UserService) CreateReferenceRecording(c echo.Context) (*model.User, error) {
+func (r *UserService) GetUser(userRid uuid.UUID) (*model.User, error) {
+	return r.selectOrInsertUser(userRid)
+}
+
+func (r *UserService) GetReferenceSamples(userRid uuid.UUID) ([]*model.ReferenceSample, error) {
+	referenceSamples, err := r.referenceSampleDb.SelectReferenceSamplesByUserRID(userRid)
+	if err != nil {
+		return nil, fmt.Errorf("error selecting reference samples: %v", err)
+	}
+	return referenceSamples, nil
+}
+
+func (r *UserService) GetEnrollmentStatus(profileRid uuid.UUID) (*model.EnrollmentStatus, error) {
+	count, err := r.referenceSampleDb.CountReferenceSamplesByProfileRID(profileRid)
+	if err != nil {
+		return nil, fmt.Errorf("error counting reference samples: %v", err)
+	}
+
+	return &model.EnrollmentStatus{
+		ProfileRID:  profileRid,
+		SampleCount: count,
+		MinSamples:  r.minSamples,
+		MaxSamples:  r.maxSamples,
+	}, nil
+}
+
+func (r *UserService) CreateReferenceRecording(c echo.Context) (*model.ReferenceSample, error) {
 	currentStepString := c.Param("step")
 	currentStep, err := strconv.Atoi(currentStepString)
 	if err != nil {
 		return nil, err
 	}
 
+	profileRid, err := uuid.Parse(c.Param("profile"))
+	if err != nil {
+		return nil, echo.NewHTTPError(http.StatusBadRequest, "invalid profile")
+	}
+
+	userRid := helper.GetCurrentUserRID(c.Request().Context())
+	user, err := r.selectOrInsertUser(userRid)
+	if err != nil {
+		return nil, err
+	}
+
+	voiceProfile, err := r.GetVoiceProfile(user.RID, profileRid)
+	if errors.Is(err, ErrVoiceProfileNotFound) {
+		return nil, echo.NewHTTPError(http.StatusNotFound, err.Error())
+	} else if err != nil {
+		return nil, err
+	}
+
+	enrollmentStatus, err := r.GetEnrollmentStatus(voiceProfile.RID)
+	if err != nil {
+		return nil, err
+	}
+	if !enrollmentStatus.IsValidStep(currentStep) {
+		return nil, echo.NewHTTPError(http.StatusBadRequest, "invalid step value")
+	}
+
 	if err := c.Request().ParseMultipartForm(MAX_SIZE_MB << 20); err != nil {
 		return nil, err
 	}
@@ -94,31 +283,219 @@ func (r *UserService) CreateReferenceRecording(c echo.Context) (*model.User, err
 		return nil, err
 	}
 
-	userRid := helper.GetCurrentUserRID(c.Request().Context())
-	user, err := r.selectOrInsertUser(userRid)
+	recording, channel, err := voice.NormaliseRecording(buf.Bytes(), voice.Encoding(c.FormValue("encoding")))
+	if err != nil {
+		return nil, echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	referenceSample, err := r.referenceSampleDb.UpsertReferenceSample(&model.ReferenceSample{
+		UserRID:    user.RID,
+		ProfileRID: voiceProfile.RID,
+		Step:       currentStep,
+		Recording:  recording,
+		Channel:    channel,
+	})
 	if err != nil {
 		return nil, err
 	}
 
-	if currentStep == 1 {
-		user.Recording1 = buf.Bytes()
-	} else if currentStep == 2 {
-		user.Recording2 = buf.Bytes()
-	} else if currentStep == 3 {
-		user.Recording3 = buf.Bytes()
-	} else {
-		return nil, echo.NewHTTPError(http.StatusBadRequest, "invalid step value")
+	// a recording of a complete enrollment renews the template age and activates the profile for matching,
+	// whichever step it was recorded for
+	enrollmentStatus, err = r.GetEnrollmentStatus(voiceProfile.RID)
+	if err != nil {
+		return nil, err
+	}
+	if enrollmentStatus.IsComplete() {
+		user.TemplateRefreshedAt = time.Now()
+		err = r.auditService.Record(user.RID, user.RID, model.AuditActionTemplateRefreshed, map[string]any{"samples": enrollmentStatus.SampleCount, "profile_rid": voiceProfile.RID})
+		if err != nil {
+			return nil, err
+		}
+
+		if !voiceProfile.Active {
+			voiceProfile.Active = true
+			_, err = r.voiceProfileDb.UpdateVoiceProfile(voiceProfile)
+			if err != nil {
+				return nil, err
+			}
+		}
 	}
 
-	data, err := r.userDb.UpdateUser(user)
+	_, err = r.userDb.UpdateUser(user)
 	if err != nil {
 		return nil, err
 	}
 
-	_, err = helper.StartJob(fmt.Sprintf("http://localhost:%v/jobs/processReferenceRecordings", r.jobsPort), map[string]string{"rid": user.RID.String()})
+	_, err = r.jobService.Enqueue(model.JobTypeProcessReferenceRecordings, &model.ProcessReferenceRecordingsPayload{UserRID: user.RID})
 	if err != nil {
 		return nil, err
 	}
 
-	return data, nil
+	return referenceSample, nil
+}
+
+// handleProcessReferenceRecordingsJob extracts the features of all unprocessed reference samples of the user.
+// Remote extractors post the features to StoreReferenceSampleFeatures later.
+func (r *UserService) handleProcessReferenceRecordingsJob(processJob *model.Job) error {
+	payload := &model.ProcessReferenceRecordingsPayload{}
+	err := json.Unmarshal(processJob.Payload, payload)
+	if err != nil {
+		return err
+	}
+
+	referenceSamples, err := r.referenceSampleDb.SelectUnprocessedReferenceSamplesByUserRID(payload.UserRID)
+	if err != nil {
+		return fmt.Errorf("error selecting unprocessed reference samples: %v", err)
+	}
+	if len(referenceSamples) == 0 {
+		return nil
+	}
+
+	features, err := r.featureExtractor.ExtractReferenceFeatures(context.Background(), payload.UserRID, referenceSamples)
+	if errors.Is(err, voice.ErrFeaturesPending) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	return r.StoreReferenceSampleFeatures(payload.UserRID, features)
+}
+
+// StoreReferenceSampleFeatures persists the extracted features of reference samples of the user.
+// Results for samples of other users or re-recorded samples are discarded. Features of another
+// extractor are stored with their tag, but not used for matching until re-embedded.
+// Profiles whose new enrollment samples got their features are screened for duplicate voices and against the watchlist.
+func (r *UserService) StoreReferenceSampleFeatures(userRid uuid.UUID, features []*model.ReferenceSampleFeatures) error {
+	enrolledProfileRids := []uuid.UUID{}
+	for _, sample := range features {
+		if len(sample.Error) > 0 {
+			r.logger.Printf("feature extraction of reference sample %v failed: %v", sample.RID, sample.Error)
+			continue
+		}
+		if len(sample.Extractor) == 0 {
+			return fmt.Errorf("reference sample %v has features without extractor", sample.RID)
+		}
+		if sample.Extractor != r.featureExtractor.Extractor() {
+			r.logger.Printf("reference sample %v was extracted by %v, expected %v", sample.RID, sample.Extractor, r.featureExtractor.Extractor())
+		} else if len(sample.RecordingMfcc) != r.featureExtractor.Dimension() {
+			return fmt.Errorf("reference sample %v has %v features, expected %v", sample.RID, len(sample.RecordingMfcc), r.featureExtractor.Dimension())
+		}
+
+		referenceSample, err := r.referenceSampleDb.SelectReferenceSample(sample.RID)
+		if err != nil {
+			return fmt.Errorf("error selecting reference sample %v: %v", sample.RID, err)
+		}
+		if referenceSample.UserRID != userRid {
+			return fmt.Errorf("reference sample %v does not belong to user %v", sample.RID, userRid)
+		}
+
+		updated, err := r.referenceSampleDb.UpdateReferenceSampleFeatures(sample.RID, sample.RecordingMfcc, sample.Extractor, sample.Version)
+		if err != nil {
+			return fmt.Errorf("error updating reference sample features: %v", err)
+		}
+		if !updated {
+			r.logger.Printf("discarded features of re-recorded reference sample %v", sample.RID)
+		} else if referenceSample.Source == model.ReferenceSampleSourceEnrollment && len(referenceSample.RecordingMfcc) == 0 && !slices.Contains(enrolledProfileRids, referenceSample.ProfileRID) {
+			// re-embedded samples had features before, they were screened when enrolled
+			enrolledProfileRids = append(enrolledProfileRids, referenceSample.ProfileRID)
+		}
+	}
+
+	for _, profileRid := range enrolledProfileRids {
+		err := r.screenEnrollment(userRid, profileRid)
+		if err != nil {
+			r.logger.Printf("error screening enrollment of profile %v: %v", profileRid, err)
+		}
+	}
+
+	return nil
+}
+
+// screenEnrollment screens the enrolled samples of the profile for duplicate voices and against the watchlist
+// once the enrollment is complete and all its samples have features of the current extractor.
+func (r *UserService) screenEnrollment(userRid uuid.UUID, profileRid uuid.UUID) error {
+	referenceSamples, err := r.referenceSampleDb.SelectReferenceSamplesByUserRID(userRid)
+	if err != nil {
+		return fmt.Errorf("error selecting reference samples: %v", err)
+	}
+
+	vectors := []model.Vector{}
+	for _, referenceSample := range referenceSamples {
+		if referenceSample.ProfileRID != profileRid || referenceSample.Source != model.ReferenceSampleSourceEnrollment {
+			continue
+		}
+		if len(referenceSample.RecordingMfcc) == 0 || referenceSample.Extractor != r.featureExtractor.Extractor() {
+			// screened once the last sample of the enrollment has its features
+			return nil
+		}
+		vectors = append(vectors, referenceSample.RecordingMfcc)
+	}
+	if len(vectors) < r.minSamples {
+		return nil
+	}
+
+	err = r.screenDuplicates(userRid, profileRid, vectors, len(referenceSamples))
+	if err != nil {
+		return fmt.Errorf("error screening for duplicate voices: %v", err)
+	}
+
+	// screened last, a watchlist hit overrides the status of a duplicate
+	err = r.screenWatchlist(userRid, profileRid, vectors)
+	if err != nil {
+		return fmt.Errorf("error screening against the watchlist: %v", err)
+	}
+	return nil
+}
+
+// GetProfileDistances returns the distances of the vector to the references of each active profile of the user,
+// preferring the references of the channel of the vector.
+func (r *UserService) GetProfileDistances(userRid uuid.UUID, vector model.Vector, metric model.DistanceMetric, extractor model.Extractor, channel model.Channel) ([]*model.ProfileDistances, error) {
+	profileDistances, err := r.referenceSampleDb.SelectProfileDistances(userRid, vector, metric, extractor, channel)
+	if err != nil {
+		return nil, fmt.Errorf("error selecting reference distances: %v", err)
+	}
+	return profileDistances, nil
+}
+
+// SearchNearestUsers returns the users with the closest references of the extractor to the vector,
+// optionally narrowed to the users with the login code. The search covers the limit nearest references.
+func (r *UserService) SearchNearestUsers(vector model.Vector, metric model.DistanceMetric, extractor model.Extractor, voiceLoginCode string, limit int) ([]*model.VoiceCandidate, error) {
+	candidates, err := r.referenceSampleDb.SelectNearestUsers(vector, metric, extractor, strings.ToUpper(strings.TrimSpace(voiceLoginCode)), limit)
+	if err != nil {
+		return nil, fmt.Errorf("error searching nearest users: %v", err)
+	}
+	return candidates, nil
+}
+
+// DeleteUser deletes the voice profiles and reference recordings of the user with the user.
+// Identification attempts are kept, they belong to the audit trail.
+func (r *UserService) DeleteUser(userRid uuid.UUID) error {
+	err := r.auditService.Record(userRid, userRid, model.AuditActionAccountDeleted, map[string]any{})
+	if err != nil {
+		return err
+	}
+
+	err = r.userDb.DeleteUser(userRid)
+	if err != nil {
+		return fmt.Errorf("error deleting user: %v", err)
+	}
+	return nil
+}
+
+// GetDuressRecordings returns the recordings of the secret sentence of the duress profile of the user,
+// none if the user has no active duress profile.
+func (r *UserService) GetDuressRecordings(userRid uuid.UUID) ([][]byte, error) {
+	recordings, err := r.referenceSampleDb.SelectDuressRecordings(userRid)
+	if err != nil {
+		return nil, fmt.Errorf("error selecting duress recordings: %v", err)
+	}
+	return recordings, nil
+}
+
+func (r *UserService) GetMatchThreshold(userRid uuid.UUID) (float64, error) {
+	threshold, err := r.userDb.SelectMatchThreshold(userRid)
+	if err != nil && err != sql.ErrNoRows {
+		return 0, fmt.Errorf("error selecting match threshold: %v", err)
+	}
+	return threshold, nil
 }
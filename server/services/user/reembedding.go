@@ -0,0 +1,97 @@
+package user
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"ht/model"
+	"ht/server/voice"
+
+	"github.com/google/uuid"
+)
+
+// StartReembedding enqueues the re-embedding of all reference samples without features of the
+// current extractor, unless it is already running. Progress is kept in the samples themselves,
+// so a restarted re-embedding continues with the remaining ones.
+func (r *UserService) StartReembedding() error {
+	stale, err := r.referenceSampleDb.CountStaleReferenceSamples(r.featureExtractor.Extractor())
+	if err != nil {
+		return fmt.Errorf("error counting stale reference samples: %v", err)
+	}
+	if stale == 0 {
+		return nil
+	}
+
+	running, err := r.jobService.HasUnfinishedJob(model.JobTypeReembedReferenceSamples)
+	if err != nil {
+		return err
+	}
+	if running {
+		return nil
+	}
+
+	r.logger.Printf("re-embedding %v reference samples with %v", stale, r.featureExtractor.Extractor())
+	_, err = r.jobService.Enqueue(model.JobTypeReembedReferenceSamples, &model.ReembedReferenceSamplesPayload{
+		Extractor: r.featureExtractor.Extractor(),
+	})
+	return err
+}
+
+// handleReembedReferenceSamplesJob recomputes the features of one batch of stale reference samples
+// from their recordings and enqueues the next batch. Jobs of a replaced extractor are dropped.
+func (r *UserService) handleReembedReferenceSamplesJob(reembedJob *model.Job) error {
+	payload := &model.ReembedReferenceSamplesPayload{}
+	err := json.Unmarshal(reembedJob.Payload, payload)
+	if err != nil {
+		return err
+	}
+	if payload.Extractor != r.featureExtractor.Extractor() {
+		r.logger.Printf("dropping re-embedding for replaced extractor %v", payload.Extractor)
+		return nil
+	}
+
+	referenceSamples, err := r.referenceSampleDb.SelectStaleReferenceSamples(payload.Extractor, payload.AfterID, r.reembedBatchSize)
+	if err != nil {
+		return fmt.Errorf("error selecting stale reference samples: %v", err)
+	}
+	if len(referenceSamples) == 0 {
+		r.logger.Printf("re-embedding with %v finished", payload.Extractor)
+		return nil
+	}
+
+	// extraction is done per user, as the remote extractor reports per user
+	userRids := []uuid.UUID{}
+	samplesByUser := map[uuid.UUID][]*model.ReferenceSample{}
+	for _, referenceSample := range referenceSamples {
+		if _, ok := samplesByUser[referenceSample.UserRID]; !ok {
+			userRids = append(userRids, referenceSample.UserRID)
+		}
+		samplesByUser[referenceSample.UserRID] = append(samplesByUser[referenceSample.UserRID], referenceSample)
+	}
+
+	for _, userRid := range userRids {
+		features, err := r.featureExtractor.ExtractReferenceFeatures(context.Background(), userRid, samplesByUser[userRid])
+		if errors.Is(err, voice.ErrFeaturesPending) {
+			continue
+		} else if err != nil {
+			return err
+		}
+
+		err = r.StoreReferenceSampleFeatures(userRid, features)
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(referenceSamples) < r.reembedBatchSize {
+		r.logger.Printf("re-embedding with %v finished", payload.Extractor)
+		return nil
+	}
+
+	_, err = r.jobService.Enqueue(model.JobTypeReembedReferenceSamples, &model.ReembedReferenceSamplesPayload{
+		Extractor: payload.Extractor,
+		AfterID:   referenceSamples[len(referenceSamples)-1].ID,
+	})
+	return err
+}
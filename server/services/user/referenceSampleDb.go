@@ -0,0 +1,1014 @@
+package user
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"ht/model"
+	"ht/server/database"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type ReferenceSampleDBHandlerFunctions interface {
+	CreateTable() error
+	DropTable() error
+	UpsertReferenceSample(referenceSample *model.ReferenceSample) (*model.ReferenceSample, error)
+	DeleteReferenceSamplesForRetraining(profileRid uuid.UUID, keepSteps int) ([]uuid.UUID, error)
+	DeleteReferenceSample(rid uuid.UUID) error
+	SelectReferenceSample(rid uuid.UUID) (*model.ReferenceSample, error)
+	SelectReferenceSamplesByUserRID(userRid uuid.UUID) ([]*model.ReferenceSample, error)
+	SelectUnprocessedReferenceSamplesByUserRID(userRid uuid.UUID) ([]*model.ReferenceSample, error)
+	UpdateReferenceSampleFeatures(rid uuid.UUID, recordingMfcc model.Vector, extractor model.Extractor, version time.Time) (bool, error)
+	CountReferenceSamplesByProfileRID(profileRid uuid.UUID) (int, error)
+	InsertAdaptedReferenceSample(referenceSample *model.ReferenceSample) (*model.ReferenceSample, error)
+	DeleteAdaptedReferenceSamplesExceeding(profileRid uuid.UUID, keep int) ([]uuid.UUID, error)
+	DeleteAdaptedReferenceSamplesByUserRID(userRid uuid.UUID) ([]uuid.UUID, error)
+	SelectProfileDistances(userRid uuid.UUID, vector model.Vector, metric model.DistanceMetric, extractor model.Extractor, channel model.Channel) ([]*model.ProfileDistances, error)
+	SelectNearestUsers(vector model.Vector, metric model.DistanceMetric, extractor model.Extractor, voiceLoginCode string, limit int) ([]*model.VoiceCandidate, error)
+	SelectDuressRecordings(userRid uuid.UUID) ([][]byte, error)
+	CreateVectorIndexes(extractor model.Extractor, dimension int) error
+	SelectStaleReferenceSamples(extractor model.Extractor, afterId int, limit int) ([]*model.ReferenceSample, error)
+	CountStaleReferenceSamples(extractor model.Extractor) (int, error)
+}
+
+type ReferenceSampleDBHandler struct {
+	db *database.Database
+}
+
+func newReferenceSampleDBHandler(dbConnection *database.Database) *ReferenceSampleDBHandler {
+	return &ReferenceSampleDBHandler{
+		db: dbConnection,
+	}
+}
+
+func (r ReferenceSampleDBHandler) CreateTable() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	_, err := r.db.Instance.ExecContext(
+		ctx,
+		`CREATE EXTENSION IF NOT EXISTS vector;
+
+		CREATE TABLE IF NOT EXISTS reference_sample (
+			id BIGINT GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+			rid UUID DEFAULT gen_random_uuid() UNIQUE NOT NULL,
+			user_rid UUID NOT NULL REFERENCES "user" (rid) ON DELETE CASCADE,
+			profile_rid UUID REFERENCES voice_profile (rid) ON DELETE CASCADE,
+			step INT,
+			source TEXT DEFAULT 'enrollment',
+			attempt_rid UUID,
+			recording BYTEA,
+			recording_normalised BYTEA,
+			recording_mfcc VECTOR,
+			extractor TEXT,
+			channel TEXT NOT NULL DEFAULT 'wideband',
+			created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+			updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+		);
+
+		ALTER TABLE reference_sample ALTER COLUMN step DROP NOT NULL;
+		ALTER TABLE reference_sample ADD COLUMN IF NOT EXISTS source TEXT DEFAULT 'enrollment';
+		ALTER TABLE reference_sample ADD COLUMN IF NOT EXISTS attempt_rid UUID;
+		ALTER TABLE reference_sample ADD COLUMN IF NOT EXISTS extractor TEXT;
+		ALTER TABLE reference_sample ADD COLUMN IF NOT EXISTS profile_rid UUID REFERENCES voice_profile (rid) ON DELETE CASCADE;
+		ALTER TABLE reference_sample ADD COLUMN IF NOT EXISTS channel TEXT NOT NULL DEFAULT 'wideband';`,
+	)
+	if err != nil {
+		return fmt.Errorf("error creating reference_sample table: %v", err)
+	}
+
+	err = r.db.CreateIndex("reference_sample", "rid")
+	if err != nil {
+		return err
+	}
+
+	// steps are unique per profile, samples without profile are migrated below
+	err = r.db.CreateUniqueCombinedIndex("reference_sample", "profile_rid", "step")
+	if err != nil {
+		return err
+	}
+
+	err = r.migrateUserRecordings()
+	if err != nil {
+		return err
+	}
+
+	err = r.migrateExtractor(ctx)
+	if err != nil {
+		return err
+	}
+
+	err = r.migrateVoiceProfiles(ctx)
+	if err != nil {
+		return err
+	}
+
+	err = r.db.DropIndex("reference_sample", "user_rid_step")
+	if err != nil {
+		return err
+	}
+
+	r.db.Logger.Println("created table reference_sample")
+	return nil
+}
+
+// migrateUserRecordings moves the recordings of the fixed user columns
+// into the reference_sample table and drops the old columns afterwards.
+func (r ReferenceSampleDBHandler) migrateUserRecordings() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+
+	exists := false
+	err := r.db.Instance.QueryRowContext(
+		ctx,
+		`SELECT EXISTS (
+			SELECT 1
+			FROM information_schema.columns
+			WHERE table_schema = current_schema()
+				AND table_name = 'user'
+				AND column_name = 'recording_1'
+		)`,
+	).Scan(&exists)
+	if err != nil {
+		return fmt.Errorf("error checking user recording columns: %v", err)
+	}
+	if !exists {
+		return nil
+	}
+
+	tx, err := r.db.Instance.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(
+		ctx,
+		`INSERT INTO reference_sample (user_rid, step, recording, recording_normalised, recording_mfcc)
+			SELECT rid, 1, recording_1, recording_1_normalised, recording_1_mfcc FROM "user" WHERE recording_1 IS NOT NULL
+			UNION ALL
+			SELECT rid, 2, recording_2, recording_2_normalised, recording_2_mfcc FROM "user" WHERE recording_2 IS NOT NULL
+			UNION ALL
+			SELECT rid, 3, recording_3, recording_3_normalised, recording_3_mfcc FROM "user" WHERE recording_3 IS NOT NULL
+		ON CONFLICT DO NOTHING;`,
+	)
+	if err != nil {
+		return fmt.Errorf("error migrating user recordings: %v", err)
+	}
+
+	_, err = tx.ExecContext(
+		ctx,
+		`ALTER TABLE "user"
+			DROP COLUMN IF EXISTS recording_1,
+			DROP COLUMN IF EXISTS recording_2,
+			DROP COLUMN IF EXISTS recording_3,
+			DROP COLUMN IF EXISTS recording_1_normalised,
+			DROP COLUMN IF EXISTS recording_2_normalised,
+			DROP COLUMN IF EXISTS recording_3_normalised,
+			DROP COLUMN IF EXISTS recording_1_mfcc,
+			DROP COLUMN IF EXISTS recording_2_mfcc,
+			DROP COLUMN IF EXISTS recording_3_mfcc;`,
+	)
+	if err != nil {
+		return fmt.Errorf("error dropping user recording columns: %v", err)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+
+	migrated, _ := result.RowsAffected()
+	r.db.Logger.Printf("migrated %v user recordings to reference_sample", migrated)
+	return nil
+}
+
+// migrateExtractor drops the fixed dimension of recording_mfcc, vectors of different extractors
+// can have different ones, and tags the existing vectors with the legacy extractor.
+func (r ReferenceSampleDBHandler) migrateExtractor(ctx context.Context) error {
+	dimension, err := r.db.VectorDimension(ctx, "reference_sample", "recording_mfcc")
+	if err != nil {
+		return err
+	}
+	if dimension == 0 {
+		return nil
+	}
+
+	tx, err := r.db.Instance.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(
+		ctx,
+		`DROP INDEX IF EXISTS idx_reference_sample_recording_mfcc_vector_l2_ops;
+		DROP INDEX IF EXISTS idx_reference_sample_recording_mfcc_vector_cosine_ops;
+		ALTER TABLE reference_sample ALTER COLUMN recording_mfcc TYPE VECTOR;`,
+	)
+	if err != nil {
+		return fmt.Errorf("error dropping reference_sample vector dimension: %v", err)
+	}
+
+	_, err = tx.ExecContext(
+		ctx,
+		`UPDATE
+			reference_sample
+		SET
+			extractor = $1
+		WHERE
+			recording_mfcc IS NOT NULL
+			AND extractor IS NULL`,
+		model.LegacyExtractor,
+	)
+	if err != nil {
+		return fmt.Errorf("error tagging reference_sample vectors: %v", err)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+
+	r.db.Logger.Printf("migrated reference_sample vectors of dimension %v to extractor %v", dimension, model.LegacyExtractor)
+	return nil
+}
+
+// migrateVoiceProfiles moves the samples without profile into an active default profile of their user,
+// the references of existing users keep matching as before.
+func (r ReferenceSampleDBHandler) migrateVoiceProfiles(ctx context.Context) error {
+	tx, err := r.db.Instance.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(
+		ctx,
+		`INSERT INTO voice_profile (user_rid, name, active)
+			SELECT DISTINCT user_rid, $1, TRUE FROM reference_sample WHERE profile_rid IS NULL
+		ON CONFLICT (user_rid, name) DO NOTHING;`,
+		model.DefaultVoiceProfileName,
+	)
+	if err != nil {
+		return fmt.Errorf("error creating default voice profiles: %v", err)
+	}
+
+	result, err := tx.ExecContext(
+		ctx,
+		`UPDATE
+			reference_sample
+		SET
+			profile_rid = voice_profile.rid
+		FROM
+			voice_profile
+		WHERE
+			reference_sample.profile_rid IS NULL
+			AND voice_profile.user_rid = reference_sample.user_rid
+			AND voice_profile.name = $1`,
+		model.DefaultVoiceProfileName,
+	)
+	if err != nil {
+		return fmt.Errorf("error migrating reference samples to voice profiles: %v", err)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+
+	migrated, _ := result.RowsAffected()
+	if migrated > 0 {
+		r.db.Logger.Printf("migrated %v reference samples to default voice profiles", migrated)
+	}
+	return nil
+}
+
+func (r ReferenceSampleDBHandler) DropTable() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	query := `DROP TABLE IF EXISTS reference_sample`
+	_, err := r.db.Instance.ExecContext(ctx, query)
+	if err != nil {
+		return fmt.Errorf("error dropping reference_sample table: %#v", err)
+	}
+
+	r.db.Logger.Printf("dropped table reference_sample")
+	return nil
+}
+
+// UpsertReferenceSample inserts the sample or replaces the recording of the same step of the profile.
+// The extracted features of a replaced recording are reset.
+func (r ReferenceSampleDBHandler) UpsertReferenceSample(referenceSample *model.ReferenceSample) (*model.ReferenceSample, error) {
+	newReferenceSample := &model.ReferenceSample{}
+
+	row := r.db.Instance.QueryRow(
+		`INSERT INTO reference_sample (user_rid, profile_rid, step, recording, channel)
+			VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (profile_rid, step) DO UPDATE
+		SET
+			recording = EXCLUDED.recording,
+			channel = EXCLUDED.channel,
+			recording_normalised = NULL,
+			recording_mfcc = NULL,
+			extractor = NULL,
+			updated_at = CURRENT_TIMESTAMP
+		RETURNING
+			id,
+			rid,
+			user_rid,
+			profile_rid,
+			COALESCE(step, 0),
+			source,
+			attempt_rid,
+			recording,
+			recording_normalised,
+			recording_mfcc,
+			COALESCE(extractor, ''),
+			channel,
+			created_at,
+			updated_at;`,
+		referenceSample.UserRID,
+		referenceSample.ProfileRID,
+		referenceSample.Step,
+		referenceSample.Recording,
+		referenceSample.Channel,
+	)
+
+	err := row.Scan(
+		&newReferenceSample.ID,
+		&newReferenceSample.RID,
+		&newReferenceSample.UserRID,
+		&newReferenceSample.ProfileRID,
+		&newReferenceSample.Step,
+		&newReferenceSample.Source,
+		&newReferenceSample.AttemptRID,
+		&newReferenceSample.Recording,
+		&newReferenceSample.RecordingNormalised,
+		&newReferenceSample.RecordingMfcc,
+		&newReferenceSample.Extractor,
+		&newReferenceSample.Channel,
+		&newReferenceSample.CreatedAt,
+		&newReferenceSample.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return newReferenceSample, nil
+}
+
+func (r ReferenceSampleDBHandler) DeleteReferenceSample(rid uuid.UUID) error {
+	_, err := r.db.Instance.Exec(
+		`DELETE FROM reference_sample
+		WHERE rid = $1`,
+		rid,
+	)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (r ReferenceSampleDBHandler) SelectReferenceSample(rid uuid.UUID) (*model.ReferenceSample, error) {
+	referenceSample := &model.ReferenceSample{}
+
+	row := r.db.Instance.QueryRow(
+		`SELECT
+			id,
+			rid,
+			user_rid,
+			profile_rid,
+			COALESCE(step, 0),
+			source,
+			attempt_rid,
+			recording,
+			recording_normalised,
+			recording_mfcc,
+			COALESCE(extractor, ''),
+			channel,
+			created_at,
+			updated_at
+		FROM
+			reference_sample
+		WHERE
+			rid = $1`,
+		rid,
+	)
+	err := row.Scan(
+		&referenceSample.ID,
+		&referenceSample.RID,
+		&referenceSample.UserRID,
+		&referenceSample.ProfileRID,
+		&referenceSample.Step,
+		&referenceSample.Source,
+		&referenceSample.AttemptRID,
+		&referenceSample.Recording,
+		&referenceSample.RecordingNormalised,
+		&referenceSample.RecordingMfcc,
+		&referenceSample.Extractor,
+		&referenceSample.Channel,
+		&referenceSample.CreatedAt,
+		&referenceSample.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return referenceSample, nil
+}
+
+func (r ReferenceSampleDBHandler) SelectReferenceSamplesByUserRID(userRid uuid.UUID) ([]*model.ReferenceSample, error) {
+	var referenceSamples []*model.ReferenceSample
+
+	rows, err := r.db.Instance.Query(
+		`SELECT
+			id,
+			rid,
+			user_rid,
+			profile_rid,
+			COALESCE(step, 0),
+			source,
+			attempt_rid,
+			recording,
+			recording_normalised,
+			recording_mfcc,
+			COALESCE(extractor, ''),
+			channel,
+			created_at,
+			updated_at
+		FROM
+			reference_sample
+		WHERE
+			user_rid = $1
+		ORDER BY
+			source DESC,
+			step ASC,
+			created_at ASC`,
+		userRid,
+	)
+	if err != nil {
+		return []*model.ReferenceSample{}, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		referenceSample := &model.ReferenceSample{}
+		err := rows.Scan(
+			&referenceSample.ID,
+			&referenceSample.RID,
+			&referenceSample.UserRID,
+			&referenceSample.ProfileRID,
+			&referenceSample.Step,
+			&referenceSample.Source,
+			&referenceSample.AttemptRID,
+			&referenceSample.Recording,
+			&referenceSample.RecordingNormalised,
+			&referenceSample.RecordingMfcc,
+			&referenceSample.Extractor,
+			&referenceSample.Channel,
+			&referenceSample.CreatedAt,
+			&referenceSample.UpdatedAt,
+		)
+		if err != nil {
+			return []*model.ReferenceSample{}, err
+		}
+
+		referenceSamples = append(referenceSamples, referenceSample)
+	}
+
+	return referenceSamples, nil
+}
+
+// SelectUnprocessedReferenceSamplesByUserRID returns the samples of the user without extracted features.
+func (r ReferenceSampleDBHandler) SelectUnprocessedReferenceSamplesByUserRID(userRid uuid.UUID) ([]*model.ReferenceSample, error) {
+	var referenceSamples []*model.ReferenceSample
+
+	rows, err := r.db.Instance.Query(
+		`SELECT
+			id,
+			rid,
+			user_rid,
+			profile_rid,
+			COALESCE(step, 0),
+			source,
+			attempt_rid,
+			recording,
+			recording_normalised,
+			recording_mfcc,
+			COALESCE(extractor, ''),
+			channel,
+			created_at,
+			updated_at
+		FROM
+			reference_sample
+		WHERE
+			user_rid = $1
+			AND recording_mfcc IS NULL
+		ORDER BY
+			step ASC`,
+		userRid,
+	)
+	if err != nil {
+		return []*model.ReferenceSample{}, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		referenceSample := &model.ReferenceSample{}
+		err := rows.Scan(
+			&referenceSample.ID,
+			&referenceSample.RID,
+			&referenceSample.UserRID,
+			&referenceSample.ProfileRID,
+			&referenceSample.Step,
+			&referenceSample.Source,
+			&referenceSample.AttemptRID,
+			&referenceSample.Recording,
+			&referenceSample.RecordingNormalised,
+			&referenceSample.RecordingMfcc,
+			&referenceSample.Extractor,
+			&referenceSample.Channel,
+			&referenceSample.CreatedAt,
+			&referenceSample.UpdatedAt,
+		)
+		if err != nil {
+			return []*model.ReferenceSample{}, err
+		}
+
+		referenceSamples = append(referenceSamples, referenceSample)
+	}
+
+	return referenceSamples, nil
+}
+
+// UpdateReferenceSampleFeatures stores the extracted features if the sample was not changed since the version.
+// It returns false if the sample was re-recorded in the meantime.
+func (r ReferenceSampleDBHandler) UpdateReferenceSampleFeatures(rid uuid.UUID, recordingMfcc model.Vector, extractor model.Extractor, version time.Time) (bool, error) {
+	result, err := r.db.Instance.Exec(
+		`UPDATE
+			reference_sample
+		SET
+			recording_mfcc = $1,
+			extractor = $2
+		WHERE
+			rid = $3
+			AND updated_at = $4`,
+		recordingMfcc,
+		extractor,
+		rid,
+		version,
+	)
+	if err != nil {
+		return false, err
+	}
+
+	updated, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return updated > 0, nil
+}
+
+func (r ReferenceSampleDBHandler) CountReferenceSamplesByProfileRID(profileRid uuid.UUID) (int, error) {
+	count := 0
+
+	err := r.db.Instance.QueryRow(
+		`SELECT
+			COUNT(*)
+		FROM
+			reference_sample
+		WHERE
+			profile_rid = $1
+			AND source = 'enrollment'`,
+		profileRid,
+	).Scan(&count)
+
+	return count, err
+}
+
+// InsertAdaptedReferenceSample adds the recording and features of an identification attempt as reference.
+func (r ReferenceSampleDBHandler) InsertAdaptedReferenceSample(referenceSample *model.ReferenceSample) (*model.ReferenceSample, error) {
+	newReferenceSample := &model.ReferenceSample{}
+
+	row := r.db.Instance.QueryRow(
+		`INSERT INTO reference_sample (user_rid, profile_rid, source, attempt_rid, recording, recording_mfcc, extractor, channel)
+			VALUES ($1, $2, 'adapted', $3, $4, $5, $6, $7)
+		RETURNING
+			id,
+			rid,
+			user_rid,
+			profile_rid,
+			COALESCE(step, 0),
+			source,
+			attempt_rid,
+			recording,
+			recording_normalised,
+			recording_mfcc,
+			COALESCE(extractor, ''),
+			channel,
+			created_at,
+			updated_at;`,
+		referenceSample.UserRID,
+		referenceSample.ProfileRID,
+		referenceSample.AttemptRID,
+		referenceSample.Recording,
+		referenceSample.RecordingMfcc,
+		referenceSample.Extractor,
+		referenceSample.Channel,
+	)
+
+	err := row.Scan(
+		&newReferenceSample.ID,
+		&newReferenceSample.RID,
+		&newReferenceSample.UserRID,
+		&newReferenceSample.ProfileRID,
+		&newReferenceSample.Step,
+		&newReferenceSample.Source,
+		&newReferenceSample.AttemptRID,
+		&newReferenceSample.Recording,
+		&newReferenceSample.RecordingNormalised,
+		&newReferenceSample.RecordingMfcc,
+		&newReferenceSample.Extractor,
+		&newReferenceSample.Channel,
+		&newReferenceSample.CreatedAt,
+		&newReferenceSample.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return newReferenceSample, nil
+}
+
+// DeleteAdaptedReferenceSamplesExceeding keeps the newest adapted samples of the profile and returns the deleted ones.
+func (r ReferenceSampleDBHandler) DeleteAdaptedReferenceSamplesExceeding(profileRid uuid.UUID, keep int) ([]uuid.UUID, error) {
+	rows, err := r.db.Instance.Query(
+		`DELETE FROM reference_sample
+		WHERE id IN (
+			SELECT
+				id
+			FROM
+				reference_sample
+			WHERE
+				profile_rid = $1
+				AND source = 'adapted'
+			ORDER BY
+				created_at DESC
+			OFFSET $2)
+		RETURNING
+			rid`,
+		profileRid,
+		keep,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return scanRIDs(rows)
+}
+
+// DeleteReferenceSamplesForRetraining removes the adapted samples and the enrollment samples after
+// keepSteps of the profile and returns the deleted ones. The kept steps are replaced by re-recording them.
+func (r ReferenceSampleDBHandler) DeleteReferenceSamplesForRetraining(profileRid uuid.UUID, keepSteps int) ([]uuid.UUID, error) {
+	rows, err := r.db.Instance.Query(
+		`DELETE FROM reference_sample
+		WHERE
+			profile_rid = $1
+			AND (source = 'adapted' OR step > $2)
+		RETURNING
+			rid`,
+		profileRid,
+		keepSteps,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return scanRIDs(rows)
+}
+
+// DeleteAdaptedReferenceSamplesByUserRID removes all adapted samples of the user and returns the deleted ones.
+func (r ReferenceSampleDBHandler) DeleteAdaptedReferenceSamplesByUserRID(userRid uuid.UUID) ([]uuid.UUID, error) {
+	rows, err := r.db.Instance.Query(
+		`DELETE FROM reference_sample
+		WHERE
+			user_rid = $1
+			AND source = 'adapted'
+		RETURNING
+			rid`,
+		userRid,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return scanRIDs(rows)
+}
+
+func scanRIDs(rows *sql.Rows) ([]uuid.UUID, error) {
+	defer rows.Close()
+
+	rids := []uuid.UUID{}
+	for rows.Next() {
+		rid := uuid.UUID{}
+		err := rows.Scan(&rid)
+		if err != nil {
+			return nil, err
+		}
+		rids = append(rids, rid)
+	}
+
+	return rids, rows.Err()
+}
+
+// SelectProfileDistances returns the distances of the vector to the reference samples of each active
+// profile of the user which have features of the extractor, others are not comparable. Only the samples
+// of the channel are compared, profiles without any are compared with the samples of the other channel.
+// The duress profile is left out, its voice is the same as the one of the other profiles.
+func (r ReferenceSampleDBHandler) SelectProfileDistances(userRid uuid.UUID, vector model.Vector, metric model.DistanceMetric, extractor model.Extractor, channel model.Channel) ([]*model.ProfileDistances, error) {
+	operator, err := metric.Operator()
+	if err != nil {
+		return nil, err
+	}
+
+	profileDistances := []*model.ProfileDistances{}
+
+	rows, err := r.db.Instance.Query(
+		fmt.Sprintf(`SELECT
+			reference_sample.profile_rid,
+			reference_sample.recording_mfcc %s $2::vector AS distance,
+			reference_sample.channel <> $4 AS cross_channel
+		FROM
+			reference_sample
+			JOIN voice_profile ON voice_profile.rid = reference_sample.profile_rid
+		WHERE
+			reference_sample.user_rid = $1
+			AND voice_profile.active
+			AND NOT voice_profile.duress
+			AND reference_sample.recording_mfcc IS NOT NULL
+			AND reference_sample.extractor = $3
+			AND vector_dims(reference_sample.recording_mfcc) = vector_dims($2::vector)
+			AND (
+				reference_sample.channel = $4
+				OR NOT EXISTS (
+					SELECT
+						1
+					FROM
+						reference_sample same_channel
+					WHERE
+						same_channel.profile_rid = reference_sample.profile_rid
+						AND same_channel.channel = $4
+						AND same_channel.recording_mfcc IS NOT NULL
+						AND same_channel.extractor = $3))
+		ORDER BY
+			voice_profile.id ASC,
+			reference_sample.created_at ASC`, operator),
+		userRid,
+		vector,
+		extractor,
+		channel,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		profileRid := uuid.UUID{}
+		distance := 0.0
+		crossChannel := false
+		err := rows.Scan(&profileRid, &distance, &crossChannel)
+		if err != nil {
+			return nil, err
+		}
+		if len(profileDistances) == 0 || profileDistances[len(profileDistances)-1].ProfileRID != profileRid {
+			profileDistances = append(profileDistances, &model.ProfileDistances{ProfileRID: profileRid, CrossChannel: crossChannel})
+		}
+		current := profileDistances[len(profileDistances)-1]
+		current.Distances = append(current.Distances, distance)
+	}
+
+	return profileDistances, rows.Err()
+}
+
+// SelectNearestUsers returns the users of the limit nearest reference samples of active profiles of the
+// extractor ordered by the distance of their closest sample. Duress profiles are left out like in SelectProfileDistances.
+// Without login code the hnsw index of CreateVectorIndexes is used, so the search is approximate, with login code
+// only the references of the matching users are scanned.
+func (r ReferenceSampleDBHandler) SelectNearestUsers(vector model.Vector, metric model.DistanceMetric, extractor model.Extractor, voiceLoginCode string, limit int) ([]*model.VoiceCandidate, error) {
+	operator, err := metric.Operator()
+	if err != nil {
+		return nil, err
+	}
+
+	voiceLoginCodeFilter := ""
+	args := []any{vector, limit, extractor}
+	if len(voiceLoginCode) > 0 {
+		voiceLoginCodeFilter = `AND user_rid IN (
+				SELECT
+					rid
+				FROM
+					"user"
+				WHERE
+					voice_login_code = $4)`
+		args = append(args, voiceLoginCode)
+	}
+
+	candidates := []*model.VoiceCandidate{}
+
+	rows, err := r.db.Instance.Query(
+		fmt.Sprintf(`SELECT
+			user_rid,
+			MIN(distance) AS distance
+		FROM (
+			SELECT
+				user_rid,
+				recording_mfcc::vector(%[3]d) %[1]s $1::vector(%[3]d) AS distance
+			FROM
+				reference_sample
+			WHERE
+				recording_mfcc IS NOT NULL
+				AND extractor = $3
+				AND vector_dims(recording_mfcc) = %[3]d
+				AND profile_rid IN (
+					SELECT
+						rid
+					FROM
+						voice_profile
+					WHERE
+						active
+						AND NOT duress)
+				%[2]s
+			ORDER BY
+				recording_mfcc::vector(%[3]d) %[1]s $1::vector(%[3]d)
+			LIMIT $2) AS nearest
+		GROUP BY
+			user_rid
+		ORDER BY
+			distance ASC`, operator, voiceLoginCodeFilter, len(vector)),
+		args...,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		candidate := &model.VoiceCandidate{}
+		err := rows.Scan(&candidate.UserRID, &candidate.Distance)
+		if err != nil {
+			return nil, err
+		}
+		candidates = append(candidates, candidate)
+	}
+
+	return candidates, rows.Err()
+}
+
+// SelectDuressRecordings returns the enrolled recordings of the active duress profile of the user.
+func (r ReferenceSampleDBHandler) SelectDuressRecordings(userRid uuid.UUID) ([][]byte, error) {
+	recordings := [][]byte{}
+
+	rows, err := r.db.Instance.Query(
+		`SELECT
+			reference_sample.recording
+		FROM
+			reference_sample
+			JOIN voice_profile ON voice_profile.rid = reference_sample.profile_rid
+		WHERE
+			reference_sample.user_rid = $1
+			AND voice_profile.active
+			AND voice_profile.duress
+			AND reference_sample.source = 'enrollment'
+			AND reference_sample.recording IS NOT NULL
+		ORDER BY
+			reference_sample.created_at ASC`,
+		userRid,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		recording := []byte{}
+		err := rows.Scan(&recording)
+		if err != nil {
+			return nil, err
+		}
+		recordings = append(recordings, recording)
+	}
+
+	return recordings, rows.Err()
+}
+
+// CreateVectorIndexes creates the hnsw indexes of the voice login for the vectors of the extractor.
+func (r ReferenceSampleDBHandler) CreateVectorIndexes(extractor model.Extractor, dimension int) error {
+	for _, metric := range []model.DistanceMetric{model.DistanceMetricL2, model.DistanceMetricCosine} {
+		operatorClass, err := metric.OperatorClass()
+		if err != nil {
+			return err
+		}
+		err = r.db.CreateVectorIndex("reference_sample", "recording_mfcc", dimension, operatorClass, "extractor", string(extractor))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SelectStaleReferenceSamples returns the samples after the id with a recording and without
+// features of the extractor, ordered by id.
+func (r ReferenceSampleDBHandler) SelectStaleReferenceSamples(extractor model.Extractor, afterId int, limit int) ([]*model.ReferenceSample, error) {
+	var referenceSamples []*model.ReferenceSample
+
+	rows, err := r.db.Instance.Query(
+		`SELECT
+			id,
+			rid,
+			user_rid,
+			profile_rid,
+			COALESCE(step, 0),
+			source,
+			attempt_rid,
+			recording,
+			recording_normalised,
+			recording_mfcc,
+			COALESCE(extractor, ''),
+			channel,
+			created_at,
+			updated_at
+		FROM
+			reference_sample
+		WHERE
+			id > $2
+			AND recording IS NOT NULL
+			AND extractor IS DISTINCT FROM $1
+		ORDER BY
+			id ASC
+		LIMIT $3`,
+		extractor,
+		afterId,
+		limit,
+	)
+	if err != nil {
+		return []*model.ReferenceSample{}, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		referenceSample := &model.ReferenceSample{}
+		err := rows.Scan(
+			&referenceSample.ID,
+			&referenceSample.RID,
+			&referenceSample.UserRID,
+			&referenceSample.ProfileRID,
+			&referenceSample.Step,
+			&referenceSample.Source,
+			&referenceSample.AttemptRID,
+			&referenceSample.Recording,
+			&referenceSample.RecordingNormalised,
+			&referenceSample.RecordingMfcc,
+			&referenceSample.Extractor,
+			&referenceSample.Channel,
+			&referenceSample.CreatedAt,
+			&referenceSample.UpdatedAt,
+		)
+		if err != nil {
+			return []*model.ReferenceSample{}, err
+		}
+
+		referenceSamples = append(referenceSamples, referenceSample)
+	}
+
+	return referenceSamples, rows.Err()
+}
+
+// CountStaleReferenceSamples counts the samples with a recording and without features of the extractor.
+func (r ReferenceSampleDBHandler) CountStaleReferenceSamples(extractor model.Extractor) (int, error) {
+	count := 0
+
+	err := r.db.Instance.QueryRow(
+		`SELECT
+			COUNT(*)
+		FROM
+			reference_sample
+		WHERE
+			recording IS NOT NULL
+			AND extractor IS DISTINCT FROM $1`,
+		extractor,
+	).Scan(&count)
+
+	return count, err
+}
@@ -0,0 +1,292 @@
+package user
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"ht/model"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+)
+
+const maxVoiceProfileNameLength = 40
+
+var (
+	ErrVoiceProfileNotFound    = errors.New("voice profile not found")
+	ErrInvalidVoiceProfileName = fmt.Errorf("the profile name has to have 1 to %v characters", maxVoiceProfileNameLength)
+	ErrVoiceProfileNameTaken   = errors.New("a profile with this name already exists")
+	ErrVoiceProfileLimit       = errors.New("the maximum number of voice profiles is reached")
+	ErrLastVoiceProfile        = errors.New("the last voice profile can not be deleted")
+	ErrDuressVoiceProfileTaken = errors.New("a duress profile already exists")
+	ErrReferenceSampleNotFound = errors.New("recording not found")
+)
+
+func normaliseVoiceProfileName(name string) (string, error) {
+	name = strings.TrimSpace(name)
+	if len(name) == 0 || utf8.RuneCountInString(name) > maxVoiceProfileNameLength {
+		return "", ErrInvalidVoiceProfileName
+	}
+	return name, nil
+}
+
+func (r *UserService) GetVoiceProfiles(userRid uuid.UUID) ([]*model.VoiceProfile, error) {
+	voiceProfiles, err := r.voiceProfileDb.SelectVoiceProfilesByUserRID(userRid)
+	if err != nil {
+		return nil, fmt.Errorf("error selecting voice profiles: %v", err)
+	}
+	return voiceProfiles, nil
+}
+
+// GetVoiceProfile returns the profile if it belongs to the user.
+func (r *UserService) GetVoiceProfile(userRid uuid.UUID, profileRid uuid.UUID) (*model.VoiceProfile, error) {
+	voiceProfile, err := r.voiceProfileDb.SelectVoiceProfile(profileRid)
+	if err == sql.ErrNoRows {
+		return nil, ErrVoiceProfileNotFound
+	} else if err != nil {
+		return nil, fmt.Errorf("error selecting voice profile: %v", err)
+	}
+	if voiceProfile.UserRID != userRid {
+		return nil, ErrVoiceProfileNotFound
+	}
+	return voiceProfile, nil
+}
+
+// GetVoiceProfileRecordings returns the reference samples of the profile of the user.
+func (r *UserService) GetVoiceProfileRecordings(userRid uuid.UUID, profileRid uuid.UUID) ([]*model.ReferenceSample, error) {
+	voiceProfile, err := r.GetVoiceProfile(userRid, profileRid)
+	if err != nil {
+		return nil, err
+	}
+
+	referenceSamples, err := r.GetReferenceSamples(userRid)
+	if err != nil {
+		return nil, err
+	}
+
+	recordings := []*model.ReferenceSample{}
+	for _, referenceSample := range referenceSamples {
+		if referenceSample.ProfileRID == voiceProfile.RID {
+			recordings = append(recordings, referenceSample)
+		}
+	}
+	return recordings, nil
+}
+
+// GetReferenceRecording returns the reference sample if it belongs to the user.
+func (r *UserService) GetReferenceRecording(userRid uuid.UUID, rid uuid.UUID) (*model.ReferenceSample, error) {
+	referenceSample, err := r.referenceSampleDb.SelectReferenceSample(rid)
+	if err == sql.ErrNoRows {
+		return nil, ErrReferenceSampleNotFound
+	} else if err != nil {
+		return nil, fmt.Errorf("error selecting reference sample: %v", err)
+	}
+	if referenceSample.UserRID != userRid {
+		return nil, ErrReferenceSampleNotFound
+	}
+	return referenceSample, nil
+}
+
+// GetDefaultVoiceProfile returns the oldest profile of the user which is not the duress profile,
+// the first enrollment creates it.
+func (r *UserService) GetDefaultVoiceProfile(userRid uuid.UUID) (*model.VoiceProfile, error) {
+	voiceProfiles, err := r.GetVoiceProfiles(userRid)
+	if err != nil {
+		return nil, err
+	}
+	for _, voiceProfile := range voiceProfiles {
+		if !voiceProfile.Duress {
+			return voiceProfile, nil
+		}
+	}
+
+	return r.CreateVoiceProfile(userRid, model.DefaultVoiceProfileName)
+}
+
+// HasActiveVoiceProfile returns true if the user can be identified, the duress profile alone does not count.
+func (r *UserService) HasActiveVoiceProfile(userRid uuid.UUID) (bool, error) {
+	voiceProfiles, err := r.GetVoiceProfiles(userRid)
+	if err != nil {
+		return false, err
+	}
+	for _, voiceProfile := range voiceProfiles {
+		if voiceProfile.Active && !voiceProfile.Duress {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// CreateVoiceProfile adds an inactive profile, it is matched once its enrollment is complete.
+func (r *UserService) CreateVoiceProfile(userRid uuid.UUID, name string) (*model.VoiceProfile, error) {
+	return r.createVoiceProfile(userRid, name, false)
+}
+
+// CreateDuressVoiceProfile adds the inactive duress profile, which the user records with a secret sentence
+// instead of the displayed ones. Identifications matching it look successful, but are reported as duress.
+func (r *UserService) CreateDuressVoiceProfile(userRid uuid.UUID) (*model.VoiceProfile, error) {
+	return r.createVoiceProfile(userRid, model.DuressVoiceProfileName, true)
+}
+
+func (r *UserService) createVoiceProfile(userRid uuid.UUID, name string, duress bool) (*model.VoiceProfile, error) {
+	name, err := normaliseVoiceProfileName(name)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := r.selectOrInsertUser(userRid)
+	if err != nil {
+		return nil, err
+	}
+
+	voiceProfiles, err := r.GetVoiceProfiles(user.RID)
+	if err != nil {
+		return nil, err
+	}
+	if len(voiceProfiles) >= r.maxProfiles {
+		return nil, ErrVoiceProfileLimit
+	}
+	if hasVoiceProfileName(voiceProfiles, name) {
+		return nil, ErrVoiceProfileNameTaken
+	}
+	if duress && hasDuressVoiceProfile(voiceProfiles) {
+		return nil, ErrDuressVoiceProfileTaken
+	}
+
+	voiceProfile, err := r.voiceProfileDb.InsertVoiceProfile(&model.VoiceProfile{
+		UserRID: user.RID,
+		Name:    name,
+		Duress:  duress,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error inserting voice profile: %v", err)
+	}
+
+	err = r.auditService.Record(user.RID, user.RID, model.AuditActionVoiceProfileCreated, map[string]any{
+		"profile_rid": voiceProfile.RID,
+		"name":        voiceProfile.Name,
+		"duress":      voiceProfile.Duress,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return voiceProfile, nil
+}
+
+func (r *UserService) RenameVoiceProfile(userRid uuid.UUID, profileRid uuid.UUID, name string) (*model.VoiceProfile, error) {
+	name, err := normaliseVoiceProfileName(name)
+	if err != nil {
+		return nil, err
+	}
+
+	voiceProfile, err := r.GetVoiceProfile(userRid, profileRid)
+	if err != nil {
+		return nil, err
+	}
+	if voiceProfile.Name == name {
+		return voiceProfile, nil
+	}
+
+	voiceProfiles, err := r.GetVoiceProfiles(userRid)
+	if err != nil {
+		return nil, err
+	}
+	if hasVoiceProfileName(voiceProfiles, name) {
+		return nil, ErrVoiceProfileNameTaken
+	}
+
+	previousName := voiceProfile.Name
+	voiceProfile.Name = name
+	voiceProfile, err = r.voiceProfileDb.UpdateVoiceProfile(voiceProfile)
+	if err != nil {
+		return nil, fmt.Errorf("error updating voice profile: %v", err)
+	}
+
+	err = r.auditService.Record(userRid, userRid, model.AuditActionVoiceProfileRenamed, map[string]any{
+		"profile_rid":   voiceProfile.RID,
+		"previous_name": previousName,
+		"name":          voiceProfile.Name,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return voiceProfile, nil
+}
+
+// RetrainVoiceProfile drops the adapted and optional samples of the profile before its required
+// steps are recorded again. The profile keeps matching with the old recordings until they are replaced.
+func (r *UserService) RetrainVoiceProfile(userRid uuid.UUID, profileRid uuid.UUID) (*model.VoiceProfile, error) {
+	voiceProfile, err := r.GetVoiceProfile(userRid, profileRid)
+	if err != nil {
+		return nil, err
+	}
+
+	deletedRids, err := r.referenceSampleDb.DeleteReferenceSamplesForRetraining(voiceProfile.RID, r.minSamples)
+	if err != nil {
+		return nil, fmt.Errorf("error deleting reference samples: %v", err)
+	}
+
+	err = r.auditService.Record(userRid, userRid, model.AuditActionVoiceProfileRetrained, map[string]any{
+		"profile_rid":           voiceProfile.RID,
+		"reference_sample_rids": deletedRids,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return voiceProfile, nil
+}
+
+// DeleteVoiceProfile removes the profile with its reference samples. The last profile of a user
+// besides the duress profile can not be deleted, it is retrained instead.
+func (r *UserService) DeleteVoiceProfile(userRid uuid.UUID, profileRid uuid.UUID) error {
+	voiceProfile, err := r.GetVoiceProfile(userRid, profileRid)
+	if err != nil {
+		return err
+	}
+
+	voiceProfiles, err := r.GetVoiceProfiles(userRid)
+	if err != nil {
+		return err
+	}
+	if !voiceProfile.Duress && countVoiceProfiles(voiceProfiles) <= 1 {
+		return ErrLastVoiceProfile
+	}
+
+	err = r.voiceProfileDb.DeleteVoiceProfile(voiceProfile.RID)
+	if err != nil {
+		return fmt.Errorf("error deleting voice profile: %v", err)
+	}
+
+	return r.auditService.Record(userRid, userRid, model.AuditActionVoiceProfileDeleted, map[string]any{
+		"profile_rid": voiceProfile.RID,
+		"name":        voiceProfile.Name,
+	})
+}
+
+// countVoiceProfiles returns the number of profiles besides the duress profile.
+func countVoiceProfiles(voiceProfiles []*model.VoiceProfile) int {
+	count := 0
+	for _, voiceProfile := range voiceProfiles {
+		if !voiceProfile.Duress {
+			count++
+		}
+	}
+	return count
+}
+
+func hasDuressVoiceProfile(voiceProfiles []*model.VoiceProfile) bool {
+	return countVoiceProfiles(voiceProfiles) < len(voiceProfiles)
+}
+
+func hasVoiceProfileName(voiceProfiles []*model.VoiceProfile, name string) bool {
+	for _, voiceProfile := range voiceProfiles {
+		if strings.EqualFold(voiceProfile.Name, name) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,271 @@
+package user
+
+import (
+	"context"
+	"fmt"
+	"ht/model"
+	"ht/server/database"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type VoiceProfileDBHandlerFunctions interface {
+	CreateTable() error
+	DropTable() error
+	InsertVoiceProfile(voiceProfile *model.VoiceProfile) (*model.VoiceProfile, error)
+	UpdateVoiceProfile(voiceProfile *model.VoiceProfile) (*model.VoiceProfile, error)
+	DeleteVoiceProfile(rid uuid.UUID) error
+	SelectVoiceProfile(rid uuid.UUID) (*model.VoiceProfile, error)
+	SelectVoiceProfilesByUserRID(userRid uuid.UUID) ([]*model.VoiceProfile, error)
+}
+
+type VoiceProfileDBHandler struct {
+	db *database.Database
+}
+
+func newVoiceProfileDBHandler(dbConnection *database.Database) *VoiceProfileDBHandler {
+	return &VoiceProfileDBHandler{
+		db: dbConnection,
+	}
+}
+
+func (r VoiceProfileDBHandler) CreateTable() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	_, err := r.db.Instance.ExecContext(
+		ctx,
+		`CREATE TABLE IF NOT EXISTS voice_profile (
+			id BIGINT GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+			rid UUID DEFAULT gen_random_uuid() UNIQUE NOT NULL,
+			user_rid UUID NOT NULL REFERENCES "user" (rid) ON DELETE CASCADE,
+			name TEXT NOT NULL,
+			active BOOLEAN DEFAULT FALSE,
+			duress BOOLEAN DEFAULT FALSE,
+			created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+			updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+		);
+
+		ALTER TABLE voice_profile ADD COLUMN IF NOT EXISTS duress BOOLEAN DEFAULT FALSE;
+
+		CREATE UNIQUE INDEX IF NOT EXISTS idx_voice_profile_duress_user_rid
+			ON voice_profile (user_rid) WHERE duress;`,
+	)
+	if err != nil {
+		return fmt.Errorf("error creating voice_profile table: %v", err)
+	}
+
+	err = r.db.CreateIndex("voice_profile", "rid")
+	if err != nil {
+		return err
+	}
+
+	err = r.db.CreateUniqueCombinedIndex("voice_profile", "user_rid", "name")
+	if err != nil {
+		return err
+	}
+
+	r.db.Logger.Println("created table voice_profile")
+	return nil
+}
+
+func (r VoiceProfileDBHandler) DropTable() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	query := `DROP TABLE IF EXISTS voice_profile`
+	_, err := r.db.Instance.ExecContext(ctx, query)
+	if err != nil {
+		return fmt.Errorf("error dropping voice_profile table: %#v", err)
+	}
+
+	r.db.Logger.Printf("dropped table voice_profile")
+	return nil
+}
+
+func (r VoiceProfileDBHandler) InsertVoiceProfile(voiceProfile *model.VoiceProfile) (*model.VoiceProfile, error) {
+	newVoiceProfile := &model.VoiceProfile{}
+
+	row := r.db.Instance.QueryRow(
+		`INSERT INTO voice_profile (user_rid, name, duress)
+			VALUES ($1, $2, $3)
+		RETURNING
+			id,
+			rid,
+			user_rid,
+			name,
+			active,
+			duress,
+			created_at,
+			updated_at;`,
+		voiceProfile.UserRID,
+		voiceProfile.Name,
+		voiceProfile.Duress,
+	)
+
+	err := row.Scan(
+		&newVoiceProfile.ID,
+		&newVoiceProfile.RID,
+		&newVoiceProfile.UserRID,
+		&newVoiceProfile.Name,
+		&newVoiceProfile.Active,
+		&newVoiceProfile.Duress,
+		&newVoiceProfile.CreatedAt,
+		&newVoiceProfile.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return newVoiceProfile, nil
+}
+
+func (r VoiceProfileDBHandler) UpdateVoiceProfile(voiceProfile *model.VoiceProfile) (*model.VoiceProfile, error) {
+	voiceProfileUpdated := &model.VoiceProfile{}
+
+	row := r.db.Instance.QueryRow(
+		`UPDATE
+			voice_profile
+		SET
+			name = $1,
+			active = $2,
+			updated_at = CURRENT_TIMESTAMP
+		WHERE
+			rid = $3
+		RETURNING
+			id,
+			rid,
+			user_rid,
+			name,
+			active,
+			duress,
+			(SELECT COUNT(*) FROM reference_sample WHERE profile_rid = voice_profile.rid AND source = 'enrollment'),
+			created_at,
+			updated_at`,
+		voiceProfile.Name,
+		voiceProfile.Active,
+		voiceProfile.RID,
+	)
+
+	err := row.Scan(
+		&voiceProfileUpdated.ID,
+		&voiceProfileUpdated.RID,
+		&voiceProfileUpdated.UserRID,
+		&voiceProfileUpdated.Name,
+		&voiceProfileUpdated.Active,
+		&voiceProfileUpdated.Duress,
+		&voiceProfileUpdated.SampleCount,
+		&voiceProfileUpdated.CreatedAt,
+		&voiceProfileUpdated.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return voiceProfileUpdated, nil
+}
+
+// DeleteVoiceProfile removes the profile, its reference samples are deleted with it.
+func (r VoiceProfileDBHandler) DeleteVoiceProfile(rid uuid.UUID) error {
+	_, err := r.db.Instance.Exec(
+		`DELETE FROM voice_profile
+		WHERE rid = $1`,
+		rid,
+	)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (r VoiceProfileDBHandler) SelectVoiceProfile(rid uuid.UUID) (*model.VoiceProfile, error) {
+	voiceProfile := &model.VoiceProfile{}
+
+	row := r.db.Instance.QueryRow(
+		`SELECT
+			id,
+			rid,
+			user_rid,
+			name,
+			active,
+			duress,
+			(SELECT COUNT(*) FROM reference_sample WHERE profile_rid = voice_profile.rid AND source = 'enrollment'),
+			created_at,
+			updated_at
+		FROM
+			voice_profile
+		WHERE
+			rid = $1`,
+		rid,
+	)
+	err := row.Scan(
+		&voiceProfile.ID,
+		&voiceProfile.RID,
+		&voiceProfile.UserRID,
+		&voiceProfile.Name,
+		&voiceProfile.Active,
+		&voiceProfile.Duress,
+		&voiceProfile.SampleCount,
+		&voiceProfile.CreatedAt,
+		&voiceProfile.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return voiceProfile, nil
+}
+
+// SelectVoiceProfilesByUserRID returns the profiles of the user, the oldest first.
+func (r VoiceProfileDBHandler) SelectVoiceProfilesByUserRID(userRid uuid.UUID) ([]*model.VoiceProfile, error) {
+	voiceProfiles := []*model.VoiceProfile{}
+
+	rows, err := r.db.Instance.Query(
+		`SELECT
+			id,
+			rid,
+			user_rid,
+			name,
+			active,
+			duress,
+			(SELECT COUNT(*) FROM reference_sample WHERE profile_rid = voice_profile.rid AND source = 'enrollment'),
+			created_at,
+			updated_at
+		FROM
+			voice_profile
+		WHERE
+			user_rid = $1
+		ORDER BY
+			created_at ASC`,
+		userRid,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		voiceProfile := &model.VoiceProfile{}
+		err := rows.Scan(
+			&voiceProfile.ID,
+			&voiceProfile.RID,
+			&voiceProfile.UserRID,
+			&voiceProfile.Name,
+			&voiceProfile.Active,
+			&voiceProfile.Duress,
+			&voiceProfile.SampleCount,
+			&voiceProfile.CreatedAt,
+			&voiceProfile.UpdatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		voiceProfiles = append(voiceProfiles, voiceProfile)
+	}
+
+	return voiceProfiles, rows.Err()
+}
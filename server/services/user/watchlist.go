@@ -0,0 +1,237 @@
+package user
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"ht/helper"
+	"ht/model"
+	"strconv"
+
+	"github.com/google/uuid"
+)
+
+var (
+	ErrWatchlistEntryNotFound = errors.New("watchlist entry not found")
+	ErrNoWatchlistTemplates   = errors.New("no features of the current extractor to put on the watchlist")
+)
+
+// WatchlistPolicy decides when a voice matches the fraud watchlist and what happens then.
+type WatchlistPolicy struct {
+	Enabled bool
+	Metric  model.DistanceMetric
+	// Threshold is the distance a voice has to be below to match a template of the watchlist.
+	Threshold float64
+	Action    model.WatchlistAction
+}
+
+func NewWatchlistPolicyFromEnv() (*WatchlistPolicy, error) {
+	enabled, err := strconv.ParseBool(helper.GetEnvVariableWithDefault("WATCHLIST_ENABLED", "true"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid WATCHLIST_ENABLED: %v", err)
+	}
+	threshold, err := strconv.ParseFloat(helper.GetEnvVariableWithDefault("WATCHLIST_THRESHOLD", "3"), 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid WATCHLIST_THRESHOLD: %v", err)
+	}
+
+	// the voices are compared like the identification compares them, unless configured otherwise
+	metric := model.DistanceMetric(helper.GetEnvVariableWithDefault("WATCHLIST_METRIC", helper.GetEnvVariableWithDefault("MATCHING_METRIC", string(model.DistanceMetricL2))))
+	_, err = metric.Operator()
+	if err != nil {
+		return nil, fmt.Errorf("invalid WATCHLIST_METRIC: %v", err)
+	}
+	action := model.WatchlistAction(helper.GetEnvVariableWithDefault("WATCHLIST_ACTION", string(model.WatchlistActionReject)))
+	err = action.Validate()
+	if err != nil {
+		return nil, fmt.Errorf("invalid WATCHLIST_ACTION: %v", err)
+	}
+	if threshold <= 0 {
+		return nil, fmt.Errorf("WATCHLIST_THRESHOLD has to be positive")
+	}
+
+	return &WatchlistPolicy{
+		Enabled:   enabled,
+		Metric:    metric,
+		Threshold: threshold,
+		Action:    action,
+	}, nil
+}
+
+// ScreenWatchlist returns the hit of the vector on the active watchlist with the action of the policy,
+// or nil if it matches no entry. Templates of other extractors are not compared.
+func (r *UserService) ScreenWatchlist(vector model.Vector, extractor model.Extractor) (*model.WatchlistHit, error) {
+	if !r.watchlistPolicy.Enabled {
+		return nil, nil
+	}
+
+	watchlistHit, err := r.watchlistDb.SelectNearestWatchlistEntry(vector, r.watchlistPolicy.Metric, extractor)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("error searching watchlist: %v", err)
+	}
+	if watchlistHit.Distance >= r.watchlistPolicy.Threshold {
+		return nil, nil
+	}
+
+	watchlistHit.Action = r.watchlistPolicy.Action
+	return watchlistHit, nil
+}
+
+// screenWatchlist screens the enrolled vectors of the profile against the watchlist. On a hit the account
+// gets the status of the action, a blocked account stays blocked, and the fraud team is alerted.
+func (r *UserService) screenWatchlist(userRid uuid.UUID, profileRid uuid.UUID, vectors []model.Vector) error {
+	var watchlistHit *model.WatchlistHit
+	for _, vector := range vectors {
+		hit, err := r.ScreenWatchlist(vector, r.featureExtractor.Extractor())
+		if err != nil {
+			return err
+		}
+		if hit != nil && (watchlistHit == nil || hit.Distance < watchlistHit.Distance) {
+			watchlistHit = hit
+		}
+	}
+	if watchlistHit == nil {
+		return nil
+	}
+
+	user, err := r.GetUser(userRid)
+	if err != nil {
+		return fmt.Errorf("error selecting user: %v", err)
+	}
+	if user.Status != model.UserStatusBlocked {
+		_, err = r.userDb.UpdateUserStatus(userRid, watchlistHit.Action.Status())
+		if err != nil {
+			return fmt.Errorf("error updating user status: %v", err)
+		}
+	}
+	r.logger.Printf("enrolled voice of user %v matches watchlist entry %v, applied %v", userRid, watchlistHit.EntryRID, watchlistHit.Action)
+
+	details := map[string]any{
+		"profile_rid": profileRid,
+		"entry_rid":   watchlistHit.EntryRID,
+		"reason":      watchlistHit.Reason,
+		"distance":    watchlistHit.Distance,
+		"action":      watchlistHit.Action,
+	}
+	err = r.alerter.Alert(&model.Alert{Type: model.AlertTypeWatchlistHit, UserRID: userRid, Details: details})
+	if err != nil {
+		r.logger.Printf("error alerting watchlist hit of user %v: %v", userRid, err)
+	}
+
+	return r.auditService.Record(userRid, userRid, model.AuditActionWatchlistHit, details)
+}
+
+// AddAccountToWatchlist puts the reference samples of the account on the watchlist.
+func (r *UserService) AddAccountToWatchlist(actorRid uuid.UUID, userRid uuid.UUID, reason model.WatchlistReason, note string) (*model.WatchlistEntry, error) {
+	referenceSamples, err := r.referenceSampleDb.SelectReferenceSamplesByUserRID(userRid)
+	if err != nil {
+		return nil, fmt.Errorf("error selecting reference samples: %v", err)
+	}
+
+	vectors := []model.Vector{}
+	for _, referenceSample := range referenceSamples {
+		if len(referenceSample.RecordingMfcc) > 0 && referenceSample.Extractor == r.featureExtractor.Extractor() {
+			vectors = append(vectors, referenceSample.RecordingMfcc)
+		}
+	}
+
+	return r.addWatchlistEntry(actorRid, userRid, &model.WatchlistEntry{
+		Reason:    reason,
+		Note:      note,
+		Source:    model.WatchlistSourceAccount,
+		SourceRID: userRid,
+	}, vectors)
+}
+
+// AddAttemptToWatchlist puts the features of the recording of an identification attempt on the watchlist.
+func (r *UserService) AddAttemptToWatchlist(actorRid uuid.UUID, identificationAttempt *model.IdentificationAttempt, reason model.WatchlistReason, note string) (*model.WatchlistEntry, error) {
+	vectors := []model.Vector{}
+	if len(identificationAttempt.RecordingMfcc) > 0 && identificationAttempt.Extractor == r.featureExtractor.Extractor() {
+		vectors = append(vectors, identificationAttempt.RecordingMfcc)
+	}
+
+	return r.addWatchlistEntry(actorRid, identificationAttempt.UserRID, &model.WatchlistEntry{
+		Reason:    reason,
+		Note:      note,
+		Source:    model.WatchlistSourceAttempt,
+		SourceRID: identificationAttempt.RID,
+	}, vectors)
+}
+
+// addWatchlistEntry stores the entry with the vectors of the current extractor as templates,
+// it is recorded against the account the voice was taken from.
+func (r *UserService) addWatchlistEntry(actorRid uuid.UUID, subjectRid uuid.UUID, watchlistEntry *model.WatchlistEntry, vectors []model.Vector) (*model.WatchlistEntry, error) {
+	err := watchlistEntry.Reason.Validate()
+	if err != nil {
+		return nil, err
+	}
+	if len(vectors) == 0 {
+		return nil, ErrNoWatchlistTemplates
+	}
+
+	watchlistEntry.CreatedBy = actorRid
+	watchlistEntry, err = r.watchlistDb.InsertWatchlistEntry(watchlistEntry, vectors, r.featureExtractor.Extractor())
+	if err != nil {
+		return nil, fmt.Errorf("error inserting watchlist entry: %v", err)
+	}
+
+	err = r.auditService.Record(actorRid, subjectRid, model.AuditActionWatchlistEntryAdded, map[string]any{
+		"entry_rid":  watchlistEntry.RID,
+		"reason":     watchlistEntry.Reason,
+		"source":     watchlistEntry.Source,
+		"source_rid": watchlistEntry.SourceRID,
+		"templates":  watchlistEntry.Templates,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return watchlistEntry, nil
+}
+
+// DeactivateWatchlistEntry stops screening against the entry, e.g. after it was put on the watchlist by mistake.
+func (r *UserService) DeactivateWatchlistEntry(actorRid uuid.UUID, rid uuid.UUID) (*model.WatchlistEntry, error) {
+	watchlistEntry, err := r.watchlistDb.DeactivateWatchlistEntry(rid)
+	if err == sql.ErrNoRows {
+		return nil, ErrWatchlistEntryNotFound
+	} else if err != nil {
+		return nil, fmt.Errorf("error deactivating watchlist entry: %v", err)
+	}
+
+	err = r.auditService.Record(actorRid, actorRid, model.AuditActionWatchlistEntryDeactivated, map[string]any{
+		"entry_rid": watchlistEntry.RID,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return watchlistEntry, nil
+}
+
+// GetWatchlistEntries returns the watchlist, newest entries first.
+func (r *UserService) GetWatchlistEntries(lastId int, entries int) ([]*model.WatchlistEntry, error) {
+	watchlistEntries, err := r.watchlistDb.SelectWatchlistEntries(lastId, entries)
+	if err != nil {
+		return nil, fmt.Errorf("error selecting watchlist entries: %v", err)
+	}
+	return watchlistEntries, nil
+}
+
+// SetUserStatus lets admins release a held account or block one.
+func (r *UserService) SetUserStatus(actorRid uuid.UUID, userRid uuid.UUID, status model.UserStatus) (*model.User, error) {
+	user, err := r.userDb.UpdateUserStatus(userRid, status)
+	if err != nil {
+		return nil, fmt.Errorf("error updating user status: %v", err)
+	}
+
+	err = r.auditService.Record(actorRid, userRid, model.AuditActionUserStatusChanged, map[string]any{
+		"status": status,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
@@ -0,0 +1,313 @@
+package user
+
+import (
+	"context"
+	"fmt"
+	"ht/model"
+	"ht/server/database"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type WatchlistDBHandlerFunctions interface {
+	CreateTable() error
+	DropTable() error
+	InsertWatchlistEntry(watchlistEntry *model.WatchlistEntry, vectors []model.Vector, extractor model.Extractor) (*model.WatchlistEntry, error)
+	DeactivateWatchlistEntry(rid uuid.UUID) (*model.WatchlistEntry, error)
+	SelectWatchlistEntries(lastId int, entries int) ([]*model.WatchlistEntry, error)
+	SelectNearestWatchlistEntry(vector model.Vector, metric model.DistanceMetric, extractor model.Extractor) (*model.WatchlistHit, error)
+}
+
+type WatchlistDBHandler struct {
+	db *database.Database
+}
+
+func newWatchlistDBHandler(dbConnection *database.Database) *WatchlistDBHandler {
+	return &WatchlistDBHandler{
+		db: dbConnection,
+	}
+}
+
+func (r WatchlistDBHandler) CreateTable() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	_, err := r.db.Instance.ExecContext(
+		ctx,
+		`CREATE EXTENSION IF NOT EXISTS vector;
+
+		CREATE TABLE IF NOT EXISTS watchlist_entry (
+			id BIGINT GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+			rid UUID DEFAULT gen_random_uuid() UNIQUE NOT NULL,
+			reason TEXT NOT NULL,
+			note TEXT NOT NULL DEFAULT '',
+			source TEXT NOT NULL,
+			source_rid UUID NOT NULL,
+			active BOOLEAN NOT NULL DEFAULT true,
+			created_by UUID NOT NULL,
+			created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+			updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+		);
+
+		CREATE TABLE IF NOT EXISTS watchlist_template (
+			id BIGINT GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+			entry_rid UUID NOT NULL REFERENCES watchlist_entry (rid) ON DELETE CASCADE,
+			recording_mfcc VECTOR NOT NULL,
+			extractor TEXT NOT NULL,
+			created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+		);`,
+	)
+	if err != nil {
+		return fmt.Errorf("error creating watchlist tables: %v", err)
+	}
+
+	err = r.db.CreateIndex("watchlist_entry", "rid")
+	if err != nil {
+		return err
+	}
+
+	err = r.db.CreateIndex("watchlist_template", "entry_rid")
+	if err != nil {
+		return err
+	}
+
+	r.db.Logger.Println("created tables watchlist_entry and watchlist_template")
+	return nil
+}
+
+func (r WatchlistDBHandler) DropTable() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	query := `DROP TABLE IF EXISTS watchlist_template; DROP TABLE IF EXISTS watchlist_entry`
+	_, err := r.db.Instance.ExecContext(ctx, query)
+	if err != nil {
+		return fmt.Errorf("error dropping watchlist tables: %#v", err)
+	}
+
+	r.db.Logger.Printf("dropped tables watchlist_entry and watchlist_template")
+	return nil
+}
+
+// InsertWatchlistEntry inserts the entry with the vectors of the extractor as its templates in one transaction.
+func (r WatchlistDBHandler) InsertWatchlistEntry(watchlistEntry *model.WatchlistEntry, vectors []model.Vector, extractor model.Extractor) (*model.WatchlistEntry, error) {
+	newWatchlistEntry := &model.WatchlistEntry{}
+
+	tx, err := r.db.Instance.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	row := tx.QueryRow(
+		`INSERT INTO watchlist_entry (reason, note, source, source_rid, created_by)
+			VALUES ($1, $2, $3, $4, $5)
+		RETURNING
+			id,
+			rid,
+			reason,
+			note,
+			source,
+			source_rid,
+			active,
+			created_by,
+			created_at,
+			updated_at;`,
+		watchlistEntry.Reason,
+		watchlistEntry.Note,
+		watchlistEntry.Source,
+		watchlistEntry.SourceRID,
+		watchlistEntry.CreatedBy,
+	)
+
+	err = row.Scan(
+		&newWatchlistEntry.ID,
+		&newWatchlistEntry.RID,
+		&newWatchlistEntry.Reason,
+		&newWatchlistEntry.Note,
+		&newWatchlistEntry.Source,
+		&newWatchlistEntry.SourceRID,
+		&newWatchlistEntry.Active,
+		&newWatchlistEntry.CreatedBy,
+		&newWatchlistEntry.CreatedAt,
+		&newWatchlistEntry.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	statement, err := tx.Prepare(
+		`INSERT INTO watchlist_template (entry_rid, recording_mfcc, extractor)
+			VALUES ($1, $2, $3)`,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer statement.Close()
+
+	for _, vector := range vectors {
+		_, err = statement.Exec(newWatchlistEntry.RID, vector, extractor)
+		if err != nil {
+			return nil, err
+		}
+	}
+	newWatchlistEntry.Templates = len(vectors)
+
+	return newWatchlistEntry, tx.Commit()
+}
+
+// DeactivateWatchlistEntry stops screening against the entry, its templates are kept for the audit trail.
+func (r WatchlistDBHandler) DeactivateWatchlistEntry(rid uuid.UUID) (*model.WatchlistEntry, error) {
+	watchlistEntry := &model.WatchlistEntry{}
+
+	row := r.db.Instance.QueryRow(
+		`UPDATE
+			watchlist_entry
+		SET
+			active = false,
+			updated_at = CURRENT_TIMESTAMP
+		WHERE
+			rid = $1
+		RETURNING
+			id,
+			rid,
+			reason,
+			note,
+			source,
+			source_rid,
+			active,
+			(
+				SELECT
+					COUNT(*)
+				FROM
+					watchlist_template
+				WHERE
+					entry_rid = watchlist_entry.rid),
+			created_by,
+			created_at,
+			updated_at`,
+		rid,
+	)
+
+	err := row.Scan(
+		&watchlistEntry.ID,
+		&watchlistEntry.RID,
+		&watchlistEntry.Reason,
+		&watchlistEntry.Note,
+		&watchlistEntry.Source,
+		&watchlistEntry.SourceRID,
+		&watchlistEntry.Active,
+		&watchlistEntry.Templates,
+		&watchlistEntry.CreatedBy,
+		&watchlistEntry.CreatedAt,
+		&watchlistEntry.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return watchlistEntry, nil
+}
+
+// SelectWatchlistEntries returns the entries with the number of their templates, newest first.
+func (r WatchlistDBHandler) SelectWatchlistEntries(lastId int, entries int) ([]*model.WatchlistEntry, error) {
+	watchlistEntries := []*model.WatchlistEntry{}
+
+	rows, err := r.db.Instance.Query(
+		`SELECT
+			watchlist_entry.id,
+			watchlist_entry.rid,
+			watchlist_entry.reason,
+			watchlist_entry.note,
+			watchlist_entry.source,
+			watchlist_entry.source_rid,
+			watchlist_entry.active,
+			COUNT(watchlist_template.id),
+			watchlist_entry.created_by,
+			watchlist_entry.created_at,
+			watchlist_entry.updated_at
+		FROM
+			watchlist_entry
+			LEFT JOIN watchlist_template ON watchlist_template.entry_rid = watchlist_entry.rid
+		WHERE
+			0 = $1
+			OR watchlist_entry.id < $1
+		GROUP BY
+			watchlist_entry.id
+		ORDER BY
+			watchlist_entry.id DESC
+		LIMIT $2`,
+		lastId,
+		entries,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		watchlistEntry := &model.WatchlistEntry{}
+		err := rows.Scan(
+			&watchlistEntry.ID,
+			&watchlistEntry.RID,
+			&watchlistEntry.Reason,
+			&watchlistEntry.Note,
+			&watchlistEntry.Source,
+			&watchlistEntry.SourceRID,
+			&watchlistEntry.Active,
+			&watchlistEntry.Templates,
+			&watchlistEntry.CreatedBy,
+			&watchlistEntry.CreatedAt,
+			&watchlistEntry.UpdatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		watchlistEntries = append(watchlistEntries, watchlistEntry)
+	}
+
+	return watchlistEntries, rows.Err()
+}
+
+// SelectNearestWatchlistEntry returns the active entry with the template closest to the vector of the extractor,
+// the distance is that of the closest template. The watchlist is small, so all templates are scanned exactly.
+func (r WatchlistDBHandler) SelectNearestWatchlistEntry(vector model.Vector, metric model.DistanceMetric, extractor model.Extractor) (*model.WatchlistHit, error) {
+	operator, err := metric.Operator()
+	if err != nil {
+		return nil, err
+	}
+
+	watchlistHit := &model.WatchlistHit{}
+
+	row := r.db.Instance.QueryRow(
+		fmt.Sprintf(`SELECT
+			watchlist_entry.rid,
+			watchlist_entry.reason,
+			watchlist_template.recording_mfcc %s $1::vector AS distance
+		FROM
+			watchlist_template
+			JOIN watchlist_entry ON watchlist_entry.rid = watchlist_template.entry_rid
+		WHERE
+			watchlist_entry.active
+			AND watchlist_template.extractor = $2
+			AND vector_dims(watchlist_template.recording_mfcc) = vector_dims($1::vector)
+		ORDER BY
+			distance ASC
+		LIMIT 1`, operator),
+		vector,
+		extractor,
+	)
+
+	err = row.Scan(
+		&watchlistHit.EntryRID,
+		&watchlistHit.Reason,
+		&watchlistHit.Distance,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return watchlistHit, nil
+}
@@ -0,0 +1,105 @@
+package voice
+
+import (
+	"context"
+	"fmt"
+	"ht/model"
+	"math/rand"
+
+	"github.com/google/uuid"
+)
+
+// sentences are read out with the local matcher, which has no language model.
+var sentences = []string{
+	"The purple whale sings to the moon on Tuesdays.",
+	"A tiny dragon sells warm bread at the station.",
+	"My umbrella dreams of dancing in the desert.",
+	"Seven clocks argued about the color of noon.",
+	"The quiet robot paints clouds with a spoon.",
+	"A brave sock sailed across the kitchen sea.",
+	"Bananas whisper secrets to the sleepy cactus.",
+	"The library cat collects forgotten thunder.",
+	"Every pebble on the hill knows a silly song.",
+	"Our teapot once won a race against the wind.",
+}
+
+// LocalMatcher extracts averaged MFCC features from wav recordings in process. It is
+// deterministic and needs no python service, which is good enough for demos and tests,
+// but its features are not comparable with the ones of the jobs service.
+type LocalMatcher struct {
+	dimension int
+}
+
+func NewLocalMatcher(dimension int) (*LocalMatcher, error) {
+	if dimension > melFilters {
+		return nil, fmt.Errorf("local matcher supports at most %v dimensions", melFilters)
+	}
+	return &LocalMatcher{
+		dimension: dimension,
+	}, nil
+}
+
+func (r *LocalMatcher) Extractor() model.Extractor {
+	return model.NewExtractor("go-mfcc", "1")
+}
+
+func (r *LocalMatcher) Dimension() int {
+	return r.dimension
+}
+
+func (r *LocalMatcher) ExtractReferenceFeatures(ctx context.Context, userRid uuid.UUID, referenceSamples []*model.ReferenceSample) ([]*model.ReferenceSampleFeatures, error) {
+	features := make([]*model.ReferenceSampleFeatures, 0, len(referenceSamples))
+	for _, referenceSample := range referenceSamples {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		sampleFeatures := &model.ReferenceSampleFeatures{
+			RID:       referenceSample.RID,
+			Version:   referenceSample.UpdatedAt,
+			Extractor: r.Extractor(),
+		}
+		mfcc, err := r.recordingToMfcc(referenceSample.Recording)
+		if err != nil {
+			sampleFeatures.Error = err.Error()
+		} else {
+			sampleFeatures.RecordingMfcc = mfcc
+		}
+		features = append(features, sampleFeatures)
+	}
+	return features, nil
+}
+
+func (r *LocalMatcher) ExtractAttemptFeatures(ctx context.Context, identificationAttempt *model.IdentificationAttempt) (model.Vector, error) {
+	return r.ExtractFeatures(ctx, identificationAttempt.Recording)
+}
+
+func (r *LocalMatcher) ExtractFeatures(ctx context.Context, recording []byte) (model.Vector, error) {
+	mfcc, err := r.recordingToMfcc(recording)
+	if err != nil {
+		return nil, &ExtractionError{Cause: err}
+	}
+	return mfcc, nil
+}
+
+// CreateSentence picks one of a fixed set of sentences.
+func (r *LocalMatcher) CreateSentence(ctx context.Context) (string, error) {
+	return sentences[rand.Intn(len(sentences))], nil
+}
+
+// IsAvailable is always true, the local matcher runs in process.
+func (r *LocalMatcher) IsAvailable(ctx context.Context) bool {
+	return true
+}
+
+func (r *LocalMatcher) recordingToMfcc(recording []byte) (model.Vector, error) {
+	samples, sampleRate, err := decodeWav(recording)
+	if err != nil {
+		return nil, err
+	}
+	mfcc, err := extractMfcc(samples, sampleRate, r.dimension)
+	if err != nil {
+		return nil, err
+	}
+	return model.Vector(mfcc), nil
+}
@@ -0,0 +1,137 @@
+package voice
+
+import (
+	"context"
+	"errors"
+	"ht/model"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestNewLocalMatcher(t *testing.T) {
+	tests := []struct {
+		name      string
+		dimension int
+		wantErr   bool
+	}{
+		{"default dimension", 40, false},
+		{"all mel filters", melFilters, false},
+		{"more than the mel filters", melFilters + 1, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			matcher, err := NewLocalMatcher(test.dimension)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("NewLocalMatcher() error = %v, wantErr %v", err, test.wantErr)
+			}
+			if err == nil && matcher.Dimension() != test.dimension {
+				t.Errorf("Dimension() = %v, expected %v", matcher.Dimension(), test.dimension)
+			}
+		})
+	}
+}
+
+func TestLocalMatcherExtractFeatures(t *testing.T) {
+	matcher, err := NewLocalMatcher(20)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		recording []byte
+		wantErr   bool
+	}{
+		{"wideband tone", toneWav(16000, 1, 0.5, 220), false},
+		{"narrowband tone", toneWav(8000, 1, 0.5, 220), false},
+		{"silence", toneWav(16000, 1, 0), true},
+		{"too short", toneWav(16000, 0.05, 0.5, 220), true},
+		{"not a wav", []byte("not a recording"), true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			features, err := matcher.ExtractFeatures(context.Background(), test.recording)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("ExtractFeatures() error = %v, wantErr %v", err, test.wantErr)
+			}
+			extractionError := &ExtractionError{}
+			if err != nil && !errors.As(err, &extractionError) {
+				t.Errorf("error %v is not an ExtractionError", err)
+			}
+			if err == nil && len(features) != matcher.Dimension() {
+				t.Errorf("got %v features, expected %v", len(features), matcher.Dimension())
+			}
+		})
+	}
+}
+
+func TestLocalMatcherDistances(t *testing.T) {
+	matcher, err := NewLocalMatcher(20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	extract := func(recording []byte) model.Vector {
+		features, err := matcher.ExtractFeatures(context.Background(), recording)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return features
+	}
+
+	reference := extract(toneWav(16000, 1, 0.5, 220, 440))
+	same := extract(toneWav(16000, 1, 0.5, 220, 440))
+	quieter := extract(toneWav(16000, 1, 0.25, 220, 440))
+	other := extract(toneWav(16000, 1, 0.5, 1800, 3200))
+
+	distance := func(a model.Vector, b model.Vector) float64 {
+		sum := 0.0
+		for i := range a {
+			sum += float64((a[i] - b[i]) * (a[i] - b[i]))
+		}
+		return math.Sqrt(sum)
+	}
+	if d := distance(reference, same); d != 0 {
+		t.Errorf("distance of the same recording = %v, expected 0", d)
+	}
+	if distance(reference, quieter) >= distance(reference, other) {
+		t.Errorf("a quieter recording is not closer than other sounds: %v >= %v", distance(reference, quieter), distance(reference, other))
+	}
+}
+
+func TestLocalMatcherExtractReferenceFeatures(t *testing.T) {
+	matcher, err := NewLocalMatcher(20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	referenceSamples := []*model.ReferenceSample{
+		{RID: uuid.New(), Recording: toneWav(16000, 1, 0.5, 220), UpdatedAt: time.Now()},
+		{RID: uuid.New(), Recording: toneWav(16000, 1, 0)},
+	}
+
+	features, err := matcher.ExtractReferenceFeatures(context.Background(), uuid.New(), referenceSamples)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(features) != len(referenceSamples) {
+		t.Fatalf("got %v features, expected %v", len(features), len(referenceSamples))
+	}
+	if features[0].RID != referenceSamples[0].RID || len(features[0].RecordingMfcc) != 20 || features[0].Error != "" {
+		t.Errorf("features of the tone = %+v", features[0])
+	}
+	// a failing sample is reported in its error instead of failing all
+	if features[1].Error == "" || features[1].RecordingMfcc != nil {
+		t.Errorf("features of the silence = %+v", features[1])
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = matcher.ExtractReferenceFeatures(ctx, uuid.New(), referenceSamples)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("error with a cancelled context = %v, expected %v", err, context.Canceled)
+	}
+}
@@ -0,0 +1,78 @@
+package voice
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"ht/helper"
+	"ht/model"
+	"ht/server/jobs"
+	"strconv"
+
+	"github.com/google/uuid"
+)
+
+// ErrFeaturesPending is returned by extractors computing the features asynchronously,
+// the results are delivered through the callbacks of the jobs service instead.
+var ErrFeaturesPending = errors.New("features are delivered asynchronously")
+
+// ExtractionError is returned for recordings the features can not be extracted of,
+// retrying will not help.
+type ExtractionError struct {
+	Cause error
+}
+
+func (r *ExtractionError) Error() string {
+	return fmt.Sprintf("feature extraction failed: %v", r.Cause)
+}
+
+func (r *ExtractionError) Unwrap() error {
+	return r.Cause
+}
+
+// FeatureExtractor computes the feature vectors of recordings.
+type FeatureExtractor interface {
+	// Extractor returns the name and version the vectors are tagged with.
+	Extractor() model.Extractor
+	// Dimension returns the length of the vectors.
+	Dimension() int
+	// ExtractReferenceFeatures returns the features of the reference samples of the user,
+	// failures of single samples are reported in their Error.
+	ExtractReferenceFeatures(ctx context.Context, userRid uuid.UUID, referenceSamples []*model.ReferenceSample) ([]*model.ReferenceSampleFeatures, error)
+	// ExtractAttemptFeatures returns the features of the recording of the identification attempt.
+	ExtractAttemptFeatures(ctx context.Context, identificationAttempt *model.IdentificationAttempt) (model.Vector, error)
+	// ExtractFeatures returns the features of the recording synchronously, it never returns ErrFeaturesPending.
+	ExtractFeatures(ctx context.Context, recording []byte) (model.Vector, error)
+}
+
+// VoiceMatcher extracts features and creates the sentences users read out.
+type VoiceMatcher interface {
+	FeatureExtractor
+	// CreateSentence returns a new sentence for the user to read out.
+	CreateSentence(ctx context.Context) (string, error)
+	// IsAvailable returns false if recordings can not be processed now, e.g. the jobs service is down.
+	IsAvailable(ctx context.Context) bool
+}
+
+// NewVoiceMatcherFromEnv reads VOICE_MATCHER, either remote for the jobs service (default)
+// or local for the in-process implementation, which needs no python service.
+// VOICE_DIMENSION is the vector length, VOICE_REMOTE_EXTRACTOR the name@version the jobs service reports.
+func NewVoiceMatcherFromEnv(jobsClient *jobs.Client) (VoiceMatcher, error) {
+	dimension, err := strconv.Atoi(helper.GetEnvVariableWithDefault("VOICE_DIMENSION", "40"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid VOICE_DIMENSION: %v", err)
+	}
+	if dimension < 1 {
+		return nil, fmt.Errorf("VOICE_DIMENSION has to be at least 1")
+	}
+
+	switch matcher := helper.GetEnvVariableWithDefault("VOICE_MATCHER", "remote"); matcher {
+	case "remote":
+		extractor := model.Extractor(helper.GetEnvVariableWithDefault("VOICE_REMOTE_EXTRACTOR", string(model.LegacyExtractor)))
+		return NewRemoteMatcher(jobsClient, extractor, dimension), nil
+	case "local":
+		return NewLocalMatcher(dimension)
+	default:
+		return nil, fmt.Errorf("invalid VOICE_MATCHER: %v", matcher)
+	}
+}
@@ -0,0 +1,215 @@
+package voice
+
+import (
+	"fmt"
+	"math"
+	"math/cmplx"
+)
+
+const (
+	frameDuration   = 0.025
+	hopDuration     = 0.010
+	preEmphasis     = 0.97
+	melFilters      = 64
+	trimTopDb       = 60
+	minVoicedFrames = 10
+)
+
+// extractMfcc returns the mean over all frames of the mel frequency cepstral coefficients,
+// after trimming leading and trailing silence like librosa.effects.trim.
+func extractMfcc(samples []float64, sampleRate int, coefficients int) ([]float32, error) {
+	frames, err := mfccFrames(samples, sampleRate, coefficients)
+	if err != nil {
+		return nil, err
+	}
+
+	features := make([]float32, coefficients)
+	for c := range features {
+		mean := 0.0
+		for _, frame := range frames {
+			mean += frame[c] / float64(len(frames))
+		}
+		features[c] = float32(mean)
+	}
+	return features, nil
+}
+
+// mfccFrames returns the mel frequency cepstral coefficients of each frame in order,
+// after trimming leading and trailing silence.
+func mfccFrames(samples []float64, sampleRate int, coefficients int) ([][]float64, error) {
+	frameLength := int(frameDuration * float64(sampleRate))
+	hopLength := int(hopDuration * float64(sampleRate))
+	if frameLength < 2 || hopLength < 1 {
+		return nil, fmt.Errorf("sample rate %v too low", sampleRate)
+	}
+
+	samples = trimSilence(samples, frameLength, hopLength)
+	frameCount := 0
+	if len(samples) >= frameLength {
+		frameCount = 1 + (len(samples)-frameLength)/hopLength
+	}
+	if frameCount < minVoicedFrames {
+		return nil, fmt.Errorf("recording too short or silent")
+	}
+
+	fftLength := 1
+	for fftLength < frameLength {
+		fftLength *= 2
+	}
+
+	window := hammingWindow(frameLength)
+	filterbank := melFilterbank(melFilters, fftLength, sampleRate)
+	frames := make([][]float64, frameCount)
+	frame := make([]complex128, fftLength)
+	logEnergies := make([]float64, melFilters)
+
+	for i := 0; i < frameCount; i++ {
+		start := i * hopLength
+		previous := 0.0
+		if start > 0 {
+			previous = samples[start-1]
+		}
+		for j := range frame {
+			frame[j] = 0
+		}
+		for j := 0; j < frameLength; j++ {
+			frame[j] = complex((samples[start+j]-preEmphasis*previous)*window[j], 0)
+			previous = samples[start+j]
+		}
+
+		spectrum := fft(frame)
+		for m, filter := range filterbank {
+			energy := 0.0
+			for k, weight := range filter {
+				if weight > 0 {
+					power := cmplx.Abs(spectrum[k])
+					energy += weight * power * power / float64(fftLength)
+				}
+			}
+			logEnergies[m] = math.Log(energy + 1e-10)
+		}
+
+		frames[i] = dct(logEnergies, coefficients)
+	}
+
+	return frames, nil
+}
+
+// trimSilence removes the leading and trailing frames more than trimTopDb below the loudest frame.
+func trimSilence(samples []float64, frameLength int, hopLength int) []float64 {
+	if len(samples) < frameLength {
+		return samples
+	}
+
+	frameCount := 1 + (len(samples)-frameLength)/hopLength
+	rms := make([]float64, frameCount)
+	maxRms := 0.0
+	for i := range rms {
+		sum := 0.0
+		for _, sample := range samples[i*hopLength : i*hopLength+frameLength] {
+			sum += sample * sample
+		}
+		rms[i] = math.Sqrt(sum / float64(frameLength))
+		maxRms = math.Max(maxRms, rms[i])
+	}
+	if maxRms == 0 {
+		return nil
+	}
+
+	threshold := maxRms * math.Pow(10, -trimTopDb/20.0)
+	first, last := 0, frameCount-1
+	for first < last && rms[first] < threshold {
+		first++
+	}
+	for last > first && rms[last] < threshold {
+		last--
+	}
+
+	return samples[first*hopLength : min(len(samples), last*hopLength+frameLength)]
+}
+
+func hammingWindow(length int) []float64 {
+	window := make([]float64, length)
+	for i := range window {
+		window[i] = 0.54 - 0.46*math.Cos(2*math.Pi*float64(i)/float64(length-1))
+	}
+	return window
+}
+
+func hzToMel(hz float64) float64 {
+	return 2595 * math.Log10(1+hz/700)
+}
+
+func melToHz(mel float64) float64 {
+	return 700 * (math.Pow(10, mel/2595) - 1)
+}
+
+// melFilterbank returns triangular filters over the first half of the fft bins, evenly spaced on the mel scale.
+func melFilterbank(filters int, fftLength int, sampleRate int) [][]float64 {
+	bins := fftLength/2 + 1
+	maxMel := hzToMel(float64(sampleRate) / 2)
+
+	centers := make([]float64, filters+2)
+	for i := range centers {
+		hz := melToHz(maxMel * float64(i) / float64(filters+1))
+		centers[i] = hz * float64(fftLength) / float64(sampleRate)
+	}
+
+	filterbank := make([][]float64, filters)
+	for m := 0; m < filters; m++ {
+		filterbank[m] = make([]float64, bins)
+		left, center, right := centers[m], centers[m+1], centers[m+2]
+		for k := 0; k < bins; k++ {
+			bin := float64(k)
+			if bin > left && bin <= center && center > left {
+				filterbank[m][k] = (bin - left) / (center - left)
+			} else if bin > center && bin < right && right > center {
+				filterbank[m][k] = (right - bin) / (right - center)
+			}
+		}
+	}
+	return filterbank
+}
+
+// dct returns the first coefficients of the orthonormal DCT-II of the values.
+func dct(values []float64, coefficients int) []float64 {
+	n := float64(len(values))
+	result := make([]float64, coefficients)
+	for k := range result {
+		sum := 0.0
+		for i, value := range values {
+			sum += value * math.Cos(math.Pi*float64(k)*(float64(i)+0.5)/n)
+		}
+		scale := math.Sqrt(2 / n)
+		if k == 0 {
+			scale = math.Sqrt(1 / n)
+		}
+		result[k] = sum * scale
+	}
+	return result
+}
+
+// fft is a recursive radix-2 fast fourier transform, the length has to be a power of two.
+func fft(values []complex128) []complex128 {
+	n := len(values)
+	if n == 1 {
+		return []complex128{values[0]}
+	}
+
+	even := make([]complex128, n/2)
+	odd := make([]complex128, n/2)
+	for i := 0; i < n/2; i++ {
+		even[i] = values[2*i]
+		odd[i] = values[2*i+1]
+	}
+	evenSpectrum := fft(even)
+	oddSpectrum := fft(odd)
+
+	spectrum := make([]complex128, n)
+	for k := 0; k < n/2; k++ {
+		twiddle := cmplx.Exp(complex(0, -2*math.Pi*float64(k)/float64(n))) * oddSpectrum[k]
+		spectrum[k] = evenSpectrum[k] + twiddle
+		spectrum[k+n/2] = evenSpectrum[k] - twiddle
+	}
+	return spectrum
+}
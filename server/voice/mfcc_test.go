@@ -0,0 +1,78 @@
+package voice
+
+import (
+	"math"
+	"testing"
+)
+
+func TestExtractMfcc(t *testing.T) {
+	tone, _, err := decodeWav(toneWav(16000, 1, 0.5, 220, 440))
+	if err != nil {
+		t.Fatal(err)
+	}
+	padded := append(append(make([]float64, 8000), tone...), make([]float64, 8000)...)
+
+	tests := []struct {
+		name       string
+		samples    []float64
+		sampleRate int
+		// tolerance is relative to the coefficients of the tone, trimming works on whole frames
+		tolerance float64
+		wantErr   bool
+	}{
+		{"tone", tone, 16000, 0, false},
+		{"silence around the tone is trimmed", padded, 16000, 0.1, false},
+		{"silence", make([]float64, 16000), 16000, 0, true},
+		{"too short", tone[:1000], 16000, 0, true},
+		{"sample rate too low", tone, 50, 0, true},
+	}
+
+	reference, err := extractMfcc(tone, 16000, 13)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			features, err := extractMfcc(test.samples, test.sampleRate, 13)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("extractMfcc() error = %v, wantErr %v", err, test.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if len(features) != 13 {
+				t.Fatalf("got %v coefficients, expected 13", len(features))
+			}
+			for c := range features {
+				if math.Abs(float64(features[c]-reference[c])) > test.tolerance*math.Max(1, math.Abs(float64(reference[c]))) {
+					t.Errorf("coefficient %v = %v, expected %v", c, features[c], reference[c])
+				}
+			}
+		})
+	}
+}
+
+func TestExtractMfccIsMeanOfFrames(t *testing.T) {
+	samples, sampleRate, err := decodeWav(toneWav(8000, 1, 0.5, 300, 900))
+	if err != nil {
+		t.Fatal(err)
+	}
+	features, err := extractMfcc(samples, sampleRate, 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	frames, err := mfccFrames(samples, sampleRate, 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for c := range features {
+		mean := 0.0
+		for _, frame := range frames {
+			mean += frame[c] / float64(len(frames))
+		}
+		if float32(mean) != features[c] {
+			t.Errorf("coefficient %v = %v, expected the frame mean %v", c, features[c], mean)
+		}
+	}
+}
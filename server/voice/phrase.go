@@ -0,0 +1,91 @@
+package voice
+
+import (
+	"fmt"
+	"math"
+)
+
+const (
+	phraseCoefficients = 13
+	// maxPhraseStretch is how much longer one recording of a phrase may be than the other.
+	maxPhraseStretch = 2.0
+)
+
+// PhraseTemplate is the sequence of normalised cepstral frames of a recording. Unlike the averaged
+// features it keeps the order of the sounds, so it tells what was said rather than who said it.
+type PhraseTemplate [][]float64
+
+// ExtractPhrase returns the phrase template of a wav recording. The first coefficient, which is the
+// loudness, is dropped and the others are normalised to zero mean and unit variance over the
+// recording, which removes most of the differences between microphones and channels.
+func ExtractPhrase(recording []byte) (PhraseTemplate, error) {
+	samples, sampleRate, err := decodeWav(recording)
+	if err != nil {
+		return nil, err
+	}
+	frames, err := mfccFrames(samples, sampleRate, phraseCoefficients)
+	if err != nil {
+		return nil, err
+	}
+
+	template := make(PhraseTemplate, len(frames))
+	for i, frame := range frames {
+		template[i] = append([]float64{}, frame[1:]...)
+	}
+	for c := 0; c < phraseCoefficients-1; c++ {
+		mean := 0.0
+		for _, frame := range template {
+			mean += frame[c] / float64(len(template))
+		}
+		variance := 0.0
+		for _, frame := range template {
+			variance += (frame[c] - mean) * (frame[c] - mean) / float64(len(template))
+		}
+		deviation := math.Sqrt(variance)
+		if deviation == 0 {
+			deviation = 1
+		}
+		for _, frame := range template {
+			frame[c] = (frame[c] - mean) / deviation
+		}
+	}
+	return template, nil
+}
+
+// PhraseDistance returns the mean distance between the frames of both templates aligned with dynamic
+// time warping, so the same phrase said at a different pace stays close. Templates whose lengths differ
+// by more than maxPhraseStretch can not be the same phrase and are infinitely far apart.
+func PhraseDistance(a PhraseTemplate, b PhraseTemplate) (float64, error) {
+	if len(a) == 0 || len(b) == 0 {
+		return 0, fmt.Errorf("empty phrase template")
+	}
+	if float64(max(len(a), len(b))) > maxPhraseStretch*float64(min(len(a), len(b))) {
+		return math.Inf(1), nil
+	}
+
+	// symmetric dynamic time warping, diagonal steps count twice so the cost is normalised by len(a)+len(b)
+	previous := make([]float64, len(b)+1)
+	current := make([]float64, len(b)+1)
+	for j := range previous {
+		previous[j] = math.Inf(1)
+	}
+	previous[0] = 0
+	for i := 1; i <= len(a); i++ {
+		current[0] = math.Inf(1)
+		for j := 1; j <= len(b); j++ {
+			cost := frameDistance(a[i-1], b[j-1])
+			current[j] = min(previous[j-1]+2*cost, previous[j]+cost, current[j-1]+cost)
+		}
+		previous, current = current, previous
+	}
+
+	return previous[len(b)] / float64(len(a)+len(b)), nil
+}
+
+func frameDistance(a []float64, b []float64) float64 {
+	sum := 0.0
+	for i := range a {
+		sum += (a[i] - b[i]) * (a[i] - b[i])
+	}
+	return math.Sqrt(sum)
+}
@@ -0,0 +1,101 @@
+package voice
+
+import (
+	"math"
+	"testing"
+)
+
+func TestPhraseDistance(t *testing.T) {
+	a := PhraseTemplate{{0, 0}, {1, 0}, {2, 0}}
+	tests := []struct {
+		name     string
+		a        PhraseTemplate
+		b        PhraseTemplate
+		expected float64
+		wantErr  bool
+	}{
+		{"identical", a, a, 0, false},
+		{"offset by one", a, PhraseTemplate{{0, 1}, {1, 1}, {2, 1}}, 1, false},
+		{"repeated frame is aligned", a, PhraseTemplate{{0, 0}, {1, 0}, {1, 0}, {2, 0}}, 0, false},
+		{"too much longer", a, PhraseTemplate{{0, 0}, {0, 0}, {1, 0}, {1, 0}, {2, 0}, {2, 0}, {2, 0}}, math.Inf(1), false},
+		{"empty", a, PhraseTemplate{}, 0, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			distance, err := PhraseDistance(test.a, test.b)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("PhraseDistance() error = %v, wantErr %v", err, test.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if math.Abs(distance-test.expected) > 1e-9 {
+				t.Errorf("PhraseDistance() = %v, expected %v", distance, test.expected)
+			}
+			reverse, err := PhraseDistance(test.b, test.a)
+			if err != nil || reverse != distance {
+				t.Errorf("PhraseDistance() is not symmetric: %v and %v (%v)", distance, reverse, err)
+			}
+		})
+	}
+}
+
+func TestExtractPhrase(t *testing.T) {
+	extract := func(recording []byte) PhraseTemplate {
+		template, err := ExtractPhrase(recording)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return template
+	}
+	phrase := extract(toneWav(16000, 1.2, 0.5, 300, 700, 1200, 2000))
+
+	tests := []struct {
+		name      string
+		recording []byte
+		closer    bool
+	}{
+		{"same phrase quieter", toneWav(16000, 1.2, 0.1, 300, 700, 1200, 2000), true},
+		{"same phrase slower", toneWav(16000, 1.8, 0.5, 300, 700, 1200, 2000), true},
+		{"same phrase on the phone", toneWav(8000, 1.2, 0.5, 300, 700, 1200, 2000), true},
+		{"same sounds in another order", toneWav(16000, 1.2, 0.5, 2000, 1200, 700, 300), false},
+		{"other sounds", toneWav(16000, 1.2, 0.5, 500, 2500, 900, 1600), false},
+	}
+
+	// a different phrase has to be further away than any variation of the same one
+	threshold := 0.0
+	distances := map[string]float64{}
+	for _, test := range tests {
+		distance, err := PhraseDistance(phrase, extract(test.recording))
+		if err != nil {
+			t.Fatal(err)
+		}
+		distances[test.name] = distance
+		if test.closer {
+			threshold = math.Max(threshold, distance)
+		}
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if !test.closer && distances[test.name] <= threshold {
+				t.Errorf("distance %v of a different phrase is not above %v of the same one", distances[test.name], threshold)
+			}
+		})
+	}
+
+	for c := 0; c < phraseCoefficients-1; c++ {
+		mean := 0.0
+		for _, frame := range phrase {
+			mean += frame[c] / float64(len(phrase))
+		}
+		if math.Abs(mean) > 1e-9 {
+			t.Errorf("coefficient %v has mean %v, expected 0", c, mean)
+		}
+	}
+
+	_, err := ExtractPhrase(toneWav(16000, 1, 0))
+	if err == nil {
+		t.Errorf("ExtractPhrase() of silence did not fail")
+	}
+}
@@ -0,0 +1,94 @@
+package voice
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"ht/model"
+	"ht/server/jobs"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// RemoteMatcher delegates to the python jobs service, features are posted back
+// to the callback endpoints, so the extraction always returns ErrFeaturesPending.
+type RemoteMatcher struct {
+	jobsClient *jobs.Client
+	extractor  model.Extractor
+	dimension  int
+}
+
+func NewRemoteMatcher(jobsClient *jobs.Client, extractor model.Extractor, dimension int) *RemoteMatcher {
+	return &RemoteMatcher{
+		jobsClient: jobsClient,
+		extractor:  extractor,
+		dimension:  dimension,
+	}
+}
+
+func (r *RemoteMatcher) Extractor() model.Extractor {
+	return r.extractor
+}
+
+func (r *RemoteMatcher) Dimension() int {
+	return r.dimension
+}
+
+func (r *RemoteMatcher) ExtractReferenceFeatures(ctx context.Context, userRid uuid.UUID, referenceSamples []*model.ReferenceSample) ([]*model.ReferenceSampleFeatures, error) {
+	request := &jobs.ProcessReferenceRecordingsRequest{UserRID: userRid.String()}
+	for _, referenceSample := range referenceSamples {
+		request.Samples = append(request.Samples, &jobs.ReferenceRecording{
+			RID:       referenceSample.RID.String(),
+			Recording: referenceSample.Recording,
+			Version:   referenceSample.UpdatedAt.Format(time.RFC3339Nano),
+		})
+	}
+
+	err := r.jobsClient.ProcessReferenceRecordings(ctx, request)
+	if err != nil {
+		return nil, err
+	}
+	return nil, ErrFeaturesPending
+}
+
+func (r *RemoteMatcher) ExtractAttemptFeatures(ctx context.Context, identificationAttempt *model.IdentificationAttempt) (model.Vector, error) {
+	err := r.jobsClient.Identify(ctx, &jobs.IdentifyRequest{
+		UserRID:   identificationAttempt.UserRID.String(),
+		RID:       identificationAttempt.RID.String(),
+		Recording: identificationAttempt.Recording,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return nil, ErrFeaturesPending
+}
+
+func (r *RemoteMatcher) ExtractFeatures(ctx context.Context, recording []byte) (model.Vector, error) {
+	response, err := r.jobsClient.ExtractFeatures(ctx, recording)
+	statusError := &jobs.StatusError{}
+	if errors.As(err, &statusError) && statusError.StatusCode == http.StatusUnprocessableEntity {
+		return nil, &ExtractionError{Cause: err}
+	} else if err != nil {
+		return nil, err
+	}
+	if model.Extractor(response.Extractor) != r.extractor {
+		return nil, fmt.Errorf("jobs service extracted with %v, expected %v", response.Extractor, r.extractor)
+	}
+	return model.Vector(response.RecordingMfcc), nil
+}
+
+func (r *RemoteMatcher) CreateSentence(ctx context.Context) (string, error) {
+	return r.jobsClient.CreateSentence(ctx)
+}
+
+// IsAvailable returns false while the circuit breaker of the jobs client is open or the jobs service is not healthy.
+func (r *RemoteMatcher) IsAvailable(ctx context.Context) bool {
+	return !r.jobsClient.IsCircuitOpen() && r.jobsClient.Health(ctx) == nil
+}
+
+// Health probes the jobs service.
+func (r *RemoteMatcher) Health(ctx context.Context) error {
+	return r.jobsClient.Health(ctx)
+}
@@ -0,0 +1,113 @@
+package voice
+
+import (
+	"encoding/binary"
+	"fmt"
+	"ht/model"
+)
+
+// Encoding is the encoding of an uploaded recording.
+type Encoding string
+
+const (
+	// EncodingContainer is a recording with a header, e.g. the webm or wav of the browser
+	// or a wav of a telephony system. Its channel is detected from the header.
+	EncodingContainer Encoding = ""
+	// EncodingMuLaw is headerless 8 kHz mono G.711 µ-law, as exported by north american and japanese telephony systems.
+	EncodingMuLaw Encoding = "mulaw"
+	// EncodingALaw is headerless 8 kHz mono G.711 A-law, as exported by european telephony systems.
+	EncodingALaw Encoding = "alaw"
+)
+
+// telephonySampleRate is the sample rate of G.711.
+const telephonySampleRate = 8000
+
+// NormaliseRecording prepares an uploaded recording for storage and returns its channel.
+// Headerless G.711 is wrapped into a wav, so every extractor can read it without knowing
+// the encoding. Recordings with a header are stored as they are.
+func NormaliseRecording(recording []byte, encoding Encoding) ([]byte, model.Channel, error) {
+	if len(recording) == 0 {
+		return nil, "", fmt.Errorf("empty recording")
+	}
+
+	switch encoding {
+	case EncodingContainer:
+		return recording, DetectChannel(recording), nil
+	case EncodingMuLaw:
+		return wrapG711(recording, wavFormatMuLaw), model.ChannelNarrowband, nil
+	case EncodingALaw:
+		return wrapG711(recording, wavFormatALaw), model.ChannelNarrowband, nil
+	default:
+		return nil, "", fmt.Errorf("unsupported recording encoding: %v", encoding)
+	}
+}
+
+// DetectChannel returns the channel of a recording with a header. Wavs are narrowband up to 8 kHz,
+// everything else is recorded by the browser and wideband.
+func DetectChannel(recording []byte) model.Channel {
+	if !isWav(recording) {
+		return model.ChannelWideband
+	}
+	_, sampleRate, err := decodeWav(recording)
+	if err != nil {
+		// formats the local decoder does not know are left to the extractor
+		return model.ChannelWideband
+	}
+	return model.ChannelForSampleRate(sampleRate)
+}
+
+// wrapG711 prepends the wav header of 8 kHz mono G.711 with the format to the samples.
+func wrapG711(samples []byte, format uint16) []byte {
+	// non-PCM formats have an extended fmt chunk and a fact chunk with the number of samples
+	header := make([]byte, 0, 58)
+	header = append(header, "RIFF"...)
+	header = binary.LittleEndian.AppendUint32(header, uint32(50+len(samples)+len(samples)%2))
+	header = append(header, "WAVE"...)
+
+	header = append(header, "fmt "...)
+	header = binary.LittleEndian.AppendUint32(header, 18)
+	header = binary.LittleEndian.AppendUint16(header, format)
+	header = binary.LittleEndian.AppendUint16(header, 1)
+	header = binary.LittleEndian.AppendUint32(header, telephonySampleRate)
+	header = binary.LittleEndian.AppendUint32(header, telephonySampleRate)
+	header = binary.LittleEndian.AppendUint16(header, 1)
+	header = binary.LittleEndian.AppendUint16(header, 8)
+	header = binary.LittleEndian.AppendUint16(header, 0)
+
+	header = append(header, "fact"...)
+	header = binary.LittleEndian.AppendUint32(header, 4)
+	header = binary.LittleEndian.AppendUint32(header, uint32(len(samples)))
+
+	header = append(header, "data"...)
+	header = binary.LittleEndian.AppendUint32(header, uint32(len(samples)))
+
+	wav := append(header, samples...)
+	if len(samples)%2 == 1 {
+		wav = append(wav, 0)
+	}
+	return wav
+}
+
+// muLawToLinear expands a G.711 µ-law sample to 16 bit linear PCM.
+func muLawToLinear(sample byte) int16 {
+	sample = ^sample
+	magnitude := (int(sample&0x0F)<<3 + 0x84) << ((sample & 0x70) >> 4)
+	if sample&0x80 != 0 {
+		return int16(0x84 - magnitude)
+	}
+	return int16(magnitude - 0x84)
+}
+
+// aLawToLinear expands a G.711 A-law sample to 16 bit linear PCM.
+func aLawToLinear(sample byte) int16 {
+	sample ^= 0x55
+	magnitude := int(sample&0x0F)<<4 + 8
+	segment := (sample & 0x70) >> 4
+	if segment > 0 {
+		magnitude = (magnitude + 0x100) << (segment - 1)
+	}
+	if sample&0x80 != 0 {
+		return int16(magnitude)
+	}
+	return int16(-magnitude)
+}
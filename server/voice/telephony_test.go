@@ -0,0 +1,104 @@
+package voice
+
+import (
+	"ht/model"
+	"testing"
+)
+
+func TestMuLawToLinear(t *testing.T) {
+	tests := []struct {
+		name     string
+		sample   byte
+		expected int16
+	}{
+		{"positive zero", 0xFF, 0},
+		{"negative zero", 0x7F, 0},
+		{"positive maximum", 0x80, 32124},
+		{"negative maximum", 0x00, -32124},
+		{"smallest positive step", 0xFE, 8},
+		{"smallest negative step", 0x7E, -8},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := muLawToLinear(test.sample); got != test.expected {
+				t.Errorf("muLawToLinear(%#x) = %v, expected %v", test.sample, got, test.expected)
+			}
+		})
+	}
+}
+
+func TestALawToLinear(t *testing.T) {
+	tests := []struct {
+		name     string
+		sample   byte
+		expected int16
+	}{
+		{"smallest positive", 0xD5, 8},
+		{"smallest negative", 0x55, -8},
+		{"positive maximum", 0xAA, 32256},
+		{"negative maximum", 0x2A, -32256},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := aLawToLinear(test.sample); got != test.expected {
+				t.Errorf("aLawToLinear(%#x) = %v, expected %v", test.sample, got, test.expected)
+			}
+		})
+	}
+}
+
+func TestNormaliseRecording(t *testing.T) {
+	g711 := []byte{0xFF, 0x80, 0x00}
+	tests := []struct {
+		name      string
+		recording []byte
+		encoding  Encoding
+		channel   model.Channel
+		samples   []float64
+		wantErr   bool
+	}{
+		{"mulaw is wrapped", g711, EncodingMuLaw, model.ChannelNarrowband, []float64{0, 32124.0 / 32768, -32124.0 / 32768}, false},
+		{"alaw is wrapped", []byte{0xD5, 0x55}, EncodingALaw, model.ChannelNarrowband, []float64{8.0 / 32768, -8.0 / 32768}, false},
+		{"wideband wav", wrapPCM16([]byte{0, 0, 0, 0}, 16000), EncodingContainer, model.ChannelWideband, []float64{0, 0}, false},
+		{"narrowband wav", wrapPCM16([]byte{0, 0, 0, 0}, 8000), EncodingContainer, model.ChannelNarrowband, []float64{0, 0}, false},
+		{"browser recording", []byte("\x1aE\xdf\xa3webm"), EncodingContainer, model.ChannelWideband, nil, false},
+		{"empty recording", nil, EncodingMuLaw, "", nil, true},
+		{"unknown encoding", g711, Encoding("opus"), "", nil, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			recording, channel, err := NormaliseRecording(test.recording, test.encoding)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("NormaliseRecording() error = %v, wantErr %v", err, test.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if channel != test.channel {
+				t.Errorf("channel = %v, expected %v", channel, test.channel)
+			}
+			if test.samples == nil {
+				return
+			}
+
+			samples, sampleRate, err := decodeWav(recording)
+			if err != nil {
+				t.Fatalf("decodeWav() error = %v", err)
+			}
+			if sampleRate != telephonySampleRate && test.encoding != EncodingContainer {
+				t.Errorf("sample rate = %v, expected %v", sampleRate, telephonySampleRate)
+			}
+			if len(samples) != len(test.samples) {
+				t.Fatalf("got %v samples, expected %v", len(samples), len(test.samples))
+			}
+			for i := range samples {
+				if samples[i] != test.samples[i] {
+					t.Errorf("sample %v = %v, expected %v", i, samples[i], test.samples[i])
+				}
+			}
+		})
+	}
+}
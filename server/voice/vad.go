@@ -0,0 +1,218 @@
+package voice
+
+import (
+	"encoding/binary"
+	"fmt"
+	"ht/helper"
+	"ht/model"
+	"math"
+	"strconv"
+	"time"
+)
+
+const (
+	// vadFrameDuration is the length of the frames the voice activity is detected in.
+	vadFrameDuration = 20 * time.Millisecond
+	// samples at clipLevel count as clipped, frames with more than clipRatio clipped samples are too loud
+	clipLevel = 0.99
+	clipRatio = 0.01
+
+	minStreamSampleRate = telephonySampleRate
+	maxStreamSampleRate = 48000
+)
+
+// VADConfig configures the voice activity detection of streamed recordings.
+type VADConfig struct {
+	// SpeechLevel is the level in dBFS from which a frame counts as speech.
+	SpeechLevel float64
+	// MinSpeech is the speech needed to complete the recording, which ends after EndSilence without speech.
+	MinSpeech  time.Duration
+	EndSilence time.Duration
+	// SilenceHint is the time without speech after which the speaker is asked to speak.
+	SilenceHint time.Duration
+	// MaxDuration ends the recording, it is complete if it contains MinSpeech.
+	MaxDuration time.Duration
+}
+
+func NewVADConfigFromEnv() (*VADConfig, error) {
+	speechLevel, err := strconv.ParseFloat(helper.GetEnvVariableWithDefault("VAD_SPEECH_LEVEL_DB", "-45"), 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid VAD_SPEECH_LEVEL_DB: %v", err)
+	}
+	minSpeechMs, err := strconv.Atoi(helper.GetEnvVariableWithDefault("VAD_MIN_SPEECH_MS", "2500"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid VAD_MIN_SPEECH_MS: %v", err)
+	}
+	endSilenceMs, err := strconv.Atoi(helper.GetEnvVariableWithDefault("VAD_END_SILENCE_MS", "800"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid VAD_END_SILENCE_MS: %v", err)
+	}
+	silenceHintMs, err := strconv.Atoi(helper.GetEnvVariableWithDefault("VAD_SILENCE_HINT_MS", "2000"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid VAD_SILENCE_HINT_MS: %v", err)
+	}
+	maxDurationSeconds, err := strconv.Atoi(helper.GetEnvVariableWithDefault("VAD_MAX_DURATION_SECONDS", "10"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid VAD_MAX_DURATION_SECONDS: %v", err)
+	}
+	if speechLevel >= 0 {
+		return nil, fmt.Errorf("VAD_SPEECH_LEVEL_DB has to be below 0")
+	}
+	if minSpeechMs < 1 || endSilenceMs < 1 || silenceHintMs < 1 || maxDurationSeconds < 1 {
+		return nil, fmt.Errorf("VAD_MIN_SPEECH_MS, VAD_END_SILENCE_MS, VAD_SILENCE_HINT_MS and VAD_MAX_DURATION_SECONDS have to be at least 1")
+	}
+	if time.Duration(minSpeechMs)*time.Millisecond > time.Duration(maxDurationSeconds)*time.Second {
+		return nil, fmt.Errorf("VAD_MIN_SPEECH_MS has to fit into VAD_MAX_DURATION_SECONDS")
+	}
+
+	return &VADConfig{
+		SpeechLevel: speechLevel,
+		MinSpeech:   time.Duration(minSpeechMs) * time.Millisecond,
+		EndSilence:  time.Duration(endSilenceMs) * time.Millisecond,
+		SilenceHint: time.Duration(silenceHintMs) * time.Millisecond,
+		MaxDuration: time.Duration(maxDurationSeconds) * time.Second,
+	}, nil
+}
+
+// StreamRecorder collects the chunks of a streamed recording of mono 16 bit little endian PCM
+// and detects the voice activity in them frame by frame.
+type StreamRecorder struct {
+	config     *VADConfig
+	sampleRate int
+	frameSize  int
+	pcm        []byte
+	frame      []float64
+	state      model.VoiceActivityState
+	// frames is the number of detected frames, silentFrames the number of frames since the last speech
+	frames       int
+	speechFrames int
+	silentFrames int
+}
+
+func NewStreamRecorder(config *VADConfig, sampleRate int) (*StreamRecorder, error) {
+	if sampleRate < minStreamSampleRate || sampleRate > maxStreamSampleRate {
+		return nil, fmt.Errorf("sample rate has to be between %v and %v Hz, got %v", minStreamSampleRate, maxStreamSampleRate, sampleRate)
+	}
+
+	frameSize := sampleRate * int(vadFrameDuration/time.Millisecond) / 1000
+	return &StreamRecorder{
+		config:     config,
+		sampleRate: sampleRate,
+		frameSize:  frameSize,
+		pcm:        make([]byte, 0, int(config.MaxDuration.Seconds()*float64(sampleRate))*2),
+		frame:      make([]float64, 0, frameSize),
+		state:      model.VoiceActivityStateListening,
+	}, nil
+}
+
+// Write adds the chunk to the recording and returns the voice activity after it.
+// The recording ends with a final state, later chunks are ignored.
+func (r *StreamRecorder) Write(chunk []byte) (*model.VoiceActivity, error) {
+	if len(chunk)%2 != 0 {
+		return nil, fmt.Errorf("chunk of %v bytes does not contain whole 16 bit samples", len(chunk))
+	}
+
+	tooLoud := false
+	for i := 0; i < len(chunk) && !r.state.IsFinal(); i += 2 {
+		r.pcm = append(r.pcm, chunk[i], chunk[i+1])
+		r.frame = append(r.frame, float64(int16(binary.LittleEndian.Uint16(chunk[i:i+2])))/32768)
+		if len(r.frame) < r.frameSize {
+			continue
+		}
+
+		speech, clipped := detectFrame(r.frame, r.config.SpeechLevel)
+		r.frame = r.frame[:0]
+		tooLoud = tooLoud || clipped
+		r.detect(speech)
+	}
+
+	if tooLoud && !r.state.IsFinal() {
+		r.state = model.VoiceActivityStateTooLoud
+	}
+
+	return r.Activity(), nil
+}
+
+// detect moves the recording to the state after the next frame.
+func (r *StreamRecorder) detect(speech bool) {
+	r.frames++
+	if speech {
+		r.speechFrames++
+		r.silentFrames = 0
+	} else {
+		r.silentFrames++
+	}
+
+	enoughSpeech := r.duration(r.speechFrames) >= r.config.MinSpeech
+	switch {
+	case enoughSpeech && r.duration(r.silentFrames) >= r.config.EndSilence:
+		r.state = model.VoiceActivityStateComplete
+	case r.duration(r.frames) >= r.config.MaxDuration && enoughSpeech:
+		r.state = model.VoiceActivityStateComplete
+	case r.duration(r.frames) >= r.config.MaxDuration:
+		r.state = model.VoiceActivityStateInsufficient
+	case r.duration(r.silentFrames) >= r.config.SilenceHint:
+		r.state = model.VoiceActivityStateSilent
+	case r.speechFrames == 0:
+		r.state = model.VoiceActivityStateListening
+	default:
+		r.state = model.VoiceActivityStateSpeaking
+	}
+}
+
+func (r *StreamRecorder) duration(frames int) time.Duration {
+	return time.Duration(frames) * vadFrameDuration
+}
+
+// Activity returns the current voice activity of the recording.
+func (r *StreamRecorder) Activity() *model.VoiceActivity {
+	return &model.VoiceActivity{
+		State:         r.state,
+		SpeechMs:      r.duration(r.speechFrames).Milliseconds(),
+		DurationMs:    r.duration(r.frames).Milliseconds(),
+		MinSpeechMs:   r.config.MinSpeech.Milliseconds(),
+		MaxDurationMs: r.config.MaxDuration.Milliseconds(),
+	}
+}
+
+// Recording returns the recording so far as wav and its channel.
+func (r *StreamRecorder) Recording() ([]byte, model.Channel) {
+	return wrapPCM16(r.pcm, r.sampleRate), model.ChannelForSampleRate(r.sampleRate)
+}
+
+// detectFrame returns whether the frame is speech by its level and whether it is clipped.
+func detectFrame(frame []float64, speechLevel float64) (bool, bool) {
+	sum := 0.0
+	clipped := 0
+	for _, sample := range frame {
+		sum += sample * sample
+		if math.Abs(sample) >= clipLevel {
+			clipped++
+		}
+	}
+
+	level := 10 * math.Log10(sum/float64(len(frame))+1e-12)
+	return level >= speechLevel, float64(clipped) > clipRatio*float64(len(frame))
+}
+
+// wrapPCM16 prepends the wav header of mono 16 bit PCM with the sample rate to the samples.
+func wrapPCM16(samples []byte, sampleRate int) []byte {
+	header := make([]byte, 0, 44)
+	header = append(header, "RIFF"...)
+	header = binary.LittleEndian.AppendUint32(header, uint32(36+len(samples)))
+	header = append(header, "WAVE"...)
+
+	header = append(header, "fmt "...)
+	header = binary.LittleEndian.AppendUint32(header, 16)
+	header = binary.LittleEndian.AppendUint16(header, wavFormatPCM)
+	header = binary.LittleEndian.AppendUint16(header, 1)
+	header = binary.LittleEndian.AppendUint32(header, uint32(sampleRate))
+	header = binary.LittleEndian.AppendUint32(header, uint32(sampleRate*2))
+	header = binary.LittleEndian.AppendUint16(header, 2)
+	header = binary.LittleEndian.AppendUint16(header, 16)
+
+	header = append(header, "data"...)
+	header = binary.LittleEndian.AppendUint32(header, uint32(len(samples)))
+
+	return append(header, samples...)
+}
@@ -0,0 +1,109 @@
+package voice
+
+import (
+	"ht/model"
+	"testing"
+	"time"
+)
+
+func TestStreamRecorder(t *testing.T) {
+	config := &VADConfig{
+		SpeechLevel: -45,
+		MinSpeech:   500 * time.Millisecond,
+		EndSilence:  200 * time.Millisecond,
+		SilenceHint: 400 * time.Millisecond,
+		MaxDuration: 2 * time.Second,
+	}
+	speech := func(seconds float64) []byte { return tonePCM(8000, seconds, 0.5, 300) }
+	silence := func(seconds float64) []byte { return tonePCM(8000, seconds, 0) }
+
+	tests := []struct {
+		name     string
+		chunks   [][]byte
+		state    model.VoiceActivityState
+		speechMs int64
+	}{
+		{"waiting for speech", [][]byte{silence(0.2)}, model.VoiceActivityStateListening, 0},
+		{"silent too long", [][]byte{silence(0.5)}, model.VoiceActivityStateSilent, 0},
+		{"speaking", [][]byte{speech(0.3)}, model.VoiceActivityStateSpeaking, 300},
+		{"short pause while speaking", [][]byte{speech(0.3), silence(0.1)}, model.VoiceActivityStateSpeaking, 300},
+		{"stopped before enough speech", [][]byte{speech(0.3), silence(0.5)}, model.VoiceActivityStateSilent, 300},
+		{"complete after the end silence", [][]byte{speech(0.6), silence(0.2)}, model.VoiceActivityStateComplete, 600},
+		{"complete at the maximum duration", [][]byte{speech(2)}, model.VoiceActivityStateComplete, 2000},
+		{"insufficient at the maximum duration", [][]byte{silence(2)}, model.VoiceActivityStateInsufficient, 0},
+		{"chunks after the end are ignored", [][]byte{speech(0.6), silence(0.2), speech(0.5)}, model.VoiceActivityStateComplete, 600},
+		{"clipped speech", [][]byte{tonePCM(8000, 0.1, 1, 300)}, model.VoiceActivityStateTooLoud, 100},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			recorder, err := NewStreamRecorder(config, 8000)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var activity *model.VoiceActivity
+			for _, chunk := range test.chunks {
+				activity, err = recorder.Write(chunk)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if activity.State != test.state {
+				t.Errorf("state = %v, expected %v", activity.State, test.state)
+			}
+			if activity.SpeechMs != test.speechMs {
+				t.Errorf("speech = %vms, expected %vms", activity.SpeechMs, test.speechMs)
+			}
+		})
+	}
+}
+
+func TestStreamRecorderRecording(t *testing.T) {
+	config := &VADConfig{SpeechLevel: -45, MinSpeech: time.Second, EndSilence: time.Second, SilenceHint: time.Second, MaxDuration: 2 * time.Second}
+	tests := []struct {
+		name       string
+		sampleRate int
+		channel    model.Channel
+		wantErr    bool
+	}{
+		{"narrowband", 8000, model.ChannelNarrowband, false},
+		{"wideband", 16000, model.ChannelWideband, false},
+		{"sample rate too low", 4000, "", true},
+		{"sample rate too high", 96000, "", true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			recorder, err := NewStreamRecorder(config, test.sampleRate)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("NewStreamRecorder() error = %v, wantErr %v", err, test.wantErr)
+			}
+			if err != nil {
+				return
+			}
+
+			pcm := tonePCM(test.sampleRate, 0.1, 0.5, 300)
+			_, err = recorder.Write(pcm)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = recorder.Write([]byte{0})
+			if err == nil {
+				t.Errorf("Write() of half a sample did not fail")
+			}
+
+			recording, channel := recorder.Recording()
+			if channel != test.channel {
+				t.Errorf("channel = %v, expected %v", channel, test.channel)
+			}
+			samples, sampleRate, err := decodeWav(recording)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if sampleRate != test.sampleRate || len(samples) != len(pcm)/2 {
+				t.Errorf("recording has %v samples at %v Hz, expected %v at %v Hz", len(samples), sampleRate, len(pcm)/2, test.sampleRate)
+			}
+		})
+	}
+}
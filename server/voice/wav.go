@@ -0,0 +1,119 @@
+package voice
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+)
+
+const (
+	wavFormatPCM   = 1
+	wavFormatFloat = 3
+	wavFormatALaw  = 6
+	wavFormatMuLaw = 7
+)
+
+// isWav returns true if the recording has a RIFF/WAVE header.
+func isWav(data []byte) bool {
+	return len(data) >= 12 && string(data[0:4]) == "RIFF" && string(data[8:12]) == "WAVE"
+}
+
+// decodeWav returns the mono samples in [-1, 1] and the sample rate of a RIFF/WAVE recording.
+// It supports PCM with 8, 16, 24 or 32 bits, IEEE float with 32 bits and G.711 µ-law
+// and A-law with 8 bits, channels are averaged.
+func decodeWav(data []byte) ([]float64, int, error) {
+	if !isWav(data) {
+		return nil, 0, fmt.Errorf("recording is not a wav file")
+	}
+
+	var format, channels, bitsPerSample uint16
+	var sampleRate uint32
+	var samples []byte
+	fmtFound := false
+
+	for offset := 12; offset+8 <= len(data); {
+		chunkId := string(data[offset : offset+4])
+		chunkSize := int(binary.LittleEndian.Uint32(data[offset+4 : offset+8]))
+		start := offset + 8
+		end := start + chunkSize
+		if end > len(data) {
+			// streamed recordings might not know their size in advance
+			end = len(data)
+		}
+
+		switch chunkId {
+		case "fmt ":
+			if end-start < 16 {
+				return nil, 0, fmt.Errorf("invalid wav fmt chunk")
+			}
+			format = binary.LittleEndian.Uint16(data[start : start+2])
+			channels = binary.LittleEndian.Uint16(data[start+2 : start+4])
+			sampleRate = binary.LittleEndian.Uint32(data[start+4 : start+8])
+			bitsPerSample = binary.LittleEndian.Uint16(data[start+14 : start+16])
+			// WAVE_FORMAT_EXTENSIBLE stores the actual format in the sub format
+			if format == 0xFFFE && end-start >= 26 {
+				format = binary.LittleEndian.Uint16(data[start+24 : start+26])
+			}
+			fmtFound = true
+		case "data":
+			samples = data[start:end]
+		}
+
+		// chunks are padded to an even size
+		offset = start + chunkSize + chunkSize%2
+	}
+
+	if !fmtFound || samples == nil {
+		return nil, 0, fmt.Errorf("wav file without fmt or data chunk")
+	}
+	if channels == 0 || sampleRate == 0 {
+		return nil, 0, fmt.Errorf("invalid wav format: %v channels, %v Hz", channels, sampleRate)
+	}
+
+	bytesPerSample := int(bitsPerSample / 8)
+	frameSize := bytesPerSample * int(channels)
+	if frameSize == 0 {
+		return nil, 0, fmt.Errorf("invalid wav sample size: %v bits", bitsPerSample)
+	}
+
+	decode, err := sampleDecoder(format, bitsPerSample)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	frames := len(samples) / frameSize
+	mono := make([]float64, frames)
+	for i := 0; i < frames; i++ {
+		sum := 0.0
+		for channel := 0; channel < int(channels); channel++ {
+			position := i*frameSize + channel*bytesPerSample
+			sum += decode(samples[position : position+bytesPerSample])
+		}
+		mono[i] = sum / float64(channels)
+	}
+
+	return mono, int(sampleRate), nil
+}
+
+func sampleDecoder(format uint16, bitsPerSample uint16) (func([]byte) float64, error) {
+	switch {
+	case format == wavFormatMuLaw && bitsPerSample == 8:
+		return func(b []byte) float64 { return float64(muLawToLinear(b[0])) / 32768 }, nil
+	case format == wavFormatALaw && bitsPerSample == 8:
+		return func(b []byte) float64 { return float64(aLawToLinear(b[0])) / 32768 }, nil
+	case format == wavFormatPCM && bitsPerSample == 8:
+		return func(b []byte) float64 { return (float64(b[0]) - 128) / 128 }, nil
+	case format == wavFormatPCM && bitsPerSample == 16:
+		return func(b []byte) float64 { return float64(int16(binary.LittleEndian.Uint16(b))) / 32768 }, nil
+	case format == wavFormatPCM && bitsPerSample == 24:
+		return func(b []byte) float64 {
+			value := int32(b[0]) | int32(b[1])<<8 | int32(int8(b[2]))<<16
+			return float64(value) / 8388608
+		}, nil
+	case format == wavFormatPCM && bitsPerSample == 32:
+		return func(b []byte) float64 { return float64(int32(binary.LittleEndian.Uint32(b))) / 2147483648 }, nil
+	case format == wavFormatFloat && bitsPerSample == 32:
+		return func(b []byte) float64 { return float64(math.Float32frombits(binary.LittleEndian.Uint32(b))) }, nil
+	}
+	return nil, fmt.Errorf("unsupported wav format %v with %v bits", format, bitsPerSample)
+}
@@ -0,0 +1,75 @@
+package voice
+
+import (
+	"encoding/binary"
+	"math"
+	"testing"
+)
+
+// toneWav returns a mono 16 bit wav of sine tones, each one playing for its share of the duration.
+func toneWav(sampleRate int, seconds float64, amplitude float64, frequencies ...float64) []byte {
+	return wrapPCM16(tonePCM(sampleRate, seconds, amplitude, frequencies...), sampleRate)
+}
+
+func tonePCM(sampleRate int, seconds float64, amplitude float64, frequencies ...float64) []byte {
+	count := int(seconds * float64(sampleRate))
+	pcm := make([]byte, 0, count*2)
+	for i := 0; i < count; i++ {
+		sample := 0.0
+		if len(frequencies) > 0 {
+			frequency := frequencies[i*len(frequencies)/count]
+			sample = amplitude * math.Sin(2*math.Pi*frequency*float64(i)/float64(sampleRate))
+		}
+		pcm = binary.LittleEndian.AppendUint16(pcm, uint16(int16(sample*32767)))
+	}
+	return pcm
+}
+
+func TestDecodeWav(t *testing.T) {
+	stereo := wrapPCM16([]byte{0x00, 0x40, 0x00, 0xC0}, 16000)
+	// channels 2, byte rate and block align of a 16 bit stereo wav
+	binary.LittleEndian.PutUint16(stereo[22:24], 2)
+	binary.LittleEndian.PutUint32(stereo[28:32], 16000*4)
+	binary.LittleEndian.PutUint16(stereo[32:34], 4)
+
+	unsupported := wrapPCM16([]byte{0, 0}, 16000)
+	binary.LittleEndian.PutUint16(unsupported[20:22], 2)
+
+	tests := []struct {
+		name       string
+		data       []byte
+		sampleRate int
+		samples    []float64
+		wantErr    bool
+	}{
+		{"pcm 16 bit", wrapPCM16([]byte{0x00, 0x40, 0x00, 0xC0}, 16000), 16000, []float64{0.5, -0.5}, false},
+		{"channels are averaged", stereo, 16000, []float64{0}, false},
+		{"truncated data chunk", wrapPCM16([]byte{0x00, 0x40, 0x00, 0xC0}, 8000)[:46], 8000, []float64{0.5}, false},
+		{"not a wav", []byte("OggS0000WAVE"), 0, nil, true},
+		{"missing data chunk", wrapPCM16(nil, 16000)[:36], 0, nil, true},
+		{"unsupported format", unsupported, 0, nil, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			samples, sampleRate, err := decodeWav(test.data)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("decodeWav() error = %v, wantErr %v", err, test.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if sampleRate != test.sampleRate {
+				t.Errorf("sample rate = %v, expected %v", sampleRate, test.sampleRate)
+			}
+			if len(samples) != len(test.samples) {
+				t.Fatalf("got %v samples, expected %v", len(samples), len(test.samples))
+			}
+			for i := range samples {
+				if samples[i] != test.samples[i] {
+					t.Errorf("sample %v = %v, expected %v", i, samples[i], test.samples[i])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,143 @@
+package handler
+
+import (
+	"ht/helper"
+	"ht/model"
+	"ht/server"
+	"ht/web/view/screens"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+)
+
+type AdminView struct {
+	server *server.Server
+}
+
+func NewAdminView(server *server.Server) *AdminView {
+	newAdminView := &AdminView{
+		server: server,
+	}
+	return newAdminView
+}
+
+func (r *AdminView) HandleAdmin(c echo.Context) error {
+	return render(c, screens.Admin())
+}
+
+func (r *AdminView) HandleAdminUser(c echo.Context) error {
+	auth, err := r.server.AuthService.GetAuthByEmail(c.QueryParam("email"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, "user not found")
+	}
+
+	user, err := r.server.UserService.GetUser(auth.RID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+
+	templateAge, err := r.server.UserService.GetTemplateAge(auth.RID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+
+	referenceSamples, err := r.server.UserService.GetReferenceSamples(auth.RID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+
+	allowance, err := r.server.IdentificationService.GetAllowance(auth.RID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+
+	auditEvents, err := r.server.AuditService.GetAuditEventsBySubject(auth.RID, 0, 50)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+
+	return render(c, screens.AdminUser(auth, user, templateAge, allowance, referenceSamples, auditEvents))
+}
+
+// api
+func (r *AdminView) HandleRevertAdaptation(c echo.Context) error {
+	userRid, err := uuid.Parse(c.Param("rid"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid user rid")
+	}
+
+	adminRid := helper.GetCurrentUserRID(c.Request().Context())
+	err = r.server.UserService.RevertAdaptation(adminRid, userRid)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+
+	return HandleInfoView(c, "Success", "Adapted reference samples removed.")
+}
+
+func (r *AdminView) HandleDisableAdaptation(c echo.Context) error {
+	userRid, err := uuid.Parse(c.Param("rid"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid user rid")
+	}
+
+	adminRid := helper.GetCurrentUserRID(c.Request().Context())
+	_, err = r.server.UserService.SetAdaptationEnabled(adminRid, userRid, false)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+
+	return HandleInfoView(c, "Success", "Adaptation disabled.")
+}
+
+func (r *AdminView) HandleDismissTemplateRefresh(c echo.Context) error {
+	userRid, err := uuid.Parse(c.Param("rid"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid user rid")
+	}
+
+	adminRid := helper.GetCurrentUserRID(c.Request().Context())
+	err = r.server.UserService.RevertTemplateRefreshDue(adminRid, userRid)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+
+	return HandleInfoView(c, "Success", "Template refresh prompt dismissed.")
+}
+
+func (r *AdminView) HandleUnlockVoice(c echo.Context) error {
+	userRid, err := uuid.Parse(c.Param("rid"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid user rid")
+	}
+
+	adminRid := helper.GetCurrentUserRID(c.Request().Context())
+	err = r.server.IdentificationService.Unlock(adminRid, userRid)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+
+	return HandleInfoView(c, "Success", "Voice identification unlocked.")
+}
+
+func (r *AdminView) HandleUpdateUserStatus(c echo.Context) error {
+	userRid, err := uuid.Parse(c.Param("rid"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid user rid")
+	}
+
+	status := model.UserStatus(c.FormValue("status"))
+	err = status.Validate()
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	adminRid := helper.GetCurrentUserRID(c.Request().Context())
+	_, err = r.server.UserService.SetUserStatus(adminRid, userRid, status)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+
+	return HandleInfoView(c, "Success", "Account status updated.")
+}
@@ -0,0 +1,97 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"ht/helper"
+	"ht/server"
+	"ht/server/services/identification"
+	"ht/web/view/screens"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+)
+
+type AgentView struct {
+	server *server.Server
+}
+
+func NewAgentView(server *server.Server) *AgentView {
+	newAgentView := &AgentView{
+		server: server,
+	}
+	return newAgentView
+}
+
+func (r *AgentView) HandleAgent(c echo.Context) error {
+	agentRid := helper.GetCurrentUserRID(c.Request().Context())
+	auditEvents, err := r.server.AuditService.GetAuditEventsByActor(agentRid, 0, 20)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+
+	return render(c, screens.Agent(auditEvents))
+}
+
+func (r *AgentView) HandleAgentCustomer(c echo.Context) error {
+	auth, err := r.server.AuthService.GetAuthByEmail(c.QueryParam("email"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, "customer not found")
+	}
+
+	voiceProfiles, err := r.server.UserService.GetVoiceProfiles(auth.RID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+
+	allowance, err := r.server.IdentificationService.GetAllowance(auth.RID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+
+	return render(c, screens.AgentCustomer(auth, voiceProfiles, allowance))
+}
+
+func (r *AgentView) HandleAssistedVerification(c echo.Context) error {
+	rid, err := uuid.Parse(c.Param("rid"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid verification rid")
+	}
+
+	identificationAttempt, err := r.server.IdentificationService.GetAssistedIdentificationAttempt(rid)
+	if errors.Is(err, identification.ErrAssistedAttemptNotFound) {
+		return echo.NewHTTPError(http.StatusNotFound, err.Error())
+	} else if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+
+	auth, err := r.server.AuthService.GetAuth(identificationAttempt.UserRID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+
+	return render(c, screens.AgentVerification(auth, identificationAttempt))
+}
+
+// api
+func (r *AgentView) HandleCreateAssistedVerification(c echo.Context) error {
+	customerRid, err := uuid.Parse(c.Param("rid"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid customer rid")
+	}
+
+	_, err = r.server.AuthService.GetAuth(customerRid)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, "customer not found")
+	}
+
+	identificationAttempt, err := r.server.IdentificationService.CreateAssistedIdentificationAttempt(c, customerRid)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+
+	c.Response().Header().Add("HX-Redirect", fmt.Sprintf("/agent/verification/%v", identificationAttempt.RID))
+
+	return c.NoContent(http.StatusCreated)
+}
@@ -1,15 +1,22 @@
 package handler
 
 import (
+	"errors"
 	"ht/helper"
 	"ht/server"
+	"ht/server/services/identification"
+	"ht/server/voice"
 	"ht/web/view/screens"
+	"io"
 	"net/http"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/labstack/echo/v4"
 )
 
+const MAX_RECORDING_SIZE_MB = 5
+
 type AuthView struct {
 	server *server.Server
 }
@@ -51,6 +58,21 @@ func HandleResetPasswordView(c echo.Context) error {
 	return render(c, screens.ResetPassword())
 }
 
+func (r *AuthView) HandleVoiceLoginView(c echo.Context) error {
+	if !r.server.IdentificationService.IsVoiceLoginEnabled() {
+		return HandleNotFound(c)
+	}
+
+	sentence, err := r.server.VoiceMatcher.CreateSentence(c.Request().Context())
+	if err != nil {
+		return echo.NewHTTPError(http.StatusServiceUnavailable, err)
+	}
+
+	c.Response().Header().Add("HX-Push-Url", "/voiceLogin")
+	c.Response().Header().Add("HX-Reswap", "innerHTML")
+	return render(c, screens.VoiceLogin(sentence))
+}
+
 // api handler
 func (r *AuthView) HandleRegisterWithEmail(c echo.Context) error {
 	helper.SetContext(c, helper.ProjectRidKey, uuid.UUID{})
@@ -88,16 +110,82 @@ func (r *AuthView) HandleVerifyEmail(c echo.Context) error {
 
 func (r *AuthView) HandleLoginWithEmail(c echo.Context) error {
 	helper.SetContext(c, helper.ProjectRidKey, uuid.UUID{})
-	err := r.server.AuthService.HandleLoginWithEmail(c)
+	auth, err := r.server.AuthService.HandleLoginWithEmail(c)
 	if err != nil {
 		return echo.NewHTTPError(http.StatusUnauthorized, err)
 	}
 
+	// users without an enrolled voice have no second factor yet, they enroll it after the login
+	if r.server.AuthService.IsVoiceRequired() && auth.EmailVerified {
+		enrolled, err := r.server.UserService.HasActiveVoiceProfile(auth.RID)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, err)
+		}
+		if enrolled {
+			err = r.server.AuthService.RequireSecondFactor(c)
+			if err != nil {
+				return echo.NewHTTPError(http.StatusInternalServerError, err)
+			}
+			err = r.server.AuthService.SetVoiceVerificationReturn(c, "/user/onboardingStart")
+			if err != nil {
+				return echo.NewHTTPError(http.StatusInternalServerError, err)
+			}
+
+			c.Response().Header().Add("HX-Redirect", "/identification")
+			return c.NoContent(http.StatusOK)
+		}
+	}
+
 	c.Response().Header().Add("HX-Redirect", "/user/onboardingStart")
 
 	return c.NoContent(http.StatusOK)
 }
 
+func (r *AuthView) HandleLoginWithVoice(c echo.Context) error {
+	helper.SetContext(c, helper.ProjectRidKey, uuid.UUID{})
+	if err := c.Request().ParseMultipartForm(MAX_RECORDING_SIZE_MB << 20); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err)
+	}
+
+	file, _, err := c.Request().FormFile("recording")
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err)
+	}
+	defer file.Close()
+
+	recording, err := io.ReadAll(io.LimitReader(file, MAX_RECORDING_SIZE_MB<<20))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err)
+	}
+
+	candidate, err := r.server.IdentificationService.IdentifySpeaker(c, recording, c.FormValue("voice_login_code"))
+	extractionError := &voice.ExtractionError{}
+	if errors.Is(err, identification.ErrNoVoiceMatch) || errors.As(err, &extractionError) {
+		return echo.NewHTTPError(http.StatusUnauthorized, "Your voice was not recognised, please try again or login with your email.")
+	} else if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+
+	err = r.server.AuthService.LoginUser(c, candidate.UserRID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, err)
+	}
+	err = r.server.AuthService.MarkVoiceVerified(c, time.Now())
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+	if candidate.Duress {
+		err = r.server.AuthService.RestrictSession(c)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, err)
+		}
+	}
+
+	c.Response().Header().Add("HX-Redirect", "/user")
+
+	return c.NoContent(http.StatusOK)
+}
+
 func (r *AuthView) HandleRequestPasswordReset(c echo.Context) error {
 	helper.SetContext(c, helper.ProjectRidKey, uuid.UUID{})
 	err := r.server.AuthService.HandleRequestPasswordReset(c)
@@ -0,0 +1,80 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"ht/helper"
+	"ht/server"
+	"ht/server/services/batch"
+	"ht/web/view/screens"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+)
+
+type BatchView struct {
+	server *server.Server
+}
+
+func NewBatchView(server *server.Server) *BatchView {
+	newBatchView := &BatchView{
+		server: server,
+	}
+	return newBatchView
+}
+
+func (r *BatchView) HandleBatches(c echo.Context) error {
+	batches, err := r.server.BatchService.GetBatches(0, 50)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+
+	return render(c, screens.Batches(batches))
+}
+
+func (r *BatchView) HandleBatch(c echo.Context) error {
+	rid, err := uuid.Parse(c.Param("rid"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid batch rid")
+	}
+
+	batchData, err := r.server.BatchService.GetBatch(rid)
+	if errors.Is(err, batch.ErrBatchNotFound) {
+		return echo.NewHTTPError(http.StatusNotFound, err.Error())
+	} else if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+
+	return render(c, screens.Batch(batchData))
+}
+
+// api
+func (r *BatchView) HandleCreateBatch(c echo.Context) error {
+	batchData, err := r.server.BatchService.CreateBatch(c)
+	if err != nil {
+		return err
+	}
+
+	c.Response().Header().Add("HX-Redirect", fmt.Sprintf("/batch/%v", batchData.RID))
+
+	return c.NoContent(http.StatusCreated)
+}
+
+func (r *BatchView) HandleBatchResult(c echo.Context) error {
+	rid, err := uuid.Parse(c.Param("rid"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid batch rid")
+	}
+
+	actorRid := helper.GetCurrentUserRID(c.Request().Context())
+	batchData, result, err := r.server.BatchService.GetBatchResult(actorRid, rid)
+	if errors.Is(err, batch.ErrBatchNotFound) {
+		return echo.NewHTTPError(http.StatusNotFound, err.Error())
+	} else if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+
+	c.Response().Header().Set(echo.HeaderContentDisposition, fmt.Sprintf("attachment; filename=\"batch-%v.csv\"", batchData.RID))
+	return c.Blob(http.StatusOK, "text/csv", result)
+}
@@ -0,0 +1,91 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"ht/model"
+	"ht/server"
+	"ht/server/jobs"
+	"ht/server/services/identification"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+)
+
+// CallbackView receives the results of the jobs service, see JobsCallbackMiddleware.
+// Errors are answered with their status code, the error view would answer with 200.
+type CallbackView struct {
+	server *server.Server
+}
+
+func NewCallbackView(server *server.Server) *CallbackView {
+	newCallbackView := &CallbackView{
+		server: server,
+	}
+	return newCallbackView
+}
+
+// api
+func (r *CallbackView) HandleReferenceSamplesCallback(c echo.Context) error {
+	callback := &jobs.ReferenceSamplesCallback{}
+	err := c.Bind(callback)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	userRid, err := uuid.Parse(callback.UserRID)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("invalid user rid: %v", err)})
+	}
+
+	features := make([]*model.ReferenceSampleFeatures, 0, len(callback.Samples))
+	for _, sample := range callback.Samples {
+		sampleRid, err := uuid.Parse(sample.RID)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("invalid reference sample rid: %v", err)})
+		}
+		version, err := time.Parse(time.RFC3339Nano, sample.Version)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("invalid reference sample version: %v", err)})
+		}
+		features = append(features, &model.ReferenceSampleFeatures{
+			RID:           sampleRid,
+			Version:       version,
+			RecordingMfcc: model.Vector(sample.RecordingMfcc),
+			Extractor:     model.Extractor(callback.Extractor),
+			Error:         sample.Error,
+		})
+	}
+
+	err = r.server.UserService.StoreReferenceSampleFeatures(userRid, features)
+	if err != nil {
+		return c.JSON(http.StatusUnprocessableEntity, map[string]string{"error": err.Error()})
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+func (r *CallbackView) HandleIdentificationAttemptCallback(c echo.Context) error {
+	callback := &jobs.IdentificationAttemptCallback{}
+	err := c.Bind(callback)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	rid, err := uuid.Parse(callback.RID)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("invalid identification attempt rid: %v", err)})
+	}
+
+	_, err = r.server.IdentificationService.CompleteIdentificationAttempt(rid, model.Vector(callback.RecordingMfcc), model.Extractor(callback.Extractor), callback.Error)
+	if errors.Is(err, identification.ErrInvalidTransition) {
+		// expired or already decided, nothing to retry
+		return c.JSON(http.StatusConflict, map[string]string{"error": err.Error()})
+	} else if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
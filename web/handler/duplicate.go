@@ -0,0 +1,105 @@
+package handler
+
+import (
+	"errors"
+	"ht/helper"
+	"ht/model"
+	"ht/server"
+	"ht/server/services/user"
+	"ht/web/view/screens"
+	"net/http"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+)
+
+type DuplicateView struct {
+	server *server.Server
+}
+
+func NewDuplicateView(server *server.Server) *DuplicateView {
+	newDuplicateView := &DuplicateView{
+		server: server,
+	}
+	return newDuplicateView
+}
+
+func (r *DuplicateView) HandleDuplicateReviews(c echo.Context) error {
+	duplicateReviews, err := r.server.UserService.GetOpenDuplicateReviews(0, 50)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+
+	userRids := []uuid.UUID{}
+	for _, duplicateReview := range duplicateReviews {
+		userRids = append(userRids, duplicateReview.UserRID)
+	}
+
+	return render(c, screens.DuplicateReviews(duplicateReviews, r.accountEmails(userRids)))
+}
+
+func (r *DuplicateView) HandleDuplicateReview(c echo.Context) error {
+	rid, err := uuid.Parse(c.Param("rid"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid review rid")
+	}
+
+	duplicateReview, err := r.server.UserService.GetDuplicateReview(rid)
+	if errors.Is(err, user.ErrDuplicateReviewNotFound) {
+		return echo.NewHTTPError(http.StatusNotFound, err.Error())
+	} else if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+
+	userRids := []uuid.UUID{duplicateReview.UserRID}
+	for _, match := range duplicateReview.Matches {
+		userRids = append(userRids, match.UserRID)
+	}
+
+	return render(c, screens.DuplicateReview(duplicateReview, r.accountEmails(userRids)))
+}
+
+// accountEmails returns the emails of the accounts for the reviewers, deleted accounts are left out.
+func (r *DuplicateView) accountEmails(userRids []uuid.UUID) map[uuid.UUID]string {
+	emails := map[uuid.UUID]string{}
+	for _, userRid := range userRids {
+		auth, err := r.server.AuthService.GetAuth(userRid)
+		if err == nil {
+			emails[userRid] = auth.Email
+		}
+	}
+	return emails
+}
+
+// api
+func (r *DuplicateView) HandleResolveDuplicateReview(c echo.Context) error {
+	rid, err := uuid.Parse(c.Param("rid"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid review rid")
+	}
+
+	var confirmed bool
+	switch model.DuplicateReviewState(c.FormValue("state")) {
+	case model.DuplicateReviewStateConfirmed:
+		confirmed = true
+	case model.DuplicateReviewStateDismissed:
+		confirmed = false
+	default:
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid review state")
+	}
+
+	reviewerRid := helper.GetCurrentUserRID(c.Request().Context())
+	_, err = r.server.UserService.ResolveDuplicateReview(reviewerRid, rid, confirmed, strings.TrimSpace(c.FormValue("comment")))
+	if errors.Is(err, user.ErrDuplicateReviewNotFound) {
+		return echo.NewHTTPError(http.StatusNotFound, err.Error())
+	} else if errors.Is(err, user.ErrDuplicateReviewResolved) {
+		return echo.NewHTTPError(http.StatusConflict, err.Error())
+	} else if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+
+	c.Response().Header().Add("HX-Redirect", "/duplicates")
+
+	return c.NoContent(http.StatusOK)
+}
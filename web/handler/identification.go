@@ -1,18 +1,29 @@
 package handler
 
 import (
+	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"ht/helper"
 	"ht/model"
 	"ht/server"
+	"ht/server/services/auth"
+	"ht/server/services/identification"
 	"ht/web/view/screens"
 	"log"
 	"net/http"
+	"strconv"
+	"time"
 
+	"github.com/google/uuid"
 	"github.com/labstack/echo/v4"
+	"golang.org/x/net/websocket"
 )
 
+// streamTimeout ends recording streams the recorder stopped sending to.
+const streamTimeout = time.Minute
+
 type IdentificationView struct {
 	server *server.Server
 }
@@ -25,58 +36,278 @@ func NewIdentificationView(server *server.Server) *IdentificationView {
 }
 
 func (r *IdentificationView) HandleIdentification(c echo.Context) error {
-	bytes, err := helper.StartJob(fmt.Sprintf("http://localhost:%v/jobs/createSentence", r.server.JobsPort), map[string]string{})
+	allowance, err := r.server.IdentificationService.GetAllowance(helper.GetCurrentUserRID(c.Request().Context()))
 	if err != nil {
-		return err
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+	// a login waiting for the voice would be stuck, it is completed with an emailed code instead
+	if isVoiceUnavailable(allowance) && r.server.AuthService.IsEmailFallbackEnabled() && r.server.AuthService.IsSecondFactorPending(c) {
+		return render(c, screens.LoginCodeFallback())
+	}
+
+	if !allowance.Status.MayIdentify() {
+		return render(c, screens.AccountRestricted(allowance.Status))
+	} else if allowance.Lockout != nil {
+		return render(c, screens.VoiceLocked())
+	} else if !allowance.RetryAt.IsZero() {
+		return render(c, screens.ResultRetry("Too many attempts", fmt.Sprintf("Please try again at %v.", allowance.RetryAt.Format("15:04"))))
 	}
 
-	sentence := ""
-	err = json.Unmarshal(bytes, &sentence)
+	sentence, err := r.server.VoiceMatcher.CreateSentence(c.Request().Context())
 	if err != nil {
-		return err
+		if r.server.AuthService.IsEmailFallbackEnabled() && r.server.AuthService.IsSecondFactorPending(c) {
+			log.Printf("voice identification unavailable, offering login code: %v", err)
+			return render(c, screens.LoginCodeFallback())
+		}
+		return echo.NewHTTPError(http.StatusServiceUnavailable, err)
 	}
 
 	return render(c, screens.Identification(sentence))
 }
 
 func (r *IdentificationView) HandleAuthenticationWaiting(c echo.Context) error {
-	userId := helper.GetCurrentUserRID(c.Request().Context())
-	log.Printf("starting identification for userId: %v", userId)
-	identificationAttempt, err := helper.StartJob(fmt.Sprintf("http://localhost:%v/jobs/identify", r.server.JobsPort), map[string]string{"user_rid": userId.String()})
-	if err != nil {
-		return err
+	identificationAttempt, err := r.server.IdentificationService.StartProcessingLatestIdentificationAttempt(c)
+	if errors.Is(err, identification.ErrInvalidTransition) {
+		// already processed, the result screen shows the state
+		return render(c, screens.WaitForAuthentication())
+	} else if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
 	}
 
-	log.Printf("identificationAttempt result: %v", string(identificationAttempt))
+	// the identify job runs in a worker, the result is pushed to the waiting screen by HandleIdentificationEvents
+	log.Printf("queued identification %v with job %v", identificationAttempt.RID, identificationAttempt.JobRID)
 
 	return render(c, screens.WaitForAuthentication())
 }
 
 func (r *IdentificationView) HandleResult(c echo.Context) error {
-	identificationAttempt := &model.IdentificationAttempt{}
-	var err error
-	for identificationAttempt.Used {
-		identificationAttempt, err = r.server.IdentificationService.GetLatestIdentificationAttempt(c)
+	identificationAttempt, err := r.server.IdentificationService.GetLatestIdentificationAttempt(c)
+	if err == sql.ErrNoRows {
+		return c.Redirect(http.StatusSeeOther, "/identification")
+	} else if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+
+	allowance, err := r.server.IdentificationService.GetAllowance(identificationAttempt.UserRID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+
+	// the result looks as usual, only the session is restricted
+	if identificationAttempt.Duress {
+		err = r.server.AuthService.RestrictSession(c)
 		if err != nil {
 			return echo.NewHTTPError(http.StatusInternalServerError, err)
 		}
 	}
 
-	identificationAttempt, err = r.server.IdentificationService.UpdateIdentificationAttemptUsed(c)
+	// attempts of other sessions, devices or agents are shown but never verify this session
+	if identificationAttempt.IsAccepted() && r.server.AuthService.IsSessionIdentificationAttempt(c, identificationAttempt.RID) {
+		err = r.server.AuthService.MarkVoiceVerified(c, identificationAttempt.AcceptedAt)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, err)
+		}
+		err = r.server.AuthService.CompleteSecondFactor(c, identificationAttempt.AcceptedAt)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, err)
+		}
+
+		// a sensitive action sent the user here, it continues right away
+		returnPath, err := r.server.AuthService.TakeVoiceVerificationReturn(c)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, err)
+		} else if len(returnPath) > 0 {
+			return c.Redirect(http.StatusSeeOther, returnPath)
+		}
+	}
+
+	return render(c, screens.Result(identificationAttempt.State, allowance))
+}
+
+// HandleIdentificationEvents streams the state of the latest attempt of the current user as server-sent events
+// until the attempt is decided or the client disconnects.
+func (r *IdentificationView) HandleIdentificationEvents(c echo.Context) error {
+	userId := helper.GetCurrentUserRID(c.Request().Context())
+	stateChanges, unsubscribe := r.server.IdentificationService.SubscribeStateChanges(userId)
+	defer unsubscribe()
+
+	c.Response().Header().Set(echo.HeaderContentType, "text/event-stream")
+	c.Response().Header().Set(echo.HeaderCacheControl, "no-cache")
+	c.Response().Header().Set(echo.HeaderConnection, "keep-alive")
+	c.Response().WriteHeader(http.StatusOK)
+
+	// the attempt might have changed before subscribing
+	identificationAttempt, err := r.server.IdentificationService.GetLatestIdentificationAttempt(c)
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	} else if err == nil {
+		err = writeStateChangeEvent(c, &model.IdentificationAttemptStateChange{
+			RID:     identificationAttempt.RID,
+			UserRID: identificationAttempt.UserRID,
+			State:   identificationAttempt.State,
+		})
+		if err != nil || identificationAttempt.State.IsFinal() {
+			return err
+		}
+	}
+
+	keepAlive := time.NewTicker(15 * time.Second)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case <-c.Request().Context().Done():
+			return nil
+		case <-keepAlive.C:
+			_, err = fmt.Fprint(c.Response(), ": keep-alive\n\n")
+			if err != nil {
+				return nil
+			}
+			c.Response().Flush()
+		case stateChange := <-stateChanges:
+			err = writeStateChangeEvent(c, stateChange)
+			if err != nil || stateChange.State.IsFinal() {
+				return nil
+			}
+		}
+	}
+}
+
+func writeStateChangeEvent(c echo.Context, stateChange *model.IdentificationAttemptStateChange) error {
+	data, err := json.Marshal(stateChange)
 	if err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, err)
+		return err
+	}
+
+	_, err = fmt.Fprintf(c.Response(), "event: state\ndata: %s\n\n", data)
+	if err != nil {
+		return err
+	}
+	c.Response().Flush()
+	return nil
+}
+
+// HandleIdentificationStream receives the recording of the current user over a websocket as binary chunks
+// of mono 16 bit little endian PCM with the sampleRate query parameter. The voice activity is sent back after
+// every chunk, once enough speech is captured the identification attempt is created and processed right away.
+func (r *IdentificationView) HandleIdentificationStream(c echo.Context) error {
+	sampleRate, err := strconv.Atoi(c.QueryParam("sampleRate"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid sample rate")
+	}
+
+	streamServer := websocket.Server{
+		Handshake: checkSameOrigin,
+		Handler: func(ws *websocket.Conn) {
+			defer ws.Close()
+			r.streamIdentification(c, ws, sampleRate)
+		},
+	}
+	streamServer.ServeHTTP(c.Response(), c.Request())
+
+	// the connection is hijacked, errors are sent over the websocket
+	return nil
+}
+
+func (r *IdentificationView) streamIdentification(c echo.Context, ws *websocket.Conn, sampleRate int) {
+	ws.MaxPayloadBytes = identification.MAX_SIZE_MB << 20
+	err := ws.SetDeadline(time.Now().Add(streamTimeout))
+	if err != nil {
+		log.Printf("error setting stream deadline: %v", err)
+		return
+	}
+
+	recorder, err := r.server.IdentificationService.StartRecordingStream(c, sampleRate)
+	if err != nil {
+		sendStreamError(ws, err)
+		return
+	}
+
+	for {
+		var chunk []byte
+		err = websocket.Message.Receive(ws, &chunk)
+		if err != nil {
+			// the recorder stopped or disconnected before the recording was complete
+			return
+		}
+
+		activity, err := recorder.Write(chunk)
+		if err != nil {
+			sendStreamError(ws, fmt.Errorf("%w: %v", identification.ErrInvalidRecordingStream, err))
+			return
+		}
+
+		if activity.State == model.VoiceActivityStateComplete {
+			identificationAttempt, err := r.server.IdentificationService.FinishRecordingStream(c, recorder)
+			if err != nil {
+				sendStreamError(ws, err)
+				return
+			}
+			activity.AttemptRID = identificationAttempt.RID
+			// the session is stored server side, so it is updated although the connection is hijacked
+			err = r.server.AuthService.SetIdentificationAttempt(c, identificationAttempt.RID)
+			if err != nil {
+				sendStreamError(ws, err)
+				return
+			}
+			log.Printf("queued streamed identification %v with job %v", identificationAttempt.RID, identificationAttempt.JobRID)
+		}
+
+		err = websocket.JSON.Send(ws, activity)
+		if err != nil || activity.State.IsFinal() {
+			return
+		}
+	}
+}
+
+func sendStreamError(ws *websocket.Conn, err error) {
+	status := http.StatusInternalServerError
+	if errors.Is(err, identification.ErrAccountRestricted) || errors.Is(err, identification.ErrLockedOut) || errors.Is(err, identification.ErrRateLimited) {
+		// the recorder reloads the identification screen, which explains the limit
+		status = http.StatusTooManyRequests
+	} else if errors.Is(err, identification.ErrInvalidRecordingStream) {
+		status = http.StatusBadRequest
+	} else {
+		log.Printf("error streaming identification: %v", err)
 	}
 
-	log.Printf("identificationAttempt: %v, %v", identificationAttempt.ID, identificationAttempt.Identified)
+	sendErr := websocket.JSON.Send(ws, &model.VoiceActivity{
+		State:  model.VoiceActivityStateError,
+		Status: status,
+		Error:  err.Error(),
+	})
+	if sendErr != nil {
+		log.Printf("error sending stream error: %v", sendErr)
+	}
+}
+
+// checkSameOrigin refuses websockets opened by other sites, which would be sent the session cookie of the user.
+func checkSameOrigin(config *websocket.Config, request *http.Request) error {
+	origin, err := websocket.Origin(config, request)
+	if err != nil {
+		return err
+	}
+	if origin == nil || origin.Host != request.Host {
+		return fmt.Errorf("websocket from foreign origin %v", origin)
+	}
 
-	return render(c, screens.Result(identificationAttempt.Identified))
+	config.Origin = origin
+	return nil
 }
 
 // api
 func (r *IdentificationView) HandleCreateIdentificationAttempt(c echo.Context) error {
 	log.Println("identificationAttempt")
 
-	_, err := r.server.IdentificationService.CreateIdentificationAttempt(c)
+	identificationAttempt, err := r.server.IdentificationService.CreateIdentificationAttempt(c)
+	if errors.Is(err, identification.ErrAccountRestricted) || errors.Is(err, identification.ErrLockedOut) || errors.Is(err, identification.ErrRateLimited) {
+		// the recorder reloads the identification screen, which explains the limit
+		return c.String(http.StatusTooManyRequests, err.Error())
+	} else if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+
+	err = r.server.AuthService.SetIdentificationAttempt(c, identificationAttempt.RID)
 	if err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, err)
 	}
@@ -85,3 +316,132 @@ func (r *IdentificationView) HandleCreateIdentificationAttempt(c echo.Context) e
 
 	return c.NoContent(http.StatusCreated)
 }
+
+func (r *IdentificationView) HandleVerifyStepUp(c echo.Context) error {
+	_, err := r.server.IdentificationService.VerifyStepUp(c, c.FormValue("code"))
+	if errors.Is(err, identification.ErrInvalidTransition) {
+		return echo.NewHTTPError(http.StatusBadRequest, err)
+	} else if err != nil && !errors.Is(err, identification.ErrInvalidStepUpCode) {
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+
+	// a wrong code rejects the attempt, the result screen shows it
+	c.Response().Header().Add("HX-Redirect", "/identification/result")
+
+	return c.NoContent(http.StatusOK)
+}
+
+func (r *IdentificationView) HandleRequestStepUpCode(c echo.Context) error {
+	err := r.server.IdentificationService.RequestStepUpCode(c)
+	if errors.Is(err, identification.ErrInvalidTransition) {
+		return echo.NewHTTPError(http.StatusBadRequest, err)
+	} else if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+
+	return HandleInfoView(c, "Success", "New code sent to your email.")
+}
+
+func (r *IdentificationView) HandleRequestUnlockCode(c echo.Context) error {
+	err := r.server.IdentificationService.RequestUnlockCode(helper.GetCurrentUserRID(c.Request().Context()))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+
+	return HandleInfoView(c, "Success", "Unlock code sent to your email.")
+}
+
+// HandleRequestLoginCode sends the code which completes a partial login while the voice identification is unavailable.
+func (r *IdentificationView) HandleRequestLoginCode(c echo.Context) error {
+	userRid := helper.GetCurrentUserRID(c.Request().Context())
+	err := r.checkLoginCodeFallback(c, userRid)
+	if err != nil {
+		return err
+	}
+
+	err = r.server.AuthService.RequestLoginCode(userRid)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+
+	return HandleInfoView(c, "Success", "Login code sent to your email.")
+}
+
+func (r *IdentificationView) HandleVerifyLoginCode(c echo.Context) error {
+	userRid := helper.GetCurrentUserRID(c.Request().Context())
+	err := r.checkLoginCodeFallback(c, userRid)
+	if err != nil {
+		return err
+	}
+
+	err = r.server.AuthService.CompleteSecondFactorWithCode(c, c.FormValue("code"))
+	if errors.Is(err, auth.ErrInvalidLoginCode) || errors.Is(err, auth.ErrLoginCodeBurned) {
+		burned := errors.Is(err, auth.ErrLoginCodeBurned)
+		err = r.server.AuditService.Record(userRid, userRid, model.AuditActionLoginFallbackFailed, map[string]any{
+			"factor": "email",
+			"burned": burned,
+		})
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, err)
+		}
+		if burned {
+			return echo.NewHTTPError(http.StatusBadRequest, "Too many invalid codes, please request a new one.")
+		}
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid or expired code.")
+	} else if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+
+	err = r.server.AuditService.Record(userRid, userRid, model.AuditActionLoginFallbackUsed, map[string]any{
+		"factor": "email",
+	})
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+
+	returnPath, err := r.server.AuthService.TakeVoiceVerificationReturn(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	} else if len(returnPath) == 0 {
+		returnPath = "/user/onboardingStart"
+	}
+
+	c.Response().Header().Add("HX-Redirect", returnPath)
+
+	return c.NoContent(http.StatusOK)
+}
+
+// checkLoginCodeFallback allows the login code only for partial logins of users who can not identify by voice now,
+// either because of their account or because the voice matcher is down.
+func (r *IdentificationView) checkLoginCodeFallback(c echo.Context, userRid uuid.UUID) error {
+	if !r.server.AuthService.IsEmailFallbackEnabled() || !r.server.AuthService.IsSecondFactorPending(c) {
+		return echo.NewHTTPError(http.StatusForbidden, "The login code is not available.")
+	}
+
+	allowance, err := r.server.IdentificationService.GetAllowance(userRid)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+	if !isVoiceUnavailable(allowance) && r.server.VoiceMatcher.IsAvailable(c.Request().Context()) {
+		return echo.NewHTTPError(http.StatusForbidden, "Please verify your voice to complete the login.")
+	}
+	return nil
+}
+
+// isVoiceUnavailable returns true if the user can not identify until an unlock or a review, unlike a rate limit.
+func isVoiceUnavailable(allowance *model.IdentificationAllowance) bool {
+	return !allowance.Status.MayIdentify() || allowance.Lockout != nil
+}
+
+func (r *IdentificationView) HandleUnlock(c echo.Context) error {
+	err := r.server.IdentificationService.UnlockWithCode(helper.GetCurrentUserRID(c.Request().Context()), c.FormValue("code"))
+	if errors.Is(err, identification.ErrInvalidUnlockCode) {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid or expired code.")
+	} else if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+
+	c.Response().Header().Add("HX-Redirect", "/identification")
+
+	return c.NoContent(http.StatusOK)
+}
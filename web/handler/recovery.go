@@ -0,0 +1,176 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"ht/helper"
+	"ht/server"
+	"ht/server/services/auth"
+	"ht/server/services/identification"
+	"ht/server/services/recovery"
+	"ht/server/voice"
+	"ht/web/view/screens"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+)
+
+type RecoveryView struct {
+	server *server.Server
+}
+
+func NewRecoveryView(server *server.Server) *RecoveryView {
+	newRecoveryView := &RecoveryView{
+		server: server,
+	}
+	return newRecoveryView
+}
+
+func (r *RecoveryView) HandleRecoveryStart(c echo.Context) error {
+	if !r.server.RecoveryService.IsEnabled() {
+		return HandleNotFound(c)
+	}
+
+	c.Response().Header().Add("HX-Push-Url", "/recovery")
+	c.Response().Header().Add("HX-Reswap", "innerHTML")
+	return render(c, screens.RecoveryStart())
+}
+
+func (r *RecoveryView) HandleRecovery(c echo.Context) error {
+	if !r.server.RecoveryService.IsEnabled() {
+		return HandleNotFound(c)
+	}
+	rid, err := uuid.Parse(c.Param("rid"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid recovery rid")
+	}
+
+	accountRecovery, err := r.server.RecoveryService.ChallengeAccountRecovery(c.Request().Context(), rid)
+	if err != nil {
+		return recoveryError(err)
+	}
+
+	return render(c, screens.Recovery(accountRecovery, accountRecovery.IsAvailable(time.Now())))
+}
+
+func (r *RecoveryView) HandleCancelRecoveryView(c echo.Context) error {
+	if !r.server.RecoveryService.IsEnabled() {
+		return HandleNotFound(c)
+	}
+	rid, err := uuid.Parse(c.Param("rid"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid recovery rid")
+	}
+
+	return render(c, screens.CancelRecovery(rid.String()))
+}
+
+// api
+func (r *RecoveryView) HandleStartRecovery(c echo.Context) error {
+	helper.SetContext(c, helper.ProjectRidKey, uuid.UUID{})
+	accountRecovery, err := r.server.RecoveryService.StartRecovery(c.Request().Context(), c.FormValue("email"))
+	if err != nil {
+		return recoveryError(err)
+	}
+
+	c.Response().Header().Add("HX-Redirect", fmt.Sprintf("/recovery/%v", accountRecovery.RID))
+
+	return c.NoContent(http.StatusCreated)
+}
+
+func (r *RecoveryView) HandleVerifyRecovery(c echo.Context) error {
+	helper.SetContext(c, helper.ProjectRidKey, uuid.UUID{})
+	rid, err := uuid.Parse(c.Param("rid"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid recovery rid")
+	}
+	if err := c.Request().ParseMultipartForm(MAX_RECORDING_SIZE_MB << 20); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err)
+	}
+
+	file, _, err := c.Request().FormFile("recording")
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err)
+	}
+	defer file.Close()
+
+	recording, err := io.ReadAll(io.LimitReader(file, MAX_RECORDING_SIZE_MB<<20))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err)
+	}
+
+	accountRecovery, completionCode, err := r.server.RecoveryService.VerifyAccountRecovery(c.Request().Context(), rid, recording)
+	if err != nil {
+		return recoveryError(err)
+	}
+
+	return HandleInfoView(c, "Voice verified", fmt.Sprintf(
+		"Your completion code is %v, it is only shown once. Set your new credentials at /recovery/%v from %v on.",
+		completionCode,
+		accountRecovery.RID,
+		accountRecovery.AvailableAt.Format("2006-01-02 15:04"),
+	))
+}
+
+func (r *RecoveryView) HandleCompleteRecovery(c echo.Context) error {
+	helper.SetContext(c, helper.ProjectRidKey, uuid.UUID{})
+	rid, err := uuid.Parse(c.Param("rid"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid recovery rid")
+	}
+
+	_, err = r.server.RecoveryService.CompleteAccountRecovery(c, rid)
+	if err != nil {
+		return recoveryError(err)
+	}
+
+	c.Response().Header().Add("HX-Redirect", "/login")
+
+	return c.NoContent(http.StatusOK)
+}
+
+func (r *RecoveryView) HandleCancelRecovery(c echo.Context) error {
+	helper.SetContext(c, helper.ProjectRidKey, uuid.UUID{})
+	rid, err := uuid.Parse(c.Param("rid"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid recovery rid")
+	}
+
+	_, err = r.server.RecoveryService.CancelAccountRecovery(c.Request().Context(), rid, c.FormValue("code"))
+	if err != nil {
+		return recoveryError(err)
+	}
+
+	return HandleInfoView(c, "Recovery cancelled", "The recovery of your account was cancelled.")
+}
+
+// recoveryError maps the errors of the recovery service to responses, the user is told what to do next.
+func recoveryError(err error) error {
+	extractionError := &voice.ExtractionError{}
+	switch {
+	case errors.Is(err, recovery.ErrRecoveryDisabled), errors.Is(err, recovery.ErrRecoveryNotFound):
+		return echo.NewHTTPError(http.StatusNotFound, err.Error())
+	case errors.Is(err, identification.ErrRateLimited):
+		return echo.NewHTTPError(http.StatusTooManyRequests, "Too many failed attempts, please try again later.")
+	case errors.Is(err, recovery.ErrRecoveryClosed):
+		return echo.NewHTTPError(http.StatusConflict, "This recovery is closed, please start a new one.")
+	case errors.Is(err, recovery.ErrChallengeExpired):
+		return echo.NewHTTPError(http.StatusBadRequest, "The sentence expired, please reload the page for a new one.")
+	// an account which can not be recovered answers like a decoy, so it does not tell which accounts exist
+	case errors.Is(err, recovery.ErrVoiceNotVerified), errors.Is(err, recovery.ErrRecoveryUnavailable), errors.As(err, &extractionError):
+		return echo.NewHTTPError(http.StatusUnauthorized, "Your voice could not be verified, please reload the page and read out the new sentence.")
+	case errors.Is(err, recovery.ErrRecoveryNotAvailableYet):
+		return echo.NewHTTPError(http.StatusConflict, "The recovery can not be completed yet, please wait until the delay is over.")
+	case errors.Is(err, recovery.ErrInvalidRecoveryCode):
+		return echo.NewHTTPError(http.StatusUnauthorized, "The code is invalid.")
+	case errors.Is(err, recovery.ErrInvalidEmail), errors.Is(err, recovery.ErrPasswordsDoNotMatch):
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	case errors.Is(err, auth.ErrEmailTaken):
+		return echo.NewHTTPError(http.StatusConflict, "The email is already used by another account.")
+	default:
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+}
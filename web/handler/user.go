@@ -1,16 +1,18 @@
 package handler
 
 import (
-	"encoding/json"
+	"errors"
 	"fmt"
 	"ht/helper"
 	"ht/model"
 	"ht/server"
+	"ht/server/services/auth"
+	"ht/server/services/user"
 	"ht/web/view/screens"
 	"net/http"
 	"strconv"
-	"time"
 
+	"github.com/google/uuid"
 	"github.com/labstack/echo/v4"
 )
 
@@ -26,13 +28,105 @@ func NewUserView(server *server.Server) *UserView {
 }
 
 func (r *UserView) HandleUser(c echo.Context) error {
-	return render(c, screens.User(&model.User{CreatedAt: time.Now()}))
+	userRid := helper.GetCurrentUserRID(c.Request().Context())
+	user, err := r.server.UserService.GetUser(userRid)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+
+	templateAge, err := r.server.UserService.CheckTemplateRefresh(userRid)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+
+	voiceProfiles, err := r.server.UserService.GetVoiceProfiles(userRid)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+
+	account, err := r.server.AuthService.GetAuth(userRid)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+
+	return render(c, screens.User(user, account, templateAge, r.server.UserService.IsAdaptationAvailable(), voiceProfiles))
+}
+
+func (r *UserView) HandleVoiceProfileRecordings(c echo.Context) error {
+	voiceProfile, err := r.voiceProfileFromParam(c)
+	if err != nil {
+		return err
+	}
+
+	referenceSamples, err := r.server.UserService.GetVoiceProfileRecordings(voiceProfile.UserRID, voiceProfile.RID)
+	if err != nil {
+		return voiceProfileError(err)
+	}
+
+	return render(c, screens.VoiceProfileRecordings(voiceProfile, referenceSamples))
+}
+
+func (r *UserView) HandleReferenceRecording(c echo.Context) error {
+	rid, err := uuid.Parse(c.Param("rid"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid recording")
+	}
+
+	userRid := helper.GetCurrentUserRID(c.Request().Context())
+	referenceSample, err := r.server.UserService.GetReferenceRecording(userRid, rid)
+	if err != nil {
+		return voiceProfileError(err)
+	}
+
+	c.Response().Header().Set("Cache-Control", "no-store")
+	return c.Blob(http.StatusOK, "audio/wav", referenceSample.Recording)
+}
+
+// voiceProfileFromParam returns the profile of the route if it belongs to the current user.
+func (r *UserView) voiceProfileFromParam(c echo.Context) (*model.VoiceProfile, error) {
+	profileRid, err := uuid.Parse(c.Param("profile"))
+	if err != nil {
+		return nil, echo.NewHTTPError(http.StatusBadRequest, "invalid profile")
+	}
+
+	userRid := helper.GetCurrentUserRID(c.Request().Context())
+	voiceProfile, err := r.server.UserService.GetVoiceProfile(userRid, profileRid)
+	if err != nil {
+		return nil, voiceProfileError(err)
+	}
+	return voiceProfile, nil
+}
+
+func voiceProfileError(err error) error {
+	switch {
+	case errors.Is(err, user.ErrVoiceProfileNotFound), errors.Is(err, user.ErrReferenceSampleNotFound):
+		return echo.NewHTTPError(http.StatusNotFound, err.Error())
+	case errors.Is(err, user.ErrInvalidVoiceProfileName),
+		errors.Is(err, user.ErrVoiceProfileNameTaken),
+		errors.Is(err, user.ErrVoiceProfileLimit),
+		errors.Is(err, user.ErrLastVoiceProfile),
+		errors.Is(err, user.ErrDuressVoiceProfileTaken):
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	default:
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
 }
 
 func (r *UserView) HandleOnboardingStart(c echo.Context) error {
 	return render(c, screens.OnboardingStart())
 }
 
+// HandleDefaultOnboardingRecording continues the enrollment of the first profile of the user.
+func (r *UserView) HandleDefaultOnboardingRecording(c echo.Context) error {
+	userRid := helper.GetCurrentUserRID(c.Request().Context())
+	voiceProfile, err := r.server.UserService.GetDefaultVoiceProfile(userRid)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+
+	return c.Redirect(http.StatusSeeOther, fmt.Sprintf("/user/profile/%v/onboardingRecording/%v", voiceProfile.RID, c.Param("step")))
+}
+
 func (r *UserView) HandleOnboardingRecording(c echo.Context) error {
 	currentStepString := c.Param("step")
 	currentStep, err := strconv.Atoi(currentStepString)
@@ -40,21 +134,44 @@ func (r *UserView) HandleOnboardingRecording(c echo.Context) error {
 		return err
 	}
 
-	bytes, err := helper.StartJob(fmt.Sprintf("http://localhost:%v/jobs/createSentence", r.server.JobsPort), map[string]string{})
+	voiceProfile, err := r.voiceProfileFromParam(c)
 	if err != nil {
 		return err
 	}
 
-	sentence := ""
-	err = json.Unmarshal(bytes, &sentence)
+	enrollmentStatus, err := r.server.UserService.GetEnrollmentStatus(voiceProfile.RID)
 	if err != nil {
-		return err
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+	if !enrollmentStatus.IsValidStep(currentStep) {
+		if enrollmentStatus.IsComplete() && !enrollmentStatus.CanRecordMore() {
+			return c.Redirect(http.StatusSeeOther, fmt.Sprintf("/user/profile/%v/onboardingSuccess", voiceProfile.RID))
+		}
+		return c.Redirect(http.StatusSeeOther, fmt.Sprintf("/user/profile/%v/onboardingRecording/%v", voiceProfile.RID, enrollmentStatus.NextStep()))
+	}
+
+	sentence, err := r.server.VoiceMatcher.CreateSentence(c.Request().Context())
+	if err != nil {
+		return echo.NewHTTPError(http.StatusServiceUnavailable, err)
 	}
 
-	return render(c, screens.OnboardingRecording(sentence, currentStep))
+	return render(c, screens.OnboardingRecording(sentence, currentStep, voiceProfile, enrollmentStatus))
 }
 
 func (r *UserView) HandleOnboardingSuccess(c echo.Context) error {
+	voiceProfile, err := r.voiceProfileFromParam(c)
+	if err != nil {
+		return err
+	}
+
+	enrollmentStatus, err := r.server.UserService.GetEnrollmentStatus(voiceProfile.RID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+	if !enrollmentStatus.IsComplete() {
+		return c.Redirect(http.StatusSeeOther, fmt.Sprintf("/user/profile/%v/onboardingRecording/%v", voiceProfile.RID, enrollmentStatus.NextStep()))
+	}
+
 	return render(c, screens.OnboardingSuccess())
 }
 
@@ -66,12 +183,163 @@ func (r *UserView) HandleCreateReferenceRecording(c echo.Context) error {
 		return err
 	}
 
-	_, err = r.server.UserService.CreateReferenceRecording(c)
+	referenceSample, err := r.server.UserService.CreateReferenceRecording(c)
 	if err != nil {
+		if httpError, ok := err.(*echo.HTTPError); ok {
+			return httpError
+		}
 		return echo.NewHTTPError(http.StatusInternalServerError, err)
 	}
 
-	c.Response().Header().Add("HX-Redirect", fmt.Sprintf("/user/onboardingRecording/%v", currentStep+1))
+	enrollmentStatus, err := r.server.UserService.GetEnrollmentStatus(referenceSample.ProfileRID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+
+	if enrollmentStatus.IsComplete() && !enrollmentStatus.CanRecordMore() {
+		c.Response().Header().Add("HX-Redirect", fmt.Sprintf("/user/profile/%v/onboardingSuccess", referenceSample.ProfileRID))
+	} else {
+		c.Response().Header().Add("HX-Redirect", fmt.Sprintf("/user/profile/%v/onboardingRecording/%v", referenceSample.ProfileRID, currentStep+1))
+	}
 
 	return c.NoContent(http.StatusCreated)
 }
+
+func (r *UserView) HandleUpdateAdaptation(c echo.Context) error {
+	userRid := helper.GetCurrentUserRID(c.Request().Context())
+	enabled := c.FormValue("adaptation_enabled") == "on"
+
+	_, err := r.server.UserService.SetAdaptationEnabled(userRid, userRid, enabled)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+
+	if enabled {
+		return HandleInfoView(c, "Success", "Your voice template will adapt to confident identifications.")
+	}
+	return HandleInfoView(c, "Success", "Your voice template will no longer adapt.")
+}
+
+func (r *UserView) HandleCreateVoiceProfile(c echo.Context) error {
+	userRid := helper.GetCurrentUserRID(c.Request().Context())
+	voiceProfile, err := r.server.UserService.CreateVoiceProfile(userRid, c.FormValue("name"))
+	if err != nil {
+		return voiceProfileError(err)
+	}
+
+	c.Response().Header().Add("HX-Redirect", fmt.Sprintf("/user/profile/%v/onboardingRecording/1", voiceProfile.RID))
+	return c.NoContent(http.StatusCreated)
+}
+
+func (r *UserView) HandleCreateDuressVoiceProfile(c echo.Context) error {
+	userRid := helper.GetCurrentUserRID(c.Request().Context())
+	voiceProfile, err := r.server.UserService.CreateDuressVoiceProfile(userRid)
+	if err != nil {
+		return voiceProfileError(err)
+	}
+
+	c.Response().Header().Add("HX-Redirect", fmt.Sprintf("/user/profile/%v/onboardingRecording/1", voiceProfile.RID))
+	return c.NoContent(http.StatusCreated)
+}
+
+func (r *UserView) HandleRenameVoiceProfile(c echo.Context) error {
+	voiceProfile, err := r.voiceProfileFromParam(c)
+	if err != nil {
+		return err
+	}
+
+	voiceProfile, err = r.server.UserService.RenameVoiceProfile(voiceProfile.UserRID, voiceProfile.RID, c.FormValue("name"))
+	if err != nil {
+		return voiceProfileError(err)
+	}
+
+	return HandleInfoView(c, "Success", fmt.Sprintf("Your voice profile is now called %v.", voiceProfile.Name))
+}
+
+func (r *UserView) HandleRetrainVoiceProfile(c echo.Context) error {
+	voiceProfile, err := r.voiceProfileFromParam(c)
+	if err != nil {
+		return err
+	}
+
+	_, err = r.server.UserService.RetrainVoiceProfile(voiceProfile.UserRID, voiceProfile.RID)
+	if err != nil {
+		return voiceProfileError(err)
+	}
+
+	c.Response().Header().Add("HX-Redirect", fmt.Sprintf("/user/profile/%v/onboardingRecording/1", voiceProfile.RID))
+	return c.NoContent(http.StatusOK)
+}
+
+func (r *UserView) HandleDeleteVoiceProfile(c echo.Context) error {
+	voiceProfile, err := r.voiceProfileFromParam(c)
+	if err != nil {
+		return err
+	}
+
+	err = r.server.UserService.DeleteVoiceProfile(voiceProfile.UserRID, voiceProfile.RID)
+	if err != nil {
+		return voiceProfileError(err)
+	}
+
+	c.Response().Header().Add("HX-Redirect", "/user")
+	return c.NoContent(http.StatusOK)
+}
+
+func (r *UserView) HandleChangeEmail(c echo.Context) error {
+	_, err := r.server.AuthService.ChangeEmail(c, c.FormValue("email"))
+	if errors.Is(err, auth.ErrInvalidEmail) {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	} else if errors.Is(err, auth.ErrEmailTaken) {
+		return echo.NewHTTPError(http.StatusConflict, "The email is already used by another account.")
+	} else if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+
+	c.Response().Header().Add("HX-Redirect", "/user")
+	return c.NoContent(http.StatusOK)
+}
+
+func (r *UserView) HandleConfirmEmailChange(c echo.Context) error {
+	account, err := r.server.AuthService.ConfirmEmailChange(c, c.FormValue("verification_code"))
+	if errors.Is(err, auth.ErrNoEmailChange) || errors.Is(err, auth.ErrInvalidEmailVerificationCode) {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	} else if errors.Is(err, auth.ErrEmailTaken) {
+		return echo.NewHTTPError(http.StatusConflict, "The email is already used by another account.")
+	} else if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+
+	userRid := helper.GetCurrentUserRID(c.Request().Context())
+	err = r.server.AuditService.Record(userRid, userRid, model.AuditActionEmailChanged, map[string]any{
+		"email": account.Email,
+	})
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+
+	c.Response().Header().Add("HX-Redirect", "/user")
+	return c.NoContent(http.StatusOK)
+}
+
+func (r *UserView) HandleDeleteAccount(c echo.Context) error {
+	userRid := helper.GetCurrentUserRID(c.Request().Context())
+	// the reference samples are deleted with the user, the attempts only keep their decisions
+	err := r.server.IdentificationService.DeleteRecordings(userRid)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+
+	err = r.server.UserService.DeleteUser(userRid)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+
+	err = r.server.AuthService.HandleDeleteAuth(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+
+	c.Response().Header().Add("HX-Redirect", "/register")
+	return c.NoContent(http.StatusOK)
+}
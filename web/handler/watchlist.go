@@ -0,0 +1,121 @@
+package handler
+
+import (
+	"database/sql"
+	"errors"
+	"ht/helper"
+	"ht/model"
+	"ht/server"
+	"ht/server/services/user"
+	"ht/web/view/screens"
+	"net/http"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+)
+
+type WatchlistView struct {
+	server *server.Server
+}
+
+func NewWatchlistView(server *server.Server) *WatchlistView {
+	newWatchlistView := &WatchlistView{
+		server: server,
+	}
+	return newWatchlistView
+}
+
+func (r *WatchlistView) HandleWatchlist(c echo.Context) error {
+	watchlistEntries, err := r.server.UserService.GetWatchlistEntries(0, 100)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+
+	return render(c, screens.Watchlist(watchlistEntries))
+}
+
+// api
+func (r *WatchlistView) HandleAddWatchlistEntry(c echo.Context) error {
+	reason := model.WatchlistReason(c.FormValue("reason"))
+	err := reason.Validate()
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	note := strings.TrimSpace(c.FormValue("note"))
+	email := strings.TrimSpace(c.FormValue("email"))
+	attempt := strings.TrimSpace(c.FormValue("attempt"))
+
+	adminRid := helper.GetCurrentUserRID(c.Request().Context())
+	switch {
+	case len(email) > 0:
+		err = r.addAccountToWatchlist(adminRid, email, reason, note)
+	case len(attempt) > 0:
+		err = r.addAttemptToWatchlist(adminRid, attempt, reason, note)
+	default:
+		return echo.NewHTTPError(http.StatusBadRequest, "email or identification attempt required")
+	}
+	if err != nil {
+		return err
+	}
+
+	c.Response().Header().Add("HX-Redirect", "/admin/watchlist")
+
+	return c.NoContent(http.StatusOK)
+}
+
+func (r *WatchlistView) addAccountToWatchlist(adminRid uuid.UUID, email string, reason model.WatchlistReason, note string) error {
+	auth, err := r.server.AuthService.GetAuthByEmail(email)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, "user not found")
+	}
+
+	_, err = r.server.UserService.AddAccountToWatchlist(adminRid, auth.RID, reason, note)
+	return watchlistEntryError(err)
+}
+
+func (r *WatchlistView) addAttemptToWatchlist(adminRid uuid.UUID, attempt string, reason model.WatchlistReason, note string) error {
+	attemptRid, err := uuid.Parse(attempt)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid identification attempt rid")
+	}
+
+	identificationAttempt, err := r.server.IdentificationService.GetIdentificationAttempt(attemptRid)
+	if err == sql.ErrNoRows {
+		return echo.NewHTTPError(http.StatusNotFound, "identification attempt not found")
+	} else if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+
+	_, err = r.server.UserService.AddAttemptToWatchlist(adminRid, identificationAttempt, reason, note)
+	return watchlistEntryError(err)
+}
+
+// watchlistEntryError maps the errors of adding a watchlist entry to http errors.
+func watchlistEntryError(err error) error {
+	if errors.Is(err, user.ErrNoWatchlistTemplates) {
+		return echo.NewHTTPError(http.StatusUnprocessableEntity, err.Error())
+	} else if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+	return nil
+}
+
+func (r *WatchlistView) HandleDeactivateWatchlistEntry(c echo.Context) error {
+	rid, err := uuid.Parse(c.Param("rid"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid watchlist entry rid")
+	}
+
+	adminRid := helper.GetCurrentUserRID(c.Request().Context())
+	_, err = r.server.UserService.DeactivateWatchlistEntry(adminRid, rid)
+	if errors.Is(err, user.ErrWatchlistEntryNotFound) {
+		return echo.NewHTTPError(http.StatusNotFound, err.Error())
+	} else if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+
+	c.Response().Header().Add("HX-Redirect", "/admin/watchlist")
+
+	return c.NoContent(http.StatusOK)
+}
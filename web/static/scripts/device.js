@@ -0,0 +1,11 @@
+// Describes the device for the risk assessment of identification attempts,
+// the server hashes it together with the browser headers.
+function deviceDescription() {
+	return [
+		navigator.platform,
+		navigator.hardwareConcurrency,
+		screen.width + "x" + screen.height,
+		screen.colorDepth,
+		Intl.DateTimeFormat().resolvedOptions().timeZone,
+	].join("|");
+}
@@ -0,0 +1,17 @@
+// Posts the samples of the first input channel to the main thread as 16 bit PCM.
+class PcmProcessor extends AudioWorkletProcessor {
+	process(inputs) {
+		const samples = inputs[0][0];
+		if (samples) {
+			const pcm = new Int16Array(samples.length);
+			for (let i = 0; i < samples.length; i++) {
+				const sample = Math.max(-1, Math.min(1, samples[i]));
+				pcm[i] = sample < 0 ? sample * 0x8000 : sample * 0x7FFF;
+			}
+			this.port.postMessage(pcm.buffer, [pcm.buffer]);
+		}
+		return true;
+	}
+}
+
+registerProcessor("pcm-processor", PcmProcessor);
@@ -0,0 +1,76 @@
+// Streams the microphone as mono 16 bit PCM over a websocket to the url, the sample rate and the
+// device description are added as query parameters. onActivity is called with every voice activity
+// the server sends, the stream stops itself with a final state. Returns a function stopping the stream.
+async function streamRecording(url, onActivity) {
+	const finalStates = ["complete", "insufficient", "error"];
+
+	const micStream = await navigator
+		.mediaDevices
+		.getUserMedia({ audio: { channelCount: 1, echoCancellation: true, noiseSuppression: true } });
+	const audioContext = new AudioContext();
+	await audioContext.audioWorklet.addModule("/static/scripts/pcmProcessor.js");
+	const source = audioContext.createMediaStreamSource(micStream);
+	const processor = new AudioWorkletNode(audioContext, "pcm-processor");
+
+	const protocol = window.location.protocol === "https:" ? "wss:" : "ws:";
+	const params = new URLSearchParams({
+		sampleRate: audioContext.sampleRate,
+		device: deviceDescription(),
+	});
+	const socket = new WebSocket(`${protocol}//${window.location.host}${url}?${params}`);
+
+	let stopped = false;
+	const stop = () => {
+		if (stopped) {
+			return;
+		}
+		stopped = true;
+		processor.port.onmessage = null;
+		source.disconnect();
+		processor.disconnect();
+		micStream.getTracks().forEach(track => track.stop());
+		audioContext.close();
+		if (socket.readyState === WebSocket.CONNECTING || socket.readyState === WebSocket.OPEN) {
+			socket.close();
+		}
+	};
+
+	// chunks of 100 ms keep the number of messages low
+	const chunkSize = Math.round(audioContext.sampleRate / 10);
+	let chunk = new Int16Array(chunkSize);
+	let length = 0;
+	processor.port.onmessage = (e) => {
+		if (socket.readyState !== WebSocket.OPEN) {
+			return;
+		}
+		for (const sample of new Int16Array(e.data)) {
+			chunk[length++] = sample;
+			if (length === chunkSize) {
+				socket.send(chunk.buffer);
+				chunk = new Int16Array(chunkSize);
+				length = 0;
+			}
+		}
+	};
+
+	// the microphone is connected once the server listens, so the start of the recording is not lost
+	socket.onopen = () => {
+		source.connect(processor);
+		processor.connect(audioContext.destination);
+	};
+	socket.onmessage = (e) => {
+		const activity = JSON.parse(e.data);
+		if (finalStates.includes(activity.state)) {
+			stop();
+		}
+		onActivity(activity);
+	};
+	socket.onclose = () => {
+		if (!stopped) {
+			stop();
+			onActivity({ state: "error", error: "The connection was closed." });
+		}
+	};
+
+	return stop;
+}
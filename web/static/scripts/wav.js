@@ -0,0 +1,43 @@
+// Converts a recorded audio blob to a mono 16 bit PCM wav blob, which can be
+// decoded by the jobs service and by the local voice matcher of the server.
+async function toWavBlob(blob) {
+	const audioContext = new AudioContext();
+	const audioBuffer = await audioContext.decodeAudioData(await blob.arrayBuffer());
+	audioContext.close();
+
+	const samples = new Float32Array(audioBuffer.length);
+	for (let channel = 0; channel < audioBuffer.numberOfChannels; channel++) {
+		const channelData = audioBuffer.getChannelData(channel);
+		for (let i = 0; i < channelData.length; i++) {
+			samples[i] += channelData[i] / audioBuffer.numberOfChannels;
+		}
+	}
+
+	const view = new DataView(new ArrayBuffer(44 + samples.length * 2));
+	const writeString = (offset, value) => {
+		for (let i = 0; i < value.length; i++) {
+			view.setUint8(offset + i, value.charCodeAt(i));
+		}
+	};
+
+	writeString(0, "RIFF");
+	view.setUint32(4, 36 + samples.length * 2, true);
+	writeString(8, "WAVE");
+	writeString(12, "fmt ");
+	view.setUint32(16, 16, true);
+	view.setUint16(20, 1, true);
+	view.setUint16(22, 1, true);
+	view.setUint32(24, audioBuffer.sampleRate, true);
+	view.setUint32(28, audioBuffer.sampleRate * 2, true);
+	view.setUint16(32, 2, true);
+	view.setUint16(34, 16, true);
+	writeString(36, "data");
+	view.setUint32(40, samples.length * 2, true);
+
+	for (let i = 0; i < samples.length; i++) {
+		const sample = Math.max(-1, Math.min(1, samples[i]));
+		view.setInt16(44 + i * 2, sample < 0 ? sample * 0x8000 : sample * 0x7FFF, true);
+	}
+
+	return new Blob([view], { type: "audio/wav" });
+}